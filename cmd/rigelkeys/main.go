@@ -0,0 +1,96 @@
+// Command rigelkeys manages the keyring that encrypts the values of secret fields.
+//
+// Usage:
+//
+//	rigelkeys addkey -keyring FILE -id ID
+//	rigelkeys rotate -keyring FILE
+//
+// addkey adds a new random key to the keyring file, creating it if needed, and makes it the
+// current key. rotate re-encrypts every secret value in the storage that was encrypted with
+// another key with the current key. The storage is configured by the RIGEL_ETCD_* environment
+// variables or the file named by RIGEL_CONFIG_FILE, as for rigel.Default.
+//
+// To rotate keys: run addkey, give the new keyring to all clients and the server, run rotate,
+// then remove the old key from the keyring file and give the keyring out again.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/remiges-aniket/rigel"
+	"github.com/remiges-aniket/utils"
+)
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+	}
+
+	var err error
+	switch os.Args[1] {
+	case "addkey":
+		err = addKey(os.Args[2:])
+	case "rotate":
+		err = rotate(os.Args[2:])
+	default:
+		usage()
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "rigelkeys %s: %v\n", os.Args[1], err)
+		os.Exit(1)
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: rigelkeys addkey -keyring FILE -id ID")
+	fmt.Fprintln(os.Stderr, "       rigelkeys rotate -keyring FILE")
+	os.Exit(2)
+}
+
+// addKey adds a new current key to a keyring file.
+func addKey(args []string) error {
+	fs := flag.NewFlagSet("addkey", flag.ExitOnError)
+	path := fs.String("keyring", "", "keyring file")
+	id := fs.String("id", "", "id of the new key")
+	fs.Parse(args)
+	if *path == "" || *id == "" {
+		usage()
+	}
+
+	if err := rigel.AddKeyringKey(*path, *id); err != nil {
+		return err
+	}
+	fmt.Printf("added key %s to %s as the current key\n", *id, *path)
+	return nil
+}
+
+// rotate re-encrypts all secret values with the current key of a keyring file.
+func rotate(args []string) error {
+	fs := flag.NewFlagSet("rotate", flag.ExitOnError)
+	path := fs.String("keyring", os.Getenv(rigel.EnvKeyringFile), "keyring file")
+	fs.Parse(args)
+	if *path == "" {
+		usage()
+	}
+
+	keyring, err := rigel.LoadKeyring(*path)
+	if err != nil {
+		return err
+	}
+	opts, err := rigel.LoadOptions()
+	if err != nil {
+		return err
+	}
+	storage, err := opts.Storage()
+	if err != nil {
+		return err
+	}
+	defer storage.Client.Close()
+
+	rotated, err := rigel.RotateSecrets(context.Background(), storage, keyring, utils.RIGELPREFIX+"/")
+	fmt.Printf("re-encrypted %d secret values\n", rotated)
+	return err
+}
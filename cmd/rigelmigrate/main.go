@@ -0,0 +1,86 @@
+// Command rigelmigrate moves config values stored by older servers and clients in legacy key
+// layouts to the canonical layout of package layout.
+//
+// Usage:
+//
+//	rigelmigrate [-dry-run]
+//
+// Each legacy key is reported with its canonical key. With -dry-run nothing is changed;
+// otherwise each value is moved to its canonical key and the legacy key is deleted. A legacy
+// key whose canonical key already holds a different value is reported as a conflict and left
+// alone, to be resolved by hand. The storage is configured by the RIGEL_ETCD_* environment
+// variables or the file named by RIGEL_CONFIG_FILE, as for rigel.Default.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/remiges-aniket/layout"
+	"github.com/remiges-aniket/rigel"
+)
+
+func main() {
+	dryRun := flag.Bool("dry-run", false, "report the keys to move without moving them")
+	flag.Parse()
+
+	if err := migrate(*dryRun); err != nil {
+		fmt.Fprintf(os.Stderr, "rigelmigrate: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// migrate moves the keys in legacy layouts to the canonical layout, or only reports them if dryRun is set.
+func migrate(dryRun bool) error {
+	opts, err := rigel.LoadOptions()
+	if err != nil {
+		return err
+	}
+	storage, err := opts.Storage()
+	if err != nil {
+		return err
+	}
+	defer storage.Client.Close()
+
+	ctx := context.Background()
+	kvs, err := storage.GetWithPrefix(ctx, layout.Prefix+"/")
+	if err != nil {
+		return err
+	}
+
+	var moved, conflicts int
+	for _, m := range layout.Migrations(kvs) {
+		switch {
+		case m.Conflict:
+			conflicts++
+			fmt.Printf("conflict %s -> %s: the canonical key holds a different value\n", m.From, m.To)
+		case dryRun:
+			fmt.Printf("would move %s -> %s\n", m.From, m.To)
+			moved++
+		default:
+			ok, err := storage.Move(ctx, m.From, m.To, m.Value)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				conflicts++
+				fmt.Printf("conflict %s -> %s: a key changed during the migration\n", m.From, m.To)
+				continue
+			}
+			fmt.Printf("moved %s -> %s\n", m.From, m.To)
+			moved++
+		}
+	}
+
+	if dryRun {
+		fmt.Printf("%d keys to move, %d conflicts\n", moved, conflicts)
+	} else {
+		fmt.Printf("moved %d keys, %d conflicts\n", moved, conflicts)
+	}
+	if conflicts > 0 {
+		return fmt.Errorf("%d keys were not moved", conflicts)
+	}
+	return nil
+}
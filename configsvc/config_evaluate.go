@@ -0,0 +1,65 @@
+package configsvc
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
+	"github.com/remiges-aniket/rigel"
+	"github.com/remiges-aniket/utils"
+	"github.com/remiges-tech/alya/service"
+	"github.com/remiges-tech/alya/wscutils"
+	"github.com/remiges-tech/logharbour/logharbour"
+)
+
+type configevaluate struct {
+	App        string            `json:"app" validate:"required"`
+	Module     string            `json:"module" validate:"required"`
+	Ver        int               `json:"ver" validate:"required"`
+	Config     string            `json:"config" validate:"required"`
+	Attributes map[string]string `json:"attributes"` // Attributes of a client, such as hostname, region or instance id
+}
+
+// Config_evaluate handles the POST /configevaluate request. It returns the values the config
+// resolves to for a client with the given attributes, evaluating the targeting rules of the
+// values and of the values of the parents of the config.
+func Config_evaluate(c *gin.Context, s *service.Service) {
+	l := s.LogHarbour
+	l.Log("Starting execution of Config_evaluate()")
+
+	var configevaluate configevaluate
+	err := wscutils.BindJSON(c, &configevaluate)
+	if err != nil {
+		l.LogActivity("error while binding json", err)
+		return
+	}
+
+	validationErrors := validateConfigevaluate(configevaluate, c)
+	if len(validationErrors) > 0 {
+		l.LogDebug("Validation errors:", logharbour.DebugInfo{Variables: map[string]any{"validationErrors": validationErrors}})
+		wscutils.SendErrorResponse(c, wscutils.NewResponse(wscutils.ErrorStatus, nil, validationErrors))
+		return
+	}
+
+	// Extracting Rigel client from service dependency and initializing with values from request parameters.
+	rigelClient := s.Dependencies["rigel"]
+	r, ok := rigelClient.(*rigel.Rigel)
+	if !ok {
+		str := "rigelClient"
+		l.Debug0().LogDebug("Invalid Rigel Client Dependency:", logharbour.DebugInfo{Variables: map[string]any{"rigelClient": rigelClient}})
+		wscutils.SendErrorResponse(c, wscutils.NewResponse(wscutils.ErrorStatus, nil, []wscutils.ErrorMessage{wscutils.BuildErrorMessage(utils.INVALID_DEPENDENCY, &str)}))
+		return
+	}
+	view := r.View(configevaluate.App, configevaluate.Module, configevaluate.Ver, configevaluate.Config).
+		WithAttributes(configevaluate.Attributes)
+
+	sendEffectiveConfig(c, s, view, nil)
+}
+
+// validateConfigevaluate performs validation for the Configevaluate.
+func validateConfigevaluate(config configevaluate, c *gin.Context) []wscutils.ErrorMessage {
+	return wscutils.WscValidate(config, config.getVals)
+}
+
+// getVals returns validation error details based on the field and tag.
+func (config *configevaluate) getVals(err validator.FieldError) []string {
+	return nil
+}
@@ -0,0 +1,149 @@
+package configsvc
+
+import (
+	"crypto/subtle"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
+	"github.com/remiges-aniket/types"
+	"github.com/remiges-aniket/utils"
+	"github.com/remiges-tech/alya/service"
+	"github.com/remiges-tech/alya/wscutils"
+	"github.com/remiges-tech/logharbour/logharbour"
+)
+
+// EnvAdminToken names the environment variable holding the admin token of the server, which
+// takes precedence over the admin_token of its config file.
+const EnvAdminToken = "RIGEL_ADMIN_TOKEN"
+
+// configlock names what a lock applies to: the app alone locks all its configs, the app and
+// module all the configs of the module, and the app, module, ver and config one named config.
+type configlock struct {
+	App     string     `json:"app" validate:"required"`
+	Module  string     `json:"module,omitempty"`
+	Ver     int        `json:"ver,omitempty"`
+	Config  string     `json:"config,omitempty"`
+	Reason  string     `json:"reason,omitempty"`
+	Expires *time.Time `json:"expires,omitempty"` // Expires ends the lock at the given time
+}
+
+// Config_lock handles the POST /configlock request. It locks an app, a module or a named config,
+// so that writes to the configs in it are rejected with the error code utils.CONFIG_LOCKED.
+// Only admins can lock configs, see RequireAdmin.
+func Config_lock(c *gin.Context, s *service.Service) {
+	l := s.LogHarbour
+	l.Log("Starting execution of Config_lock()")
+
+	if !RequireAdmin(c, s) {
+		return
+	}
+
+	var configlock configlock
+	if err := wscutils.BindJSON(c, &configlock); err != nil {
+		l.LogActivity("error while binding json", err)
+		return
+	}
+	scope, validationErrors := validateConfiglock(configlock)
+	if len(validationErrors) > 0 {
+		l.LogDebug("Validation errors:", logharbour.DebugInfo{Variables: map[string]any{"validationErrors": validationErrors}})
+		wscutils.SendErrorResponse(c, wscutils.NewResponse(wscutils.ErrorStatus, nil, validationErrors))
+		return
+	}
+
+	r, ok := rigelDependency(c, s)
+	if !ok {
+		return
+	}
+	view := r.View(configlock.App, configlock.Module, configlock.Ver, configlock.Config)
+
+	lock := types.Lock{Scope: scope, Reason: configlock.Reason, Expires: configlock.Expires}
+	if err := view.SetLock(c, lock); err != nil {
+		l.LogActivity("error while setting lock:", err)
+		wscutils.SendErrorResponse(c, wscutils.NewErrorResponse("unable_to_set"))
+		return
+	}
+	l.LogActivity("config locked:", configlock)
+	wscutils.SendSuccessResponse(c, &wscutils.Response{Status: wscutils.SuccessStatus, Data: "locked successfully", Messages: []wscutils.ErrorMessage{}})
+}
+
+// Config_unlock handles the POST /configunlock request. It removes the lock of an app, a module
+// or a named config, named as for /configlock. Only admins can unlock configs.
+func Config_unlock(c *gin.Context, s *service.Service) {
+	l := s.LogHarbour
+	l.Log("Starting execution of Config_unlock()")
+
+	if !RequireAdmin(c, s) {
+		return
+	}
+
+	var configlock configlock
+	if err := wscutils.BindJSON(c, &configlock); err != nil {
+		l.LogActivity("error while binding json", err)
+		return
+	}
+	scope, validationErrors := validateConfiglock(configlock)
+	if len(validationErrors) > 0 {
+		l.LogDebug("Validation errors:", logharbour.DebugInfo{Variables: map[string]any{"validationErrors": validationErrors}})
+		wscutils.SendErrorResponse(c, wscutils.NewResponse(wscutils.ErrorStatus, nil, validationErrors))
+		return
+	}
+
+	r, ok := rigelDependency(c, s)
+	if !ok {
+		return
+	}
+	view := r.View(configlock.App, configlock.Module, configlock.Ver, configlock.Config)
+
+	if err := view.RemoveLock(c, scope); err != nil {
+		l.LogActivity("error while removing lock:", err)
+		wscutils.SendErrorResponse(c, wscutils.NewErrorResponse("unable_to_set"))
+		return
+	}
+	l.LogActivity("config unlocked:", configlock)
+	wscutils.SendSuccessResponse(c, &wscutils.Response{Status: wscutils.SuccessStatus, Data: "unlocked successfully", Messages: []wscutils.ErrorMessage{}})
+}
+
+// IsAdmin reports whether authorization, the value of an Authorization header, carries token
+// as a bearer token. No request is an admin's if token is empty.
+func IsAdmin(token string, authorization string) bool {
+	given, found := strings.CutPrefix(authorization, "Bearer ")
+	return token != "" && found && subtle.ConstantTimeCompare([]byte(given), []byte(token)) == 1
+}
+
+// RequireAdmin checks that the request carries the admin token of the service, its "adminToken"
+// dependency, responding with the error code utils.NOT_AUTHORIZED if it does not.
+func RequireAdmin(c *gin.Context, s *service.Service) bool {
+	token, _ := s.Dependencies["adminToken"].(string)
+	if IsAdmin(token, c.GetHeader("Authorization")) {
+		return true
+	}
+	s.LogHarbour.LogActivity("admin request refused:", c.Request.URL.Path)
+	wscutils.SendErrorResponse(c, wscutils.NewErrorResponse(utils.NOT_AUTHORIZED))
+	return false
+}
+
+// validateConfiglock performs validation for the Configlock and returns the scope of the lock.
+// A config needs its module and version.
+func validateConfiglock(config configlock) (types.LockScope, []wscutils.ErrorMessage) {
+	if validationErrors := wscutils.WscValidate(config, config.getVals); len(validationErrors) > 0 {
+		return "", validationErrors
+	}
+	switch {
+	case config.Config != "":
+		if config.Module == "" || config.Ver == 0 {
+			field := "config"
+			return "", []wscutils.ErrorMessage{wscutils.BuildErrorMessage(wscutils.ERRCODE_INVALID_REQUEST, &field)}
+		}
+		return types.LockScopeConfig, nil
+	case config.Module != "":
+		return types.LockScopeModule, nil
+	}
+	return types.LockScopeApp, nil
+}
+
+// getVals returns validation error details based on the field and tag.
+func (config *configlock) getVals(err validator.FieldError) []string {
+	return nil
+}
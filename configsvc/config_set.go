@@ -1,11 +1,14 @@
 package configsvc
 
 import (
+	"context"
+	"errors"
 	"fmt"
 
 	"github.com/gin-gonic/gin"
 	"github.com/go-playground/validator/v10"
 	"github.com/remiges-aniket/rigel"
+	"github.com/remiges-aniket/types"
 	"github.com/remiges-aniket/utils"
 	"github.com/remiges-tech/alya/service"
 	"github.com/remiges-tech/alya/wscutils"
@@ -19,18 +22,32 @@ type configset struct {
 	Config string `json:"config" validate:"required"`
 	Key    string `json:"key" validate:"required"`
 	Value  any    `json:"value" validate:"required"`
+	lockOverride
+}
+
+// lockOverride is embedded in write requests. Override makes the write succeed even if the config
+// is locked; the write is then recorded along with OverrideReason. Only admins can override locks.
+type lockOverride struct {
+	Override       bool   `json:"override,omitempty"`
+	OverrideReason string `json:"overrideReason,omitempty"`
 }
 
 type configupdate struct {
-	App         string `json:"app" validate:"required"`
-	Module      string `json:"module" validate:"required"`
-	Ver         int    `json:"ver" validate:"required"`
-	Config      string `json:"config" validate:"required"`
-	Description string `json:"description" validate:"required"`
-	Values      []struct {
-		Name  string `json:"name" validate:"required"`
-		Value string `json:"value" validate:"required"`
-	} `json:"values" validate:"required"`
+	App         string        `json:"app" validate:"required"`
+	Module      string        `json:"module" validate:"required"`
+	Ver         int           `json:"ver" validate:"required"`
+	Config      string        `json:"config" validate:"required"`
+	Description string        `json:"description" validate:"required"`
+	Parent      *string       `json:"parent,omitempty"` // Parent makes the config an overlay of the named config, "" removes it
+	Values      []UpdateValue `json:"values" validate:"required"`
+	lockOverride
+}
+
+// UpdateValue is a value set by /configupdate.
+type UpdateValue struct {
+	Name  string       `json:"name" validate:"required"`
+	Value string       `json:"value" validate:"required"`
+	Rules []types.Rule `json:"rules,omitempty"` // Rules replace the targeting rules of the value if present
 }
 
 func Config_set(c *gin.Context, s *service.Service) {
@@ -60,18 +77,53 @@ func Config_set(c *gin.Context, s *service.Service) {
 		wscutils.SendErrorResponse(c, wscutils.NewResponse(wscutils.ErrorStatus, nil, []wscutils.ErrorMessage{wscutils.BuildErrorMessage(utils.INVALID_DEPENDENCY, &str)}))
 		return
 	}
-	r.WithApp(configset.App).WithModule(configset.Module).WithVersion(configset.Ver).WithConfig(configset.Config)
-	val := fmt.Sprintf("%#v", configset.Value)
-	err = r.Set(c, configset.Key, val)
+	view, ok := configset.apply(c, s, r.View(configset.App, configset.Module, configset.Ver, configset.Config))
+	if !ok {
+		return
+	}
+	code, err := SetConfig(c, view, configset.Key, configset.Value)
 	if err != nil {
 		l.LogActivity("error while setting value in etcd:", err)
-		wscutils.SendErrorResponse(c, wscutils.NewErrorResponse("unable_to_set"))
+		wscutils.SendErrorResponse(c, wscutils.NewErrorResponse(code))
 		return
 	} else {
 		wscutils.SendSuccessResponse(c, &wscutils.Response{Status: wscutils.SuccessStatus, Data: "data set successfully", Messages: []wscutils.ErrorMessage{}})
 	}
 }
 
+// SetConfig sets key in the config of view to value, a value decoded from JSON. On failure it
+// returns the error code of the response. It serves /configset and the SetConfig call of the
+// gRPC API.
+func SetConfig(ctx context.Context, view *rigel.View, key string, value any) (string, error) {
+	if err := view.Set(ctx, key, fmt.Sprintf("%#v", value)); err != nil {
+		return WriteErrorCode(err, "unable_to_set"), err
+	}
+	return "", nil
+}
+
+// apply returns view, made to override locks if the request asks to. A request that is not an
+// admin's cannot, and gets the error code utils.NOT_AUTHORIZED, see RequireAdmin.
+func (o lockOverride) apply(c *gin.Context, s *service.Service, view *rigel.View) (*rigel.View, bool) {
+	if !o.Override {
+		return view, true
+	}
+	if !RequireAdmin(c, s) {
+		return nil, false
+	}
+	s.LogHarbour.LogActivity("overriding config locks:", map[string]any{"app": view.App(), "module": view.Module(), "ver": view.Version(), "config": view.Config(), "reason": o.OverrideReason})
+	return view.WithLockOverride(o.OverrideReason), true
+}
+
+// WriteErrorCode returns the error code for err, an error writing to a config: utils.CONFIG_LOCKED
+// if the config is locked, and code otherwise.
+func WriteErrorCode(err error, code string) string {
+	var locked *rigel.LockedError
+	if errors.As(err, &locked) {
+		return utils.CONFIG_LOCKED
+	}
+	return code
+}
+
 // validateConfigset performs validation for the Configset.
 func validateConfigset(config configset, c *gin.Context) []wscutils.ErrorMessage {
 	// Validate the request body
@@ -1,6 +1,9 @@
 package configsvc
 
 import (
+	"context"
+	"fmt"
+
 	"github.com/gin-gonic/gin"
 	"github.com/go-playground/validator/v10"
 	"github.com/remiges-aniket/utils"
@@ -38,19 +41,46 @@ func Config_update(c *gin.Context, s *service.Service) {
 		wscutils.SendErrorResponse(c, wscutils.NewResponse(wscutils.ErrorStatus, nil, []wscutils.ErrorMessage{wscutils.BuildErrorMessage(utils.INVALID_DEPENDENCY, &str)}))
 		return
 	}
-	r.WithApp(configupdate.App).WithModule(configupdate.Module).WithVersion(configupdate.Ver).WithConfig(configupdate.Config)
+	view, ok := configupdate.apply(c, s, r.View(configupdate.App, configupdate.Module, configupdate.Ver, configupdate.Config))
+	if !ok {
+		return
+	}
 
-	for _, v := range configupdate.Values {
-		err = r.Set(c, v.Name, v.Value)
-		if err != nil {
-			l.LogActivity("error while setting value in etcd:", err)
-			wscutils.SendErrorResponse(c, wscutils.NewErrorResponse("unable_to_set"))
-			return
-		}
+	if code, err := UpdateConfig(c, view, configupdate.Parent, configupdate.Values); err != nil {
+		l.LogActivity("error while updating config in etcd:", err)
+		wscutils.SendErrorResponse(c, wscutils.NewErrorResponse(code))
+		return
 	}
 	wscutils.SendSuccessResponse(c, &wscutils.Response{Status: wscutils.SuccessStatus, Data: "data set successfully", Messages: []wscutils.ErrorMessage{}})
 }
 
+// UpdateConfig sets the parent of the config of view, unless parent is nil, and values along
+// with their rules, in a single write: if any of them is rejected, none is made. On failure it
+// returns the error code of the response. It serves /configupdate and the UpdateConfig call of
+// the gRPC API.
+func UpdateConfig(ctx context.Context, view *rigel.View, parent *string, values []UpdateValue) (string, error) {
+	u := view.Update()
+	if parent != nil {
+		if err := u.SetParent(ctx, *parent); err != nil {
+			return utils.INVALID_PARENT, fmt.Errorf("failed to set config parent: %w", err)
+		}
+	}
+	for _, v := range values {
+		if err := u.Set(ctx, v.Name, v.Value); err != nil {
+			return "unable_to_set", fmt.Errorf("failed to set %s: %w", v.Name, err)
+		}
+		if v.Rules != nil {
+			if err := u.SetRules(ctx, v.Name, v.Rules); err != nil {
+				return utils.INVALID_RULES, fmt.Errorf("failed to set rules of %s: %w", v.Name, err)
+			}
+		}
+	}
+	if err := u.Commit(ctx); err != nil {
+		return WriteErrorCode(err, "unable_to_set"), fmt.Errorf("failed to update config: %w", err)
+	}
+	return "", nil
+}
+
 // validateConfigupdate performs validation for the Configupdate.
 func validateConfigupdate(config configupdate, c *gin.Context) []wscutils.ErrorMessage {
 	// Validate the request body
@@ -0,0 +1,165 @@
+package configsvc
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/remiges-aniket/layout"
+	"github.com/remiges-aniket/rigel"
+	"github.com/remiges-aniket/utils"
+	"github.com/remiges-tech/alya/service"
+	"github.com/remiges-tech/alya/wscutils"
+	"github.com/remiges-tech/logharbour/logharbour"
+)
+
+// configwatch holds the query parameters of /configwatch. Rev is the revision returned by the
+// previous poll, 0 for the first one, and Timeout the number of seconds to wait for a change,
+// utils.LONGPOLLTIMEOUT if not given and at most utils.LONGPOLLMAXTIMEOUT.
+type configwatch struct {
+	App     string `form:"app" binding:"required"`
+	Module  string `form:"module" binding:"required"`
+	Ver     int    `form:"ver" binding:"required"`
+	Config  string `form:"config" binding:"required"`
+	Rev     int64  `form:"rev"`
+	Timeout int    `form:"timeout"`
+}
+
+// watchChange is a change returned by /configwatch: a "put" or a "delete".
+type watchChange struct {
+	Type string `json:"type"`
+	StreamChange
+}
+
+// configWatchResponse is the response of /configwatch. Revision is to be passed as rev to the
+// next poll. If Reset is set, Changes holds all the values of the config rather than the changes
+// since rev, either because rev was 0 or because the server no longer has the changes since rev.
+type configWatchResponse struct {
+	Revision int64         `json:"revision"`
+	Reset    bool          `json:"reset,omitempty"`
+	Changes  []watchChange `json:"changes"`
+}
+
+// Config_watch handles the GET /configwatch request, a long poll for the changes to a named
+// config. It responds with the changes made after revision rev as soon as there are any, or
+// with no changes once the timeout passes. Clients loop on it, passing the revision of each
+// response to the next poll, and miss no change between polls. The values of secrets are masked.
+func Config_watch(c *gin.Context, s *service.Service) {
+	l := s.LogHarbour
+	l.Log("Starting execution of Config_watch()")
+
+	var configwatch configwatch
+	if err := c.ShouldBindQuery(&configwatch); err != nil {
+		l.LogActivity("error while binding query", err)
+		wscutils.SendErrorResponse(c, wscutils.NewErrorResponse(wscutils.ERRCODE_INVALID_REQUEST))
+		return
+	}
+	timeout := utils.LONGPOLLTIMEOUT
+	if configwatch.Timeout != 0 {
+		timeout = time.Duration(configwatch.Timeout) * time.Second
+	}
+	if configwatch.Rev < 0 || timeout < 0 || timeout > utils.LONGPOLLMAXTIMEOUT {
+		field := "timeout"
+		if configwatch.Rev < 0 {
+			field = "rev"
+		}
+		wscutils.SendErrorResponse(c, wscutils.NewResponse(wscutils.ErrorStatus, nil, []wscutils.ErrorMessage{wscutils.BuildErrorMessage(wscutils.ERRCODE_INVALID_REQUEST, &field)}))
+		return
+	}
+
+	hub, ok := hubDependency(c, s)
+	if !ok {
+		return
+	}
+	r, ok := rigelDependency(c, s)
+	if !ok {
+		return
+	}
+	prefix := layout.ConfigPath(configwatch.App, configwatch.Module, configwatch.Ver, configwatch.Config) + "/"
+
+	if configwatch.Rev == 0 {
+		kvs, rev, err := hub.Snapshot(c, prefix)
+		if err != nil {
+			l.LogActivity("error while reading config:", err)
+			wscutils.SendErrorResponse(c, wscutils.NewResponse(wscutils.ErrorStatus, nil, []wscutils.ErrorMessage{wscutils.BuildErrorMessage(wscutils.ErrcodeMissing, nil, err.Error())}))
+			return
+		}
+		wscutils.SendSuccessResponse(c, wscutils.NewSuccessResponse(resetResponse(c, r, kvs, rev)))
+		return
+	}
+
+	sub, err := hub.Subscribe(c, prefix, configwatch.Rev)
+	if errors.Is(err, rigel.ErrFutureRevision) {
+		field := "rev"
+		wscutils.SendErrorResponse(c, wscutils.NewResponse(wscutils.ErrorStatus, nil, []wscutils.ErrorMessage{wscutils.BuildErrorMessage(wscutils.ERRCODE_INVALID_REQUEST, &field, err.Error())}))
+		return
+	}
+	if err != nil {
+		l.LogActivity("error while subscribing to changes:", err)
+		wscutils.SendErrorResponse(c, wscutils.NewResponse(wscutils.ErrorStatus, nil, []wscutils.ErrorMessage{wscutils.BuildErrorMessage(wscutils.ErrcodeMissing, nil, err.Error())}))
+		return
+	}
+	// The hub keeps watching for a grace period after the poll, so the next one resumes from its changes
+	defer sub.Close()
+	if sub.Snapshot != nil {
+		wscutils.SendSuccessResponse(c, wscutils.NewSuccessResponse(resetResponse(c, r, sub.Snapshot, sub.SnapshotRevision)))
+		return
+	}
+
+	// The response holds the changes of the revisions whose changes have all been received, up
+	// to the revision of the response, so that the next poll, resuming after it, misses none of
+	// the changes of a revision. pending holds the changes received of the revision after it.
+	response := configWatchResponse{Revision: configwatch.Rev, Changes: []watchChange{}}
+	var pending []watchChange
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+	// settle is started by the first complete revision with changes, to gather those of the
+	// revisions made right after it
+	var settle <-chan time.Time
+	for {
+		select {
+		case <-c.Request.Context().Done():
+			return
+		case <-deadline.C:
+			wscutils.SendSuccessResponse(c, wscutils.NewSuccessResponse(response))
+			return
+		case <-settle:
+			wscutils.SendSuccessResponse(c, wscutils.NewSuccessResponse(response))
+			return
+		case event, ok := <-sub.Events():
+			if !ok {
+				// Respond with the changes so far; the next poll resumes after them
+				l.LogActivity("config watch ended:", sub.Err())
+				wscutils.SendSuccessResponse(c, wscutils.NewSuccessResponse(response))
+				return
+			}
+			if event.Err != nil {
+				l.LogDebug("config watch error:", logharbour.DebugInfo{Variables: map[string]any{"error": event.Err.Error()}})
+				continue
+			}
+			if change, ok := NewStreamChange(c, r, event.Key, event.Value, event.Revision); ok {
+				pending = append(pending, watchChange{Type: event.Type.String(), StreamChange: change})
+			}
+			if !event.Last {
+				continue
+			}
+			response.Revision = event.Revision
+			response.Changes = append(response.Changes, pending...)
+			pending = nil
+			if settle == nil && len(response.Changes) > 0 {
+				settle = time.After(utils.LONGPOLLSETTLE)
+			}
+		}
+	}
+}
+
+// resetResponse returns the response holding all the values in kvs, read at revision rev, with
+// the values of secret fields, looked up with r, masked.
+func resetResponse(ctx context.Context, r *rigel.Rigel, kvs map[string]string, rev int64) configWatchResponse {
+	response := configWatchResponse{Revision: rev, Reset: true, Changes: []watchChange{}}
+	for _, change := range SnapshotChanges(ctx, r, kvs, rev) {
+		response.Changes = append(response.Changes, watchChange{Type: "put", StreamChange: change})
+	}
+	return response
+}
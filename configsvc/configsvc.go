@@ -1,14 +1,21 @@
 package configsvc
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
-	"strconv"
+	"sort"
 	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/go-playground/validator/v10"
 	"github.com/remiges-aniket/etcd"
+	"github.com/remiges-aniket/layout"
+	"github.com/remiges-aniket/rigel"
 	"github.com/remiges-aniket/trees"
+	"github.com/remiges-aniket/types"
 	"github.com/remiges-aniket/utils"
 	"github.com/remiges-tech/alya/service"
 	"github.com/remiges-tech/alya/wscutils"
@@ -16,20 +23,27 @@ import (
 
 // getSchemaResponse represents the structure for outgoing  responses.
 
-type getConfigResponse struct {
-	App         *string  `json:"app,omitempty"`
-	Module      *string  `json:"module,omitempty"`
-	Version     *int     `json:"ver,omitempty"`
-	Config      *string  `json:"config,omitempty"`
-	Description string   `json:"description,omitempty"`
-	Values      []values `json:"values,omitempty"`
+type ConfigResponse struct {
+	App         *string       `json:"app,omitempty"`
+	Module      *string       `json:"module,omitempty"`
+	Version     *int          `json:"ver,omitempty"`
+	Config      *string       `json:"config,omitempty"`
+	Description string        `json:"description,omitempty"`
+	Parent      string        `json:"parent,omitempty"`
+	Values      []ConfigValue `json:"values,omitempty"`
 }
 
-type values struct {
-	Name  string `json:"name,omitempty"`
-	Value string `json:"value,omitempty"`
+type ConfigValue struct {
+	Name   string        `json:"name,omitempty"`
+	Value  string        `json:"value,omitempty"`
+	Source string        `json:"source,omitempty"` // Source is the config an effective value came from
+	Rules  []types.Rule  `json:"rules,omitempty"`  // Rules are the targeting rules of the value
+	Values []ConfigValue `json:"values,omitempty"` // Values holds the values of a group of fields
 }
 
+// Config_get handles the GET /configget request. It returns the values stored in the config,
+// or with effective=true the values the config resolves to through its parents, each with
+// the config it came from.
 func Config_get(c *gin.Context, s *service.Service) {
 	lh := s.LogHarbour
 	lh.Log("Config_get request received")
@@ -41,7 +55,6 @@ func Config_get(c *gin.Context, s *service.Service) {
 		return
 	}
 
-	var response getConfigResponse
 	var queryParams utils.GetConfigRequestParams
 	err := c.ShouldBindQuery(&queryParams)
 	if err != nil {
@@ -61,31 +74,59 @@ func Config_get(c *gin.Context, s *service.Service) {
 		return
 	}
 
-	keyStr := utils.RIGELPREFIX + "/" + *queryParams.App + "/" + *queryParams.Module + "/" + strconv.Itoa(queryParams.Version) + "/" + *queryParams.Config
+	if queryParams.Effective || queryParams.At != "" {
+		configGetEffective(c, s, &queryParams)
+		return
+	}
+	if !timeAsked(&queryParams, nil, time.Now()) {
+		field := "at"
+		wscutils.SendErrorResponse(c, wscutils.NewResponse(wscutils.ErrorStatus, nil, []wscutils.ErrorMessage{wscutils.BuildErrorMessage(utils.INVALID_TIME, &field)}))
+		return
+	}
 
-	fmt.Println("KEY:", keyStr)	
-	getValue, err := client.GetWithPrefix(c, keyStr)
+	r, ok := rigelDependency(c, s)
+	if !ok {
+		return
+	}
+	response, err := ReadConfig(c, client, r, &queryParams)
 	if err != nil {
 		wscutils.SendErrorResponse(c, wscutils.NewResponse(wscutils.ErrorStatus, nil, []wscutils.ErrorMessage{wscutils.BuildErrorMessage(wscutils.ErrcodeMissing, nil, err.Error())}))
 		lh.Debug0().LogActivity("error while get data from db error:", err.Error)
 		return
 	}
-	// set response fields
-	// bindGetConfigResponse(&response, &queryParams, getValue)
-	bindGetConfigResponse(&response, &getValue)
 
-	lh.Log(fmt.Sprintf("Record found: %v", map[string]any{"key with --prefix": keyStr, "value": response}))
+	lh.Log(fmt.Sprintf("Record found: %v", map[string]any{"value": response}))
 	// te := make([]*etcdls.Node, 0)
 	// arr, _ := etcdls.BuildTree(te, getValue)
 	wscutils.SendSuccessResponse(c, wscutils.NewSuccessResponse(response))
 }
 
-// Config_list: handles the GET /configlist request
+// ReadConfig returns the values stored in the config named by queryParams, with the values of
+// the secret fields of its schema, read with r, masked. It serves /configget and the GetConfig
+// call of the gRPC API.
+func ReadConfig(ctx context.Context, client *etcd.EtcdStorage, r *rigel.Rigel, queryParams *utils.GetConfigRequestParams) (ConfigResponse, error) {
+	var response ConfigResponse
+	schema, err := r.View(*queryParams.App, *queryParams.Module, queryParams.Version, "").GetSchema(ctx)
+	if err != nil {
+		return response, fmt.Errorf("failed to get schema: %w", err)
+	}
+	keyStr := layout.ConfigPath(*queryParams.App, *queryParams.Module, queryParams.Version, *queryParams.Config)
+	getValue, err := client.GetWithPrefix(ctx, keyStr+"/")
+	if err != nil {
+		return response, err
+	}
+	// set response fields
+	bindGetConfigResponse(&response, queryParams, schema, keyStr, &getValue)
+	return response, nil
+}
+
+// Config_list: handles the GET /configlist request. The configs can be filtered, sorted and paged
+// with the query parameters of utils.ListParams.
 func Config_list(c *gin.Context, s *service.Service) {
 	lh := s.LogHarbour
 	lh.Log("Config_list Request Received")
 
-	// Extracting etcdStorage and rigelTree from service dependency.
+	// Extracting etcdStorage from service dependency.
 
 	etcd, ok := s.Dependencies["etcd"].(*etcd.EtcdStorage)
 	if !ok {
@@ -93,48 +134,204 @@ func Config_list(c *gin.Context, s *service.Service) {
 		wscutils.SendErrorResponse(c, wscutils.NewResponse(wscutils.ErrorStatus, nil, []wscutils.ErrorMessage{wscutils.BuildErrorMessage(utils.INVALID_DEPENDENCY, &field)}))
 		return
 	}
-	r := s.Dependencies["rTree"]
-	rTree, ok := r.(*utils.Node)
-	if !ok {
-		field := "rigelTree"
-		wscutils.SendErrorResponse(c, wscutils.NewResponse(wscutils.ErrorStatus, nil, []wscutils.ErrorMessage{wscutils.BuildErrorMessage(utils.INVALID_DEPENDENCY, &field)}))
+	var params utils.ListParams
+	if err := c.ShouldBindQuery(&params); err != nil {
+		lh.Debug0().LogActivity("error while binding query:", err.Error())
+		wscutils.SendErrorResponse(c, wscutils.NewErrorResponse(wscutils.ERRCODE_INVALID_REQUEST))
+		return
+	}
+	if field, err := params.Validate(); err != nil {
+		lh.Debug0().LogActivity("invalid list parameters:", err.Error())
+		wscutils.SendErrorResponse(c, wscutils.NewResponse(wscutils.ErrorStatus, nil, []wscutils.ErrorMessage{wscutils.BuildErrorMessage(wscutils.ERRCODE_INVALID_REQUEST, &field, err.Error())}))
 		return
 	}
 
 	container := &trees.Container{
-		Etcd: etcd,
+		Etcd:   etcd,
+		Params: params,
 	}
 
-	trees.Process(rTree, container)
+	if err := trees.Process(c, container); err != nil {
+		lh.Debug0().LogActivity("error while listing configs:", err.Error())
+		wscutils.SendErrorResponse(c, wscutils.NewResponse(wscutils.ErrorStatus, nil, []wscutils.ErrorMessage{wscutils.BuildErrorMessage(wscutils.ErrcodeMissing, nil, err.Error())}))
+		return
+	}
 
-	wscutils.SendSuccessResponse(c, &wscutils.Response{Status: "success", Data: map[string]any{"configurations": container.ResponseData}, Messages: []wscutils.ErrorMessage{}})
+	data := map[string]any{"configurations": container.ResponseData, "total": container.Total}
+	if container.NextCursor != "" {
+		data["nextCursor"] = container.NextCursor
+	}
+	wscutils.SendSuccessResponse(c, &wscutils.Response{Status: "success", Data: data, Messages: []wscutils.ErrorMessage{}})
 }
 
-// bindGetConfigResponse is specifically used in Cinfig_get to bing and set the response
-func bindGetConfigResponse(response *getConfigResponse, getValue *map[string]string) {
-	fmt.Println("getValue:", getValue)
-
+// bindGetConfigResponse is specifically used in Cinfig_get to bing and set the response.
+// Keys below the config are nested by path, so the values of a group of fields, such as
+// db/host and db/port, are returned as the values of a group named db. The values of the secret
+// fields of schema are masked.
+func bindGetConfigResponse(response *ConfigResponse, queryParams *utils.GetConfigRequestParams, schema *types.Schema, keyStr string, getValue *map[string]string) {
 	for key, vals := range *getValue {
-
-		arry := strings.Split(key, "/")
-		keyStr := arry[len(arry)-1]
-		if strings.EqualFold(keyStr, "description") {
+		relPath := strings.TrimPrefix(key, keyStr+"/")
+		if relPath == layout.ConfigDescriptionKey {
 			response.Description = vals
 			continue
-		} else {
+		}
+		if relPath == layout.ParentKey {
+			response.Parent = vals
+			continue
+		}
+		if rulesPath, found := strings.CutPrefix(relPath, layout.RulesKey+"/"); found {
+			var rules []types.Rule
+			if err := json.Unmarshal([]byte(vals), &rules); err == nil && len(rules) > 0 {
+				response.Values = addValue(response.Values, strings.Split(rulesPath, "/"), ConfigValue{Rules: rules})
+			}
+			continue
+		}
+		if layout.IsReserved(relPath) {
+			// Flag states, locks and lock overrides are not values
+			continue
+		}
+		response.Values = addValue(response.Values, strings.Split(relPath, "/"), ConfigValue{Value: rigel.MaskValue(schema, layout.KeyFromPath(relPath), vals)})
 
-			response.Values = append(response.Values, values{
-				Name:  keyStr,
-				Value: vals,
-			})
+		response.App = queryParams.App
+		response.Module = queryParams.Module
+		response.Version = &queryParams.Version
+		response.Config = queryParams.Config
+	}
+	sortValues(response.Values)
+}
+
+// configGetEffective responds to a /configget request for the effective values of a config.
+func configGetEffective(c *gin.Context, s *service.Service, queryParams *utils.GetConfigRequestParams) {
+	r, ok := s.Dependencies["rigel"].(*rigel.Rigel)
+	if !ok {
+		field := "rigelClient"
+		wscutils.SendErrorResponse(c, wscutils.NewResponse(wscutils.ErrorStatus, nil, []wscutils.ErrorMessage{wscutils.BuildErrorMessage(utils.INVALID_DEPENDENCY, &field)}))
+		return
+	}
+	view := r.View(*queryParams.App, *queryParams.Module, queryParams.Version, *queryParams.Config)
+	var at *time.Time
+	if queryParams.At != "" {
+		t, err := time.Parse(time.RFC3339, queryParams.At)
+		if err != nil {
+			field := "at"
+			wscutils.SendErrorResponse(c, wscutils.NewResponse(wscutils.ErrorStatus, nil, []wscutils.ErrorMessage{wscutils.BuildErrorMessage(utils.INVALID_TIME, &field)}))
+			return
 		}
+		at = &t
+	}
+	if !timeAsked(queryParams, at, time.Now()) {
+		field := "at"
+		wscutils.SendErrorResponse(c, wscutils.NewResponse(wscutils.ErrorStatus, nil, []wscutils.ErrorMessage{wscutils.BuildErrorMessage(utils.INVALID_TIME, &field)}))
+		return
+	}
+	sendEffectiveConfig(c, s, view, at)
+}
+
+// timeAsked reports whether at, nil for the current time, is of a kind of time the Past, Current
+// and Future flags of queryParams allow. With none of the flags set, any time is allowed.
+func timeAsked(queryParams *utils.GetConfigRequestParams, at *time.Time, now time.Time) bool {
+	if !queryParams.Past && !queryParams.Current && !queryParams.Future {
+		return true
+	}
+	switch {
+	case at == nil:
+		return queryParams.Current
+	case at.After(now):
+		return queryParams.Future
+	default:
+		return queryParams.Past
+	}
+}
+
+// sendEffectiveConfig responds with the values the config of view resolves to, each with the config it came from.
+// If at is not nil, the values are those at that time, see rigel.Rigel.GetAllResolvedAt.
+func sendEffectiveConfig(c *gin.Context, s *service.Service, view *rigel.View, at *time.Time) {
+	lh := s.LogHarbour
+
+	response, err := EffectiveConfig(c, view, at)
+	if errors.Is(err, rigel.ErrPastTime) {
+		field := "at"
+		wscutils.SendErrorResponse(c, wscutils.NewResponse(wscutils.ErrorStatus, nil, []wscutils.ErrorMessage{wscutils.BuildErrorMessage(utils.INVALID_TIME, &field)}))
+		return
+	}
+	if err != nil {
+		wscutils.SendErrorResponse(c, wscutils.NewResponse(wscutils.ErrorStatus, nil, []wscutils.ErrorMessage{wscutils.BuildErrorMessage(wscutils.ErrcodeMissing, nil, err.Error())}))
+		lh.Debug0().LogActivity("error while getting effective config:", err.Error())
+		return
+	}
+	wscutils.SendSuccessResponse(c, wscutils.NewSuccessResponse(response))
+}
 
-		ver, _ := strconv.Atoi(arry[5])
-		response.App = &arry[3]
-		response.Module = &arry[4]
-		response.Version = &ver
-		response.Config = &arry[6]
+// EffectiveConfig returns the values the config of view resolves to, each with the config it came
+// from, with the values of secrets masked. If at is not nil, the values are those at that time, see
+// rigel.Rigel.GetAllResolvedAt, and ErrPastTime of package rigel is returned for a past time whose
+// values are not kept.
+// It serves /configget and the GetConfig call of the gRPC API.
+func EffectiveConfig(ctx context.Context, view *rigel.View, at *time.Time) (ConfigResponse, error) {
+	var resolved map[string]rigel.ResolvedValue
+	var err error
+	if at == nil {
+		resolved, err = view.GetAllResolved(ctx)
+	} else {
+		resolved, err = view.GetAllResolvedAt(ctx, *at)
+	}
+	if err != nil {
+		return ConfigResponse{}, err
+	}
+	schema, err := view.GetSchema(ctx)
+	if err != nil {
+		return ConfigResponse{}, fmt.Errorf("failed to get schema: %w", err)
+	}
+	parent, err := view.Parent(ctx)
+	if err != nil {
+		return ConfigResponse{}, fmt.Errorf("failed to get config parent: %w", err)
+	}
+
+	app, module, version, config := view.App(), view.Module(), view.Version(), view.Config()
+	response := ConfigResponse{
+		App:     &app,
+		Module:  &module,
+		Version: &version,
+		Config:  &config,
+		Parent:  parent,
+	}
+	for name, rv := range resolved {
+		// Secret values never leave the server
+		response.Values = addValue(response.Values, strings.Split(name, "."), ConfigValue{Value: rigel.MaskValue(schema, name, rv.Value), Source: rv.Source})
+	}
+	sortValues(response.Values)
+	return response, nil
+}
+
+// addValue adds the value leaf at the key path given by parts to vals, creating groups as needed.
+// The value and the rules of a key are added separately and merged into one leaf.
+func addValue(vals []ConfigValue, parts []string, leaf ConfigValue) []ConfigValue {
+	for i := range vals {
+		if vals[i].Name != parts[0] {
+			continue
+		}
+		if len(parts) > 1 {
+			vals[i].Values = addValue(vals[i].Values, parts[1:], leaf)
+		} else if leaf.Rules != nil {
+			vals[i].Rules = leaf.Rules
+		} else {
+			vals[i].Value = leaf.Value
+			vals[i].Source = leaf.Source
+		}
+		return vals
+	}
+	if len(parts) == 1 {
+		leaf.Name = parts[0]
+		return append(vals, leaf)
+	}
+	return append(vals, ConfigValue{Name: parts[0], Values: addValue(nil, parts[1:], leaf)})
+}
 
+// sortValues sorts values, and the values of groups, by name.
+func sortValues(vals []ConfigValue) {
+	sort.Slice(vals, func(i, j int) bool { return vals[i].Name < vals[j].Name })
+	for _, v := range vals {
+		sortValues(v.Values)
 	}
 }
 
@@ -0,0 +1,729 @@
+package configsvc
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/remiges-aniket/etcd"
+	"github.com/remiges-aniket/rigel"
+	"github.com/remiges-aniket/types"
+	"github.com/remiges-aniket/utils"
+	"github.com/remiges-tech/alya/service"
+	"github.com/remiges-tech/logharbour/logharbour"
+	"go.etcd.io/etcd/tests/v3/integration"
+)
+
+// testAdminToken is the admin token of the service created by setupService.
+const testAdminToken = "admin-token"
+
+// setupService creates a service backed by an embedded etcd server, with the config
+// handlers registered and a schema with an int field "port", a feature flag "beta" and a secret
+// "password" for each of the given apps.
+func setupService(t *testing.T, apps ...string) (*gin.Engine, *etcd.EtcdStorage) {
+	t.Helper()
+	integration.BeforeTestExternal(t)
+	clus := integration.NewClusterV3(t, &integration.ClusterConfig{Size: 1})
+	t.Cleanup(func() { clus.Terminate(t) })
+
+	etcdStorage := &etcd.EtcdStorage{Client: clus.RandClient()}
+	keyringPath := filepath.Join(t.TempDir(), "keyring.json")
+	if err := rigel.AddKeyringKey(keyringPath, "k1"); err != nil {
+		t.Fatalf("AddKeyringKey() error = %v", err)
+	}
+	keyring, err := rigel.LoadKeyring(keyringPath)
+	if err != nil {
+		t.Fatalf("LoadKeyring() error = %v", err)
+	}
+	rigelClient := rigel.NewWithStorage(etcdStorage).WithKeyring(keyring)
+
+	schema := types.Schema{
+		Version:     1,
+		Description: "test schema",
+		Fields: []types.Field{
+			{Name: "port", Type: "int"},
+			{Name: "beta", Type: "bool", Flag: true},
+			{Name: "password", Type: types.FieldTypeSecret},
+		},
+	}
+	for _, app := range apps {
+		if err := rigelClient.WithApp(app).WithModule("testModule").AddSchema(context.Background(), schema); err != nil {
+			t.Fatalf("AddSchema() error = %v", err)
+		}
+	}
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	l := logharbour.NewLogger(logharbour.NewLoggerContext(logharbour.Info), "rigel", io.Discard)
+	s := service.NewService(r).
+		WithLogHarbour(l).
+		WithDependency("etcd", etcdStorage).
+		WithDependency("rigel", rigelClient).
+		WithDependency("hub", rigel.NewHub(etcdStorage, 2)).
+		WithDependency("adminToken", testAdminToken)
+	s.RegisterRoute(http.MethodPost, "/configset", Config_set)
+	s.RegisterRoute(http.MethodPost, "/configupdate", Config_update)
+	s.RegisterRoute(http.MethodGet, "/configget", Config_get)
+	s.RegisterRoute(http.MethodPost, "/configevaluate", Config_evaluate)
+	s.RegisterRoute(http.MethodGet, "/flagget", Flag_get)
+	s.RegisterRoute(http.MethodPost, "/flagset", Flag_set)
+	s.RegisterRoute(http.MethodPost, "/configlock", Config_lock)
+	s.RegisterRoute(http.MethodPost, "/configunlock", Config_unlock)
+	s.RegisterRoute(http.MethodPost, "/configschedule", Config_schedule)
+	s.RegisterRoute(http.MethodGet, "/schedulelist", Schedule_list)
+	s.RegisterRoute(http.MethodPost, "/schedulecancel", Schedule_cancel)
+	s.RegisterRoute(http.MethodGet, "/search", Search)
+	s.RegisterRoute(http.MethodGet, "/configstream", Config_stream)
+	s.RegisterRoute(http.MethodGet, "/configwatch", Config_watch)
+
+	return r, etcdStorage
+}
+
+func TestConfigSetConcurrent(t *testing.T) {
+	apps := []string{"app0", "app1", "app2", "app3"}
+	r, etcdStorage := setupService(t, apps...)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 40; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			app := apps[i%len(apps)]
+			body, _ := json.Marshal(map[string]any{"data": map[string]any{
+				"app": app, "module": "testModule", "ver": 1, "config": "prod",
+				"key": "port", "value": 8000 + i%len(apps),
+			}})
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/configset", bytes.NewReader(body)))
+			if w.Code != http.StatusOK {
+				t.Errorf("POST /configset for %s returned %d: %s", app, w.Code, w.Body.String())
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	// Every app must only have received its own value
+	for i, app := range apps {
+		value, err := etcdStorage.Get(context.Background(), fmt.Sprintf("/remiges/rigel/%s/testModule/1/config/prod/port", app))
+		if err != nil {
+			t.Fatalf("Get() error = %v", err)
+		}
+		if want := fmt.Sprint(8000 + i); value != want {
+			t.Errorf("port of %s = %q, want %q", app, value, want)
+		}
+	}
+}
+
+func TestConfigGetEffective(t *testing.T) {
+	r, etcdStorage := setupService(t, "app0")
+	ctx := context.Background()
+
+	if err := etcdStorage.Put(ctx, "/remiges/rigel/app0/testModule/1/config/prod/port", "8080"); err != nil {
+		t.Fatalf("Put() error = %v", err)
+	}
+
+	body, _ := json.Marshal(map[string]any{"data": map[string]any{
+		"app": "app0", "module": "testModule", "ver": 1, "config": "prod-mumbai",
+		"description": "mumbai", "parent": "prod", "values": []map[string]string{},
+	}})
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/configupdate", bytes.NewReader(body)))
+	if w.Code != http.StatusOK {
+		t.Fatalf("POST /configupdate returned %d: %s", w.Code, w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/configget?app=app0&module=testModule&ver=1&config=prod-mumbai&effective=true", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("GET /configget returned %d: %s", w.Code, w.Body.String())
+	}
+	var resp struct {
+		Data ConfigResponse `json:"data"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("failed to unmarshal response: %v", err)
+	}
+	if resp.Data.Parent != "prod" {
+		t.Errorf("parent = %q, want prod", resp.Data.Parent)
+	}
+	want := []ConfigValue{{Name: "port", Value: "8080", Source: "prod"}}
+	if !reflect.DeepEqual(resp.Data.Values, want) {
+		t.Errorf("values = %+v, want %+v", resp.Data.Values, want)
+	}
+}
+
+func TestConfigEvaluate(t *testing.T) {
+	r, _ := setupService(t, "app0")
+
+	body, _ := json.Marshal(map[string]any{"data": map[string]any{
+		"app": "app0", "module": "testModule", "ver": 1, "config": "prod", "description": "prod",
+		"values": []map[string]any{{
+			"name": "port", "value": "8080",
+			"rules": []map[string]any{{"match": map[string]string{"region": "mumbai"}, "value": "9090"}},
+		}},
+	}})
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/configupdate", bytes.NewReader(body)))
+	if w.Code != http.StatusOK {
+		t.Fatalf("POST /configupdate returned %d: %s", w.Code, w.Body.String())
+	}
+
+	for region, want := range map[string]string{"mumbai": "9090", "delhi": "8080"} {
+		body, _ := json.Marshal(map[string]any{"data": map[string]any{
+			"app": "app0", "module": "testModule", "ver": 1, "config": "prod",
+			"attributes": map[string]string{"region": region},
+		}})
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/configevaluate", bytes.NewReader(body)))
+		if w.Code != http.StatusOK {
+			t.Fatalf("POST /configevaluate returned %d: %s", w.Code, w.Body.String())
+		}
+		var resp struct {
+			Data ConfigResponse `json:"data"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("failed to unmarshal response: %v", err)
+		}
+		if len(resp.Data.Values) != 1 || resp.Data.Values[0].Value != want {
+			t.Errorf("values for %s = %+v, want port %s", region, resp.Data.Values, want)
+		}
+	}
+}
+
+func TestFlagSetAndGet(t *testing.T) {
+	r, _ := setupService(t, "app0")
+
+	for rollout, wantCode := range map[int]int{150: http.StatusBadRequest, 25: http.StatusOK} {
+		body, _ := json.Marshal(map[string]any{"data": map[string]any{
+			"app": "app0", "module": "testModule", "ver": 1, "config": "prod",
+			"flag": "beta", "rollout": rollout, "allow": []string{"admin"},
+		}})
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/flagset", bytes.NewReader(body)))
+		if w.Code != wantCode {
+			t.Errorf("POST /flagset with rollout %d returned %d, want %d: %s", rollout, w.Code, wantCode, w.Body.String())
+		}
+	}
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/flagget?app=app0&module=testModule&ver=1&config=prod&flag=beta", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("GET /flagget returned %d: %s", w.Code, w.Body.String())
+	}
+	var resp struct {
+		Data flagResponse `json:"data"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("failed to unmarshal response: %v", err)
+	}
+	if !resp.Data.Set || resp.Data.Rollout != 25 || !reflect.DeepEqual(resp.Data.Allow, []string{"admin"}) {
+		t.Errorf("flag = %+v, want rollout 25 allowing admin", resp.Data)
+	}
+}
+
+func TestConfigGetMasksSecrets(t *testing.T) {
+	r, etcdStorage := setupService(t, "app0")
+
+	body, _ := json.Marshal(map[string]any{"data": map[string]any{
+		"app": "app0", "module": "testModule", "ver": 1, "config": "prod", "description": "prod",
+		"values": []map[string]any{{"name": "password", "value": "s3cret"}},
+	}})
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/configupdate", bytes.NewReader(body)))
+	if w.Code != http.StatusOK {
+		t.Fatalf("POST /configupdate returned %d: %s", w.Code, w.Body.String())
+	}
+
+	stored, err := etcdStorage.Get(context.Background(), "/remiges/rigel/app0/testModule/1/config/prod/password")
+	if err != nil || !rigel.IsEncrypted(stored) {
+		t.Errorf("stored password = %q, %v, want it encrypted", stored, err)
+	}
+
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/configget?app=app0&module=testModule&ver=1&config=prod&effective=true", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("GET /configget returned %d: %s", w.Code, w.Body.String())
+	}
+	if strings.Contains(w.Body.String(), "s3cret") || !strings.Contains(w.Body.String(), rigel.SecretMask) {
+		t.Errorf("GET /configget = %s, want the password masked", w.Body.String())
+	}
+}
+
+func TestConfigGetReadsWhatConfigUpdateWrites(t *testing.T) {
+	r, _ := setupService(t, "app0")
+
+	body, _ := json.Marshal(map[string]any{"data": map[string]any{
+		"app": "app0", "module": "testModule", "ver": 1, "config": "prod", "description": "prod",
+		"values": []map[string]any{{"name": "port", "value": "8080"}},
+	}})
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/configupdate", bytes.NewReader(body)))
+	if w.Code != http.StatusOK {
+		t.Fatalf("POST /configupdate returned %d: %s", w.Code, w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/configget?app=app0&module=testModule&ver=1&config=prod", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("GET /configget returned %d: %s", w.Code, w.Body.String())
+	}
+	var resp struct {
+		Data ConfigResponse `json:"data"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if want := []ConfigValue{{Name: "port", Value: "8080"}}; !reflect.DeepEqual(resp.Data.Values, want) {
+		t.Errorf("values = %+v, want %+v", resp.Data.Values, want)
+	}
+}
+
+func TestConfigUpdateAllOrNothing(t *testing.T) {
+	r, etcdStorage := setupService(t, "app0")
+
+	// The second value is not an int, so the first is not set either
+	body, _ := json.Marshal(map[string]any{"data": map[string]any{
+		"app": "app0", "module": "testModule", "ver": 1, "config": "prod", "description": "prod",
+		"values": []map[string]any{{"name": "password", "value": "s3cret"}, {"name": "port", "value": "eighty"}},
+	}})
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/configupdate", bytes.NewReader(body)))
+	if w.Code == http.StatusOK {
+		t.Fatalf("POST /configupdate of an invalid value returned %d: %s", w.Code, w.Body.String())
+	}
+	kvs, err := etcdStorage.GetWithPrefix(context.Background(), utils.RIGELPREFIX+"/app0/testModule/1/config/")
+	if err != nil {
+		t.Fatalf("GetWithPrefix() error = %v", err)
+	}
+	if len(kvs) != 0 {
+		t.Errorf("POST /configupdate of an invalid value wrote %v", kvs)
+	}
+}
+
+func TestConfigLock(t *testing.T) {
+	r, _ := setupService(t, "app0")
+
+	post := func(path string, data map[string]any, admin bool) *httptest.ResponseRecorder {
+		body, _ := json.Marshal(map[string]any{"data": data})
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, path, bytes.NewReader(body))
+		if admin {
+			req.Header.Set("Authorization", "Bearer "+testAdminToken)
+		}
+		r.ServeHTTP(w, req)
+		return w
+	}
+	set := map[string]any{"app": "app0", "module": "testModule", "ver": 1, "config": "prod", "key": "port", "value": 8080}
+	lock := map[string]any{"app": "app0", "module": "testModule", "reason": "month-end"}
+
+	// Only admins manage locks
+	if w := post("/configlock", lock, false); w.Code == http.StatusOK || !strings.Contains(w.Body.String(), utils.NOT_AUTHORIZED) {
+		t.Errorf("POST /configlock without the admin token = %d %s, want %s", w.Code, w.Body.String(), utils.NOT_AUTHORIZED)
+	}
+	if w := post("/configlock", lock, true); w.Code != http.StatusOK {
+		t.Fatalf("POST /configlock returned %d: %s", w.Code, w.Body.String())
+	}
+	if w := post("/configset", set, false); w.Code == http.StatusOK || !strings.Contains(w.Body.String(), utils.CONFIG_LOCKED) {
+		t.Errorf("POST /configset = %d %s, want %s", w.Code, w.Body.String(), utils.CONFIG_LOCKED)
+	}
+	update := map[string]any{
+		"app": "app0", "module": "testModule", "ver": 1, "config": "prod", "description": "prod",
+		"values": []map[string]any{{"name": "port", "value": "8080"}},
+	}
+	if w := post("/configupdate", update, false); w.Code == http.StatusOK || !strings.Contains(w.Body.String(), utils.CONFIG_LOCKED) {
+		t.Errorf("POST /configupdate = %d %s, want %s", w.Code, w.Body.String(), utils.CONFIG_LOCKED)
+	}
+
+	set["override"] = true
+	set["overrideReason"] = "wrong port"
+	if w := post("/configset", set, false); w.Code == http.StatusOK || !strings.Contains(w.Body.String(), utils.NOT_AUTHORIZED) {
+		t.Errorf("POST /configset with override without the admin token = %d %s, want %s", w.Code, w.Body.String(), utils.NOT_AUTHORIZED)
+	}
+	if w := post("/configset", set, true); w.Code != http.StatusOK {
+		t.Errorf("POST /configset with override returned %d: %s", w.Code, w.Body.String())
+	}
+
+	if w := post("/configunlock", map[string]any{"app": "app0", "module": "testModule"}, true); w.Code != http.StatusOK {
+		t.Fatalf("POST /configunlock returned %d: %s", w.Code, w.Body.String())
+	}
+	if w := post("/configupdate", update, false); w.Code != http.StatusOK {
+		t.Errorf("POST /configupdate after unlock returned %d: %s", w.Code, w.Body.String())
+	}
+}
+
+func TestConfigSchedule(t *testing.T) {
+	r, _ := setupService(t, "app0")
+
+	post := func(path string, data map[string]any) *httptest.ResponseRecorder {
+		body, _ := json.Marshal(map[string]any{"data": data})
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, path, bytes.NewReader(body)))
+		return w
+	}
+	get := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		if w.Code != http.StatusOK {
+			t.Fatalf("GET %s returned %d: %s", path, w.Code, w.Body.String())
+		}
+		return w
+	}
+
+	if w := post("/configset", map[string]any{"app": "app0", "module": "testModule", "ver": 1, "config": "prod", "key": "port", "value": 8080}); w.Code != http.StatusOK {
+		t.Fatalf("POST /configset returned %d: %s", w.Code, w.Body.String())
+	}
+	setAt := url.QueryEscape(time.Now().UTC().Format(time.RFC3339Nano))
+
+	effectiveAt := time.Now().Add(time.Hour).UTC()
+	w := post("/configschedule", map[string]any{
+		"app": "app0", "module": "testModule", "ver": 1, "config": "prod",
+		"effectiveAt": effectiveAt, "values": []map[string]any{{"name": "port", "value": "9090"}},
+	})
+	if w.Code != http.StatusOK {
+		t.Fatalf("POST /configschedule returned %d: %s", w.Code, w.Body.String())
+	}
+	var scheduled struct {
+		Data types.ScheduledChange `json:"data"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &scheduled); err != nil || scheduled.Data.ID == "" {
+		t.Fatalf("POST /configschedule = %s, want the scheduled change", w.Body.String())
+	}
+
+	var list struct {
+		Data struct {
+			Changes []types.ScheduledChange `json:"changes"`
+		} `json:"data"`
+	}
+	w = get("/schedulelist?app=app0")
+	if err := json.Unmarshal(w.Body.Bytes(), &list); err != nil || len(list.Data.Changes) != 1 || list.Data.Changes[0].ID != scheduled.Data.ID {
+		t.Errorf("GET /schedulelist = %s, want the pending change", w.Body.String())
+	}
+
+	at := url.QueryEscape(effectiveAt.Add(time.Minute).Format(time.RFC3339))
+	w = get("/configget?app=app0&module=testModule&ver=1&config=prod&at=" + at)
+	var resp struct {
+		Data ConfigResponse `json:"data"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil || len(resp.Data.Values) != 1 || resp.Data.Values[0].Value != "9090" {
+		t.Errorf("GET /configget at %s = %s, want port 9090", at, w.Body.String())
+	}
+	// The flags restrict the kind of time asked for
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/configget?app=app0&module=testModule&ver=1&config=prod&past=true&at="+at, nil))
+	if !strings.Contains(w.Body.String(), utils.INVALID_TIME) {
+		t.Errorf("GET /configget?past=true at a future time = %s, want %s", w.Body.String(), utils.INVALID_TIME)
+	}
+
+	// Past values are read from the history of the storage, back to the first write of a value
+	w = get("/configget?app=app0&module=testModule&ver=1&config=prod&past=true&at=" + setAt)
+	resp.Data = ConfigResponse{}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil || len(resp.Data.Values) != 1 || resp.Data.Values[0].Value != "8080" {
+		t.Errorf("GET /configget at %s = %s, want port 8080", setAt, w.Body.String())
+	}
+	past := url.QueryEscape(time.Now().Add(-time.Hour).UTC().Format(time.RFC3339))
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/configget?app=app0&module=testModule&ver=1&config=prod&at="+past, nil))
+	if !strings.Contains(w.Body.String(), utils.INVALID_TIME) {
+		t.Errorf("GET /configget before the first write = %s, want %s", w.Body.String(), utils.INVALID_TIME)
+	}
+
+	if w := post("/schedulecancel", map[string]any{"id": scheduled.Data.ID}); w.Code != http.StatusOK {
+		t.Fatalf("POST /schedulecancel returned %d: %s", w.Code, w.Body.String())
+	}
+	if w := post("/schedulecancel", map[string]any{"id": scheduled.Data.ID}); !strings.Contains(w.Body.String(), utils.CHANGE_NOT_PENDING) {
+		t.Errorf("POST /schedulecancel twice = %s, want %s", w.Body.String(), utils.CHANGE_NOT_PENDING)
+	}
+	w = get("/schedulelist?app=app0")
+	if err := json.Unmarshal(w.Body.Bytes(), &list); err != nil || len(list.Data.Changes) != 0 {
+		t.Errorf("GET /schedulelist = %s, want no pending change", w.Body.String())
+	}
+	w = get("/schedulelist?app=app0&past=true")
+	if err := json.Unmarshal(w.Body.Bytes(), &list); err != nil || len(list.Data.Changes) != 1 || list.Data.Changes[0].Status != types.ScheduleCancelled {
+		t.Errorf("GET /schedulelist?past=true = %s, want the cancelled change", w.Body.String())
+	}
+}
+
+func TestSearch(t *testing.T) {
+	r, _ := setupService(t, "app0", "app1")
+
+	for app, port := range map[string]string{"app0": "8080", "app1": "443"} {
+		body, _ := json.Marshal(map[string]any{"data": map[string]any{
+			"app": app, "module": "testModule", "ver": 1, "config": "prod", "description": "prod",
+			"values": []map[string]any{{"name": "port", "value": port}, {"name": "password", "value": "s3cret-" + port}},
+		}})
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/configupdate", bytes.NewReader(body)))
+		if w.Code != http.StatusOK {
+			t.Fatalf("POST /configupdate returned %d: %s", w.Code, w.Body.String())
+		}
+	}
+
+	get := func(query url.Values) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/search?"+query.Encode(), nil))
+		return w
+	}
+	w := get(url.Values{"compare": {">1000"}})
+	var resp struct {
+		Data struct {
+			Results []rigel.SearchResult `json:"results"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("failed to decode response %s: %v", w.Body.String(), err)
+	}
+	want := []rigel.SearchResult{{App: "app0", Module: "testModule", Version: 1, Config: "prod", Key: "port", Value: "8080", Type: "int"}}
+	if !reflect.DeepEqual(resp.Data.Results, want) {
+		t.Errorf("GET /search?compare=>1000 = %+v, want %+v", resp.Data.Results, want)
+	}
+
+	// Secrets never match, even by key
+	for _, query := range []url.Values{{"valueregex": {"s3cret"}}, {"key": {"pass*"}}, {"value": {"enc:"}}} {
+		if w := get(query); w.Code != http.StatusOK || strings.Contains(w.Body.String(), "password") {
+			t.Errorf("GET /search?%s = %d %s, want no secret", query.Encode(), w.Code, w.Body.String())
+		}
+	}
+
+	for _, query := range []url.Values{{}, {"keyregex": {"("}}, {"compare": {"~5"}}} {
+		if w := get(query); w.Code != http.StatusBadRequest {
+			t.Errorf("GET /search?%s = %d %s, want %d", query.Encode(), w.Code, w.Body.String(), http.StatusBadRequest)
+		}
+	}
+}
+
+// sseEvent is an event read from a Server-Sent Events stream.
+type sseEvent struct {
+	id, event, data string
+}
+
+// openStream opens a /configstream of the server at url with the given query and Last-Event-ID,
+// and returns a channel of its events, which is closed when the stream ends.
+func openStream(t *testing.T, url string, query string, lastEventID string) (<-chan sseEvent, func()) {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, url+"/configstream?"+query, nil)
+	if lastEventID != "" {
+		req.Header.Set("Last-Event-ID", lastEventID)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		cancel()
+		t.Fatalf("GET /configstream error = %v", err)
+	}
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "text/event-stream" {
+		cancel()
+		t.Fatalf("GET /configstream returned %d %s", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+
+	events := make(chan sseEvent, 100)
+	go func() {
+		defer close(events)
+		defer resp.Body.Close()
+		scanner := bufio.NewScanner(resp.Body)
+		var e sseEvent
+		for scanner.Scan() {
+			line := scanner.Text()
+			switch {
+			case line == "":
+				if e.event != "" {
+					events <- e
+				}
+				e = sseEvent{}
+			case strings.HasPrefix(line, "id: "):
+				e.id = strings.TrimPrefix(line, "id: ")
+			case strings.HasPrefix(line, "event: "):
+				e.event = strings.TrimPrefix(line, "event: ")
+			case strings.HasPrefix(line, "data: "):
+				e.data = strings.TrimPrefix(line, "data: ")
+			}
+		}
+	}()
+	return events, cancel
+}
+
+// nextChange returns the next event of events that is not about the description of a config.
+func nextChange(t *testing.T, events <-chan sseEvent) (sseEvent, StreamChange) {
+	t.Helper()
+	for {
+		select {
+		case e, ok := <-events:
+			if !ok {
+				t.Fatalf("stream ended")
+			}
+			var change StreamChange
+			if err := json.Unmarshal([]byte(e.data), &change); err != nil {
+				t.Fatalf("failed to decode event %+v: %v", e, err)
+			}
+			if change.Kind == "description" {
+				continue
+			}
+			return e, change
+		case <-time.After(5 * time.Second):
+			t.Fatalf("no event received")
+		}
+	}
+}
+
+func TestConfigStream(t *testing.T) {
+	r, _ := setupService(t, "app0")
+	srv := httptest.NewServer(r)
+	defer srv.Close()
+
+	update := func(name, value string) {
+		body, _ := json.Marshal(map[string]any{"data": map[string]any{
+			"app": "app0", "module": "testModule", "ver": 1, "config": "prod", "description": "prod",
+			"values": []map[string]any{{"name": name, "value": value}},
+		}})
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/configupdate", bytes.NewReader(body)))
+		if w.Code != http.StatusOK {
+			t.Fatalf("POST /configupdate returned %d: %s", w.Code, w.Body.String())
+		}
+	}
+	const query = "app=app0&module=testModule&ver=1&config=prod"
+
+	events, stop := openStream(t, srv.URL, query, "")
+	// Give the watch time to be created before changing the config
+	time.Sleep(100 * time.Millisecond)
+	update("port", "8080")
+	e, change := nextChange(t, events)
+	if e.event != "put" || change.Kind != "value" || change.Key != "port" || change.Value != "8080" || e.id != fmt.Sprint(change.Revision) {
+		t.Errorf("event = %+v %+v, want a put of port 8080", e, change)
+	}
+	update("password", "s3cret")
+	if _, change := nextChange(t, events); change.Key != "password" || change.Value != rigel.SecretMask {
+		t.Errorf("event = %+v, want the password masked", change)
+	}
+	lastID := e.id
+	stop()
+
+	// A client that reconnects gets the changes it missed
+	update("port", "9090")
+	events, stop = openStream(t, srv.URL, query, lastID)
+	defer stop()
+	if _, change := nextChange(t, events); change.Key != "password" {
+		t.Errorf("first event after resuming = %+v, want the password change", change)
+	}
+	if _, change := nextChange(t, events); change.Key != "port" || change.Value != "9090" {
+		t.Errorf("event after resuming = %+v, want port 9090", change)
+	}
+
+	update("port", "8888")
+	if _, change := nextChange(t, events); change.Value != "8888" {
+		t.Errorf("event = %+v, want port 8888", change)
+	}
+
+	// The server keeps two changes, so a client resuming from further back gets a snapshot
+	resumed, stopResumed := openStream(t, srv.URL, query, lastID)
+	defer stopResumed()
+	select {
+	case e := <-resumed:
+		var snapshot streamSnapshot
+		if err := json.Unmarshal([]byte(e.data), &snapshot); err != nil || e.event != "snapshot" {
+			t.Fatalf("event = %+v, %v, want a snapshot", e, err)
+		}
+		want := map[string]string{"port": "8888", "password": rigel.SecretMask}
+		for _, v := range snapshot.Values {
+			if v.Kind == "value" && want[v.Key] != v.Value {
+				t.Errorf("snapshot value %s = %s, want %s", v.Key, v.Value, want[v.Key])
+			}
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("no snapshot received")
+	}
+
+	// Both clients of the config share one watch
+	update("port", "7070")
+	for _, stream := range []<-chan sseEvent{events, resumed} {
+		if _, change := nextChange(t, stream); change.Value != "7070" {
+			t.Errorf("event = %+v, want port 7070", change)
+		}
+	}
+}
+
+func TestConfigWatch(t *testing.T) {
+	r, _ := setupService(t, "app0")
+
+	update := func(values ...map[string]any) {
+		body, _ := json.Marshal(map[string]any{"data": map[string]any{
+			"app": "app0", "module": "testModule", "ver": 1, "config": "prod", "description": "prod", "values": values,
+		}})
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/configupdate", bytes.NewReader(body)))
+		if w.Code != http.StatusOK {
+			t.Fatalf("POST /configupdate returned %d: %s", w.Code, w.Body.String())
+		}
+	}
+	poll := func(rev int64, timeout int) configWatchResponse {
+		t.Helper()
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, fmt.Sprintf("/configwatch?app=app0&module=testModule&ver=1&config=prod&rev=%d&timeout=%d", rev, timeout), nil))
+		if w.Code != http.StatusOK {
+			t.Fatalf("GET /configwatch returned %d: %s", w.Code, w.Body.String())
+		}
+		var resp struct {
+			Data configWatchResponse `json:"data"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("failed to decode response %s: %v", w.Body.String(), err)
+		}
+		return resp.Data
+	}
+	values := func(resp configWatchResponse) map[string]string {
+		kvs := make(map[string]string)
+		for _, c := range resp.Changes {
+			if c.Kind == "value" {
+				kvs[c.Key] = c.Value
+			}
+		}
+		return kvs
+	}
+
+	update(map[string]any{"name": "port", "value": "8080"})
+	first := poll(0, 1)
+	if !first.Reset || first.Revision == 0 || !reflect.DeepEqual(values(first), map[string]string{"port": "8080"}) {
+		t.Fatalf("first poll = %+v, want all values", first)
+	}
+
+	// Changes made between polls are not missed
+	update(map[string]any{"name": "port", "value": "9090"}, map[string]any{"name": "password", "value": "s3cret"})
+	next := poll(first.Revision, 1)
+	if next.Reset || next.Revision <= first.Revision || !reflect.DeepEqual(values(next), map[string]string{"port": "9090", "password": rigel.SecretMask}) {
+		t.Errorf("poll after changes = %+v, want port and the masked password", next)
+	}
+
+	// A poll blocks until a change arrives
+	go func() {
+		time.Sleep(200 * time.Millisecond)
+		update(map[string]any{"name": "port", "value": "7070"})
+	}()
+	start := time.Now()
+	blocked := poll(next.Revision, 5)
+	if time.Since(start) < 100*time.Millisecond || !reflect.DeepEqual(values(blocked), map[string]string{"port": "7070"}) {
+		t.Errorf("blocking poll = %+v after %v, want port 7070 once it changed", blocked, time.Since(start))
+	}
+
+	// Without changes, the poll times out with the same revision
+	if idle := poll(blocked.Revision, 1); len(idle.Changes) != 0 || idle.Revision != blocked.Revision {
+		t.Errorf("idle poll = %+v, want no changes at revision %d", idle, blocked.Revision)
+	}
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/configwatch?app=app0&module=testModule&ver=1&config=prod&timeout=100000", nil))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("GET /configwatch with a long timeout returned %d, want %d", w.Code, http.StatusBadRequest)
+	}
+}
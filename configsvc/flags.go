@@ -0,0 +1,127 @@
+package configsvc
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
+	"github.com/remiges-aniket/rigel"
+	"github.com/remiges-aniket/types"
+	"github.com/remiges-aniket/utils"
+	"github.com/remiges-tech/alya/service"
+	"github.com/remiges-tech/alya/wscutils"
+	"github.com/remiges-tech/logharbour/logharbour"
+)
+
+type flagget struct {
+	App    string `form:"app" binding:"required"`
+	Module string `form:"module" binding:"required"`
+	Ver    int    `form:"ver" binding:"required"`
+	Config string `form:"config" binding:"required"`
+	Flag   string `form:"flag" binding:"required"`
+}
+
+type flagset struct {
+	App     string   `json:"app" validate:"required"`
+	Module  string   `json:"module" validate:"required"`
+	Ver     int      `json:"ver" validate:"required"`
+	Config  string   `json:"config" validate:"required"`
+	Flag    string   `json:"flag" validate:"required"`
+	Rollout *int     `json:"rollout" validate:"required,min=0,max=100"`
+	Allow   []string `json:"allow,omitempty"`
+	Deny    []string `json:"deny,omitempty"`
+}
+
+type flagResponse struct {
+	App    string `json:"app"`
+	Module string `json:"module"`
+	Ver    int    `json:"ver"`
+	Config string `json:"config"`
+	Flag   string `json:"flag"`
+	Set    bool   `json:"set"` // Set is false if the flag has no rollout state and follows its value
+	types.FlagState
+}
+
+// Flag_get handles the GET /flagget request. It returns the rollout state of a feature flag of a config.
+func Flag_get(c *gin.Context, s *service.Service) {
+	l := s.LogHarbour
+	l.Log("Starting execution of Flag_get()")
+
+	var flagget flagget
+	if err := c.ShouldBindQuery(&flagget); err != nil {
+		l.LogActivity("error while binding query", err)
+		wscutils.SendErrorResponse(c, wscutils.NewErrorResponse(wscutils.ERRCODE_INVALID_REQUEST))
+		return
+	}
+
+	r, ok := rigelDependency(c, s)
+	if !ok {
+		return
+	}
+	view := r.View(flagget.App, flagget.Module, flagget.Ver, flagget.Config)
+
+	state, set, err := view.GetFlag(c, flagget.Flag)
+	if err != nil {
+		l.LogActivity("error while getting flag state:", err)
+		wscutils.SendErrorResponse(c, wscutils.NewResponse(wscutils.ErrorStatus, nil, []wscutils.ErrorMessage{wscutils.BuildErrorMessage(wscutils.ErrcodeMissing, nil, err.Error())}))
+		return
+	}
+	wscutils.SendSuccessResponse(c, wscutils.NewSuccessResponse(flagResponse{
+		App:       flagget.App,
+		Module:    flagget.Module,
+		Ver:       flagget.Ver,
+		Config:    flagget.Config,
+		Flag:      flagget.Flag,
+		Set:       set,
+		FlagState: state,
+	}))
+}
+
+// Flag_set handles the POST /flagset request. It sets the rollout state of a feature flag of a config.
+func Flag_set(c *gin.Context, s *service.Service) {
+	l := s.LogHarbour
+	l.Log("Starting execution of Flag_set()")
+
+	var flagset flagset
+	err := wscutils.BindJSON(c, &flagset)
+	if err != nil {
+		l.LogActivity("error while binding json", err)
+		return
+	}
+
+	validationErrors := wscutils.WscValidate(flagset, flagset.getVals)
+	if len(validationErrors) > 0 {
+		l.LogDebug("Validation errors:", logharbour.DebugInfo{Variables: map[string]any{"validationErrors": validationErrors}})
+		wscutils.SendErrorResponse(c, wscutils.NewResponse(wscutils.ErrorStatus, nil, validationErrors))
+		return
+	}
+
+	r, ok := rigelDependency(c, s)
+	if !ok {
+		return
+	}
+	view := r.View(flagset.App, flagset.Module, flagset.Ver, flagset.Config)
+
+	state := types.FlagState{Rollout: *flagset.Rollout, Allow: flagset.Allow, Deny: flagset.Deny}
+	if err := view.SetFlag(c, flagset.Flag, state); err != nil {
+		l.LogActivity("error while setting flag state:", err)
+		wscutils.SendErrorResponse(c, wscutils.NewErrorResponse(WriteErrorCode(err, "unable_to_set")))
+		return
+	}
+	wscutils.SendSuccessResponse(c, &wscutils.Response{Status: wscutils.SuccessStatus, Data: "flag set successfully", Messages: []wscutils.ErrorMessage{}})
+}
+
+// getVals returns validation error details based on the field and tag.
+func (flag *flagset) getVals(err validator.FieldError) []string {
+	return nil
+}
+
+// rigelDependency returns the Rigel client of the service, responding with an error if it has none.
+func rigelDependency(c *gin.Context, s *service.Service) (*rigel.Rigel, bool) {
+	rigelClient := s.Dependencies["rigel"]
+	r, ok := rigelClient.(*rigel.Rigel)
+	if !ok {
+		str := "rigelClient"
+		s.LogHarbour.Debug0().LogDebug("Invalid Rigel Client Dependency:", logharbour.DebugInfo{Variables: map[string]any{"rigelClient": rigelClient}})
+		wscutils.SendErrorResponse(c, wscutils.NewResponse(wscutils.ErrorStatus, nil, []wscutils.ErrorMessage{wscutils.BuildErrorMessage(utils.INVALID_DEPENDENCY, &str)}))
+	}
+	return r, ok
+}
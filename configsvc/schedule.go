@@ -0,0 +1,200 @@
+package configsvc
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
+	"github.com/remiges-aniket/rigel"
+	"github.com/remiges-aniket/types"
+	"github.com/remiges-aniket/utils"
+	"github.com/remiges-tech/alya/service"
+	"github.com/remiges-tech/alya/wscutils"
+	"github.com/remiges-tech/logharbour/logharbour"
+)
+
+type configschedule struct {
+	App         string    `json:"app" validate:"required"`
+	Module      string    `json:"module" validate:"required"`
+	Ver         int       `json:"ver" validate:"required"`
+	Config      string    `json:"config" validate:"required"`
+	EffectiveAt time.Time `json:"effectiveAt" validate:"required"`
+	Reason      string    `json:"reason,omitempty"`
+	Values      []struct {
+		Name  string `json:"name" validate:"required"`
+		Value string `json:"value" validate:"required"`
+	} `json:"values" validate:"required"`
+	lockOverride
+}
+
+// schedulelist filters the scheduled changes listed. Future lists the pending changes, Past the
+// applied, cancelled and failed ones; without either, the pending changes are listed.
+type schedulelist struct {
+	App    string `form:"app"`
+	Module string `form:"module"`
+	Ver    int    `form:"ver"`
+	Config string `form:"config"`
+	Past   bool   `form:"past"`
+	Future bool   `form:"future"`
+}
+
+type schedulecancel struct {
+	ID string `json:"id" validate:"required"`
+}
+
+// Config_schedule handles the POST /configschedule request. It schedules a set of values of a
+// config to be set at effectiveAt and returns the scheduled change.
+func Config_schedule(c *gin.Context, s *service.Service) {
+	l := s.LogHarbour
+	l.Log("Starting execution of Config_schedule()")
+
+	var configschedule configschedule
+	if err := wscutils.BindJSON(c, &configschedule); err != nil {
+		l.LogActivity("error while binding json", err)
+		return
+	}
+	validationErrors := wscutils.WscValidate(configschedule, configschedule.getVals)
+	if len(validationErrors) > 0 {
+		l.LogDebug("Validation errors:", logharbour.DebugInfo{Variables: map[string]any{"validationErrors": validationErrors}})
+		wscutils.SendErrorResponse(c, wscutils.NewResponse(wscutils.ErrorStatus, nil, validationErrors))
+		return
+	}
+
+	r, ok := rigelDependency(c, s)
+	if !ok {
+		return
+	}
+	view, ok := configschedule.apply(c, s, r.View(configschedule.App, configschedule.Module, configschedule.Ver, configschedule.Config))
+	if !ok {
+		return
+	}
+
+	values := make(map[string]string, len(configschedule.Values))
+	for _, v := range configschedule.Values {
+		values[v.Name] = v.Value
+	}
+	change, err := view.ScheduleChange(c, configschedule.EffectiveAt, values, configschedule.Reason)
+	if err != nil {
+		l.LogActivity("error while scheduling change:", err)
+		wscutils.SendErrorResponse(c, wscutils.NewErrorResponse(WriteErrorCode(err, "unable_to_set")))
+		return
+	}
+	wscutils.SendSuccessResponse(c, wscutils.NewSuccessResponse(maskChange(c, r, change)))
+}
+
+// Schedule_list handles the GET /schedulelist request. It lists the scheduled changes, optionally
+// of one app, module, version or config, in the order they take effect.
+func Schedule_list(c *gin.Context, s *service.Service) {
+	l := s.LogHarbour
+	l.Log("Starting execution of Schedule_list()")
+
+	var schedulelist schedulelist
+	if err := c.ShouldBindQuery(&schedulelist); err != nil {
+		l.LogActivity("error while binding query", err)
+		wscutils.SendErrorResponse(c, wscutils.NewErrorResponse(wscutils.ERRCODE_INVALID_REQUEST))
+		return
+	}
+
+	r, ok := rigelDependency(c, s)
+	if !ok {
+		return
+	}
+	changes, err := r.ScheduledChanges(c)
+	if err != nil {
+		l.LogActivity("error while listing scheduled changes:", err)
+		wscutils.SendErrorResponse(c, wscutils.NewResponse(wscutils.ErrorStatus, nil, []wscutils.ErrorMessage{wscutils.BuildErrorMessage(wscutils.ErrcodeMissing, nil, err.Error())}))
+		return
+	}
+
+	listed := []types.ScheduledChange{}
+	for _, change := range changes {
+		if schedulelist.matches(change) {
+			listed = append(listed, maskChange(c, r, change))
+		}
+	}
+	wscutils.SendSuccessResponse(c, wscutils.NewSuccessResponse(map[string]any{"changes": listed}))
+}
+
+// Schedule_cancel handles the POST /schedulecancel request. It cancels a pending scheduled change.
+func Schedule_cancel(c *gin.Context, s *service.Service) {
+	l := s.LogHarbour
+	l.Log("Starting execution of Schedule_cancel()")
+
+	var schedulecancel schedulecancel
+	if err := wscutils.BindJSON(c, &schedulecancel); err != nil {
+		l.LogActivity("error while binding json", err)
+		return
+	}
+	validationErrors := wscutils.WscValidate(schedulecancel, schedulecancel.getVals)
+	if len(validationErrors) > 0 {
+		l.LogDebug("Validation errors:", logharbour.DebugInfo{Variables: map[string]any{"validationErrors": validationErrors}})
+		wscutils.SendErrorResponse(c, wscutils.NewResponse(wscutils.ErrorStatus, nil, validationErrors))
+		return
+	}
+
+	r, ok := rigelDependency(c, s)
+	if !ok {
+		return
+	}
+	if err := r.CancelScheduledChange(c, schedulecancel.ID); err != nil {
+		l.LogActivity("error while cancelling scheduled change:", err)
+		code := "unable_to_set"
+		switch {
+		case errors.Is(err, rigel.ErrChangeNotFound):
+			code = utils.CHANGE_NOT_FOUND
+		case errors.Is(err, rigel.ErrChangeNotPending):
+			code = utils.CHANGE_NOT_PENDING
+		}
+		wscutils.SendErrorResponse(c, wscutils.NewErrorResponse(code))
+		return
+	}
+	wscutils.SendSuccessResponse(c, &wscutils.Response{Status: wscutils.SuccessStatus, Data: "cancelled successfully", Messages: []wscutils.ErrorMessage{}})
+}
+
+// matches reports whether change passes the filters of the request.
+func (f schedulelist) matches(change types.ScheduledChange) bool {
+	if (f.App != "" && change.App != f.App) || (f.Module != "" && change.Module != f.Module) ||
+		(f.Ver != 0 && change.Version != f.Ver) || (f.Config != "" && change.Config != f.Config) {
+		return false
+	}
+	pending := change.Status == types.SchedulePending
+	if !f.Past && !f.Future {
+		return pending
+	}
+	return (f.Future && pending) || (f.Past && !pending)
+}
+
+// maskChange returns change with the values of the secret fields of its schema, read with r,
+// masked. If the schema cannot be read, all the values are masked.
+func maskChange(ctx context.Context, r *rigel.Rigel, change types.ScheduledChange) types.ScheduledChange {
+	schema, err := r.View(change.App, change.Module, change.Version, "").GetSchema(ctx)
+	mask := func(values map[string]string) map[string]string {
+		masked := make(map[string]string, len(values))
+		for name, value := range values {
+			if err != nil {
+				masked[name] = rigel.SecretMask
+			} else {
+				masked[name] = rigel.MaskValue(schema, name, value)
+			}
+		}
+		return masked
+	}
+	masked := change
+	masked.Values = mask(change.Values)
+	if change.Previous != nil {
+		masked.Previous = mask(change.Previous)
+	}
+	return masked
+}
+
+// getVals returns validation error details based on the field and tag.
+func (config *configschedule) getVals(err validator.FieldError) []string {
+	return nil
+}
+
+// getVals returns validation error details based on the field and tag.
+func (config *schedulecancel) getVals(err validator.FieldError) []string {
+	return nil
+}
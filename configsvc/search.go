@@ -0,0 +1,95 @@
+package configsvc
+
+import (
+	"errors"
+	"path"
+	"regexp"
+
+	"github.com/gin-gonic/gin"
+	"github.com/remiges-aniket/rigel"
+	"github.com/remiges-tech/alya/service"
+	"github.com/remiges-tech/alya/wscutils"
+)
+
+var errNoCriteria = errors.New("at least one of key, keyregex, value, valueregex and compare is needed")
+
+// search holds the query parameters of /search. Key is a glob and KeyRegex a regular expression
+// matching config keys; Value is a substring and ValueRegex a regular expression matching values;
+// Compare is a numeric comparison, such as ">500", matching the values of int and float fields.
+// At least one of them must be given. App, Module, Ver and Config restrict the search.
+type search struct {
+	App        string `form:"app"`
+	Module     string `form:"module"`
+	Ver        int    `form:"ver"`
+	Config     string `form:"config"`
+	Key        string `form:"key"`
+	KeyRegex   string `form:"keyregex"`
+	Value      string `form:"value"`
+	ValueRegex string `form:"valueregex"`
+	Compare    string `form:"compare"`
+}
+
+// Search handles the GET /search request. It returns the values of the named configs of all
+// apps that pass the criteria of the request, each with its app, module, version and config.
+// The values of secret fields are never searched.
+func Search(c *gin.Context, s *service.Service) {
+	l := s.LogHarbour
+	l.Log("Starting execution of Search()")
+
+	var search search
+	if err := c.ShouldBindQuery(&search); err != nil {
+		l.LogActivity("error while binding query", err)
+		wscutils.SendErrorResponse(c, wscutils.NewErrorResponse(wscutils.ERRCODE_INVALID_REQUEST))
+		return
+	}
+	query, field, err := search.query()
+	if err != nil {
+		l.LogActivity("invalid search:", err.Error())
+		wscutils.SendErrorResponse(c, wscutils.NewResponse(wscutils.ErrorStatus, nil, []wscutils.ErrorMessage{wscutils.BuildErrorMessage(wscutils.ERRCODE_INVALID_REQUEST, &field, err.Error())}))
+		return
+	}
+
+	r, ok := rigelDependency(c, s)
+	if !ok {
+		return
+	}
+	results, err := rigel.Search(c, r.Storage, query)
+	if err != nil {
+		l.LogActivity("error while searching configs:", err)
+		wscutils.SendErrorResponse(c, wscutils.NewResponse(wscutils.ErrorStatus, nil, []wscutils.ErrorMessage{wscutils.BuildErrorMessage(wscutils.ErrcodeMissing, nil, err.Error())}))
+		return
+	}
+	wscutils.SendSuccessResponse(c, wscutils.NewSuccessResponse(map[string]any{"results": results}))
+}
+
+// query converts the parameters to a rigel.SearchQuery, returning the name of the first invalid one.
+func (p search) query() (rigel.SearchQuery, string, error) {
+	q := rigel.SearchQuery{App: p.App, Module: p.Module, Version: p.Ver, Config: p.Config, Key: p.Key, Value: p.Value}
+	if p.Key == "" && p.KeyRegex == "" && p.Value == "" && p.ValueRegex == "" && p.Compare == "" {
+		return q, "key", errNoCriteria
+	}
+	var err error
+	if p.Key != "" {
+		if _, err = path.Match(p.Key, ""); err != nil {
+			return q, "key", err
+		}
+	}
+	if p.KeyRegex != "" {
+		if q.KeyRegexp, err = regexp.Compile(p.KeyRegex); err != nil {
+			return q, "keyregex", err
+		}
+	}
+	if p.ValueRegex != "" {
+		if q.ValueRegexp, err = regexp.Compile(p.ValueRegex); err != nil {
+			return q, "valueregex", err
+		}
+	}
+	if p.Compare != "" {
+		compare, err := rigel.ParseComparison(p.Compare)
+		if err != nil {
+			return q, "compare", err
+		}
+		q.Compare = &compare
+	}
+	return q, "", nil
+}
@@ -0,0 +1,245 @@
+package configsvc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/remiges-aniket/layout"
+	"github.com/remiges-aniket/rigel"
+	"github.com/remiges-aniket/utils"
+	"github.com/remiges-tech/alya/service"
+	"github.com/remiges-tech/alya/wscutils"
+	"github.com/remiges-tech/logharbour/logharbour"
+)
+
+// configstream names what a stream follows, as for /configlock: the app alone streams the
+// changes to all its configs, the app and module those of the module, and the app, module,
+// ver and config those of one named config.
+type configstream struct {
+	App    string `form:"app" binding:"required"`
+	Module string `form:"module"`
+	Ver    int    `form:"ver"`
+	Config string `form:"config"`
+	// LastEventID resumes the stream after the given revision, for clients that cannot set the Last-Event-ID header
+	LastEventID string `form:"lastEventId"`
+}
+
+// StreamChange is the data of a change event of /configstream and of a value of a snapshot event.
+// Kind tells what changed: a config "value", the "description", "parent", "rules" or "lock" of
+// a config, a feature "flag", or the "schema" of a version.
+type StreamChange struct {
+	App      string `json:"app"`
+	Module   string `json:"module"`
+	Ver      int    `json:"ver"`
+	Config   string `json:"config,omitempty"`
+	Kind     string `json:"kind"`
+	Key      string `json:"key,omitempty"`
+	Value    string `json:"value,omitempty"`
+	Revision int64  `json:"revision"`
+}
+
+// streamSnapshot is the data of a snapshot event of /configstream.
+type streamSnapshot struct {
+	Revision int64          `json:"revision"`
+	Values   []StreamChange `json:"values"`
+}
+
+// Config_stream handles the GET /configstream request. It streams the changes to an app, a
+// module or a named config as Server-Sent Events: a "put" or "delete" event per change, whose
+// id is the revision of the change, and a comment every utils.SSEKEEPALIVE to keep the
+// connection open. A client that reconnects with the Last-Event-ID header, or the lastEventId
+// parameter, gets the changes it missed; if the server no longer has them, it gets a
+// "snapshot" event holding the current values first. The values of secrets are masked.
+// All the clients of the same app, module or config share one watch of the storage.
+func Config_stream(c *gin.Context, s *service.Service) {
+	l := s.LogHarbour
+	l.Log("Starting execution of Config_stream()")
+
+	var configstream configstream
+	if err := c.ShouldBindQuery(&configstream); err != nil {
+		l.LogActivity("error while binding query", err)
+		wscutils.SendErrorResponse(c, wscutils.NewErrorResponse(wscutils.ERRCODE_INVALID_REQUEST))
+		return
+	}
+	prefix, field := StreamPrefix(configstream.App, configstream.Module, configstream.Ver, configstream.Config)
+	if prefix == "" {
+		wscutils.SendErrorResponse(c, wscutils.NewResponse(wscutils.ErrorStatus, nil, []wscutils.ErrorMessage{wscutils.BuildErrorMessage(wscutils.ERRCODE_INVALID_REQUEST, &field)}))
+		return
+	}
+	lastEventID := c.GetHeader("Last-Event-ID")
+	if lastEventID == "" {
+		lastEventID = configstream.LastEventID
+	}
+	var after int64
+	if lastEventID != "" {
+		var err error
+		if after, err = strconv.ParseInt(lastEventID, 10, 64); err != nil || after < 0 {
+			field := "lastEventId"
+			wscutils.SendErrorResponse(c, wscutils.NewResponse(wscutils.ErrorStatus, nil, []wscutils.ErrorMessage{wscutils.BuildErrorMessage(wscutils.ERRCODE_INVALID_REQUEST, &field)}))
+			return
+		}
+	}
+
+	hub, ok := hubDependency(c, s)
+	if !ok {
+		return
+	}
+	r, ok := rigelDependency(c, s)
+	if !ok {
+		return
+	}
+	sub, err := hub.Subscribe(c, prefix, after)
+	if errors.Is(err, rigel.ErrFutureRevision) {
+		field := "lastEventId"
+		wscutils.SendErrorResponse(c, wscutils.NewResponse(wscutils.ErrorStatus, nil, []wscutils.ErrorMessage{wscutils.BuildErrorMessage(wscutils.ERRCODE_INVALID_REQUEST, &field, err.Error())}))
+		return
+	}
+	if err != nil {
+		l.LogActivity("error while subscribing to changes:", err)
+		wscutils.SendErrorResponse(c, wscutils.NewResponse(wscutils.ErrorStatus, nil, []wscutils.ErrorMessage{wscutils.BuildErrorMessage(wscutils.ErrcodeMissing, nil, err.Error())}))
+		return
+	}
+	defer sub.Close()
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+
+	if sub.Snapshot != nil {
+		snapshot := streamSnapshot{Revision: sub.SnapshotRevision, Values: SnapshotChanges(c, r, sub.Snapshot, sub.SnapshotRevision)}
+		writeSSE(c.Writer, "snapshot", sub.SnapshotRevision, snapshot)
+	}
+	c.Writer.Flush()
+
+	keepalive := time.NewTicker(utils.SSEKEEPALIVE)
+	defer keepalive.Stop()
+	for {
+		select {
+		case <-c.Request.Context().Done():
+			return
+		case <-keepalive.C:
+			fmt.Fprint(c.Writer, ": keepalive\n\n")
+		case event, ok := <-sub.Events():
+			if !ok {
+				// The client reconnects and resumes after the last event it got
+				l.LogActivity("change stream ended:", sub.Err())
+				return
+			}
+			if event.Err != nil {
+				l.LogDebug("change stream watch error:", logharbour.DebugInfo{Variables: map[string]any{"error": event.Err.Error()}})
+				continue
+			}
+			change, ok := NewStreamChange(c, r, event.Key, event.Value, event.Revision)
+			if !ok {
+				continue
+			}
+			writeSSE(c.Writer, event.Type.String(), event.Revision, change)
+		}
+		c.Writer.Flush()
+	}
+}
+
+// StreamPrefix returns the prefix of the keys of an app, a module or a named config, as followed
+// by a stream, or the name of the invalid parameter. A config needs its module and version.
+func StreamPrefix(app, module string, ver int, config string) (string, string) {
+	switch {
+	case app == "":
+		return "", "app"
+	case config != "":
+		if module == "" || ver == 0 {
+			return "", "config"
+		}
+		return layout.ConfigPath(app, module, ver, config) + "/", ""
+	case module != "":
+		return layout.Prefix + "/" + app + "/" + module + "/", ""
+	}
+	return layout.Prefix + "/" + app + "/", ""
+}
+
+// NewStreamChange describes a change to key, giving false for keys that are not streamed,
+// such as the records of lock overrides. The values of secret fields, looked up with r, are masked.
+func NewStreamChange(ctx context.Context, r *rigel.Rigel, key string, value string, rev int64) (StreamChange, bool) {
+	k, ok := layout.Parse(key)
+	if !ok {
+		return StreamChange{}, false
+	}
+	change := StreamChange{App: k.App, Module: k.Module, Ver: k.Version, Config: k.Config, Value: r.MaskSecret(ctx, key, value), Revision: rev}
+	if k.Config == "" {
+		change.Kind, change.Key = "schema", k.Path
+		return change, true
+	}
+	switch {
+	case k.Path == layout.ConfigDescriptionKey:
+		change.Kind = "description"
+	case k.Path == layout.ParentKey:
+		change.Kind = "parent"
+	case k.Path == layout.LockKey:
+		change.Kind = "lock"
+	case strings.HasPrefix(k.Path, layout.RulesKey+"/"):
+		change.Kind, change.Key = "rules", layout.KeyFromPath(strings.TrimPrefix(k.Path, layout.RulesKey+"/"))
+	case strings.HasPrefix(k.Path, layout.FlagsKey+"/"):
+		change.Kind, change.Key = "flag", layout.KeyFromPath(strings.TrimPrefix(k.Path, layout.FlagsKey+"/"))
+	case layout.IsReserved(k.Path):
+		return StreamChange{}, false
+	default:
+		change.Kind, change.Key = "value", layout.KeyFromPath(k.Path)
+	}
+	return change, true
+}
+
+// SnapshotChanges describes the values in kvs, read at revision rev, ordered by module, version,
+// config, kind and key. The values of secret fields, looked up with r, are masked.
+func SnapshotChanges(ctx context.Context, r *rigel.Rigel, kvs map[string]string, rev int64) []StreamChange {
+	changes := []StreamChange{}
+	for key, value := range kvs {
+		if change, ok := NewStreamChange(ctx, r, key, value, rev); ok {
+			changes = append(changes, change)
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool { return snapshotLess(changes[i], changes[j]) })
+	return changes
+}
+
+// snapshotLess orders the values of a snapshot by version, config, kind and key.
+func snapshotLess(a, b StreamChange) bool {
+	if a.Module != b.Module {
+		return a.Module < b.Module
+	}
+	if a.Ver != b.Ver {
+		return a.Ver < b.Ver
+	}
+	if a.Config != b.Config {
+		return a.Config < b.Config
+	}
+	if a.Kind != b.Kind {
+		return a.Kind < b.Kind
+	}
+	return a.Key < b.Key
+}
+
+// writeSSE writes an event of the given type and id with data encoded as JSON.
+func writeSSE(w io.Writer, event string, id int64, data any) {
+	encoded, _ := json.Marshal(data)
+	fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", id, event, encoded)
+}
+
+// hubDependency returns the rigel.Hub of the service, responding with an error if it has none.
+func hubDependency(c *gin.Context, s *service.Service) (*rigel.Hub, bool) {
+	hub, ok := s.Dependencies["hub"].(*rigel.Hub)
+	if !ok {
+		field := "hub"
+		wscutils.SendErrorResponse(c, wscutils.NewResponse(wscutils.ErrorStatus, nil, []wscutils.ErrorMessage{wscutils.BuildErrorMessage(utils.INVALID_DEPENDENCY, &field)}))
+	}
+	return hub, ok
+}
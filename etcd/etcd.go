@@ -4,14 +4,25 @@ package etcd
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"sort"
+	"strings"
 	"time"
 
 	"github.com/remiges-aniket/types"
+	"go.etcd.io/etcd/api/v3/v3rpc/rpctypes"
 	clientv3 "go.etcd.io/etcd/client/v3"
+	"go.etcd.io/etcd/client/v3/namespace"
 )
 
-const dialTimeout = 5 * time.Second
+const (
+	dialTimeout     = 5 * time.Second
+	watchRetryDelay = time.Second
+)
+
+// errWatchClosed is reported when etcd closes a watch without giving a reason.
+var errWatchClosed = errors.New("etcd watch closed")
 
 // EtcdStorage is implements Rigel's Storage interface using etcd v3 client.
 type EtcdStorage struct {
@@ -19,6 +30,10 @@ type EtcdStorage struct {
 }
 
 var _ types.Storage = &EtcdStorage{}
+var _ types.RevisionReader = &EtcdStorage{}
+var _ types.ConditionalWriter = &EtcdStorage{}
+var _ types.RevisionWatcher = &EtcdStorage{}
+var _ types.HistoryReader = &EtcdStorage{}
 
 // NewEtcdStorage creates a new instance of EtcdStorage using the provided endpoints
 // with default settings from the package. If an optional clientv3.Config is supplied,
@@ -54,6 +69,37 @@ func NewEtcdStorage(endpoints []string, config ...clientv3.Config) (*EtcdStorage
 	return storage, nil
 }
 
+// WithNamespace makes e only see the keys below ns, such as "/tenants/acme": every key read,
+// written or watched through it is prefixed with ns, and the prefix is removed from the keys it
+// returns. Storages of different namespaces can thus share one etcd cluster without seeing each
+// other's keys. As documented by package namespace, the KV, Watcher and Lease of the client of e
+// are wrapped in place, so the client then only sees the namespace, wherever else it is used;
+// each namespace needs a client of its own. It returns e, unchanged if ns is empty.
+func (e *EtcdStorage) WithNamespace(ns string) (*EtcdStorage, error) {
+	if err := CheckNamespace(ns); err != nil {
+		return nil, err
+	}
+	if ns == "" {
+		return e, nil
+	}
+	e.Client.KV = namespace.NewKV(e.Client.KV, ns)
+	e.Client.Watcher = namespace.NewWatcher(e.Client.Watcher, ns)
+	e.Client.Lease = namespace.NewLease(e.Client.Lease, ns)
+	return e, nil
+}
+
+// CheckNamespace checks that ns is a valid namespace for WithNamespace: empty, or starting with
+// a slash and not ending with one, so that no namespace is a prefix of the keys of another.
+func CheckNamespace(ns string) error {
+	if ns == "" {
+		return nil
+	}
+	if !strings.HasPrefix(ns, "/") || strings.HasSuffix(ns, "/") {
+		return fmt.Errorf("invalid namespace %q: it must start with a slash and not end with one", ns)
+	}
+	return nil
+}
+
 // StatusCheck checks the status of the etcd client.
 // If the function succeeds, we can assume that the connection to the etcd server is working.
 func (e *EtcdStorage) StatusCheck(ctx context.Context) error {
@@ -84,18 +130,83 @@ func (e *EtcdStorage) Get(ctx context.Context, key string) (string, error) {
 	return value, nil
 }
 
-// example ; GetWithParams(c, keyStr, clientv3.WithPrefix())
+// GetWithPrefix retrieves all keys starting with prefix and their values from etcd in a single request.
+// If no key has the prefix, the function returns an empty map and no error.
 func (e *EtcdStorage) GetWithPrefix(ctx context.Context, prefix string) (map[string]string, error) {
+	keyVal, _, err := e.GetWithPrefixRev(ctx, prefix)
+	return keyVal, err
+}
 
+// GetWithPrefixRev works like GetWithPrefix and also returns the etcd revision of the read.
+func (e *EtcdStorage) GetWithPrefixRev(ctx context.Context, prefix string) (map[string]string, int64, error) {
 	resp, err := e.Client.Get(ctx, prefix, clientv3.WithPrefix())
 	if err != nil {
-		return nil, fmt.Errorf("failed to get keys from etcd: %w", err)
+		return nil, 0, fmt.Errorf("failed to get keys from etcd: %w", err)
 	}
 	keyVal := make(map[string]string)
 	for _, ev := range resp.Kvs {
 		keyVal[string(ev.Key)] = string(ev.Value)
 	}
 
+	return keyVal, resp.Header.Revision, nil
+}
+
+// GetWithPrefixAt works like GetWithPrefix, reading the keys as they were at revision rev.
+// If the revision has been compacted, it returns an error wrapping types.ErrCompacted.
+func (e *EtcdStorage) GetWithPrefixAt(ctx context.Context, prefix string, rev int64) (map[string]string, error) {
+	resp, err := e.Client.Get(ctx, prefix, clientv3.WithPrefix(), clientv3.WithRev(rev))
+	if errors.Is(err, rpctypes.ErrCompacted) {
+		return nil, fmt.Errorf("cannot read %s at revision %d: %w", prefix, rev, types.ErrCompacted)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read keys at revision %d: %w", rev, err)
+	}
+	return keyValues(resp), nil
+}
+
+// GetKeys retrieves the keys with the given prefix from etcd, without their values.
+func (e *EtcdStorage) GetKeys(ctx context.Context, prefix string) ([]string, error) {
+	resp, err := e.Client.Get(ctx, prefix, clientv3.WithPrefix(), clientv3.WithKeysOnly())
+	if err != nil {
+		return nil, fmt.Errorf("failed to get keys from etcd: %w", err)
+	}
+	keys := make([]string, len(resp.Kvs))
+	for i, kv := range resp.Kvs {
+		keys[i] = string(kv.Key)
+	}
+	return keys, nil
+}
+
+// Revision returns the current revision of etcd.
+func (e *EtcdStorage) Revision(ctx context.Context) (int64, error) {
+	// Every response carries the revision, so ask for as little as possible
+	resp, err := e.Client.Get(ctx, "\x00", clientv3.WithCountOnly())
+	if err != nil {
+		return 0, fmt.Errorf("failed to get revision from etcd: %w", err)
+	}
+	return resp.Header.Revision, nil
+}
+
+// GetMany retrieves the values of the given keys from etcd in a single transaction.
+// Keys that do not exist in etcd are left out of the returned map.
+func (e *EtcdStorage) GetMany(ctx context.Context, keys ...string) (map[string]string, error) {
+	ops := make([]clientv3.Op, len(keys))
+	for i, key := range keys {
+		ops[i] = clientv3.OpGet(key)
+	}
+
+	resp, err := e.Client.Txn(ctx).Then(ops...).Commit()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get keys from etcd: %w", err)
+	}
+
+	keyVal := make(map[string]string, len(keys))
+	for _, r := range resp.Responses {
+		for _, ev := range r.GetResponseRange().Kvs {
+			keyVal[string(ev.Key)] = string(ev.Value)
+		}
+	}
+
 	return keyVal, nil
 }
 
@@ -112,21 +223,288 @@ func (e *EtcdStorage) Put(ctx context.Context, key string, value string) error {
 	return nil
 }
 
+// PutIf stores all of kvs in a single transaction, provided that key still holds value.
+// It reports whether it did.
+func (e *EtcdStorage) PutIf(ctx context.Context, key string, value string, kvs map[string]string) (bool, error) {
+	ops := make([]clientv3.Op, 0, len(kvs))
+	for k, v := range kvs {
+		ops = append(ops, clientv3.OpPut(k, v))
+	}
+	resp, err := e.Client.Txn(ctx).
+		If(clientv3.Compare(clientv3.Value(key), "=", value)).
+		Then(ops...).
+		Commit()
+	if err != nil {
+		return false, fmt.Errorf("failed to put keys in etcd: %w", err)
+	}
+	return resp.Succeeded, nil
+}
+
+// Commit makes the writes of txn in a single transaction, provided that every key of txn.If
+// holds its value. It reports whether it did. etcd cannot compare the value of a key that does
+// not exist, so the keys that must be empty or missing are read first, and the transaction
+// requires them to be unchanged since.
+func (e *EtcdStorage) Commit(ctx context.Context, txn types.Txn) (bool, error) {
+	var cmps []clientv3.Cmp
+	var empty []string
+	for k, v := range txn.If {
+		if v == "" {
+			empty = append(empty, k)
+			continue
+		}
+		cmps = append(cmps, clientv3.Compare(clientv3.Value(k), "=", v))
+	}
+	if len(empty) > 0 {
+		ops := make([]clientv3.Op, len(empty))
+		for i, k := range empty {
+			ops[i] = clientv3.OpGet(k)
+		}
+		resp, err := e.Client.Txn(ctx).Then(ops...).Commit()
+		if err != nil {
+			return false, fmt.Errorf("failed to get keys from etcd: %w", err)
+		}
+		for i, r := range resp.Responses {
+			var modRev int64
+			if kvs := r.GetResponseRange().Kvs; len(kvs) > 0 {
+				if len(kvs[0].Value) > 0 {
+					return false, nil
+				}
+				modRev = kvs[0].ModRevision
+			}
+			cmps = append(cmps, clientv3.Compare(clientv3.ModRevision(empty[i]), "=", modRev))
+		}
+	}
+
+	ops := make([]clientv3.Op, 0, len(txn.Put)+len(txn.Delete))
+	for k, v := range txn.Put {
+		ops = append(ops, clientv3.OpPut(k, v))
+	}
+	for _, k := range txn.Delete {
+		ops = append(ops, clientv3.OpDelete(k))
+	}
+	resp, err := e.Client.Txn(ctx).If(cmps...).Then(ops...).Commit()
+	if err != nil {
+		return false, fmt.Errorf("failed to write keys in etcd: %w", err)
+	}
+	return resp.Succeeded, nil
+}
+
+// Move moves value from the key from to the key to in a single transaction. The move is made
+// only if from still holds value and to does not exist or already holds value; otherwise
+// nothing is changed and Move returns false.
+func (e *EtcdStorage) Move(ctx context.Context, from string, to string, value string) (bool, error) {
+	resp, err := e.Client.Txn(ctx).
+		If(clientv3.Compare(clientv3.Value(from), "=", value)).
+		Then(clientv3.OpTxn(
+			[]clientv3.Cmp{clientv3.Compare(clientv3.CreateRevision(to), "=", 0)},
+			[]clientv3.Op{clientv3.OpPut(to, value), clientv3.OpDelete(from)},
+			[]clientv3.Op{clientv3.OpTxn(
+				[]clientv3.Cmp{clientv3.Compare(clientv3.Value(to), "=", value)},
+				[]clientv3.Op{clientv3.OpDelete(from)},
+				nil,
+			)},
+		)).
+		Commit()
+	if err != nil {
+		return false, fmt.Errorf("failed to move key in etcd: %w", err)
+	}
+	if !resp.Succeeded {
+		return false, nil
+	}
+	nested := resp.Responses[0].GetResponseTxn()
+	if nested.Succeeded {
+		return true, nil
+	}
+	return nested.Responses[0].GetResponseTxn().Succeeded, nil
+}
+
 // Watch starts watching for changes to a key or a range of keys in etcd and sends the events to the provided channel.
 // If the key is a prefix that matches multiple keys, it watches all those keys.
 // key: The key to watch for changes
 // events is the channel to send events when the key's value changes
+//
+// The watch survives disconnects: when the etcd watch fails, an Event with Err set is sent
+// and the watch is restarted from the revision after the last one seen, so no change is missed.
+// If that revision has been compacted, the keys under the prefix are read again and compared
+// with those the watch knew of: an event is sent for each key put or deleted meanwhile, with
+// the revision of the read, before watching resumes. The events channel is closed when ctx is done.
 func (e *EtcdStorage) Watch(ctx context.Context, key string, events chan<- types.Event) error {
-	watchChan := e.Client.Watch(ctx, key, clientv3.WithPrefix())
-	go func() {
-		for watchResp := range watchChan {
-			for _, event := range watchResp.Events {
-				events <- types.Event{
-					Key:   string(event.Kv.Key),
-					Value: string(event.Kv.Value),
-				}
-			}
+	go e.watch(ctx, key, 0, nil, events)
+	return nil
+}
+
+// WatchFrom works like Watch, starting with the changes made at revision rev rather than with
+// those made after the call. If the revision before rev has been compacted, it returns an
+// error wrapping types.ErrCompacted.
+func (e *EtcdStorage) WatchFrom(ctx context.Context, key string, rev int64, events chan<- types.Event) error {
+	known := make(map[string]string)
+	if rev > 1 {
+		resp, err := e.Client.Get(ctx, key, clientv3.WithPrefix(), clientv3.WithRev(rev-1))
+		if errors.Is(err, rpctypes.ErrCompacted) {
+			return fmt.Errorf("cannot watch %s from revision %d: %w", key, rev, types.ErrCompacted)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read keys at revision %d: %w", rev-1, err)
 		}
-	}()
+		known = keyValues(resp)
+	}
+	go e.watch(ctx, key, rev, known, events)
 	return nil
 }
+
+// watch runs the watch loop for Watch, starting at revision rev (0 means the current revision).
+// known holds the values of the keys under key before rev, and is read when the watch is
+// created if nil; the watch keeps it up to date to find the keys deleted during a compacted gap.
+func (e *EtcdStorage) watch(ctx context.Context, key string, rev int64, known map[string]string, events chan<- types.Event) {
+	defer close(events)
+
+	for {
+		nextRev, nextKnown, err := e.watchOnce(ctx, key, rev, known, events)
+		rev, known = nextRev, nextKnown
+		if ctx.Err() != nil {
+			return
+		}
+
+		if errors.Is(err, rpctypes.ErrCompacted) && known != nil {
+			// The changes since rev are gone, so compare the current state of the keys instead
+			rev, err = e.resync(ctx, key, known, events)
+			if err == nil {
+				continue
+			}
+		}
+		if err != nil && !send(ctx, events, types.Event{Key: key, Err: err}) {
+			return
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(watchRetryDelay):
+		}
+	}
+}
+
+// watchOnce watches key from revision rev until the etcd watch ends, keeping known up to date,
+// or reading it once the watch is created if it is nil. It returns the revision to resume
+// from, known and the error that ended the watch.
+func (e *EtcdStorage) watchOnce(ctx context.Context, key string, rev int64, known map[string]string, events chan<- types.Event) (int64, map[string]string, error) {
+	opts := []clientv3.OpOption{clientv3.WithPrefix(), clientv3.WithCreatedNotify(), clientv3.WithPrevKV()}
+	if rev > 0 {
+		opts = append(opts, clientv3.WithRev(rev))
+	}
+
+	watchCtx, cancel := context.WithCancel(clientv3.WithRequireLeader(ctx))
+	defer cancel()
+
+	for watchResp := range e.Client.Watch(watchCtx, key, opts...) {
+		if err := watchResp.Err(); err != nil {
+			return rev, known, err
+		}
+		if watchResp.Created && rev == 0 {
+			// Events come after the revision of the watch, so read the keys as of that revision
+			resp, err := e.Client.Get(ctx, key, clientv3.WithPrefix(), clientv3.WithRev(watchResp.Header.Revision))
+			if err != nil {
+				return rev, known, fmt.Errorf("failed to read keys at revision %d: %w", watchResp.Header.Revision, err)
+			}
+			rev, known = watchResp.Header.Revision+1, keyValues(resp)
+		}
+		for i, event := range watchResp.Events {
+			e := newEvent(event)
+			// A response holds all the changes of its revisions, so the next change is of another one
+			e.Last = i == len(watchResp.Events)-1 || watchResp.Events[i+1].Kv.ModRevision != event.Kv.ModRevision
+			if !send(ctx, events, e) {
+				return rev, known, ctx.Err()
+			}
+			if event.Type == clientv3.EventTypeDelete {
+				delete(known, string(event.Kv.Key))
+			} else {
+				known[string(event.Kv.Key)] = string(event.Kv.Value)
+			}
+			rev = event.Kv.ModRevision + 1
+		}
+	}
+
+	if ctx.Err() != nil {
+		return rev, known, ctx.Err()
+	}
+	return rev, known, errWatchClosed
+}
+
+// resync reads all keys under prefix and sends an event for each key whose value differs from
+// known, or that is missing, all with the revision of the read and the known value as previous
+// value, and updates known to match.
+// It returns the revision from which watching should resume.
+func (e *EtcdStorage) resync(ctx context.Context, prefix string, known map[string]string, events chan<- types.Event) (int64, error) {
+	resp, err := e.Client.Get(ctx, prefix, clientv3.WithPrefix())
+	if err != nil {
+		return 0, fmt.Errorf("failed to read keys after compaction: %w", err)
+	}
+	rev := resp.Header.Revision
+	current := keyValues(resp)
+
+	var deleted []string
+	for key := range known {
+		if _, found := current[key]; !found {
+			deleted = append(deleted, key)
+		}
+	}
+	sort.Strings(deleted)
+	var changes []types.Event
+	for _, key := range deleted {
+		changes = append(changes, types.Event{Type: types.EventTypeDelete, Key: key, PrevValue: known[key], Revision: rev})
+		delete(known, key)
+	}
+	for _, kv := range resp.Kvs {
+		key, value := string(kv.Key), string(kv.Value)
+		old, found := known[key]
+		if found && old == value {
+			continue
+		}
+		changes = append(changes, types.Event{Type: types.EventTypePut, Key: key, Value: value, PrevValue: old, Revision: rev})
+		known[key] = value
+	}
+	for i, event := range changes {
+		event.Last = i == len(changes)-1
+		if !send(ctx, events, event) {
+			return 0, ctx.Err()
+		}
+	}
+	return rev + 1, nil
+}
+
+// keyValues returns the keys and values read by resp.
+func keyValues(resp *clientv3.GetResponse) map[string]string {
+	kvs := make(map[string]string, len(resp.Kvs))
+	for _, kv := range resp.Kvs {
+		kvs[string(kv.Key)] = string(kv.Value)
+	}
+	return kvs
+}
+
+// newEvent converts an etcd watch event to a types.Event.
+func newEvent(event *clientv3.Event) types.Event {
+	e := types.Event{
+		Type:     types.EventTypePut,
+		Key:      string(event.Kv.Key),
+		Value:    string(event.Kv.Value),
+		Revision: event.Kv.ModRevision,
+	}
+	if event.Type == clientv3.EventTypeDelete {
+		e.Type = types.EventTypeDelete
+		e.Value = ""
+	}
+	if event.PrevKv != nil {
+		e.PrevValue = string(event.PrevKv.Value)
+	}
+	return e
+}
+
+// send sends event on events, giving up if ctx is done first.
+func send(ctx context.Context, events chan<- types.Event, event types.Event) bool {
+	select {
+	case events <- event:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
@@ -2,6 +2,8 @@ package etcd
 
 import (
 	"context"
+	"errors"
+	"reflect"
 	"testing"
 	"time"
 
@@ -84,3 +86,492 @@ func TestEtcdStorage_Watch(t *testing.T) {
 		t.Errorf("Expected to receive an event, but didn't")
 	}
 }
+
+func TestEtcdStorage_WatchClosesOnCancel(t *testing.T) {
+	// Setup the test environment
+	integration.BeforeTestExternal(t)
+
+	// Create an embedded etcd server for testing
+	clus := integration.NewClusterV3(t, &integration.ClusterConfig{Size: 1})
+	defer clus.Terminate(t)
+
+	etcdStorage := &EtcdStorage{
+		Client: clus.RandClient(),
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	events := make(chan types.Event)
+	if err := etcdStorage.Watch(ctx, "test-key", events); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	cancel()
+
+	select {
+	case _, ok := <-events:
+		if ok {
+			t.Errorf("Expected the events channel to be closed")
+		}
+	case <-time.After(2 * time.Second):
+		t.Errorf("Expected the events channel to be closed after cancel, but it wasn't")
+	}
+}
+
+func TestEtcdStorage_WatchResyncsAfterCompaction(t *testing.T) {
+	// Setup the test environment
+	integration.BeforeTestExternal(t)
+
+	// Create an embedded etcd server for testing
+	clus := integration.NewClusterV3(t, &integration.ClusterConfig{Size: 1})
+	defer clus.Terminate(t)
+
+	etcdStorage := &EtcdStorage{
+		Client: clus.RandClient(),
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// Write a few revisions of the key and compact them away
+	for _, v := range []string{"v1", "v2", "v3"} {
+		if err := etcdStorage.Put(ctx, "test-prefix/key", v); err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+	}
+	resp, err := etcdStorage.Client.Get(ctx, "test-prefix/key")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if _, err := etcdStorage.Client.Compact(ctx, resp.Header.Revision); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	// Resuming from a compacted revision must fall back to comparing the current keys with the
+	// known ones: the key deleted meanwhile is reported, and both events carry the revision of the
+	// read and the known values as previous values, the last being marked as such
+	events := make(chan types.Event)
+	known := map[string]string{"test-prefix/gone": "v0", "test-prefix/key": "v1"}
+	go etcdStorage.watch(ctx, "test-prefix", 2, known, events)
+
+	want := []types.Event{
+		{Type: types.EventTypeDelete, Key: "test-prefix/gone", PrevValue: "v0", Revision: resp.Header.Revision},
+		{Type: types.EventTypePut, Key: "test-prefix/key", Value: "v3", PrevValue: "v1", Revision: resp.Header.Revision, Last: true},
+	}
+	for _, w := range want {
+		select {
+		case event := <-events:
+			if event != w {
+				t.Errorf("Expected event %+v, got %+v", w, event)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("Expected to receive an event, but didn't")
+		}
+	}
+
+	// Watching continues after the re-read
+	if err := etcdStorage.Put(ctx, "test-prefix/key", "v4"); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	select {
+	case event := <-events:
+		if event.Value != "v4" {
+			t.Errorf("Expected value 'v4', got %+v", event)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("Expected to receive an event, but didn't")
+	}
+}
+
+func TestEtcdStorage_WatchFromCompacted(t *testing.T) {
+	// Setup the test environment
+	integration.BeforeTestExternal(t)
+
+	// Create an embedded etcd server for testing
+	clus := integration.NewClusterV3(t, &integration.ClusterConfig{Size: 1})
+	defer clus.Terminate(t)
+
+	etcdStorage := &EtcdStorage{
+		Client: clus.RandClient(),
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	for _, v := range []string{"v1", "v2", "v3"} {
+		if err := etcdStorage.Put(ctx, "test-prefix/key", v); err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+	}
+	resp, err := etcdStorage.Client.Get(ctx, "test-prefix/key")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if _, err := etcdStorage.Client.Compact(ctx, resp.Header.Revision); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	err = etcdStorage.WatchFrom(ctx, "test-prefix", 2, make(chan types.Event))
+	if !errors.Is(err, types.ErrCompacted) {
+		t.Errorf("Expected types.ErrCompacted, got %v", err)
+	}
+}
+
+func TestEtcdStorage_GetWithPrefixAt(t *testing.T) {
+	// Setup the test environment
+	integration.BeforeTestExternal(t)
+
+	// Create an embedded etcd server for testing
+	clus := integration.NewClusterV3(t, &integration.ClusterConfig{Size: 1})
+	defer clus.Terminate(t)
+
+	etcdStorage := &EtcdStorage{
+		Client: clus.RandClient(),
+	}
+	ctx := context.Background()
+
+	var revs []int64
+	for _, v := range []string{"v1", "v2", "v3"} {
+		if err := etcdStorage.Put(ctx, "test-prefix/key", v); err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		rev, err := etcdStorage.Revision(ctx)
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		revs = append(revs, rev)
+	}
+
+	kvs, err := etcdStorage.GetWithPrefixAt(ctx, "test-prefix/", revs[1])
+	if err != nil || kvs["test-prefix/key"] != "v2" {
+		t.Errorf("Expected v2 at revision %d, got %v, %v", revs[1], kvs, err)
+	}
+
+	if _, err := etcdStorage.Client.Compact(ctx, revs[2]); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if _, err := etcdStorage.GetWithPrefixAt(ctx, "test-prefix/", revs[0]); !errors.Is(err, types.ErrCompacted) {
+		t.Errorf("Expected types.ErrCompacted, got %v", err)
+	}
+}
+
+func TestEtcdStorage_WatchEventTypes(t *testing.T) {
+	// Setup the test environment
+	integration.BeforeTestExternal(t)
+
+	// Create an embedded etcd server for testing
+	clus := integration.NewClusterV3(t, &integration.ClusterConfig{Size: 1})
+	defer clus.Terminate(t)
+
+	etcdStorage := &EtcdStorage{
+		Client: clus.RandClient(),
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	if err := etcdStorage.Put(ctx, "test-key", "v1"); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	events := make(chan types.Event)
+	if err := etcdStorage.Watch(ctx, "test-key", events); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	// Give the watch time to be created before changing the key
+	time.Sleep(100 * time.Millisecond)
+
+	if err := etcdStorage.Put(ctx, "test-key", "v2"); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if _, err := etcdStorage.Client.Delete(ctx, "test-key"); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	expected := []types.Event{
+		{Type: types.EventTypePut, Key: "test-key", Value: "v2", PrevValue: "v1"},
+		{Type: types.EventTypeDelete, Key: "test-key", PrevValue: "v2"},
+	}
+	for _, want := range expected {
+		select {
+		case event := <-events:
+			if event.Type != want.Type || event.Key != want.Key || event.Value != want.Value || event.PrevValue != want.PrevValue {
+				t.Errorf("Expected event %+v, got %+v", want, event)
+			}
+			if event.Revision == 0 {
+				t.Errorf("Expected the revision to be set, got %+v", event)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("Expected to receive an event, but didn't")
+		}
+	}
+}
+
+func TestEtcdStorage_WatchFrom(t *testing.T) {
+	// Setup the test environment
+	integration.BeforeTestExternal(t)
+
+	// Create an embedded etcd server for testing
+	clus := integration.NewClusterV3(t, &integration.ClusterConfig{Size: 1})
+	defer clus.Terminate(t)
+
+	etcdStorage := &EtcdStorage{
+		Client: clus.RandClient(),
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	for _, value := range []string{"v1", "v2", "v3"} {
+		if err := etcdStorage.Put(ctx, "test-key", value); err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+	}
+	_, rev, err := etcdStorage.GetWithPrefixRev(ctx, "test-key")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if current, err := etcdStorage.Revision(ctx); err != nil || current != rev {
+		t.Errorf("Expected revision %d, got %d, %v", rev, current, err)
+	}
+
+	// The changes made at the last two revisions are sent again
+	events := make(chan types.Event)
+	if err := etcdStorage.WatchFrom(ctx, "test-key", rev-1, events); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	for _, want := range []string{"v2", "v3"} {
+		select {
+		case event := <-events:
+			if event.Value != want || !event.Last {
+				t.Errorf("Expected the last change of a revision with value %s, got %+v", want, event)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("Expected to receive an event, but didn't")
+		}
+	}
+
+	// Only the last of the changes made at one revision is marked as such
+	if _, err := etcdStorage.Commit(ctx, types.Txn{Put: map[string]string{"test-key/a": "a", "test-key/b": "b"}}); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	for _, last := range []bool{false, true} {
+		select {
+		case event := <-events:
+			if event.Revision != rev+1 || event.Last != last {
+				t.Errorf("Expected a change at revision %d with Last %v, got %+v", rev+1, last, event)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("Expected to receive an event, but didn't")
+		}
+	}
+}
+
+func TestEtcdStorage_GetManyAndGetWithPrefix(t *testing.T) {
+	// Setup the test environment
+	integration.BeforeTestExternal(t)
+
+	// Create an embedded etcd server for testing
+	clus := integration.NewClusterV3(t, &integration.ClusterConfig{Size: 1})
+	defer clus.Terminate(t)
+
+	etcdStorage := &EtcdStorage{
+		Client: clus.RandClient(),
+	}
+	ctx := context.Background()
+
+	for k, v := range map[string]string{"conf/a": "1", "conf/b": "2", "other/c": "3"} {
+		if err := etcdStorage.Put(ctx, k, v); err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+	}
+
+	values, err := etcdStorage.GetMany(ctx, "conf/a", "other/c", "missing")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(values) != 2 || values["conf/a"] != "1" || values["other/c"] != "3" {
+		t.Errorf("Expected values for conf/a and other/c, got %v", values)
+	}
+
+	values, err = etcdStorage.GetWithPrefix(ctx, "conf/")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(values) != 2 || values["conf/a"] != "1" || values["conf/b"] != "2" {
+		t.Errorf("Expected values for conf/a and conf/b, got %v", values)
+	}
+
+	keys, err := etcdStorage.GetKeys(ctx, "conf/")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if !reflect.DeepEqual(keys, []string{"conf/a", "conf/b"}) {
+		t.Errorf("Expected keys conf/a and conf/b, got %v", keys)
+	}
+}
+
+func TestEtcdStorage_WithNamespace(t *testing.T) {
+	// Setup the test environment
+	integration.BeforeTestExternal(t)
+
+	// Create an embedded etcd server for testing
+	clus := integration.NewClusterV3(t, &integration.ClusterConfig{Size: 1})
+	defer clus.Terminate(t)
+
+	root := &EtcdStorage{
+		Client: clus.RandClient(),
+	}
+	// Each namespace needs a client of its own
+	namespaced := func(ns string) *EtcdStorage {
+		cli, err := integration.NewClientV3(clus.Members[0])
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		t.Cleanup(func() { cli.Close() })
+		storage, err := (&EtcdStorage{Client: cli}).WithNamespace(ns)
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		return storage
+	}
+	acme := namespaced("/tenants/acme")
+	globex := namespaced("/tenants/globex")
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	events := make(chan types.Event)
+	if err := globex.Watch(ctx, "/remiges/rigel/", events); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	// Give the watch time to be created before changing the keys
+	time.Sleep(100 * time.Millisecond)
+
+	if err := acme.Put(ctx, "/remiges/rigel/key", "acme"); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if err := globex.Put(ctx, "/remiges/rigel/key", "globex"); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	if value, err := acme.Get(ctx, "/remiges/rigel/key"); err != nil || value != "acme" {
+		t.Errorf("Expected acme, got %q, %v", value, err)
+	}
+	values, err := globex.GetWithPrefix(ctx, "/")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(values) != 1 || values["/remiges/rigel/key"] != "globex" {
+		t.Errorf("Expected only the key of globex, got %v", values)
+	}
+	if value, err := root.Get(ctx, "/tenants/acme/remiges/rigel/key"); err != nil || value != "acme" {
+		t.Errorf("Expected the key of acme below its namespace, got %q, %v", value, err)
+	}
+
+	// The watch of globex sees its own key only, without the namespace
+	select {
+	case event := <-events:
+		if event.Key != "/remiges/rigel/key" || event.Value != "globex" {
+			t.Errorf("Expected the event for the key of globex, got %+v", event)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("Expected to receive an event, but didn't")
+	}
+	select {
+	case event := <-events:
+		t.Errorf("Expected no more events, got %+v", event)
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	for _, ns := range []string{"tenants/acme", "/tenants/acme/"} {
+		if _, err := root.WithNamespace(ns); err == nil {
+			t.Errorf("Expected an error for namespace %q", ns)
+		}
+	}
+}
+
+func TestEtcdStorage_Move(t *testing.T) {
+	// Setup the test environment
+	integration.BeforeTestExternal(t)
+
+	// Create an embedded etcd server for testing
+	clus := integration.NewClusterV3(t, &integration.ClusterConfig{Size: 1})
+	defer clus.Terminate(t)
+
+	etcdStorage := &EtcdStorage{
+		Client: clus.RandClient(),
+	}
+	ctx := context.Background()
+
+	for k, v := range map[string]string{"old/a": "1", "old/b": "2", "new/b": "2", "old/c": "3", "new/c": "4"} {
+		if err := etcdStorage.Put(ctx, k, v); err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+	}
+
+	tests := []struct {
+		from, to, value string
+		moved           bool
+		wantTo          string
+	}{
+		{"old/a", "new/a", "1", true, "1"},  // the new key does not exist
+		{"old/b", "new/b", "2", true, "2"},  // the new key already holds the value
+		{"old/c", "new/c", "3", false, "4"}, // the new key holds another value
+		{"old/d", "new/d", "5", false, ""},  // the old key is gone
+	}
+	for _, tt := range tests {
+		moved, err := etcdStorage.Move(ctx, tt.from, tt.to, tt.value)
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if moved != tt.moved {
+			t.Errorf("Move(%s, %s) = %v, want %v", tt.from, tt.to, moved, tt.moved)
+		}
+		if value, _ := etcdStorage.Get(ctx, tt.to); value != tt.wantTo {
+			t.Errorf("Expected %s to hold %q, got %q", tt.to, tt.wantTo, value)
+		}
+		if value, _ := etcdStorage.Get(ctx, tt.from); tt.moved && value != "" {
+			t.Errorf("Expected %s to be deleted, got %q", tt.from, value)
+		}
+	}
+	if value, _ := etcdStorage.Get(ctx, "old/c"); value != "3" {
+		t.Errorf("Expected old/c to be kept, got %q", value)
+	}
+}
+
+func TestEtcdStorage_Commit(t *testing.T) {
+	// Setup the test environment
+	integration.BeforeTestExternal(t)
+
+	// Create an embedded etcd server for testing
+	clus := integration.NewClusterV3(t, &integration.ClusterConfig{Size: 1})
+	defer clus.Terminate(t)
+
+	etcdStorage := &EtcdStorage{
+		Client: clus.RandClient(),
+	}
+	ctx := context.Background()
+
+	for k, v := range map[string]string{"lock": "", "held": "1", "gone": "x"} {
+		if err := etcdStorage.Put(ctx, k, v); err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+	}
+
+	tests := []struct {
+		name string
+		cond map[string]string
+		done bool
+	}{
+		{"a key holding another value", map[string]string{"held": "2"}, false},
+		{"a key expected empty holding a value", map[string]string{"held": ""}, false},
+		{"an empty and a missing key", map[string]string{"lock": "", "missing": "", "held": "1"}, true},
+	}
+	for _, tt := range tests {
+		done, err := etcdStorage.Commit(ctx, types.Txn{If: tt.cond, Put: map[string]string{"put": tt.name}, Delete: []string{"gone"}})
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if done != tt.done {
+			t.Errorf("Commit() on %s = %v, want %v", tt.name, done, tt.done)
+		}
+		put, _ := etcdStorage.Get(ctx, "put")
+		gone, _ := etcdStorage.Get(ctx, "gone")
+		if tt.done != (put == tt.name) || tt.done != (gone == "") {
+			t.Errorf("Commit() on %s left put = %q and gone = %q", tt.name, put, gone)
+		}
+	}
+}
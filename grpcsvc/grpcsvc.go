@@ -0,0 +1,506 @@
+// Package grpcsvc serves the gRPC API of the Rigel server, defined in rigelpb/rigel.proto. Each
+// call is served by the same functions as the matching HTTP endpoint of packages configsvc and
+// schemaserv, so the two APIs behave alike. Errors carry the error code of the HTTP API in an
+// ErrorInfo detail, and a gRPC status code mapped from it, see Status.
+package grpcsvc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/remiges-aniket/configsvc"
+	"github.com/remiges-aniket/etcd"
+	"github.com/remiges-aniket/rigel"
+	"github.com/remiges-aniket/rigelpb"
+	"github.com/remiges-aniket/schemaserv"
+	"github.com/remiges-aniket/trees"
+	"github.com/remiges-aniket/types"
+	"github.com/remiges-aniket/utils"
+	"github.com/remiges-tech/alya/wscutils"
+	"github.com/remiges-tech/logharbour/logharbour"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// ErrorDomain is the domain of the ErrorInfo details of the errors of the API.
+const ErrorDomain = "rigel"
+
+// statusCodes maps the error codes of the HTTP API, as in errortypes.yaml, to gRPC status codes.
+var statusCodes = map[string]codes.Code{
+	wscutils.ERRCODE_INVALID_REQUEST: codes.InvalidArgument,
+	wscutils.ErrcodeInvalidJson:      codes.InvalidArgument,
+	"only_numbers_allowed":           codes.InvalidArgument,
+	utils.INVALID_TIME:               codes.InvalidArgument,
+	utils.INVALID_PARENT:             codes.InvalidArgument,
+	utils.INVALID_RULES:              codes.InvalidArgument,
+	wscutils.ErrcodeMissing:          codes.NotFound,
+	schemaserv.SCHEMA_NOT_FOUND:      codes.NotFound,
+	utils.CHANGE_NOT_FOUND:           codes.NotFound,
+	utils.CONFIG_LOCKED:              codes.FailedPrecondition,
+	utils.NOT_AUTHORIZED:             codes.PermissionDenied,
+	utils.CHANGE_NOT_PENDING:         codes.FailedPrecondition,
+	utils.INVALID_DEPENDENCY:         codes.Internal,
+	wscutils.ErrcodeDatabaseError:    codes.Internal,
+	"unable_to_set":                  codes.Internal,
+}
+
+// Server implements rigelpb.RigelServer.
+type Server struct {
+	rigelpb.UnimplementedRigelServer
+
+	rigel      *rigel.Rigel
+	etcd       *etcd.EtcdStorage
+	hub        *rigel.Hub
+	l          *logharbour.Logger
+	adminToken string
+}
+
+// NewServer returns a server of the API, with the same dependencies as the HTTP handlers: the
+// Rigel client r, the storage etcdStorage that the lists are read from, and the hub that Watch
+// subscribes to.
+func NewServer(r *rigel.Rigel, etcdStorage *etcd.EtcdStorage, hub *rigel.Hub, l *logharbour.Logger) *Server {
+	return &Server{rigel: r, etcd: etcdStorage, hub: hub, l: l}
+}
+
+// WithAdminToken sets the token that authorizes creating schemas and overriding locks, as the
+// admin token of the HTTP API, and returns the modified server. Calls carry it as a bearer token
+// in their "authorization" metadata. Without a token, no call can do either.
+func (s *Server) WithAdminToken(token string) *Server {
+	s.adminToken = token
+	return s
+}
+
+// Status returns the error of a call that failed with the error code code of the HTTP API: a
+// status whose code is mapped from code, codes.Unknown if it has no mapping, and whose ErrorInfo
+// detail has code as reason and, if field is not empty, the invalid field in its metadata.
+func Status(code string, field string, err error) error {
+	c, found := statusCodes[code]
+	if !found {
+		c = codes.Unknown
+	}
+	st := status.New(c, err.Error())
+	info := &errdetails.ErrorInfo{Reason: code, Domain: ErrorDomain}
+	if field != "" {
+		info.Metadata = map[string]string{"field": field}
+	}
+	if detailed, err := st.WithDetails(info); err == nil {
+		st = detailed
+	}
+	return st.Err()
+}
+
+// invalidArgument returns the error of a call whose field is missing or invalid.
+func invalidArgument(field string, reason string) error {
+	return Status(wscutils.ERRCODE_INVALID_REQUEST, field, fmt.Errorf("%s: %s", field, reason))
+}
+
+// requireVersion checks that a request names a schema version, returning an error naming the
+// first missing field.
+func requireVersion(app, module string, ver int32) error {
+	switch {
+	case app == "":
+		return invalidArgument("app", "required")
+	case module == "":
+		return invalidArgument("module", "required")
+	case ver == 0:
+		return invalidArgument("ver", "required")
+	}
+	return nil
+}
+
+// requireConfig checks that a request names a config, as requireVersion.
+func requireConfig(app, module string, ver int32, config string) error {
+	if err := requireVersion(app, module, ver); err != nil {
+		return err
+	}
+	if config == "" {
+		return invalidArgument("config", "required")
+	}
+	return nil
+}
+
+// GetSchema returns a schema version, as /getschema.
+func (s *Server) GetSchema(ctx context.Context, req *rigelpb.GetSchemaRequest) (*rigelpb.Schema, error) {
+	if err := requireVersion(req.App, req.Module, req.Ver); err != nil {
+		return nil, err
+	}
+	schema, err := schemaserv.GetSchema(ctx, s.rigel.View(req.App, req.Module, int(req.Ver), ""))
+	if err != nil {
+		s.l.LogActivity("error occurred while getting Schema details: ", err)
+		return nil, Status(schemaserv.SCHEMA_NOT_FOUND, "", err)
+	}
+	return &rigelpb.Schema{
+		App:         schema.App,
+		Module:      schema.Module,
+		Ver:         int32(schema.Ver),
+		Description: schema.Description,
+		Fields:      fieldsToProto(schema.Fields),
+	}, nil
+}
+
+// ListSchemas returns a page of the schemas, as /schemalist.
+func (s *Server) ListSchemas(ctx context.Context, req *rigelpb.ListRequest) (*rigelpb.ListSchemasResponse, error) {
+	params, err := listParams(req)
+	if err != nil {
+		return nil, err
+	}
+	list, err := schemaserv.ListSchemas(ctx, s.etcd, params)
+	if err != nil {
+		s.l.LogActivity("error while listing schemas:", err)
+		return nil, Status(wscutils.ErrcodeMissing, "", err)
+	}
+	resp := &rigelpb.ListSchemasResponse{Total: int32(list.Total), NextCursor: list.NextCursor}
+	for _, schema := range list.Schemas {
+		resp.Schemas = append(resp.Schemas, &rigelpb.SchemaSummary{
+			App:         schema.App,
+			Module:      schema.Module,
+			Ver:         int32(schema.Ver),
+			Description: schema.Description,
+		})
+	}
+	return resp, nil
+}
+
+// CreateSchema adds a schema version, replacing it if it exists, as /schemacreate. Only admins can.
+func (s *Server) CreateSchema(ctx context.Context, req *rigelpb.CreateSchemaRequest) (*rigelpb.CreateSchemaResponse, error) {
+	if !s.isAdmin(ctx) {
+		return nil, Status(utils.NOT_AUTHORIZED, "", errors.New("only admins can create schemas"))
+	}
+	schema := req.Schema
+	if schema == nil {
+		return nil, invalidArgument("schema", "required")
+	}
+	if err := requireVersion(schema.App, schema.Module, schema.Ver); err != nil {
+		return nil, err
+	}
+	if len(schema.Fields) == 0 {
+		return nil, invalidArgument("fields", "required")
+	}
+	fields, err := fieldsFromProto(schema.Fields)
+	if err != nil {
+		return nil, invalidArgument("fields", err.Error())
+	}
+	added := types.Schema{Version: int(schema.Ver), Description: schema.Description, Fields: fields}
+	if err := rigel.ValidateSchema(added); err != nil {
+		return nil, invalidArgument("fields", err.Error())
+	}
+	view := s.rigel.View(schema.App, schema.Module, int(schema.Ver), "")
+	if err := view.AddSchema(ctx, added); err != nil {
+		s.l.LogActivity("error while adding schema:", err)
+		return nil, Status(wscutils.ErrcodeDatabaseError, "", err)
+	}
+	return &rigelpb.CreateSchemaResponse{}, nil
+}
+
+// GetConfig returns the values of a config, as /configget.
+func (s *Server) GetConfig(ctx context.Context, req *rigelpb.GetConfigRequest) (*rigelpb.Config, error) {
+	if err := requireConfig(req.App, req.Module, req.Ver, req.Config); err != nil {
+		return nil, err
+	}
+	var config configsvc.ConfigResponse
+	var err error
+	if req.Effective || req.At != nil {
+		var at *time.Time
+		if req.At != nil {
+			if err := req.At.CheckValid(); err != nil {
+				return nil, Status(utils.INVALID_TIME, "at", err)
+			}
+			t := req.At.AsTime()
+			at = &t
+		}
+		config, err = configsvc.EffectiveConfig(ctx, s.rigel.View(req.App, req.Module, int(req.Ver), req.Config), at)
+		if errors.Is(err, rigel.ErrPastTime) {
+			return nil, Status(utils.INVALID_TIME, "at", err)
+		}
+	} else {
+		config, err = configsvc.ReadConfig(ctx, s.etcd, s.rigel, &utils.GetConfigRequestParams{App: &req.App, Module: &req.Module, Version: int(req.Ver), Config: &req.Config})
+	}
+	if err != nil {
+		s.l.LogActivity("error while getting config:", err)
+		return nil, Status(wscutils.ErrcodeMissing, "", err)
+	}
+	// The name of a config without values is not in the response of ReadConfig
+	return &rigelpb.Config{
+		App:         req.App,
+		Module:      req.Module,
+		Ver:         req.Ver,
+		Config:      req.Config,
+		Description: config.Description,
+		Parent:      config.Parent,
+		Values:      valuesToProto(config.Values),
+	}, nil
+}
+
+// ListConfigs returns a page of the configs, as /configlist.
+func (s *Server) ListConfigs(ctx context.Context, req *rigelpb.ListRequest) (*rigelpb.ListConfigsResponse, error) {
+	params, err := listParams(req)
+	if err != nil {
+		return nil, err
+	}
+	container := &trees.Container{Etcd: s.etcd, Params: params}
+	if err := trees.Process(ctx, container); err != nil {
+		s.l.LogActivity("error while listing configs:", err)
+		return nil, Status(wscutils.ErrcodeMissing, "", err)
+	}
+	resp := &rigelpb.ListConfigsResponse{Total: int32(container.Total), NextCursor: container.NextCursor}
+	for _, data := range container.ResponseData {
+		config := data.(trees.GetConfigListResponse)
+		resp.Configs = append(resp.Configs, &rigelpb.ConfigSummary{
+			App:         config.App,
+			Module:      config.Module,
+			Ver:         int32(config.Ver),
+			Config:      config.Config,
+			Description: config.Description,
+		})
+	}
+	return resp, nil
+}
+
+// SetConfig sets a value of a config, as /configset.
+func (s *Server) SetConfig(ctx context.Context, req *rigelpb.SetConfigRequest) (*rigelpb.SetConfigResponse, error) {
+	if err := requireConfig(req.App, req.Module, req.Ver, req.Config); err != nil {
+		return nil, err
+	}
+	if req.Key == "" {
+		return nil, invalidArgument("key", "required")
+	}
+	if req.Value == nil {
+		return nil, invalidArgument("value", "required")
+	}
+	view, err := s.view(ctx, req.App, req.Module, req.Ver, req.Config, req.Override, req.OverrideReason)
+	if err != nil {
+		return nil, err
+	}
+	if code, err := configsvc.SetConfig(ctx, view, req.Key, req.Value.AsInterface()); err != nil {
+		s.l.LogActivity("error while setting value in etcd:", err)
+		return nil, Status(code, "", err)
+	}
+	return &rigelpb.SetConfigResponse{}, nil
+}
+
+// UpdateConfig sets the parent and values of a config, as /configupdate.
+func (s *Server) UpdateConfig(ctx context.Context, req *rigelpb.UpdateConfigRequest) (*rigelpb.UpdateConfigResponse, error) {
+	if err := requireConfig(req.App, req.Module, req.Ver, req.Config); err != nil {
+		return nil, err
+	}
+	values := make([]configsvc.UpdateValue, len(req.Values))
+	for i, v := range req.Values {
+		if v.Name == "" {
+			return nil, invalidArgument("values", "name required")
+		}
+		values[i] = configsvc.UpdateValue{Name: v.Name, Value: v.Value}
+		if v.Rules != nil {
+			values[i].Rules = rulesFromProto(v.Rules.Rules)
+		}
+	}
+	view, err := s.view(ctx, req.App, req.Module, req.Ver, req.Config, req.Override, req.OverrideReason)
+	if err != nil {
+		return nil, err
+	}
+	if code, err := configsvc.UpdateConfig(ctx, view, req.Parent, values); err != nil {
+		s.l.LogActivity("error while updating config in etcd:", err)
+		return nil, Status(code, "", err)
+	}
+	return &rigelpb.UpdateConfigResponse{}, nil
+}
+
+// Watch streams the changes to an app, a module or a config, as /configstream. If the server no
+// longer has the changes made after req.AfterRevision, the first event holds all the values
+// instead. When the stream ends with codes.Unavailable, the client resumes it after the last
+// revision it got.
+func (s *Server) Watch(req *rigelpb.WatchRequest, stream rigelpb.Rigel_WatchServer) error {
+	prefix, field := configsvc.StreamPrefix(req.App, req.Module, int(req.Ver), req.Config)
+	if prefix == "" {
+		return invalidArgument(field, "required")
+	}
+	if req.AfterRevision < 0 {
+		return invalidArgument("after_revision", "must not be negative")
+	}
+	ctx := stream.Context()
+	sub, err := s.hub.Subscribe(ctx, prefix, req.AfterRevision)
+	if errors.Is(err, rigel.ErrFutureRevision) {
+		return Status(wscutils.ERRCODE_INVALID_REQUEST, "after_revision", err)
+	}
+	if err != nil {
+		s.l.LogActivity("error while subscribing to changes:", err)
+		return Status(wscutils.ErrcodeMissing, "", err)
+	}
+	defer sub.Close()
+
+	if sub.Snapshot != nil {
+		snapshot := &rigelpb.WatchEvent{Revision: sub.SnapshotRevision, Snapshot: true}
+		for _, change := range configsvc.SnapshotChanges(ctx, s.rigel, sub.Snapshot, sub.SnapshotRevision) {
+			snapshot.Changes = append(snapshot.Changes, changeToProto("put", change))
+		}
+		if err := stream.Send(snapshot); err != nil {
+			return err
+		}
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return status.FromContextError(ctx.Err()).Err()
+		case event, ok := <-sub.Events():
+			if !ok {
+				s.l.LogActivity("change stream ended:", sub.Err())
+				return status.Error(codes.Unavailable, fmt.Sprintf("change stream ended: %v", sub.Err()))
+			}
+			if event.Err != nil {
+				s.l.LogDebug("change stream watch error:", logharbour.DebugInfo{Variables: map[string]any{"error": event.Err.Error()}})
+				continue
+			}
+			change, ok := configsvc.NewStreamChange(ctx, s.rigel, event.Key, event.Value, event.Revision)
+			if !ok {
+				continue
+			}
+			err := stream.Send(&rigelpb.WatchEvent{Revision: event.Revision, Changes: []*rigelpb.Change{changeToProto(event.Type.String(), change)}})
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// view returns the view of a config, made to override locks if the request asks to. A call
+// that does not carry the admin token cannot, and fails with utils.NOT_AUTHORIZED.
+func (s *Server) view(ctx context.Context, app, module string, ver int32, config string, override bool, reason string) (*rigel.View, error) {
+	view := s.rigel.View(app, module, int(ver), config)
+	if !override {
+		return view, nil
+	}
+	if !s.isAdmin(ctx) {
+		return nil, Status(utils.NOT_AUTHORIZED, "override", errors.New("only admins can override locks"))
+	}
+	s.l.LogActivity("overriding config locks:", map[string]any{"app": app, "module": module, "ver": ver, "config": config, "reason": reason})
+	return view.WithLockOverride(reason), nil
+}
+
+// isAdmin reports whether the call carries the admin token of the server as a bearer token in
+// its "authorization" metadata, see configsvc.IsAdmin.
+func (s *Server) isAdmin(ctx context.Context) bool {
+	var authorization string
+	if md, ok := metadata.FromIncomingContext(ctx); ok && len(md.Get("authorization")) > 0 {
+		authorization = md.Get("authorization")[0]
+	}
+	return configsvc.IsAdmin(s.adminToken, authorization)
+}
+
+// listParams returns the list parameters of req, or an error naming the first invalid one.
+func listParams(req *rigelpb.ListRequest) (utils.ListParams, error) {
+	params := utils.ListParams{
+		App:    req.App,
+		Module: req.Module,
+		MinVer: int(req.MinVer),
+		MaxVer: int(req.MaxVer),
+		Config: req.Config,
+		Sort:   req.Sort,
+		Limit:  int(req.Limit),
+		Offset: int(req.Offset),
+		Cursor: req.Cursor,
+	}
+	if field, err := params.Validate(); err != nil {
+		return params, Status(wscutils.ERRCODE_INVALID_REQUEST, field, err)
+	}
+	return params, nil
+}
+
+func fieldsToProto(fields []types.Field) []*rigelpb.Field {
+	var pbFields []*rigelpb.Field
+	for _, f := range fields {
+		pbField := &rigelpb.Field{Name: f.Name, Type: f.Type, Flag: f.Flag, Fields: fieldsToProto(f.Fields)}
+		if c := f.Constraints; c != nil {
+			pbField.Constraints = &rigelpb.Constraints{Enum: c.Enum}
+			if c.Min != nil {
+				min := int32(*c.Min)
+				pbField.Constraints.Min = &min
+			}
+			if c.Max != nil {
+				max := int32(*c.Max)
+				pbField.Constraints.Max = &max
+			}
+		}
+		pbFields = append(pbFields, pbField)
+	}
+	return pbFields
+}
+
+// fieldsFromProto converts the fields of a schema, checking that each has a name and a type.
+func fieldsFromProto(pbFields []*rigelpb.Field) ([]types.Field, error) {
+	fields := make([]types.Field, 0, len(pbFields))
+	for _, f := range pbFields {
+		if f.Name == "" || f.Type == "" {
+			return nil, errors.New("every field needs a name and a type")
+		}
+		field := types.Field{Name: f.Name, Type: f.Type, Flag: f.Flag}
+		if f.Type == types.FieldTypeGroup {
+			nested, err := fieldsFromProto(f.Fields)
+			if err != nil {
+				return nil, err
+			}
+			field.Fields = nested
+		}
+		if c := f.Constraints; c != nil {
+			field.Constraints = &types.Constraints{Enum: c.Enum}
+			if c.Min != nil {
+				min := int(*c.Min)
+				field.Constraints.Min = &min
+			}
+			if c.Max != nil {
+				max := int(*c.Max)
+				field.Constraints.Max = &max
+			}
+		}
+		fields = append(fields, field)
+	}
+	return fields, nil
+}
+
+func valuesToProto(values []configsvc.ConfigValue) []*rigelpb.ConfigValue {
+	var pbValues []*rigelpb.ConfigValue
+	for _, v := range values {
+		pbValues = append(pbValues, &rigelpb.ConfigValue{
+			Name:   v.Name,
+			Value:  v.Value,
+			Source: v.Source,
+			Rules:  rulesToProto(v.Rules),
+			Values: valuesToProto(v.Values),
+		})
+	}
+	return pbValues
+}
+
+func rulesToProto(rules []types.Rule) []*rigelpb.Rule {
+	var pbRules []*rigelpb.Rule
+	for _, r := range rules {
+		pbRules = append(pbRules, &rigelpb.Rule{Match: r.Match, Value: r.Value})
+	}
+	return pbRules
+}
+
+// rulesFromProto converts rules, giving an empty rather than a nil slice for no rules, which
+// removes the rules of a value.
+func rulesFromProto(pbRules []*rigelpb.Rule) []types.Rule {
+	rules := make([]types.Rule, 0, len(pbRules))
+	for _, r := range pbRules {
+		rules = append(rules, types.Rule{Match: r.Match, Value: r.Value})
+	}
+	return rules
+}
+
+func changeToProto(changeType string, c configsvc.StreamChange) *rigelpb.Change {
+	return &rigelpb.Change{
+		Type:     changeType,
+		App:      c.App,
+		Module:   c.Module,
+		Ver:      int32(c.Ver),
+		Config:   c.Config,
+		Kind:     c.Kind,
+		Key:      c.Key,
+		Value:    c.Value,
+		Revision: c.Revision,
+	}
+}
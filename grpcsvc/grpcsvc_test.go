@@ -0,0 +1,246 @@
+package grpcsvc
+
+import (
+	"context"
+	"io"
+	"net"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/remiges-aniket/etcd"
+	"github.com/remiges-aniket/rigel"
+	"github.com/remiges-aniket/rigelpb"
+	"github.com/remiges-aniket/types"
+	"github.com/remiges-aniket/utils"
+	"github.com/remiges-tech/logharbour/logharbour"
+	"go.etcd.io/etcd/tests/v3/integration"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+// setupServer serves the API over an in-memory connection, backed by an embedded etcd server,
+// and returns a client of it, along with the storage.
+func setupServer(t *testing.T) (rigelpb.RigelClient, *etcd.EtcdStorage) {
+	t.Helper()
+	integration.BeforeTestExternal(t)
+	clus := integration.NewClusterV3(t, &integration.ClusterConfig{Size: 1})
+	t.Cleanup(func() { clus.Terminate(t) })
+
+	etcdStorage := &etcd.EtcdStorage{Client: clus.RandClient()}
+	keyringPath := filepath.Join(t.TempDir(), "keyring.json")
+	if err := rigel.AddKeyringKey(keyringPath, "k1"); err != nil {
+		t.Fatalf("AddKeyringKey() error = %v", err)
+	}
+	keyring, err := rigel.LoadKeyring(keyringPath)
+	if err != nil {
+		t.Fatalf("LoadKeyring() error = %v", err)
+	}
+	rigelClient := rigel.NewWithStorage(etcdStorage).WithKeyring(keyring)
+	l := logharbour.NewLogger(logharbour.NewLoggerContext(logharbour.Info), "rigel", io.Discard)
+
+	lis := bufconn.Listen(1 << 20)
+	s := grpc.NewServer()
+	rigelpb.RegisterRigelServer(s, NewServer(rigelClient, etcdStorage, rigel.NewHub(etcdStorage, 2), l).WithAdminToken("admin-token"))
+	go s.Serve(lis)
+	t.Cleanup(s.Stop)
+
+	conn, err := grpc.Dial("bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatalf("Dial() error = %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return rigelpb.NewRigelClient(conn), etcdStorage
+}
+
+// errorReason returns the status code of err and the error code of its ErrorInfo detail.
+func errorReason(err error) (codes.Code, string) {
+	st := status.Convert(err)
+	for _, d := range st.Details() {
+		if info, ok := d.(*errdetails.ErrorInfo); ok {
+			return st.Code(), info.Reason
+		}
+	}
+	return st.Code(), ""
+}
+
+func TestServer(t *testing.T) {
+	client, _ := setupServer(t)
+	ctx := context.Background()
+
+	create := &rigelpb.CreateSchemaRequest{Schema: &rigelpb.Schema{
+		App: "app0", Module: "testModule", Ver: 1, Description: "test schema",
+		Fields: []*rigelpb.Field{
+			{Name: "port", Type: "int"},
+			{Name: "password", Type: types.FieldTypeSecret},
+		},
+	}}
+	// Only admins create schemas, and only valid ones
+	_, err := client.CreateSchema(ctx, create)
+	if code, reason := errorReason(err); code != codes.PermissionDenied || reason != utils.NOT_AUTHORIZED {
+		t.Errorf("CreateSchema() without the admin token = %v, %q, want PermissionDenied, %s", code, reason, utils.NOT_AUTHORIZED)
+	}
+	admin := metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer admin-token")
+	invalid := &rigelpb.CreateSchemaRequest{Schema: &rigelpb.Schema{App: "app0", Module: "testModule", Ver: 2, Fields: []*rigelpb.Field{{Name: "port", Type: "uint"}}}}
+	if _, err := client.CreateSchema(admin, invalid); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("CreateSchema() of an invalid schema error = %v, want InvalidArgument", err)
+	}
+	if _, err := client.CreateSchema(admin, create); err != nil {
+		t.Fatalf("CreateSchema() error = %v", err)
+	}
+	schema, err := client.GetSchema(ctx, &rigelpb.GetSchemaRequest{App: "app0", Module: "testModule", Ver: 1})
+	if err != nil || schema.Description != "test schema" || len(schema.Fields) != 2 {
+		t.Errorf("GetSchema() = %v, %v, want the created schema", schema, err)
+	}
+	if _, err := client.GetSchema(ctx, &rigelpb.GetSchemaRequest{App: "app0", Module: "testModule", Ver: 2}); status.Code(err) != codes.NotFound {
+		t.Errorf("GetSchema() of a missing version error = %v, want NotFound", err)
+	}
+
+	config := &rigelpb.SetConfigRequest{App: "app0", Module: "testModule", Ver: 1, Config: "prod", Key: "port", Value: structpb.NewNumberValue(8080)}
+	if _, err := client.SetConfig(ctx, config); err != nil {
+		t.Fatalf("SetConfig() error = %v", err)
+	}
+	parent := ""
+	_, err = client.UpdateConfig(ctx, &rigelpb.UpdateConfigRequest{
+		App: "app0", Module: "testModule", Ver: 1, Config: "prod", Parent: &parent,
+		Values: []*rigelpb.UpdateValue{{Name: "password", Value: "s3cret"}},
+	})
+	if err != nil {
+		t.Fatalf("UpdateConfig() error = %v", err)
+	}
+
+	got, err := client.GetConfig(ctx, &rigelpb.GetConfigRequest{App: "app0", Module: "testModule", Ver: 1, Config: "prod"})
+	if err != nil {
+		t.Fatalf("GetConfig() error = %v", err)
+	}
+	values := map[string]string{}
+	for _, v := range got.Values {
+		values[v.Name] = v.Value
+	}
+	if want := map[string]string{"port": "8080", "password": rigel.SecretMask}; !reflect.DeepEqual(values, want) {
+		t.Errorf("GetConfig() values = %v, want %v", values, want)
+	}
+
+	// The lists are read from the storage, so they include what was created since the server started
+	schemas, err := client.ListSchemas(ctx, &rigelpb.ListRequest{App: "app0"})
+	if err != nil || schemas.Total != 1 || schemas.Schemas[0].Description != "test schema" {
+		t.Errorf("ListSchemas() = %v, %v, want the created schema", schemas, err)
+	}
+	configs, err := client.ListConfigs(ctx, &rigelpb.ListRequest{Config: "pr*"})
+	if err != nil || configs.Total != 1 || configs.Configs[0].Config != "prod" {
+		t.Errorf("ListConfigs() = %v, %v, want config prod", configs, err)
+	}
+	if _, err := client.ListConfigs(ctx, &rigelpb.ListRequest{Limit: -1}); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("ListConfigs() with a negative limit error = %v, want InvalidArgument", err)
+	}
+}
+
+func TestServerLockedConfig(t *testing.T) {
+	client, etcdStorage := setupServer(t)
+	ctx := context.Background()
+
+	r := rigel.NewWithStorage(etcdStorage)
+	if err := r.View("app0", "testModule", 1, "").AddSchema(ctx, types.Schema{Version: 1, Fields: []types.Field{{Name: "port", Type: "int"}}}); err != nil {
+		t.Fatalf("AddSchema() error = %v", err)
+	}
+	lock := types.Lock{Scope: types.LockScopeModule, Reason: "month-end", LockedAt: time.Now()}
+	if err := r.View("app0", "testModule", 1, "prod").SetLock(ctx, lock); err != nil {
+		t.Fatalf("SetLock() error = %v", err)
+	}
+
+	set := &rigelpb.SetConfigRequest{App: "app0", Module: "testModule", Ver: 1, Config: "prod", Key: "port", Value: structpb.NewNumberValue(8080)}
+	_, err := client.SetConfig(ctx, set)
+	if code, reason := errorReason(err); code != codes.FailedPrecondition || reason != utils.CONFIG_LOCKED {
+		t.Errorf("SetConfig() of a locked config = %v, %q, want FailedPrecondition, %s", code, reason, utils.CONFIG_LOCKED)
+	}
+	set.Override, set.OverrideReason = true, "wrong port"
+	_, err = client.SetConfig(ctx, set)
+	if code, reason := errorReason(err); code != codes.PermissionDenied || reason != utils.NOT_AUTHORIZED {
+		t.Errorf("SetConfig() with override without the admin token = %v, %q, want PermissionDenied, %s", code, reason, utils.NOT_AUTHORIZED)
+	}
+	admin := metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer admin-token")
+	if _, err := client.SetConfig(admin, set); err != nil {
+		t.Errorf("SetConfig() with override error = %v", err)
+	}
+
+	_, err = client.SetConfig(ctx, &rigelpb.SetConfigRequest{App: "app0", Module: "testModule", Ver: 1, Key: "port"})
+	if code, reason := errorReason(err); code != codes.InvalidArgument || reason != "invalid_request" {
+		t.Errorf("SetConfig() without a config = %v, %q, want InvalidArgument, invalid_request", code, reason)
+	}
+}
+
+func TestServerWatch(t *testing.T) {
+	client, etcdStorage := setupServer(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	r := rigel.NewWithStorage(etcdStorage)
+	if err := r.View("app0", "testModule", 1, "").AddSchema(ctx, types.Schema{Version: 1, Fields: []types.Field{{Name: "port", Type: "int"}}}); err != nil {
+		t.Fatalf("AddSchema() error = %v", err)
+	}
+	stream, err := client.Watch(ctx, &rigelpb.WatchRequest{App: "app0", Module: "testModule", Ver: 1, Config: "prod"})
+	if err != nil {
+		t.Fatalf("Watch() error = %v", err)
+	}
+	// Changes are sent once the server subscribed, which the first Recv cannot tell, so keep writing
+	var event *rigelpb.WatchEvent
+	received := make(chan error, 1)
+	go func() {
+		var err error
+		event, err = stream.Recv()
+		received <- err
+	}()
+	view := r.View("app0", "testModule", 1, "prod")
+	for done := false; !done; {
+		if err := view.Set(ctx, "port", "9090"); err != nil {
+			t.Fatalf("Set() error = %v", err)
+		}
+		select {
+		case err := <-received:
+			if err != nil {
+				t.Fatalf("Recv() error = %v", err)
+			}
+			done = true
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+	if len(event.Changes) != 1 || event.Changes[0].Key != "port" || event.Changes[0].Value != "9090" || event.Changes[0].Type != "put" {
+		t.Errorf("Watch() event = %v, want port set to 9090", event)
+	}
+
+	// A stream that resumes after the changes the server kept starts with a snapshot
+	for _, port := range []string{"7070", "6060"} {
+		if err := view.Set(ctx, "port", port); err != nil {
+			t.Fatalf("Set() error = %v", err)
+		}
+	}
+	resumed, err := client.Watch(ctx, &rigelpb.WatchRequest{App: "app0", Module: "testModule", Ver: 1, Config: "prod", AfterRevision: 1})
+	if err != nil {
+		t.Fatalf("Watch() error = %v", err)
+	}
+	snapshot, err := resumed.Recv()
+	if err != nil {
+		t.Fatalf("Recv() error = %v", err)
+	}
+	if !snapshot.Snapshot || len(snapshot.Changes) != 1 || snapshot.Changes[0].Value != "6060" {
+		t.Errorf("resumed Watch() event = %v, want a snapshot with port 6060", snapshot)
+	}
+
+	// The errors of a stream come with its first message
+	invalid, err := client.Watch(ctx, &rigelpb.WatchRequest{Module: "testModule"})
+	if err == nil {
+		_, err = invalid.Recv()
+	}
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("Watch() without an app error = %v, want InvalidArgument", err)
+	}
+}
@@ -0,0 +1,272 @@
+// Package layout defines where Rigel keeps schemas and configs in its storage. All packages
+// build and parse storage keys with it, so that the client, the server and the tools agree.
+//
+// The keys of a schema and its configs are:
+//
+//	/remiges/rigel/<app>/<module>/<ver>/fields                             the fields of the schema
+//	/remiges/rigel/<app>/<module>/<ver>/description                        the description of the schema
+//	/remiges/rigel/<app>/<module>/<ver>/config/<config>/<key path>         a value of a named config
+//	/remiges/rigel/<app>/<module>/<ver>/config/<config>/.description       the description of the config
+//	/remiges/rigel/<app>/<module>/<ver>/config/<config>/.parent            the parent of the config
+//	/remiges/rigel/<app>/<module>/<ver>/config/<config>/.rules/<key path>  the targeting rules of a value
+//	/remiges/rigel/<app>/<module>/<ver>/config/<config>/.flags/<key path>  the rollout state of a flag
+//	/remiges/rigel/<app>/<module>/<ver>/config/<config>/.lock              the lock of the config
+//	/remiges/rigel/<app>/<module>/<ver>/config/<config>/.overrides/<time>  the overrides of its locks
+//	/remiges/rigel/<app>/<module>/.lock                                    the lock of all configs of a module
+//	/remiges/rigel/<app>/.lock                                             the lock of all configs of an app
+//	/remiges/rigel/.schedule/<id>                                          a scheduled change to a config
+//	/remiges/rigel/.clock                                                  the time of the last write to configs
+//
+// The key path of a config key is its dotted name with the dots replaced by slashes, so
+// "db.pool.max" is kept at db/pool/max. Path segments starting with a dot are thus reserved.
+package layout
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	Prefix               = "/remiges/rigel" // Prefix is the prefix of all keys of Rigel
+	FieldsKey            = "fields"
+	DescriptionKey       = "description"
+	ConfigsKey           = "config" // ConfigsKey is the segment below a schema version holding its named configs
+	ConfigDescriptionKey = ".description"
+	ParentKey            = ".parent"
+	RulesKey             = ".rules"
+	FlagsKey             = ".flags"
+	LockKey              = ".lock"
+	OverridesKey         = ".overrides"
+	ScheduleKey          = ".schedule"
+	ClockKey             = ".clock"
+)
+
+// SchemaPath returns the prefix of the keys of a schema version and its configs.
+func SchemaPath(app string, module string, version int) string {
+	return fmt.Sprintf("%s/%s/%s/%d/", Prefix, app, module, version)
+}
+
+// SchemaFieldsPath returns the key holding the fields of a schema.
+func SchemaFieldsPath(app string, module string, version int) string {
+	return SchemaPath(app, module, version) + FieldsKey
+}
+
+// SchemaDescriptionPath returns the key holding the description of a schema.
+func SchemaDescriptionPath(app string, module string, version int) string {
+	return SchemaPath(app, module, version) + DescriptionKey
+}
+
+// ConfigsPath returns the prefix of the keys of all the named configs of a schema version.
+func ConfigsPath(app string, module string, version int) string {
+	return SchemaPath(app, module, version) + ConfigsKey + "/"
+}
+
+// ConfigPath returns the path of a named config, the prefix of its keys without the trailing slash.
+func ConfigPath(app string, module string, version int, config string) string {
+	return ConfigsPath(app, module, version) + config
+}
+
+// ConfigKeyPath returns the key holding the value of confKey in a named config.
+func ConfigKeyPath(app string, module string, version int, config string, confKey string) string {
+	return ConfigPath(app, module, version, config) + "/" + KeyToPath(confKey)
+}
+
+// ConfigDescriptionPath returns the key holding the description of a named config.
+func ConfigDescriptionPath(app string, module string, version int, config string) string {
+	return ConfigPath(app, module, version, config) + "/" + ConfigDescriptionKey
+}
+
+// ConfigParentPath returns the key holding the name of the parent of a named config.
+func ConfigParentPath(app string, module string, version int, config string) string {
+	return ConfigPath(app, module, version, config) + "/" + ParentKey
+}
+
+// ConfigRulesPath returns the key holding the targeting rules of confKey in a named config.
+func ConfigRulesPath(app string, module string, version int, config string, confKey string) string {
+	return ConfigPath(app, module, version, config) + "/" + RulesKey + "/" + KeyToPath(confKey)
+}
+
+// ConfigFlagPath returns the key holding the rollout state of the feature flag confKey in a named config.
+func ConfigFlagPath(app string, module string, version int, config string, confKey string) string {
+	return ConfigPath(app, module, version, config) + "/" + FlagsKey + "/" + KeyToPath(confKey)
+}
+
+// AppLockPath returns the key holding the lock of all configs of an app.
+func AppLockPath(app string) string {
+	return fmt.Sprintf("%s/%s/%s", Prefix, app, LockKey)
+}
+
+// ModuleLockPath returns the key holding the lock of all configs of a module.
+func ModuleLockPath(app string, module string) string {
+	return fmt.Sprintf("%s/%s/%s/%s", Prefix, app, module, LockKey)
+}
+
+// ConfigLockPath returns the key holding the lock of a named config.
+func ConfigLockPath(app string, module string, version int, config string) string {
+	return ConfigPath(app, module, version, config) + "/" + LockKey
+}
+
+// ConfigOverridePath returns the key recording an override of a lock of a named config made at.
+// The keys of the overrides of a config sort in the order they were made.
+func ConfigOverridePath(app string, module string, version int, config string, at time.Time) string {
+	return ConfigPath(app, module, version, config) + "/" + OverridesKey + "/" + at.UTC().Format("20060102T150405.000000000Z")
+}
+
+// SchedulePath returns the prefix of the keys of all scheduled changes.
+func SchedulePath() string {
+	return Prefix + "/" + ScheduleKey + "/"
+}
+
+// ScheduledChangePath returns the key holding the scheduled change with the given id.
+func ScheduledChangePath(id string) string {
+	return SchedulePath() + id
+}
+
+// ClockPath returns the key holding the time of the last write to configs. Its past values,
+// kept in the history of the storage, tell the time of each revision.
+func ClockPath() string {
+	return Prefix + "/" + ClockKey
+}
+
+// IsReserved reports whether a path segment is reserved for Rigel's own keys, such as .lock,
+// rather than being the name of an app, module, version, config or config key.
+func IsReserved(segment string) bool {
+	return strings.HasPrefix(segment, ".")
+}
+
+// KeyToPath converts the dotted name of a config key to its key path below the config.
+func KeyToPath(confKey string) string {
+	return strings.ReplaceAll(confKey, ".", "/")
+}
+
+// KeyFromPath converts a key path below the config to the dotted name of the config key.
+func KeyFromPath(path string) string {
+	return strings.ReplaceAll(path, "/", ".")
+}
+
+// Key is a storage key split into its parts. Config and Path are empty for the keys of a
+// schema, and Path is the key path below the config, such as "db/pool/max" or ".parent".
+type Key struct {
+	App     string
+	Module  string
+	Version int
+	Config  string
+	Path    string
+}
+
+// Parse splits a storage key in the canonical layout into its parts. It returns false for keys
+// outside the layout, including those in the legacy layouts that Migrations moves.
+func Parse(key string) (Key, bool) {
+	rest, found := strings.CutPrefix(key, Prefix+"/")
+	if !found {
+		return Key{}, false
+	}
+	parts := strings.SplitN(rest, "/", 6)
+	if len(parts) < 4 {
+		return Key{}, false
+	}
+	version, err := strconv.Atoi(parts[2])
+	if err != nil {
+		return Key{}, false
+	}
+	k := Key{App: parts[0], Module: parts[1], Version: version}
+	switch {
+	case len(parts) == 4 && (parts[3] == FieldsKey || parts[3] == DescriptionKey):
+		k.Path = parts[3]
+		return k, true
+	case len(parts) == 6 && parts[3] == ConfigsKey && parts[4] != "" && parts[5] != "":
+		k.Config, k.Path = parts[4], parts[5]
+		return k, true
+	}
+	return Key{}, false
+}
+
+// Migration moves a value from a key in a legacy layout to its key in the canonical layout.
+// Conflict is set if the canonical key already holds a different value, in which case the
+// value must not be moved.
+type Migration struct {
+	From     string
+	To       string
+	Value    string
+	Conflict bool
+}
+
+// Migrations returns the moves needed to bring the keys in kvs, read from below Prefix, into
+// the canonical layout, sorted by From. Older servers and clients kept the keys of named
+// configs directly below the schema version, at .../<ver>/<config>/<key path>; those keys
+// are moved to .../<ver>/config/<config>/<key path>. They also kept the description of a
+// config at <config>/description, which is moved to <config>/.description unless the schema
+// of the config, as found in kvs, has a field named description.
+func Migrations(kvs map[string]string) []Migration {
+	var migrations []Migration
+	for key, value := range kvs {
+		to, legacy := canonicalKey(key, kvs)
+		if !legacy {
+			continue
+		}
+		current, exists := kvs[to]
+		migrations = append(migrations, Migration{
+			From:     key,
+			To:       to,
+			Value:    value,
+			Conflict: exists && current != value,
+		})
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].From < migrations[j].From })
+	return migrations
+}
+
+// canonicalKey returns the canonical key of key if key is in a legacy layout, given the
+// other keys kvs.
+func canonicalKey(key string, kvs map[string]string) (string, bool) {
+	to, legacy := canonicalConfigKey(key)
+	if !legacy {
+		to = key
+	}
+	k, ok := Parse(to)
+	if ok && k.Config != "" && k.Path == DescriptionKey && !hasField(kvs, k, DescriptionKey) {
+		return ConfigDescriptionPath(k.App, k.Module, k.Version, k.Config), true
+	}
+	return to, legacy
+}
+
+// hasField reports whether the schema of k, as found in kvs, has a top-level field named name.
+func hasField(kvs map[string]string, k Key, name string) bool {
+	var fields []struct {
+		Name string `json:"name"`
+	}
+	if err := json.Unmarshal([]byte(kvs[SchemaFieldsPath(k.App, k.Module, k.Version)]), &fields); err != nil {
+		return false
+	}
+	for _, f := range fields {
+		if f.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// canonicalConfigKey returns the canonical key of key if key is the key of a named config
+// kept directly below the schema version.
+func canonicalConfigKey(key string) (string, bool) {
+	rest, found := strings.CutPrefix(key, Prefix+"/")
+	if !found {
+		return "", false
+	}
+	parts := strings.SplitN(rest, "/", 5)
+	if len(parts) < 5 || parts[4] == "" {
+		return "", false
+	}
+	if _, err := strconv.Atoi(parts[2]); err != nil {
+		return "", false
+	}
+	switch parts[3] {
+	case ConfigsKey, FieldsKey, DescriptionKey, "":
+		return "", false
+	}
+	return fmt.Sprintf("%s/%s/%s/%s/%s/%s/%s", Prefix, parts[0], parts[1], parts[2], ConfigsKey, parts[3], parts[4]), true
+}
@@ -0,0 +1,78 @@
+package layout
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestPaths(t *testing.T) {
+	tests := []struct {
+		name string
+		path string
+		want string
+	}{
+		{"SchemaFieldsPath", SchemaFieldsPath("app", "mod", 1), "/remiges/rigel/app/mod/1/fields"},
+		{"SchemaDescriptionPath", SchemaDescriptionPath("app", "mod", 1), "/remiges/rigel/app/mod/1/description"},
+		{"ConfigsPath", ConfigsPath("app", "mod", 1), "/remiges/rigel/app/mod/1/config/"},
+		{"ConfigKeyPath", ConfigKeyPath("app", "mod", 1, "prod", "db.pool.max"), "/remiges/rigel/app/mod/1/config/prod/db/pool/max"},
+		{"ConfigDescriptionPath", ConfigDescriptionPath("app", "mod", 1, "prod"), "/remiges/rigel/app/mod/1/config/prod/.description"},
+		{"ConfigParentPath", ConfigParentPath("app", "mod", 1, "prod"), "/remiges/rigel/app/mod/1/config/prod/.parent"},
+		{"ConfigRulesPath", ConfigRulesPath("app", "mod", 1, "prod", "db.host"), "/remiges/rigel/app/mod/1/config/prod/.rules/db/host"},
+		{"ConfigFlagPath", ConfigFlagPath("app", "mod", 1, "prod", "beta"), "/remiges/rigel/app/mod/1/config/prod/.flags/beta"},
+		{"ClockPath", ClockPath(), "/remiges/rigel/.clock"},
+	}
+	for _, tt := range tests {
+		if tt.path != tt.want {
+			t.Errorf("%s = %s, want %s", tt.name, tt.path, tt.want)
+		}
+	}
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		key  string
+		want Key
+		ok   bool
+	}{
+		{"/remiges/rigel/app/mod/1/fields", Key{App: "app", Module: "mod", Version: 1, Path: "fields"}, true},
+		{"/remiges/rigel/app/mod/1/config/prod/db/host", Key{App: "app", Module: "mod", Version: 1, Config: "prod", Path: "db/host"}, true},
+		{"/remiges/rigel/app/mod/1/config/prod/.parent", Key{App: "app", Module: "mod", Version: 1, Config: "prod", Path: ".parent"}, true},
+		{"/remiges/rigel/app/mod/1/prod/port", Key{}, false},
+		{"/remiges/rigel/app/mod/one/fields", Key{}, false},
+		{"/other/app/mod/1/fields", Key{}, false},
+	}
+	for _, tt := range tests {
+		got, ok := Parse(tt.key)
+		if ok != tt.ok || got != tt.want {
+			t.Errorf("Parse(%s) = %+v, %v, want %+v, %v", tt.key, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestMigrations(t *testing.T) {
+	kvs := map[string]string{
+		"/remiges/rigel/app/mod/1/fields":                  "[]",
+		"/remiges/rigel/app/mod/1/description":             "schema",
+		"/remiges/rigel/app/mod/1/config/prod/port":        "8080",
+		"/remiges/rigel/app/mod/1/prod/description":        "prod",
+		"/remiges/rigel/app/mod/1/prod/db/host":            "db.internal",
+		"/remiges/rigel/app/mod/1/prod/port":               "9090",
+		"/remiges/rigel/app/mod/1/dev/port":                "80",
+		"/remiges/rigel/app/mod/1/config/dev/port":         "80",
+		"/remiges/rigel/app/mod/latest/prod/port":          "1",
+		"/remiges/rigel/app/mod/1/config/dev/description":  "dev",
+		"/remiges/rigel/app/mod/2/fields":                  `[{"name":"description","type":"string"}]`,
+		"/remiges/rigel/app/mod/2/config/prod/description": "a value",
+	}
+
+	want := []Migration{
+		{From: "/remiges/rigel/app/mod/1/config/dev/description", To: "/remiges/rigel/app/mod/1/config/dev/.description", Value: "dev"},
+		{From: "/remiges/rigel/app/mod/1/dev/port", To: "/remiges/rigel/app/mod/1/config/dev/port", Value: "80"},
+		{From: "/remiges/rigel/app/mod/1/prod/db/host", To: "/remiges/rigel/app/mod/1/config/prod/db/host", Value: "db.internal"},
+		{From: "/remiges/rigel/app/mod/1/prod/description", To: "/remiges/rigel/app/mod/1/config/prod/.description", Value: "prod"},
+		{From: "/remiges/rigel/app/mod/1/prod/port", To: "/remiges/rigel/app/mod/1/config/prod/port", Value: "9090", Conflict: true},
+	}
+	if got := Migrations(kvs); !reflect.DeepEqual(got, want) {
+		t.Errorf("Migrations() = %+v, want %+v", got, want)
+	}
+}
@@ -3,8 +3,10 @@ package main
 import (
 	"context"
 	"fmt"
+	"net"
 	"net/http"
 	"strconv"
+	"time"
 
 	"log"
 	"os"
@@ -12,13 +14,16 @@ import (
 	"github.com/gin-gonic/gin"
 	"github.com/remiges-aniket/configsvc"
 	"github.com/remiges-aniket/etcd"
+	"github.com/remiges-aniket/grpcsvc"
 	"github.com/remiges-aniket/rigel"
+	"github.com/remiges-aniket/rigelpb"
 	"github.com/remiges-aniket/schemaserv"
 	"github.com/remiges-aniket/utils"
 	"github.com/remiges-tech/alya/config"
 	"github.com/remiges-tech/alya/service"
 	"github.com/remiges-tech/alya/wscutils"
 	"github.com/remiges-tech/logharbour/logharbour"
+	"google.golang.org/grpc"
 )
 
 func main() {
@@ -56,44 +61,91 @@ func main() {
 		return
 	}
 
-	//Create a new Rigel instance
-	rigelClient := rigel.NewWithStorage(etcdStorage)
+	// Keep all keys of the server in its namespace, set in the config file or by RIGEL_NAMESPACE,
+	// so that several tenants or environments can share one etcd cluster
+	ns := appConfig.Namespace
+	if v := os.Getenv(rigel.EnvNamespace); v != "" {
+		ns = v
+	}
+	etcdStorage, err = etcdStorage.WithNamespace(ns)
+	if err != nil {
+		log.Fatalf("Failed to create EtcdStorage: %v", err)
+	}
 
-	// Create a context with a timeout
-	ctx, cancel := context.WithTimeout(context.Background(), utils.DIALTIMEOUT)
-	defer cancel()
+	// Create a new Rigel instance. Its values are read from etcd on every request rather than
+	// cached: other instances of the server change them, and nothing here would learn of it.
+	rigelClient := rigel.NewWithStorage(etcdStorage).WithCache(rigel.NoCache{})
 
-	// Get all keys from etcd
-	allkeys, err := etcdStorage.GetWithPrefix(ctx, "/")
-	if err != nil {
-		log.Fatalf("etcd interaction failed: %v", err)
-		return
+	// Secret values are encrypted with the keyring named by RIGEL_KEYRING_FILE
+	if path := os.Getenv(rigel.EnvKeyringFile); path != "" {
+		keyring, err := rigel.LoadKeyring(path)
+		if err != nil {
+			log.Fatalf("Failed to load keyring: %v", err)
+		}
+		rigelClient.WithKeyring(keyring)
 	}
 
-	// Build a Rigel STree
-	rTree := utils.NewNode("")
-	for k, v := range allkeys {
-		rTree.AddPath(k, v)
+	// Watch schemas so that the handlers are served schemas from the cache
+	if err := rigelClient.WatchSchemas(context.Background()); err != nil {
+		log.Fatalf("Failed to watch schemas: %v", err)
 	}
 
 	// Services
 
+	hub := rigel.NewHub(etcdStorage, utils.SSEBUFFERSIZE).WithGracePeriod(utils.HUBGRACEPERIOD)
+	// Locks are managed and overridden only by admins, who authenticate with the admin token
+	adminToken := appConfig.AdminToken
+	if v := os.Getenv(configsvc.EnvAdminToken); v != "" {
+		adminToken = v
+	}
 	s := service.NewService(r).
 		WithLogHarbour(l).
 		WithDependency("appConfig", appConfig).
-		WithDependency("rTree", rTree).
 		WithDependency("etcd", etcdStorage).
-		WithDependency("rigel", rigelClient)
+		WithDependency("rigel", rigelClient).
+		WithDependency("hub", hub).
+		WithDependency("adminToken", adminToken)
 
 	// Config Services
 	s.RegisterRoute(http.MethodGet, "/configget", configsvc.Config_get)
 	s.RegisterRoute(http.MethodGet, "/configlist", configsvc.Config_list)
 	s.RegisterRoute(http.MethodPost, "/configset", configsvc.Config_set)
 	s.RegisterRoute(http.MethodPost, "/configupdate", configsvc.Config_update)
+	s.RegisterRoute(http.MethodPost, "/configevaluate", configsvc.Config_evaluate)
+	s.RegisterRoute(http.MethodGet, "/flagget", configsvc.Flag_get)
+	s.RegisterRoute(http.MethodPost, "/flagset", configsvc.Flag_set)
+	s.RegisterRoute(http.MethodPost, "/configlock", configsvc.Config_lock)
+	s.RegisterRoute(http.MethodPost, "/configunlock", configsvc.Config_unlock)
+	s.RegisterRoute(http.MethodPost, "/configschedule", configsvc.Config_schedule)
+	s.RegisterRoute(http.MethodGet, "/schedulelist", configsvc.Schedule_list)
+	s.RegisterRoute(http.MethodPost, "/schedulecancel", configsvc.Schedule_cancel)
+	s.RegisterRoute(http.MethodGet, "/search", configsvc.Search)
+	s.RegisterRoute(http.MethodGet, "/configstream", configsvc.Config_stream)
+	s.RegisterRoute(http.MethodGet, "/configwatch", configsvc.Config_watch)
+
+	// Apply scheduled changes as they fall due. Every instance of the server does this, and
+	// each change is applied by only one of them.
+	go applyScheduledChanges(rigelClient, l)
 
 	// Schema Services
 	s.RegisterRoute(http.MethodGet, "/getschema", schemaserv.HandleGetSchemaRequest)
 	s.RegisterRoute(http.MethodGet, "/schemalist", schemaserv.HandleGetSchemaListRequest)
+	s.RegisterRoute(http.MethodPost, "/schemacreate", schemaserv.HandleCreateSchemaRequest)
+
+	// gRPC API, served by the same code and dependencies as the HTTP API
+	if appConfig.GRPCServerPort != "" {
+		lis, err := net.Listen("tcp", ":"+appConfig.GRPCServerPort)
+		if err != nil {
+			log.Fatalf("Failed to listen for gRPC: %v", err)
+		}
+		grpcServer := grpc.NewServer()
+		rigelpb.RegisterRigelServer(grpcServer, grpcsvc.NewServer(rigelClient, etcdStorage, hub, l).WithAdminToken(adminToken))
+		go func() {
+			if err := grpcServer.Serve(lis); err != nil {
+				log.Fatalf("Failed to start gRPC server: %v", err)
+			}
+		}()
+	}
 
 	r.Run(":" + appConfig.AppServerPort)
 	if err != nil {
@@ -102,6 +154,27 @@ func main() {
 
 }
 
+// applyScheduledChanges applies the scheduled changes that fell due every utils.SCHEDULEINTERVAL,
+// and deletes the finished ones older than utils.SCHEDULERETENTION.
+func applyScheduledChanges(r *rigel.Rigel, l *logharbour.Logger) {
+	for now := range time.Tick(utils.SCHEDULEINTERVAL) {
+		applied, err := r.ApplyDueChanges(context.Background(), now)
+		if err != nil {
+			l.LogActivity("error while applying scheduled changes:", err.Error())
+		}
+		if applied > 0 {
+			l.LogActivity("applied scheduled changes:", applied)
+		}
+		pruned, err := r.PruneScheduledChanges(context.Background(), now.Add(-utils.SCHEDULERETENTION))
+		if err != nil {
+			l.LogActivity("error while pruning scheduled changes:", err.Error())
+		}
+		if pruned > 0 {
+			l.LogActivity("pruned scheduled changes:", pruned)
+		}
+	}
+}
+
 func setConfigEnvironment(environment utils.Environment) (utils.AppConfig, utils.Environment) {
 	var appConfig utils.AppConfig
 	if !environment.IsValid() {
@@ -22,6 +22,13 @@ func (c *InMemoryCache) Get(key string) (value string, found bool) {
 	return
 }
 
+func (c *InMemoryCache) Contains(key string) bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	_, found := c.data[key]
+	return found
+}
+
 func (c *InMemoryCache) Set(key string, value string) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
@@ -33,3 +40,15 @@ func (c *InMemoryCache) Delete(key string) {
 	defer c.mu.Unlock()
 	delete(c.data, key)
 }
+
+// NoCache is a Cache that keeps nothing, so that every read goes to the storage. It suits a
+// server whose values are changed by other processes and that runs no watch to learn of them.
+type NoCache struct{}
+
+func (NoCache) Get(key string) (value string, found bool) { return "", false }
+
+func (NoCache) Contains(key string) bool { return false }
+
+func (NoCache) Set(key string, value string) {}
+
+func (NoCache) Delete(key string) {}
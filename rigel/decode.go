@@ -0,0 +1,236 @@
+package rigel
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/remiges-aniket/types"
+)
+
+// LoadReport lists the differences between a config struct and the schema found by LoadConfigReport.
+type LoadReport struct {
+	UnmatchedFields []string // UnmatchedFields are struct fields, by Go path such as "DB.Host", with no schema field
+	UnmatchedKeys   []string // UnmatchedKeys are schema fields with no struct field
+}
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// LoadConfigReport loads the config into configStruct like LoadConfig, and reports which fields
+// of configStruct have no counterpart in the schema and which schema fields have no counterpart
+// in configStruct. See decodeConfig for how struct fields are mapped to schema fields.
+func (r *Rigel) LoadConfigReport(ctx context.Context, configStruct any) (LoadReport, error) {
+	// Check if configStruct is a pointer to a struct
+	val := reflect.ValueOf(configStruct)
+	if val.Kind() != reflect.Ptr || val.Elem().Kind() != reflect.Struct {
+		return LoadReport{}, fmt.Errorf("configStruct must be a pointer to a struct")
+	}
+
+	// Read the config from the storage, or from the snapshot file if the storage fails
+	schema, values, rev, err := r.loadLive(ctx)
+	if err == nil {
+		r.saveSnapshot(schema, values, rev)
+	} else if schema, values, err = r.loadSnapshot(err); err != nil {
+		return LoadReport{}, err
+	}
+
+	// The snapshot keeps secret values encrypted, so they are only decrypted here
+	values, err = r.decryptValues(schema, values)
+	if err != nil {
+		return LoadReport{}, err
+	}
+	return decodeConfig(schema, values, val.Elem())
+}
+
+// decoder fills a config struct from config values.
+type decoder struct {
+	fieldTypes map[string]string
+	values     map[string]string
+	seen       map[string]bool
+	report     LoadReport
+}
+
+// decodeConfig fills the struct v with values, keyed by schema field name.
+//
+// The schema field of a struct field is named by its rigel tag, or else its json tag, or
+// else the field name. Fields of a nested struct are named with the name of the struct
+// field and a dot as prefix, for example "db.host"; fields of an embedded struct without
+// a tag are treated as fields of the outer struct. Pointer fields are optional: they are
+// left nil when the config has no value. time.Duration fields take values such as "1m30s".
+// A tag of "-" skips the field.
+func decodeConfig(schema *types.Schema, values map[string]string, v reflect.Value) (LoadReport, error) {
+	d := &decoder{
+		fieldTypes: getFieldTypes(schema),
+		values:     values,
+		seen:       make(map[string]bool),
+	}
+	if _, err := d.decodeStruct(v, "", "", false); err != nil {
+		return LoadReport{}, err
+	}
+
+	for _, field := range schema.Leaves() {
+		if !d.seen[field.Name] {
+			d.report.UnmatchedKeys = append(d.report.UnmatchedKeys, field.Name)
+		}
+	}
+	sort.Strings(d.report.UnmatchedFields)
+	sort.Strings(d.report.UnmatchedKeys)
+	return d.report, nil
+}
+
+// decodeStruct fills the fields of the struct v. It reports whether any field was given a value.
+// Inside an optional struct, one reached through a pointer, fields without a value are left alone.
+func (d *decoder) decodeStruct(v reflect.Value, keyPrefix string, pathPrefix string, optional bool) (bool, error) {
+	set := false
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() && !f.Anonymous {
+			continue
+		}
+		name, tagged := fieldKeyName(f)
+		if name == "-" {
+			continue
+		}
+		key := keyPrefix + name
+		path := pathPrefix + f.Name
+		fv := v.Field(i)
+
+		if isNestedStruct(f.Type) {
+			childPrefix := key + "."
+			if f.Anonymous && !tagged {
+				childPrefix = keyPrefix
+			}
+			ok, err := d.decodeNested(fv, childPrefix, path+".", optional)
+			if err != nil {
+				return false, err
+			}
+			set = set || ok
+			continue
+		}
+		if !f.IsExported() {
+			continue
+		}
+
+		key, inSchema := d.resolveKey(key, tagged)
+		if !inSchema {
+			d.report.UnmatchedFields = append(d.report.UnmatchedFields, path)
+			continue
+		}
+		d.seen[key] = true
+
+		raw, found := d.values[key]
+		if !found && (optional || f.Type.Kind() == reflect.Ptr) {
+			continue
+		}
+		if f.Type.Kind() == reflect.Ptr {
+			ptr := reflect.New(f.Type.Elem())
+			if err := setValue(ptr.Elem(), raw); err != nil {
+				return false, fmt.Errorf("failed to set field %s from key %s: %w", path, key, err)
+			}
+			fv.Set(ptr)
+		} else if err := setValue(fv, raw); err != nil {
+			return false, fmt.Errorf("failed to set field %s from key %s: %w", path, key, err)
+		}
+		set = set || found
+	}
+	return set, nil
+}
+
+// resolveKey returns the schema field name for key and whether the schema has it.
+// Like encoding/json, a key taken from an untagged field name matches a schema field
+// that differs only in case, so that a field Host matches the schema field host.
+func (d *decoder) resolveKey(key string, tagged bool) (string, bool) {
+	if _, found := d.fieldTypes[key]; found || tagged {
+		return key, found
+	}
+	for name := range d.fieldTypes {
+		if strings.EqualFold(name, key) {
+			return name, true
+		}
+	}
+	return key, false
+}
+
+// decodeNested fills a nested struct field, allocating it if it is a pointer and any of its fields has a value.
+func (d *decoder) decodeNested(fv reflect.Value, keyPrefix string, pathPrefix string, optional bool) (bool, error) {
+	if fv.Kind() != reflect.Ptr {
+		return d.decodeStruct(fv, keyPrefix, pathPrefix, optional)
+	}
+	ptr := reflect.New(fv.Type().Elem())
+	set, err := d.decodeStruct(ptr.Elem(), keyPrefix, pathPrefix, true)
+	if err != nil {
+		return false, err
+	}
+	if set && fv.CanSet() {
+		fv.Set(ptr)
+	}
+	return set, nil
+}
+
+// fieldKeyName returns the schema field name of a struct field and whether it came from a tag.
+func fieldKeyName(f reflect.StructField) (string, bool) {
+	if tag := f.Tag.Get("rigel"); tag != "" {
+		return tag, true
+	}
+	if tag := strings.Split(f.Tag.Get("json"), ",")[0]; tag != "" {
+		return tag, true
+	}
+	return f.Name, false
+}
+
+// isNestedStruct reports whether t is a struct, or pointer to struct, whose fields map to config keys.
+func isNestedStruct(t reflect.Type) bool {
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return t.Kind() == reflect.Struct
+}
+
+// setValue parses raw according to the kind of v and stores it in v.
+func setValue(v reflect.Value, raw string) error {
+	if v.Type() == durationType {
+		d, err := time.ParseDuration(raw)
+		if err != nil {
+			return fmt.Errorf("failed to convert value to duration: %w", err)
+		}
+		v.SetInt(int64(d))
+		return nil
+	}
+
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(raw)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(raw, 10, v.Type().Bits())
+		if err != nil {
+			return fmt.Errorf("failed to convert value to int: %w", err)
+		}
+		v.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(raw, 10, v.Type().Bits())
+		if err != nil {
+			return fmt.Errorf("failed to convert value to uint: %w", err)
+		}
+		v.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		n, err := strconv.ParseFloat(raw, v.Type().Bits())
+		if err != nil {
+			return fmt.Errorf("failed to convert value to float: %w", err)
+		}
+		v.SetFloat(n)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return fmt.Errorf("failed to convert value to bool: %w", err)
+		}
+		v.SetBool(b)
+	default:
+		return fmt.Errorf("unsupported field type %s", v.Type())
+	}
+	return nil
+}
@@ -0,0 +1,101 @@
+package rigel
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/remiges-aniket/types"
+)
+
+func TestDecodeConfig(t *testing.T) {
+	type Pool struct {
+		Max int `rigel:"max"`
+	}
+	type Common struct {
+		LogLevel string `rigel:"logLevel"`
+	}
+	type config struct {
+		Common
+		Host    string        `rigel:"host"`
+		Port    int           `json:"port"`
+		Debug   bool          // matched to "debug" by name
+		Timeout time.Duration `rigel:"timeout"`
+		DB      struct {
+			Host string `rigel:"host"`
+			Pool Pool   `rigel:"pool"`
+		} `rigel:"db"`
+		Cache   *Pool    `rigel:"cache"`
+		Ratio   *float64 `rigel:"ratio"`
+		Retries *int     `rigel:"retries"`
+		Extra   string   `rigel:"extra"`
+		Ignored string   `rigel:"-"`
+	}
+
+	schema := &types.Schema{Fields: []types.Field{
+		{Name: "logLevel", Type: "string"},
+		{Name: "host", Type: "string"},
+		{Name: "port", Type: "int"},
+		{Name: "debug", Type: "bool"},
+		{Name: "timeout", Type: "string"},
+		{Name: "db.host", Type: "string"},
+		{Name: "db.pool.max", Type: "int"},
+		{Name: "cache.max", Type: "int"},
+		{Name: "ratio", Type: "float"},
+		{Name: "retries", Type: "int"},
+		{Name: "unused", Type: "string"},
+	}}
+	values := map[string]string{
+		"logLevel":    "info",
+		"host":        "localhost",
+		"port":        "8080",
+		"debug":       "true",
+		"timeout":     "1m30s",
+		"db.host":     "db.internal",
+		"db.pool.max": "20",
+		"ratio":       "0.5",
+	}
+
+	var c config
+	report, err := decodeConfig(schema, values, reflect.ValueOf(&c).Elem())
+	if err != nil {
+		t.Fatalf("decodeConfig() error = %v", err)
+	}
+
+	if c.LogLevel != "info" || c.Host != "localhost" || c.Port != 8080 || !c.Debug {
+		t.Errorf("flat fields = %+v", c)
+	}
+	if c.Timeout != 90*time.Second {
+		t.Errorf("Timeout = %v, want 1m30s", c.Timeout)
+	}
+	if c.DB.Host != "db.internal" || c.DB.Pool.Max != 20 {
+		t.Errorf("DB = %+v", c.DB)
+	}
+	if c.Cache != nil {
+		t.Errorf("Cache = %+v, want nil for a struct pointer without values", c.Cache)
+	}
+	if c.Ratio == nil || *c.Ratio != 0.5 {
+		t.Errorf("Ratio = %v, want 0.5", c.Ratio)
+	}
+	if c.Retries != nil {
+		t.Errorf("Retries = %v, want nil for a missing optional value", *c.Retries)
+	}
+
+	want := LoadReport{
+		UnmatchedFields: []string{"Extra"},
+		UnmatchedKeys:   []string{"unused"},
+	}
+	if !reflect.DeepEqual(report, want) {
+		t.Errorf("report = %+v, want %+v", report, want)
+	}
+}
+
+func TestDecodeConfigInvalidValue(t *testing.T) {
+	var c struct {
+		Port int `rigel:"port"`
+	}
+	schema := &types.Schema{Fields: []types.Field{{Name: "port", Type: "int"}}}
+	if _, err := decodeConfig(schema, map[string]string{"port": "eighty"}, reflect.ValueOf(&c).Elem()); err == nil {
+		t.Errorf("decodeConfig() expected an error for a non-numeric port")
+	}
+}
@@ -0,0 +1,208 @@
+package rigel
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/remiges-aniket/etcd"
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+// Environment variables read by Default. Each overrides the matching setting of the
+// optional config file named by EnvConfigFile.
+const (
+	EnvConfigFile   = "RIGEL_CONFIG_FILE"    // EnvConfigFile names a JSON file holding Options
+	EnvEndpoints    = "RIGEL_ETCD_ENDPOINTS" // EnvEndpoints is a comma separated list of etcd endpoints
+	EnvUsername     = "RIGEL_ETCD_USERNAME"
+	EnvPassword     = "RIGEL_ETCD_PASSWORD"
+	EnvCAFile       = "RIGEL_ETCD_CA_FILE"
+	EnvCertFile     = "RIGEL_ETCD_CERT_FILE"
+	EnvKeyFile      = "RIGEL_ETCD_KEY_FILE"
+	EnvDialTimeout  = "RIGEL_ETCD_DIAL_TIMEOUT" // EnvDialTimeout is a duration such as "5s"
+	EnvApp          = "RIGEL_APP"
+	EnvModule       = "RIGEL_MODULE"
+	EnvVersion      = "RIGEL_VERSION"
+	EnvConfig       = "RIGEL_CONFIG"
+	EnvSnapshotFile = "RIGEL_SNAPSHOT_FILE"
+	EnvNamespace    = "RIGEL_NAMESPACE"    // EnvNamespace is the etcd namespace, such as "/tenants/acme", see etcd.EtcdStorage.WithNamespace
+	EnvKeyringFile  = "RIGEL_KEYRING_FILE" // EnvKeyringFile names the keyring file for secret fields, see LoadKeyring
+	EnvAttributes   = "RIGEL_ATTRIBUTES"   // EnvAttributes is a comma separated list of name=value pairs, such as "region=mumbai,hostname=web-1"
+)
+
+// Options holds the settings Default uses to construct a Rigel client.
+type Options struct {
+	Endpoints    []string          `json:"endpoints"`
+	Username     string            `json:"username"`
+	Password     string            `json:"password"`
+	CAFile       string            `json:"caFile"`
+	CertFile     string            `json:"certFile"`
+	KeyFile      string            `json:"keyFile"`
+	DialTimeout  string            `json:"dialTimeout"` // DialTimeout is a duration such as "5s"
+	Namespace    string            `json:"namespace"`   // Namespace holds all the keys of the client in etcd
+	App          string            `json:"app"`
+	Module       string            `json:"module"`
+	Version      int               `json:"ver"`
+	Config       string            `json:"config"`
+	SnapshotFile string            `json:"snapshotFile"`
+	KeyringFile  string            `json:"keyringFile"`
+	Attributes   map[string]string `json:"attributes"` // Attributes are matched against targeting rules, see WithAttributes
+}
+
+// LoadOptions resolves the Options used by Default. Settings are read from the JSON file
+// named by the RIGEL_CONFIG_FILE environment variable, if set, and then overridden by the
+// other RIGEL_* environment variables. Endpoints default to localhost:2379.
+func LoadOptions() (Options, error) {
+	var opts Options
+	if path := os.Getenv(EnvConfigFile); path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return opts, fmt.Errorf("failed to read rigel config file: %w", err)
+		}
+		if err := json.Unmarshal(data, &opts); err != nil {
+			return opts, fmt.Errorf("failed to parse rigel config file: %w", err)
+		}
+	}
+
+	if v := os.Getenv(EnvEndpoints); v != "" {
+		opts.Endpoints = strings.Split(v, ",")
+	}
+	for env, field := range map[string]*string{
+		EnvUsername:     &opts.Username,
+		EnvPassword:     &opts.Password,
+		EnvCAFile:       &opts.CAFile,
+		EnvCertFile:     &opts.CertFile,
+		EnvKeyFile:      &opts.KeyFile,
+		EnvDialTimeout:  &opts.DialTimeout,
+		EnvNamespace:    &opts.Namespace,
+		EnvApp:          &opts.App,
+		EnvModule:       &opts.Module,
+		EnvConfig:       &opts.Config,
+		EnvSnapshotFile: &opts.SnapshotFile,
+		EnvKeyringFile:  &opts.KeyringFile,
+	} {
+		if v := os.Getenv(env); v != "" {
+			*field = v
+		}
+	}
+	if v := os.Getenv(EnvVersion); v != "" {
+		version, err := strconv.Atoi(v)
+		if err != nil {
+			return opts, fmt.Errorf("invalid %s: %w", EnvVersion, err)
+		}
+		opts.Version = version
+	}
+
+	if v := os.Getenv(EnvAttributes); v != "" {
+		opts.Attributes = make(map[string]string)
+		for _, pair := range strings.Split(v, ",") {
+			name, value, found := strings.Cut(pair, "=")
+			if !found {
+				return opts, fmt.Errorf("invalid %s: %q is not a name=value pair", EnvAttributes, pair)
+			}
+			opts.Attributes[strings.TrimSpace(name)] = strings.TrimSpace(value)
+		}
+	}
+
+	if len(opts.Endpoints) == 0 {
+		opts.Endpoints = []string{defaultEtcdEndpoints}
+	}
+	return opts, nil
+}
+
+// validate checks that opts names the config the client is for.
+func (opts Options) validate() error {
+	var missing []string
+	if opts.App == "" {
+		missing = append(missing, EnvApp)
+	}
+	if opts.Module == "" {
+		missing = append(missing, EnvModule)
+	}
+	if opts.Version == 0 {
+		missing = append(missing, EnvVersion)
+	}
+	if opts.Config == "" {
+		missing = append(missing, EnvConfig)
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("missing rigel settings: %s", strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// Storage connects to the etcd storage described by opts: its endpoints, credentials, TLS files,
+// dial timeout and namespace. Tools that work on the storage directly, such as key rotation, use
+// it to connect the same way as Default.
+func (opts Options) Storage() (*etcd.EtcdStorage, error) {
+	if err := etcd.CheckNamespace(opts.Namespace); err != nil {
+		return nil, err
+	}
+	cfg, err := opts.etcdConfig()
+	if err != nil {
+		return nil, err
+	}
+	storage, err := etcd.NewEtcdStorage(opts.Endpoints, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create default EtcdStorage: %w", err)
+	}
+	return storage.WithNamespace(opts.Namespace)
+}
+
+// etcdConfig builds the etcd client configuration described by opts.
+func (opts Options) etcdConfig() (clientv3.Config, error) {
+	cfg := clientv3.Config{
+		Endpoints:   opts.Endpoints,
+		Username:    opts.Username,
+		Password:    opts.Password,
+		DialTimeout: defaultDialTimeout,
+	}
+
+	if opts.DialTimeout != "" {
+		timeout, err := time.ParseDuration(opts.DialTimeout)
+		if err != nil {
+			return cfg, fmt.Errorf("invalid dial timeout: %w", err)
+		}
+		cfg.DialTimeout = timeout
+	}
+
+	if opts.CAFile != "" || opts.CertFile != "" {
+		tlsConfig, err := opts.tlsConfig()
+		if err != nil {
+			return cfg, err
+		}
+		cfg.TLS = tlsConfig
+	}
+	return cfg, nil
+}
+
+// tlsConfig builds the TLS configuration for the etcd client from the CA, certificate and key files.
+func (opts Options) tlsConfig() (*tls.Config, error) {
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+
+	if opts.CAFile != "" {
+		ca, err := os.ReadFile(opts.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA file: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(ca) {
+			return nil, fmt.Errorf("no certificates found in CA file %s", opts.CAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if opts.CertFile != "" || opts.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(opts.CertFile, opts.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	return tlsConfig, nil
+}
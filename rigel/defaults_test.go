@@ -0,0 +1,72 @@
+package rigel
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestLoadOptions(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rigel.json")
+	file := `{"endpoints": ["etcd-1:2379"], "app": "fileApp", "module": "fileModule", "ver": 2, "config": "prod", "dialTimeout": "2s"}`
+	if err := os.WriteFile(path, []byte(file), 0600); err != nil {
+		t.Fatalf("failed to write config file: %v", err)
+	}
+
+	t.Setenv(EnvConfigFile, path)
+	t.Setenv(EnvEndpoints, "etcd-a:2379,etcd-b:2379")
+	t.Setenv(EnvApp, "envApp")
+	t.Setenv(EnvVersion, "3")
+	t.Setenv(EnvAttributes, "region=mumbai, hostname=web-1")
+	t.Setenv(EnvNamespace, "/tenants/acme")
+
+	opts, err := LoadOptions()
+	if err != nil {
+		t.Fatalf("LoadOptions() error = %v", err)
+	}
+	want := Options{
+		Endpoints:   []string{"etcd-a:2379", "etcd-b:2379"},
+		App:         "envApp",
+		Module:      "fileModule",
+		Version:     3,
+		Config:      "prod",
+		DialTimeout: "2s",
+		Namespace:   "/tenants/acme",
+		Attributes:  map[string]string{"region": "mumbai", "hostname": "web-1"},
+	}
+	if !reflect.DeepEqual(opts, want) {
+		t.Errorf("LoadOptions() = %+v, want %+v", opts, want)
+	}
+	if err := opts.validate(); err != nil {
+		t.Errorf("validate() error = %v", err)
+	}
+
+	cfg, err := opts.etcdConfig()
+	if err != nil {
+		t.Fatalf("etcdConfig() error = %v", err)
+	}
+	if cfg.DialTimeout != 2*time.Second || cfg.TLS != nil {
+		t.Errorf("etcdConfig() = %+v, want 2s dial timeout and no TLS", cfg)
+	}
+}
+
+func TestLoadOptionsDefaults(t *testing.T) {
+	t.Setenv(EnvConfigFile, "")
+	opts, err := LoadOptions()
+	if err != nil {
+		t.Fatalf("LoadOptions() error = %v", err)
+	}
+	if !reflect.DeepEqual(opts.Endpoints, []string{defaultEtcdEndpoints}) {
+		t.Errorf("Endpoints = %v, want default", opts.Endpoints)
+	}
+	if err := opts.validate(); err == nil {
+		t.Errorf("validate() expected an error for missing app, module, version and config")
+	}
+
+	t.Setenv(EnvVersion, "one")
+	if _, err := LoadOptions(); err == nil {
+		t.Errorf("LoadOptions() expected an error for a non-numeric version")
+	}
+}
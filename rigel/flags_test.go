@@ -0,0 +1,82 @@
+package rigel
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/remiges-aniket/types"
+)
+
+func TestFeatureFlags(t *testing.T) {
+	r, storage := newTestRigel(t)
+	ctx := context.Background()
+
+	fields := []types.Field{
+		{Name: "debug", Type: "bool"},
+		{Name: "beta", Type: "bool", Flag: true},
+	}
+	data, _ := json.Marshal(fields)
+	storage.data[getSchemaFieldsPath("testApp", "testModule", 1)] = string(data)
+	storage.data[getConfKeyPath("testApp", "testModule", 1, "testConf", "beta")] = "true"
+
+	if _, err := r.IsEnabled(ctx, "debug", "user-1"); err == nil {
+		t.Errorf("IsEnabled() of a field that is not a flag expected an error")
+	}
+	if err := r.SetFlag(ctx, "beta", types.FlagState{Rollout: 101}); err == nil {
+		t.Errorf("SetFlag() with a rollout above 100 expected an error")
+	}
+
+	// Without a rollout state, the flag follows its value
+	if enabled, err := r.IsEnabled(ctx, "beta", "user-1"); err != nil || !enabled {
+		t.Errorf("IsEnabled() without state = %v, %v, want true", enabled, err)
+	}
+
+	enabledAt := func(rollout int) map[string]bool {
+		t.Helper()
+		if err := r.SetFlag(ctx, "beta", types.FlagState{Rollout: rollout, Allow: []string{"admin"}, Deny: []string{"user-0"}}); err != nil {
+			t.Fatalf("SetFlag() error = %v", err)
+		}
+		enabled := make(map[string]bool)
+		for i := 0; i < 1000; i++ {
+			subject := fmt.Sprintf("user-%d", i)
+			ok, err := r.IsEnabled(ctx, "beta", subject)
+			if err != nil {
+				t.Fatalf("IsEnabled() error = %v", err)
+			}
+			if ok {
+				enabled[subject] = true
+			}
+		}
+		return enabled
+	}
+
+	at10 := enabledAt(10)
+	at50 := enabledAt(50)
+	if len(at10) < 50 || len(at10) > 150 {
+		t.Errorf("%d of 1000 subjects enabled at 10%%", len(at10))
+	}
+	for subject := range at10 {
+		if !at50[subject] {
+			t.Errorf("%s enabled at 10%% but not at 50%%", subject)
+		}
+	}
+	if at50["user-0"] {
+		t.Errorf("denied subject enabled")
+	}
+	if enabled, _ := r.IsEnabled(ctx, "beta", "admin"); !enabled {
+		t.Errorf("allowed subject not enabled")
+	}
+
+	// The state is read back as set, and a rollout of 0 disables the flag despite its value
+	if err := r.SetFlag(ctx, "beta", types.FlagState{Rollout: 0}); err != nil {
+		t.Fatalf("SetFlag() error = %v", err)
+	}
+	if state, set, err := r.GetFlag(ctx, "beta"); err != nil || !set || state.Rollout != 0 {
+		t.Errorf("GetFlag() = %+v, %v, %v", state, set, err)
+	}
+	if enabled, _ := r.IsEnabled(ctx, "beta", "user-1"); enabled {
+		t.Errorf("IsEnabled() at 0%% = true")
+	}
+}
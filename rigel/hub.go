@@ -0,0 +1,305 @@
+package rigel
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/remiges-aniket/types"
+)
+
+var (
+	// ErrNoRevisions is returned by Hub.Subscribe when the storage cannot read or watch from a revision.
+	ErrNoRevisions = errors.New("storage does not support revisions")
+	// ErrFutureRevision is returned by Hub.Subscribe when asked to resume after a revision the
+	// storage has not reached.
+	ErrFutureRevision = errors.New("revision is after the current revision of the storage")
+	// ErrSubscriptionLagging ends a hub subscription whose subscriber does not keep up with the changes.
+	ErrSubscriptionLagging = errors.New("subscriber fell behind the changes")
+	// errWatchEnded ends the subscriptions of a hub watch that the storage ended.
+	errWatchEnded = errors.New("storage watch ended")
+)
+
+// hubSubscriberSlack is the number of live changes a subscription can hold, on top of the
+// replayed ones, before its subscriber is deemed to lag.
+const hubSubscriberSlack = 64
+
+// DefaultHubGracePeriod is how long a hub keeps watching a prefix after its last subscriber left,
+// unless set otherwise with WithGracePeriod.
+const DefaultHubGracePeriod = time.Minute
+
+// Hub shares watches of the storage among many subscribers, such as the clients of a server
+// streaming changes. The subscribers of a key prefix share one watch, which is started by the
+// first of them and stopped a grace period after the last leaves. The hub keeps the latest
+// changes seen by each watch, so that a subscriber that lost its connection, or a client polling
+// for changes, can resume after the last change it saw without the storage replaying them.
+type Hub struct {
+	storage    types.Storage
+	bufferSize int
+	grace      time.Duration
+
+	mu      sync.Mutex
+	watches map[string]*hubWatch
+}
+
+// hubWatch is a watch of a key prefix shared by the subscriptions in subs.
+type hubWatch struct {
+	prefix string
+	cancel context.CancelFunc
+	floor  int64         // floor is the revision from which every change is in recent or was sent
+	last   int64         // last is the revision of the latest change seen, or floor-1 before the first
+	recent []types.Event // recent holds the latest changes, oldest first
+	subs   map[*HubSubscription]struct{}
+	idle   *time.Timer // idle stops the watch once its grace period ends, while it has no subscriptions
+}
+
+// HubSubscription is a subscription to the changes to the keys under a prefix, see Hub.Subscribe.
+type HubSubscription struct {
+	// Snapshot holds the values of the keys under the prefix at SnapshotRevision, if the subscriber
+	// asked to resume after a revision whose changes the hub no longer has. Changes are then sent
+	// from SnapshotRevision on.
+	Snapshot         map[string]string
+	SnapshotRevision int64
+
+	hub    *Hub
+	watch  *hubWatch
+	events chan types.Event
+	err    error
+}
+
+// NewHub returns a hub watching storage, which must implement types.RevisionReader and
+// types.RevisionWatcher, that keeps the latest bufferSize changes of each watch.
+func NewHub(storage types.Storage, bufferSize int) *Hub {
+	return &Hub{
+		storage:    storage,
+		bufferSize: bufferSize,
+		grace:      DefaultHubGracePeriod,
+		watches:    make(map[string]*hubWatch),
+	}
+}
+
+// WithGracePeriod sets how long the hub keeps watching a prefix, along with the changes it kept,
+// after the last subscriber left, and returns the modified hub. With 0, the watch is stopped at once.
+func (h *Hub) WithGracePeriod(grace time.Duration) *Hub {
+	h.grace = grace
+	return h
+}
+
+// Subscribe subscribes to the changes to the keys under prefix. If after is 0, the changes made
+// from now on are sent. Otherwise the changes made after revision after are sent, starting with
+// those kept by the hub; if the hub no longer has them, the subscription holds a snapshot of the
+// keys to start from instead. Subscribe fails with ErrFutureRevision if after is a revision the
+// storage has not reached. The subscription must be closed with Close.
+func (h *Hub) Subscribe(ctx context.Context, prefix string, after int64) (*HubSubscription, error) {
+	if _, ok := h.storage.(types.RevisionWatcher); !ok {
+		return nil, ErrNoRevisions
+	}
+	if after > 0 {
+		// A watch from a revision the storage has not reached would wait for it without a word
+		h.mu.Lock()
+		w := h.watches[prefix]
+		seen := w != nil && after <= w.last
+		h.mu.Unlock()
+		if !seen {
+			if err := h.checkRevision(ctx, after); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	h.mu.Lock()
+	w := h.watches[prefix]
+	switch {
+	case after == 0 && w != nil:
+		// The watch already sees the changes made from now on
+		sub, err := h.subscribe(w, prefix, math.MaxInt64)
+		h.mu.Unlock()
+		return sub, err
+	case after > 0 && (w == nil || after+1 >= w.floor):
+		sub, err := h.subscribe(w, prefix, after)
+		if !errors.Is(err, types.ErrCompacted) {
+			h.mu.Unlock()
+			return sub, err
+		}
+		// The storage no longer has the changes after after either, so start from a snapshot
+	}
+	h.mu.Unlock()
+
+	for {
+		kvs, rev, err := h.Snapshot(ctx, prefix)
+		if err != nil {
+			return nil, err
+		}
+		h.mu.Lock()
+		w := h.watches[prefix]
+		if w != nil && rev+1 < w.floor {
+			// The watch dropped changes made after rev while the keys were read, so read them again
+			h.mu.Unlock()
+			continue
+		}
+		sub, err := h.subscribe(w, prefix, rev)
+		h.mu.Unlock()
+		if err != nil {
+			return nil, err
+		}
+		if after > 0 {
+			sub.Snapshot, sub.SnapshotRevision = kvs, rev
+		}
+		return sub, nil
+	}
+}
+
+// Snapshot returns the values of the keys under prefix and the revision they were read at,
+// from which Subscribe can be asked for the changes that follow.
+func (h *Hub) Snapshot(ctx context.Context, prefix string) (map[string]string, int64, error) {
+	rr, ok := h.storage.(types.RevisionReader)
+	if !ok {
+		return nil, 0, ErrNoRevisions
+	}
+	kvs, rev, err := rr.GetWithPrefixRev(ctx, prefix)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to get keys under %s: %w", prefix, err)
+	}
+	return kvs, rev, nil
+}
+
+// checkRevision returns an error wrapping ErrFutureRevision if the storage has not reached rev.
+func (h *Hub) checkRevision(ctx context.Context, rev int64) error {
+	rr, ok := h.storage.(types.RevisionReader)
+	if !ok {
+		return ErrNoRevisions
+	}
+	current, err := rr.Revision(ctx)
+	if err != nil {
+		return err
+	}
+	if rev > current {
+		return fmt.Errorf("%w: %d is after %d", ErrFutureRevision, rev, current)
+	}
+	return nil
+}
+
+// subscribe adds a subscription to w, or to a new watch of prefix if w is nil, and sends it the
+// changes made after revision after that w has. h.mu must be held.
+func (h *Hub) subscribe(w *hubWatch, prefix string, after int64) (*HubSubscription, error) {
+	if w == nil {
+		ctx, cancel := context.WithCancel(context.Background())
+		events := make(chan types.Event)
+		if err := h.storage.(types.RevisionWatcher).WatchFrom(ctx, prefix, after+1, events); err != nil {
+			cancel()
+			return nil, fmt.Errorf("failed to watch %s: %w", prefix, err)
+		}
+		w = &hubWatch{prefix: prefix, cancel: cancel, floor: after + 1, last: after, subs: make(map[*HubSubscription]struct{})}
+		h.watches[prefix] = w
+		go h.run(w, events)
+	}
+
+	if w.idle != nil {
+		w.idle.Stop()
+		w.idle = nil
+	}
+	sub := &HubSubscription{hub: h, watch: w, events: make(chan types.Event, h.bufferSize+hubSubscriberSlack)}
+	for _, event := range w.recent {
+		if event.Revision > after {
+			sub.events <- event
+		}
+	}
+	w.subs[sub] = struct{}{}
+	return sub, nil
+}
+
+// run sends the changes seen by w to its subscriptions until the watch ends.
+func (h *Hub) run(w *hubWatch, events <-chan types.Event) {
+	for event := range events {
+		h.mu.Lock()
+		if event.Err == nil {
+			w.last = event.Revision
+			w.recent = append(w.recent, event)
+			if len(w.recent) > h.bufferSize {
+				w.floor = w.recent[0].Revision + 1
+				w.recent = w.recent[1:]
+			}
+		}
+		for sub := range w.subs {
+			select {
+			case sub.events <- event:
+			default:
+				h.unsubscribe(sub, ErrSubscriptionLagging)
+			}
+		}
+		h.mu.Unlock()
+	}
+
+	// The storage ends the watch when it is stopped, or if it cannot go on
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for sub := range w.subs {
+		h.unsubscribe(sub, errWatchEnded)
+	}
+	h.stop(w)
+}
+
+// unsubscribe ends sub with err and, if it was the last subscription, stops its watch once the
+// grace period of the hub ends without a new subscription. h.mu must be held.
+func (h *Hub) unsubscribe(sub *HubSubscription, err error) {
+	w := sub.watch
+	if _, found := w.subs[sub]; !found {
+		return
+	}
+	delete(w.subs, sub)
+	sub.err = err
+	close(sub.events)
+	if len(w.subs) > 0 {
+		return
+	}
+	if h.grace <= 0 {
+		h.stop(w)
+		return
+	}
+	var idle *time.Timer
+	idle = time.AfterFunc(h.grace, func() {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		// A subscription may have come, and gone again with a new timer, while this one fired
+		if w.idle == idle {
+			h.stop(w)
+		}
+	})
+	w.idle = idle
+}
+
+// stop stops the watch w. h.mu must be held.
+func (h *Hub) stop(w *hubWatch) {
+	w.cancel()
+	if w.idle != nil {
+		w.idle.Stop()
+		w.idle = nil
+	}
+	if h.watches[w.prefix] == w {
+		delete(h.watches, w.prefix)
+	}
+}
+
+// Events returns the channel of the changes, which is closed when the subscription ends.
+// Problems with the watch are sent as events with Err set.
+func (s *HubSubscription) Events() <-chan types.Event {
+	return s.events
+}
+
+// Err returns why the subscription ended, once Events is closed: ErrSubscriptionLagging if the
+// subscriber did not keep up with the changes, or nil if it was closed with Close.
+func (s *HubSubscription) Err() error {
+	s.hub.mu.Lock()
+	defer s.hub.mu.Unlock()
+	return s.err
+}
+
+// Close ends the subscription.
+func (s *HubSubscription) Close() {
+	s.hub.mu.Lock()
+	defer s.hub.mu.Unlock()
+	s.hub.unsubscribe(s, nil)
+}
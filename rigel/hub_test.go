@@ -0,0 +1,140 @@
+package rigel
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/remiges-aniket/etcd"
+	"github.com/remiges-aniket/types"
+	"go.etcd.io/etcd/tests/v3/integration"
+)
+
+// nextEvent returns the next change of sub, failing the test if none comes.
+func nextEvent(t *testing.T, sub *HubSubscription) types.Event {
+	t.Helper()
+	select {
+	case event, ok := <-sub.Events():
+		if !ok {
+			t.Fatalf("subscription ended: %v", sub.Err())
+		}
+		return event
+	case <-time.After(2 * time.Second):
+		t.Fatalf("no change received")
+	}
+	return types.Event{}
+}
+
+func TestHub(t *testing.T) {
+	integration.BeforeTestExternal(t)
+	clus := integration.NewClusterV3(t, &integration.ClusterConfig{Size: 1})
+	defer clus.Terminate(t)
+	storage := &etcd.EtcdStorage{Client: clus.RandClient()}
+	ctx := context.Background()
+
+	hub := NewHub(storage, 2).WithGracePeriod(200 * time.Millisecond)
+	first, err := hub.Subscribe(ctx, "/cfg/", 0)
+	if err != nil {
+		t.Fatalf("Subscribe() error = %v", err)
+	}
+	second, err := hub.Subscribe(ctx, "/cfg/", 0)
+	if err != nil {
+		t.Fatalf("Subscribe() error = %v", err)
+	}
+	if len(hub.watches) != 1 {
+		t.Errorf("hub has %d watches, want the subscribers to share one", len(hub.watches))
+	}
+
+	var revs []int64
+	for _, value := range []string{"v1", "v2", "v3"} {
+		if err := storage.Put(ctx, "/cfg/key", value); err != nil {
+			t.Fatalf("Put() error = %v", err)
+		}
+		event := nextEvent(t, first)
+		if event.Value != value {
+			t.Errorf("first subscriber got %+v, want value %s", event, value)
+		}
+		if event := nextEvent(t, second); event.Value != value {
+			t.Errorf("second subscriber got %+v, want value %s", event, value)
+		}
+		revs = append(revs, event.Revision)
+	}
+
+	// A subscriber resumes from the changes kept by the hub
+	resumed, err := hub.Subscribe(ctx, "/cfg/", revs[1])
+	if err != nil {
+		t.Fatalf("Subscribe() error = %v", err)
+	}
+	if event := nextEvent(t, resumed); resumed.Snapshot != nil || event.Value != "v3" {
+		t.Errorf("resumed subscriber got %+v, snapshot %v, want v3 and no snapshot", event, resumed.Snapshot)
+	}
+	resumed.Close()
+
+	// The hub keeps two changes, so resuming after v1 needs a snapshot
+	resumed, err = hub.Subscribe(ctx, "/cfg/", revs[0]-1)
+	if err != nil {
+		t.Fatalf("Subscribe() error = %v", err)
+	}
+	if resumed.Snapshot["/cfg/key"] != "v3" || resumed.SnapshotRevision < revs[2] {
+		t.Errorf("snapshot = %v at %d, want v3 at %d or later", resumed.Snapshot, resumed.SnapshotRevision, revs[2])
+	}
+	resumed.Close()
+
+	// A revision the storage has not reached is rejected, with or without a watch of the prefix
+	for _, prefix := range []string{"/cfg/", "/other/"} {
+		if _, err := hub.Subscribe(ctx, prefix, revs[2]+100); !errors.Is(err, ErrFutureRevision) {
+			t.Errorf("Subscribe(%s) after a future revision error = %v, want ErrFutureRevision", prefix, err)
+		}
+	}
+
+	// A subscriber that stops reading is dropped, without holding up the others
+	var last int64
+	for i := 0; i < 2+hubSubscriberSlack+1; i++ {
+		if err := storage.Put(ctx, "/cfg/key", "flood"); err != nil {
+			t.Fatalf("Put() error = %v", err)
+		}
+		last = nextEvent(t, first).Revision
+	}
+	for range second.Events() {
+	}
+	if !errors.Is(second.Err(), ErrSubscriptionLagging) {
+		t.Errorf("lagging subscription ended with %v, want ErrSubscriptionLagging", second.Err())
+	}
+
+	first.Close()
+	if _, ok := <-first.Events(); ok || first.Err() != nil {
+		t.Errorf("closed subscription ended with %v, want its channel closed", first.Err())
+	}
+
+	// The watch and its changes outlive the last subscriber for the grace period, so that a
+	// client polling for changes resumes from them
+	hub.mu.Lock()
+	if len(hub.watches) != 1 {
+		t.Errorf("hub has %d watches right after the last subscriber left, want 1", len(hub.watches))
+	}
+	hub.mu.Unlock()
+	if err := storage.Put(ctx, "/cfg/key", "v4"); err != nil {
+		t.Fatalf("Put() error = %v", err)
+	}
+	resumed, err = hub.Subscribe(ctx, "/cfg/", last)
+	if err != nil {
+		t.Fatalf("Subscribe() error = %v", err)
+	}
+	if event := nextEvent(t, resumed); resumed.Snapshot != nil || event.Value != "v4" {
+		t.Errorf("subscriber resuming within the grace period got %+v, snapshot %v, want v4 and no snapshot", event, resumed.Snapshot)
+	}
+	resumed.Close()
+
+	for deadline := time.Now().Add(2 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		hub.mu.Lock()
+		watches := len(hub.watches)
+		hub.mu.Unlock()
+		if watches == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("hub has %d watches after the grace period, want 0", watches)
+		}
+	}
+}
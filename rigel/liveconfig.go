@@ -0,0 +1,104 @@
+package rigel
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+)
+
+// reloadErrorsBuffer is the number of reload errors kept for a reader of LiveConfig.Errors.
+// Errors that do not fit in the buffer are dropped.
+const reloadErrorsBuffer = 16
+
+// LiveConfig keeps a snapshot of a config struct current by watching the config in the storage.
+// The snapshot is replaced as a whole on every change, so readers never see a half-updated struct.
+type LiveConfig[T any] struct {
+	rigel    *Rigel
+	validate func(*T) error
+	current  atomic.Pointer[T]
+	reloadMu sync.Mutex // reloadMu makes reloads store their snapshots in the order they were loaded
+	errs     chan error
+	sub      *Subscription
+	cancel   context.CancelFunc
+}
+
+// NewLiveConfig loads the config of r into a new T, in the same way as LoadConfig, and starts
+// watching it for changes. On every change the config is loaded again into a new T and, if
+// validate accepts it, swapped in as the current snapshot. If validate rejects the new snapshot
+// or the load fails, the last good snapshot is kept and the error is sent on the Errors channel.
+// validate may be nil. The initial snapshot must load and validate, or an error is returned.
+//
+// T must be a struct type. The watch runs until ctx is done or Close is called.
+func NewLiveConfig[T any](ctx context.Context, r *Rigel, validate func(*T) error) (*LiveConfig[T], error) {
+	lc := &LiveConfig[T]{
+		rigel:    r,
+		validate: validate,
+		errs:     make(chan error, reloadErrorsBuffer),
+	}
+
+	snapshot, err := lc.load(ctx)
+	if err != nil {
+		return nil, err
+	}
+	lc.current.Store(snapshot)
+
+	watchCtx, cancel := context.WithCancel(ctx)
+	lc.cancel = cancel
+	lc.sub = r.OnAnyChange(func(ChangeEvent) {
+		lc.reload(watchCtx)
+	})
+	if err := r.WatchConfig(watchCtx); err != nil {
+		lc.Close()
+		return nil, fmt.Errorf("failed to watch config: %w", err)
+	}
+	// Changes made between the initial load and the start of the watch are not seen by the watch
+	lc.reload(watchCtx)
+
+	return lc, nil
+}
+
+// Get returns the current snapshot. The returned struct must not be modified.
+func (lc *LiveConfig[T]) Get() *T {
+	return lc.current.Load()
+}
+
+// Errors returns a channel on which errors from failed reloads are reported.
+func (lc *LiveConfig[T]) Errors() <-chan error {
+	return lc.errs
+}
+
+// Close stops watching the config. The last snapshot stays available through Get.
+func (lc *LiveConfig[T]) Close() {
+	lc.sub.Unsubscribe()
+	lc.cancel()
+}
+
+// load reads the config into a new T and validates it.
+func (lc *LiveConfig[T]) load(ctx context.Context) (*T, error) {
+	snapshot := new(T)
+	if err := lc.rigel.LoadConfig(ctx, snapshot); err != nil {
+		return nil, fmt.Errorf("failed to load config: %w", err)
+	}
+	if lc.validate != nil {
+		if err := lc.validate(snapshot); err != nil {
+			return nil, fmt.Errorf("config failed validation: %w", err)
+		}
+	}
+	return snapshot, nil
+}
+
+// reload replaces the current snapshot, or reports an error and keeps the last good one.
+func (lc *LiveConfig[T]) reload(ctx context.Context) {
+	lc.reloadMu.Lock()
+	defer lc.reloadMu.Unlock()
+	snapshot, err := lc.load(ctx)
+	if err != nil {
+		select {
+		case lc.errs <- err:
+		default:
+		}
+		return
+	}
+	lc.current.Store(snapshot)
+}
@@ -0,0 +1,231 @@
+package rigel
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/remiges-aniket/layout"
+	"github.com/remiges-aniket/types"
+)
+
+// LockedError is returned by the functions that write to a config, such as Set, while the
+// config is locked, unless the client overrides locks with WithLockOverride.
+type LockedError struct {
+	Lock types.Lock
+}
+
+func (e *LockedError) Error() string {
+	msg := fmt.Sprintf("config is locked by a lock of its %s", e.Lock.Scope)
+	if e.Lock.Reason != "" {
+		msg += ": " + e.Lock.Reason
+	}
+	if e.Lock.Expires != nil {
+		msg += fmt.Sprintf(" (until %s)", e.Lock.Expires.Format(time.RFC3339))
+	}
+	return msg
+}
+
+// WithLockOverride makes the client write to locked configs, giving reason as the reason for
+// doing so, and returns the modified Rigel object. Every write made by overriding a lock is
+// recorded in the storage next to the config, see Overrides. It is meant for admins who must
+// fix a config while it is locked.
+func (r *Rigel) WithLockOverride(reason string) *Rigel {
+	r.overrideLocks = true
+	r.overrideReason = reason
+	return r
+}
+
+// SetLock locks the configs in lock.Scope: the app of the client, its module, or its config.
+// Set, SetParent, SetRules and SetFlag then fail with a LockedError for every config in the
+// scope until the lock is removed with RemoveLock or expires. LockedAt is set to the current
+// time if it is zero.
+func (r *Rigel) SetLock(ctx context.Context, lock types.Lock) error {
+	key, err := r.lockPath(lock.Scope)
+	if err != nil {
+		return err
+	}
+	if lock.LockedAt.IsZero() {
+		lock.LockedAt = time.Now().UTC()
+	}
+	data, err := json.Marshal(lock)
+	if err != nil {
+		return fmt.Errorf("failed to marshal lock: %w", err)
+	}
+	if err := r.Storage.Put(ctx, key, string(data)); err != nil {
+		return fmt.Errorf("failed to set lock: %w", err)
+	}
+	return nil
+}
+
+// RemoveLock removes the lock of the app, the module or the config of the client, as given by scope.
+func (r *Rigel) RemoveLock(ctx context.Context, scope types.LockScope) error {
+	key, err := r.lockPath(scope)
+	if err != nil {
+		return err
+	}
+	w, ok := r.Storage.(types.ConditionalWriter)
+	if !ok {
+		// A storage that cannot delete keys is left with an empty lock, which locks nothing
+		if err := r.Storage.Put(ctx, key, ""); err != nil {
+			return fmt.Errorf("failed to remove lock: %w", err)
+		}
+		return nil
+	}
+	if _, err := w.Commit(ctx, types.Txn{Delete: []string{key}}); err != nil {
+		return fmt.Errorf("failed to remove lock: %w", err)
+	}
+	return nil
+}
+
+// ActiveLock returns the lock in force for the config of the client, or nil if it is not
+// locked. A lock of the config comes first, then that of its module and then that of its app.
+// Locks are always read from the storage, so that a lock set by another client is seen at once.
+func (r *Rigel) ActiveLock(ctx context.Context) (*types.Lock, error) {
+	_, lock, err := r.readLocks(ctx)
+	return lock, err
+}
+
+// readLocks reads the keys of the locks of the config of the client, giving their values
+// keyed by key along with the lock in force, as ActiveLock.
+func (r *Rigel) readLocks(ctx context.Context) (map[string]string, *types.Lock, error) {
+	keys := []string{
+		layout.ConfigLockPath(r.App, r.Module, r.Version, r.Config),
+		layout.ModuleLockPath(r.App, r.Module),
+		layout.AppLockPath(r.App),
+	}
+	kvs, err := r.Storage.GetMany(ctx, keys...)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get locks: %w", err)
+	}
+	locks := make(map[string]string, len(keys))
+	for _, key := range keys {
+		locks[key] = kvs[key]
+	}
+	now := time.Now()
+	for _, key := range keys {
+		if kvs[key] == "" {
+			continue
+		}
+		var lock types.Lock
+		if err := json.Unmarshal([]byte(kvs[key]), &lock); err != nil {
+			return nil, nil, fmt.Errorf("failed to parse lock %s: %w", key, err)
+		}
+		if lock.Active(now) {
+			return locks, &lock, nil
+		}
+	}
+	return locks, nil, nil
+}
+
+// Overrides returns the writes made to the config by overriding its locks, oldest first.
+func (r *Rigel) Overrides(ctx context.Context) ([]types.LockOverride, error) {
+	prefix := getConfPath(r.App, r.Module, r.Version, r.Config) + "/" + layout.OverridesKey + "/"
+	kvs, err := r.Storage.GetWithPrefix(ctx, prefix)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get lock overrides: %w", err)
+	}
+	overrides := make([]types.LockOverride, 0, len(kvs))
+	for key, value := range kvs {
+		var o types.LockOverride
+		if err := json.Unmarshal([]byte(value), &o); err != nil {
+			return nil, fmt.Errorf("failed to parse lock override %s: %w", key, err)
+		}
+		overrides = append(overrides, o)
+	}
+	sort.Slice(overrides, func(i, j int) bool { return overrides[i].At.Before(overrides[j].At) })
+	return overrides, nil
+}
+
+// writeUnlocked stores kvs in a single write, provided that the config is not locked, and
+// returns a LockedError otherwise. A client that overrides locks writes to a locked config
+// anyway, recording the write of each of configKeys, "" for the parent of the config, as an
+// override in the same write. If the storage is a types.ConditionalWriter, the write is made on
+// condition that the locks did not change since they were read, and is tried again if they
+// did; otherwise a lock set between the check and the write is missed.
+func (r *Rigel) writeUnlocked(ctx context.Context, kvs map[string]string, configKeys ...string) error {
+	return r.writeUnlockedIf(ctx, kvs, nil, configKeys...)
+}
+
+// writeUnlockedIf works like writeUnlocked, calling guard, unless it is nil, before each try of
+// the write. If guard fails the write is not made; otherwise the write is also made on condition
+// that the keys guard returns still hold the values it returns.
+func (r *Rigel) writeUnlockedIf(ctx context.Context, kvs map[string]string, guard func(ctx context.Context) (map[string]string, error), configKeys ...string) error {
+	w, conditional := r.Storage.(types.ConditionalWriter)
+	for {
+		locks, lock, err := r.readLocks(ctx)
+		if err != nil {
+			return err
+		}
+		if guard != nil {
+			guarded, err := guard(ctx)
+			if err != nil {
+				return err
+			}
+			for key, value := range guarded {
+				locks[key] = value
+			}
+		}
+		puts := kvs
+		if lock != nil {
+			if !r.overrideLocks {
+				return &LockedError{Lock: *lock}
+			}
+			if puts, err = r.withOverrides(kvs, *lock, configKeys); err != nil {
+				return err
+			}
+		}
+
+		if !conditional {
+			for key, value := range puts {
+				if err := r.Storage.Put(ctx, key, value); err != nil {
+					return fmt.Errorf("failed to write config: %w", err)
+				}
+			}
+			return nil
+		}
+		done, err := w.Commit(ctx, types.Txn{If: locks, Put: withClock(puts)})
+		if err != nil {
+			return fmt.Errorf("failed to write config: %w", err)
+		}
+		if done {
+			return nil
+		}
+		// A lock was set or removed, or a guarded key changed, since it was read
+	}
+}
+
+// withOverrides returns kvs along with the records of the overrides of lock made by writing
+// configKeys, see Overrides.
+func (r *Rigel) withOverrides(kvs map[string]string, lock types.Lock, configKeys []string) (map[string]string, error) {
+	puts := make(map[string]string, len(kvs)+len(configKeys))
+	for key, value := range kvs {
+		puts[key] = value
+	}
+	now := time.Now().UTC()
+	for i, configKey := range configKeys {
+		// The records of the keys written together are a nanosecond apart, in the order of configKeys
+		o := types.LockOverride{Lock: lock, Key: configKey, Reason: r.overrideReason, At: now.Add(time.Duration(i))}
+		data, err := json.Marshal(o)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal lock override: %w", err)
+		}
+		puts[layout.ConfigOverridePath(r.App, r.Module, r.Version, r.Config, o.At)] = string(data)
+	}
+	return puts, nil
+}
+
+// lockPath returns the key of the lock of the given scope for the client.
+func (r *Rigel) lockPath(scope types.LockScope) (string, error) {
+	switch scope {
+	case types.LockScopeApp:
+		return layout.AppLockPath(r.App), nil
+	case types.LockScopeModule:
+		return layout.ModuleLockPath(r.App, r.Module), nil
+	case types.LockScopeConfig:
+		return layout.ConfigLockPath(r.App, r.Module, r.Version, r.Config), nil
+	}
+	return "", fmt.Errorf("unknown lock scope %q", scope)
+}
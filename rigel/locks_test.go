@@ -0,0 +1,122 @@
+package rigel
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/remiges-aniket/layout"
+	"github.com/remiges-aniket/types"
+)
+
+func TestLocks(t *testing.T) {
+	r, storage := newTestRigel(t)
+	ctx := context.Background()
+
+	if err := r.Set(ctx, "port", "8080"); err != nil {
+		t.Fatalf("Set() error = %v", err)
+	}
+
+	past := time.Now().Add(-time.Hour)
+	for _, scope := range []types.LockScope{types.LockScopeConfig, types.LockScopeModule, types.LockScopeApp} {
+		if err := r.SetLock(ctx, types.Lock{Scope: scope, Reason: "month-end"}); err != nil {
+			t.Fatalf("SetLock(%s) error = %v", scope, err)
+		}
+		var locked *LockedError
+		if err := r.Set(ctx, "port", "9090"); !errors.As(err, &locked) || locked.Lock.Scope != scope {
+			t.Errorf("Set() with a %s lock = %v, want a LockedError", scope, err)
+		}
+		if err := r.SetRules(ctx, "port", nil); !errors.As(err, &locked) {
+			t.Errorf("SetRules() with a %s lock = %v, want a LockedError", scope, err)
+		}
+
+		// An expired lock no longer applies
+		if err := r.SetLock(ctx, types.Lock{Scope: scope, Expires: &past}); err != nil {
+			t.Fatalf("SetLock(%s) error = %v", scope, err)
+		}
+		if err := r.Set(ctx, "port", "9090"); err != nil {
+			t.Errorf("Set() with an expired %s lock error = %v", scope, err)
+		}
+		if err := r.RemoveLock(ctx, scope); err != nil {
+			t.Fatalf("RemoveLock(%s) error = %v", scope, err)
+		}
+		key, _ := r.lockPath(scope)
+		if _, found := storage.data[key]; found {
+			t.Errorf("RemoveLock(%s) left the key of the lock", scope)
+		}
+	}
+
+	// Locks of other configs and modules do not apply
+	other := r.View("testApp", "otherModule", 1, "otherConf")
+	if err := other.SetLock(ctx, types.Lock{Scope: types.LockScopeModule}); err != nil {
+		t.Fatalf("SetLock() error = %v", err)
+	}
+	if lock, err := r.ActiveLock(ctx); err != nil || lock != nil {
+		t.Errorf("ActiveLock() = %v, %v, want no lock", lock, err)
+	}
+
+	if err := r.SetLock(ctx, types.Lock{Scope: types.LockScopeConfig, Reason: "month-end"}); err != nil {
+		t.Fatalf("SetLock() error = %v", err)
+	}
+	override := r.View("testApp", "testModule", 1, "testConf").WithLockOverride("fix wrong port")
+	if err := override.Set(ctx, "port", "7070"); err != nil {
+		t.Fatalf("Set() with override error = %v", err)
+	}
+	if got := storage.data[getConfKeyPath("testApp", "testModule", 1, "testConf", "port")]; got != "7070" {
+		t.Errorf("port = %q, want 7070", got)
+	}
+	overrides, err := r.Overrides(ctx)
+	if err != nil {
+		t.Fatalf("Overrides() error = %v", err)
+	}
+	if len(overrides) != 1 || overrides[0].Key != "port" || overrides[0].Reason != "fix wrong port" || overrides[0].Lock.Reason != "month-end" {
+		t.Errorf("Overrides() = %+v, want the override of port", overrides)
+	}
+
+	// The view does not change the client it was created from
+	if err := r.Set(ctx, "port", "6060"); err == nil {
+		t.Errorf("Set() expected an error after a view overrode the lock")
+	}
+}
+
+// lockingStorage sets lock just before the first conditional write, as an admin locking the
+// config while it is written would.
+type lockingStorage struct {
+	*mockStorage
+	key, lock string
+}
+
+func (s *lockingStorage) Commit(ctx context.Context, txn types.Txn) (bool, error) {
+	if s.lock != "" {
+		s.mockStorage.Put(ctx, s.key, s.lock)
+		s.lock = ""
+	}
+	return s.mockStorage.Commit(ctx, txn)
+}
+
+func TestLockSetWhileWriting(t *testing.T) {
+	r, storage := newTestRigel(t)
+	ctx := context.Background()
+
+	lock, _ := json.Marshal(types.Lock{Scope: types.LockScopeModule, Reason: "month-end"})
+	r.Storage = &lockingStorage{mockStorage: storage, key: layout.ModuleLockPath("testApp", "testModule"), lock: string(lock)}
+
+	u := r.Update()
+	if err := u.Set(ctx, "port", "8080"); err != nil {
+		t.Fatalf("Set() error = %v", err)
+	}
+	if err := u.Set(ctx, "host", "localhost"); err != nil {
+		t.Fatalf("Set() error = %v", err)
+	}
+	var locked *LockedError
+	if err := u.Commit(ctx); !errors.As(err, &locked) {
+		t.Fatalf("Commit() = %v, want a LockedError", err)
+	}
+	for _, key := range []string{"port", "host"} {
+		if value, found := storage.data[getConfKeyPath("testApp", "testModule", 1, "testConf", key)]; found {
+			t.Errorf("%s = %q, want it not written", key, value)
+		}
+	}
+}
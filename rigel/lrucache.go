@@ -0,0 +1,161 @@
+package rigel
+
+import (
+	"container/list"
+	"sync"
+	"time"
+
+	"github.com/remiges-aniket/types"
+)
+
+// LRUCacheOptions configures an LRUCache.
+type LRUCacheOptions struct {
+	MaxEntries  int           // MaxEntries is the maximum number of entries kept, 0 for no limit
+	TTL         time.Duration // TTL is how long a value is kept, 0 for no expiry
+	NegativeTTL time.Duration // NegativeTTL is how long a key missing in the storage is remembered, 0 to not remember it
+}
+
+// CacheStats holds the counters of an LRUCache.
+type CacheStats struct {
+	Hits        uint64 // Hits is the number of Get calls that found a live entry
+	Misses      uint64 // Misses is the number of Get calls that found no live entry
+	Evictions   uint64 // Evictions is the number of entries removed to stay within MaxEntries
+	Expirations uint64 // Expirations is the number of entries removed because their TTL passed
+	Entries     int    // Entries is the number of entries currently held
+}
+
+// LRUCache is a types.Cache with a bounded number of entries, evicting the least recently
+// used entry when full. Entries expire after a TTL, and keys missing in the storage can be
+// remembered for a while so that they are not looked up again on every access.
+type LRUCache struct {
+	opts  LRUCacheOptions
+	mu    sync.Mutex
+	ll    *list.List // ll holds the entries, most recently used first
+	items map[string]*list.Element
+	stats CacheStats
+	now   func() time.Time
+}
+
+type lruEntry struct {
+	key       string
+	value     string
+	expiresAt time.Time // expiresAt is zero for entries that do not expire
+}
+
+var (
+	_ types.NegativeCache  = &LRUCache{}
+	_ types.CacheContainer = &LRUCache{}
+)
+
+// NewLRUCache creates an LRUCache configured by opts.
+func NewLRUCache(opts LRUCacheOptions) *LRUCache {
+	return &LRUCache{
+		opts:  opts,
+		ll:    list.New(),
+		items: make(map[string]*list.Element),
+		now:   time.Now,
+	}
+}
+
+// Get returns the value cached for key. A key remembered as missing is reported as found
+// with an empty value, which is what the storage returns for a missing key.
+func (c *LRUCache) Get(key string) (value string, found bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.items[key]
+	if !ok {
+		c.stats.Misses++
+		return "", false
+	}
+	entry := elem.Value.(*lruEntry)
+	if !entry.expiresAt.IsZero() && !c.now().Before(entry.expiresAt) {
+		c.removeElement(elem)
+		c.stats.Expirations++
+		c.stats.Misses++
+		return "", false
+	}
+
+	c.ll.MoveToFront(elem)
+	c.stats.Hits++
+	return entry.value, true
+}
+
+// Contains reports whether a live entry is cached for key, without counting a hit or a miss
+// and without making the entry the most recently used.
+func (c *LRUCache) Contains(key string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	elem, ok := c.items[key]
+	if !ok {
+		return false
+	}
+	expiresAt := elem.Value.(*lruEntry).expiresAt
+	return expiresAt.IsZero() || c.now().Before(expiresAt)
+}
+
+// Set caches value for key.
+func (c *LRUCache) Set(key string, value string) {
+	c.set(key, value, c.opts.TTL)
+}
+
+// SetMissing remembers that key does not exist in the storage, for NegativeTTL.
+func (c *LRUCache) SetMissing(key string) {
+	if c.opts.NegativeTTL <= 0 {
+		c.Delete(key)
+		return
+	}
+	c.set(key, "", c.opts.NegativeTTL)
+}
+
+// Delete removes key from the cache.
+func (c *LRUCache) Delete(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if elem, ok := c.items[key]; ok {
+		c.removeElement(elem)
+	}
+}
+
+// Stats returns the current counters of the cache.
+func (c *LRUCache) Stats() CacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	stats := c.stats
+	stats.Entries = c.ll.Len()
+	return stats
+}
+
+func (c *LRUCache) set(key string, value string, ttl time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var expiresAt time.Time
+	if ttl > 0 {
+		expiresAt = c.now().Add(ttl)
+	}
+
+	if elem, ok := c.items[key]; ok {
+		entry := elem.Value.(*lruEntry)
+		entry.value = value
+		entry.expiresAt = expiresAt
+		c.ll.MoveToFront(elem)
+		return
+	}
+
+	c.items[key] = c.ll.PushFront(&lruEntry{
+		key:       key,
+		value:     value,
+		expiresAt: expiresAt,
+	})
+
+	if c.opts.MaxEntries > 0 && c.ll.Len() > c.opts.MaxEntries {
+		c.removeElement(c.ll.Back())
+		c.stats.Evictions++
+	}
+}
+
+func (c *LRUCache) removeElement(elem *list.Element) {
+	c.ll.Remove(elem)
+	delete(c.items, elem.Value.(*lruEntry).key)
+}
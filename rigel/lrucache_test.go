@@ -0,0 +1,79 @@
+package rigel
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLRUCacheEviction(t *testing.T) {
+	c := NewLRUCache(LRUCacheOptions{MaxEntries: 2})
+
+	c.Set("a", "1")
+	c.Set("b", "2")
+	c.Get("a") // a is now the most recently used entry
+	c.Set("c", "3")
+
+	if _, found := c.Get("b"); found {
+		t.Errorf("expected least recently used key b to be evicted")
+	}
+	for _, key := range []string{"a", "c"} {
+		if _, found := c.Get(key); !found {
+			t.Errorf("expected key %s to be cached", key)
+		}
+	}
+
+	// Contains neither counts nor refreshes entries
+	if !c.Contains("a") || c.Contains("b") {
+		t.Errorf("Contains() does not match the cached keys")
+	}
+	c.Set("d", "4")
+	if c.Contains("a") {
+		t.Errorf("expected key a, used by Contains only, to be evicted")
+	}
+
+	stats := c.Stats()
+	if stats.Evictions != 2 || stats.Entries != 2 {
+		t.Errorf("Stats() = %+v, want 2 evictions and 2 entries", stats)
+	}
+	if stats.Hits != 3 || stats.Misses != 1 {
+		t.Errorf("Stats() = %+v, want 3 hits and 1 miss", stats)
+	}
+}
+
+func TestLRUCacheTTL(t *testing.T) {
+	now := time.Now()
+	c := NewLRUCache(LRUCacheOptions{TTL: time.Minute, NegativeTTL: time.Second})
+	c.now = func() time.Time { return now }
+
+	c.Set("a", "1")
+	c.SetMissing("b")
+
+	if value, found := c.Get("b"); !found || value != "" {
+		t.Errorf("Get(b) = %q, %v, want missing key to be found with empty value", value, found)
+	}
+
+	now = now.Add(2 * time.Second)
+	if _, found := c.Get("b"); found {
+		t.Errorf("expected missing key b to expire after NegativeTTL")
+	}
+	if value, found := c.Get("a"); !found || value != "1" {
+		t.Errorf("Get(a) = %q, %v, want \"1\", true", value, found)
+	}
+
+	now = now.Add(time.Minute)
+	if _, found := c.Get("a"); found {
+		t.Errorf("expected key a to expire after TTL")
+	}
+	if stats := c.Stats(); stats.Expirations != 2 || stats.Entries != 0 {
+		t.Errorf("Stats() = %+v, want 2 expirations and no entries", stats)
+	}
+}
+
+func TestLRUCacheWithoutNegativeTTL(t *testing.T) {
+	c := NewLRUCache(LRUCacheOptions{})
+	c.Set("a", "1")
+	c.SetMissing("a")
+	if _, found := c.Get("a"); found {
+		t.Errorf("expected SetMissing without NegativeTTL to drop the key")
+	}
+}
@@ -0,0 +1,256 @@
+package rigel
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/remiges-aniket/types"
+)
+
+var (
+	// ErrOverlayCycle is returned when the parents of a config lead back to the config.
+	ErrOverlayCycle = errors.New("config parents form a cycle")
+	// ErrOverlayTooDeep is returned when a config has more than maxOverlayDepth configs in its chain of parents.
+	ErrOverlayTooDeep = fmt.Errorf("config chain is longer than %d configs", maxOverlayDepth)
+)
+
+// ResolvedValue is the value of a config key along with the named config it was found in.
+type ResolvedValue struct {
+	Value  string `json:"value"`
+	Source string `json:"source"` // Source is the config itself or one of its parents
+}
+
+// SetParent makes the config an overlay of the named config parent of the same app, module and
+// version. The config then holds only its overrides: Get, GetAll and LoadConfig take the value of
+// a key missing in the config from its parent, which may have a parent of its own. An empty parent
+// makes the config stand alone again. SetParent rejects a parent that would lead back to the config
+// or make the chain of configs longer than maxOverlayDepth.
+func (r *Rigel) SetParent(ctx context.Context, parent string) error {
+	u := r.Update()
+	if err := u.SetParent(ctx, parent); err != nil {
+		return err
+	}
+	return u.Commit(ctx)
+}
+
+// Parent returns the name of the parent of the config, or an empty string if it has none.
+func (r *Rigel) Parent(ctx context.Context) (string, error) {
+	return r.cachedGet(ctx, getConfParentPath(r.App, r.Module, r.Version, r.Config))
+}
+
+// GetWithSource retrieves the value of a config key like Get, along with the config it came from.
+func (r *Rigel) GetWithSource(ctx context.Context, configKey string) (ResolvedValue, error) {
+	// Check if the key exists in the schema
+	schema, err := r.GetSchema(ctx)
+	if err != nil {
+		return ResolvedValue{}, fmt.Errorf("failed to check if key exists in schema: %w", err)
+	}
+	field := schema.Leaf(configKey)
+	if field == nil {
+		return ResolvedValue{}, &KeyNotFoundError{Key: configKey}
+	}
+
+	chain, err := r.configChain(ctx, configKey)
+	if err != nil {
+		return ResolvedValue{}, err
+	}
+
+	// Take the value from the first config of the chain that has a matching rule or a value,
+	// all of which configChain has read into the cache
+	kvs := make(map[string]string)
+	for _, config := range chain {
+		keys := []string{
+			getConfKeyPath(r.App, r.Module, r.Version, config, configKey),
+			getConfRulesPath(r.App, r.Module, r.Version, config, configKey),
+		}
+		values, err := r.cachedGetMany(ctx, keys...)
+		if err != nil {
+			return ResolvedValue{}, &KeyNotFoundError{Key: keys[0]}
+		}
+		for key, value := range values {
+			if value != "" {
+				kvs[key] = value
+			}
+		}
+	}
+	resolved, _ := r.resolve(configKey, chain, kvs)
+	resolved.Value, err = r.decryptValue(field, resolved.Value)
+	if err != nil {
+		return ResolvedValue{}, fmt.Errorf("failed to decrypt %s: %w", configKey, err)
+	}
+	return resolved, nil
+}
+
+// GetAllResolved retrieves the values of all keys of the config like GetAll, along with the config each came from.
+// As with GetAll, the values of secret fields are decrypted.
+func (r *Rigel) GetAllResolved(ctx context.Context) (map[string]ResolvedValue, error) {
+	schema, err := r.GetSchema(ctx)
+	if err != nil {
+		return nil, err
+	}
+	resolved, _, err := r.resolveAll(ctx, schema)
+	if err != nil {
+		return nil, err
+	}
+	for _, field := range schema.Leaves() {
+		rv, found := resolved[field.Name]
+		if !found || !field.IsSecret() {
+			continue
+		}
+		if rv.Value, err = r.decryptSecret(rv.Value); err != nil {
+			return nil, fmt.Errorf("failed to decrypt %s: %w", field.Name, err)
+		}
+		resolved[field.Name] = rv
+	}
+	return resolved, nil
+}
+
+// resolveAll reads the values of the fields of schema, resolved through the chain of parents of the
+// config, and caches them. A config without a parent costs a single prefix read of the storage; one
+// with a parent costs another, of all the configs of the version. It also returns the storage revision
+// of the last read, or 0 if the storage cannot tell it.
+func (r *Rigel) resolveAll(ctx context.Context, schema *types.Schema) (map[string]ResolvedValue, int64, error) {
+	kvs, rev, err := r.readPrefix(ctx, getConfPath(r.App, r.Module, r.Version, r.Config)+"/")
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to get config values: %w", err)
+	}
+
+	chain := []string{r.Config}
+	if kvs[getConfParentPath(r.App, r.Module, r.Version, r.Config)] != "" {
+		if kvs, rev, err = r.readPrefix(ctx, getConfigsPath(r.App, r.Module, r.Version)); err != nil {
+			return nil, 0, fmt.Errorf("failed to get config values: %w", err)
+		}
+		if chain, err = r.chainFrom(kvs); err != nil {
+			return nil, 0, err
+		}
+	}
+
+	// Cache the parents along with the values, so that Get follows the same chain
+	for _, config := range chain {
+		key := getConfParentPath(r.App, r.Module, r.Version, config)
+		r.Cache.Set(key, kvs[key])
+	}
+
+	fields := schema.Leaves()
+	resolved := make(map[string]ResolvedValue, len(fields))
+	for _, field := range fields {
+		for _, config := range chain {
+			keys := []string{
+				getConfKeyPath(r.App, r.Module, r.Version, config, field.Name),
+				getConfRulesPath(r.App, r.Module, r.Version, config, field.Name),
+			}
+			if field.Flag {
+				keys = append(keys, getConfFlagPath(r.App, r.Module, r.Version, config, field.Name))
+			}
+			for _, key := range keys {
+				if value, found := kvs[key]; found {
+					r.Cache.Set(key, value)
+				} else if nc, ok := r.Cache.(types.NegativeCache); ok {
+					nc.SetMissing(key)
+				}
+			}
+		}
+		if rv, found := r.resolve(field.Name, chain, kvs); found {
+			resolved[field.Name] = rv
+		}
+	}
+	return resolved, rev, nil
+}
+
+// readPrefix reads the keys with prefix, along with the storage revision if the storage can tell it.
+func (r *Rigel) readPrefix(ctx context.Context, prefix string) (map[string]string, int64, error) {
+	if rr, ok := r.Storage.(types.RevisionReader); ok {
+		return rr.GetWithPrefixRev(ctx, prefix)
+	}
+	kvs, err := r.Storage.GetWithPrefix(ctx, prefix)
+	return kvs, 0, err
+}
+
+// configChain returns the config followed by its parents, nearest first, reading the parents through
+// the cache. The value and the rules of configKey in each config are read along with its parent and
+// cached, so that resolving a key costs at most one storage read per config of the chain.
+func (r *Rigel) configChain(ctx context.Context, configKey string) ([]string, error) {
+	return buildChain(r.Config, func(config string) (string, error) {
+		parentKey := getConfParentPath(r.App, r.Module, r.Version, config)
+		values, err := r.cachedGetMany(ctx, parentKey,
+			getConfKeyPath(r.App, r.Module, r.Version, config, configKey),
+			getConfRulesPath(r.App, r.Module, r.Version, config, configKey))
+		if err != nil {
+			return "", err
+		}
+		return values[parentKey], nil
+	})
+}
+
+// chainFrom returns the config followed by its parents, nearest first, with the parents taken from kvs.
+func (r *Rigel) chainFrom(kvs map[string]string) ([]string, error) {
+	return buildChain(r.Config, func(config string) (string, error) {
+		return kvs[getConfParentPath(r.App, r.Module, r.Version, config)], nil
+	})
+}
+
+// checkParent checks that parent can be made the parent of the config, as by SetParent. It
+// returns the parents read along the chain of parent, keyed by storage key, which must not
+// change before the parent is written for the check to hold.
+func (r *Rigel) checkParent(ctx context.Context, parent string) (map[string]string, error) {
+	parents := make(map[string]string)
+	if parent == "" {
+		return parents, nil
+	}
+	kvs, _, err := r.readPrefix(ctx, getConfigsPath(r.App, r.Module, r.Version))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get config parents: %w", err)
+	}
+	_, err = buildChain(r.Config, func(config string) (string, error) {
+		if config == r.Config {
+			return parent, nil
+		}
+		key := getConfParentPath(r.App, r.Module, r.Version, config)
+		parents[key] = kvs[key]
+		return kvs[key], nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return parents, nil
+}
+
+// resolve finds the value of configKey in kvs, looking in each config of chain in turn.
+// In each config, a targeting rule matching the attributes of the client takes precedence over the value.
+func (r *Rigel) resolve(configKey string, chain []string, kvs map[string]string) (ResolvedValue, bool) {
+	for _, config := range chain {
+		if value, matched := r.evaluateRules(kvs[getConfRulesPath(r.App, r.Module, r.Version, config, configKey)]); matched {
+			return ResolvedValue{Value: value, Source: config}, true
+		}
+		if value, found := kvs[getConfKeyPath(r.App, r.Module, r.Version, config, configKey)]; found {
+			return ResolvedValue{Value: value, Source: config}, true
+		}
+	}
+	return ResolvedValue{}, false
+}
+
+// buildChain returns config followed by its parents, nearest first, as given by parentOf.
+func buildChain(config string, parentOf func(config string) (string, error)) ([]string, error) {
+	chain := []string{config}
+	for {
+		parent, err := parentOf(config)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get parent of config %s: %w", config, err)
+		}
+		if parent == "" {
+			return chain, nil
+		}
+		for _, c := range chain {
+			if c == parent {
+				return nil, fmt.Errorf("%w: %s -> %s", ErrOverlayCycle, strings.Join(chain, " -> "), parent)
+			}
+		}
+		if len(chain) == maxOverlayDepth {
+			return nil, fmt.Errorf("%w: %s -> %s", ErrOverlayTooDeep, strings.Join(chain, " -> "), parent)
+		}
+		chain = append(chain, parent)
+		config = parent
+	}
+}
@@ -0,0 +1,149 @@
+package rigel
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestConfigOverlay(t *testing.T) {
+	base, storage := newTestRigel(t)
+	ctx := context.Background()
+
+	storage.data[getConfKeyPath("testApp", "testModule", 1, "prod", "host")] = "prod.internal"
+	storage.data[getConfKeyPath("testApp", "testModule", 1, "prod", "port")] = "8080"
+	storage.data[getConfKeyPath("testApp", "testModule", 1, "prod", "debug")] = "false"
+	storage.data[getConfKeyPath("testApp", "testModule", 1, "prod-mumbai", "port")] = "9090"
+
+	r := base.View("testApp", "testModule", 1, "prod-mumbai")
+	if err := r.SetParent(ctx, "prod"); err != nil {
+		t.Fatalf("SetParent() error = %v", err)
+	}
+	if parent, err := r.Parent(ctx); err != nil || parent != "prod" {
+		t.Errorf("Parent() = %q, %v, want prod", parent, err)
+	}
+
+	if port, err := r.GetInt(ctx, "port"); err != nil || port != 9090 {
+		t.Errorf("GetInt(port) = %d, %v, want the override 9090", port, err)
+	}
+	if rv, err := r.GetWithSource(ctx, "host"); err != nil || rv != (ResolvedValue{Value: "prod.internal", Source: "prod"}) {
+		t.Errorf("GetWithSource(host) = %+v, %v, want the value of prod", rv, err)
+	}
+
+	resolved, err := r.GetAllResolved(ctx)
+	if err != nil {
+		t.Fatalf("GetAllResolved() error = %v", err)
+	}
+	want := map[string]ResolvedValue{
+		"host":  {Value: "prod.internal", Source: "prod"},
+		"port":  {Value: "9090", Source: "prod-mumbai"},
+		"debug": {Value: "false", Source: "prod"},
+	}
+	if !reflect.DeepEqual(resolved, want) {
+		t.Errorf("GetAllResolved() = %v, want %v", resolved, want)
+	}
+
+	var config struct {
+		Host string `json:"host"`
+		Port int    `json:"port"`
+	}
+	if err := r.LoadConfig(ctx, &config); err != nil {
+		t.Fatalf("LoadConfig() error = %v", err)
+	}
+	if config.Host != "prod.internal" || config.Port != 9090 {
+		t.Errorf("LoadConfig() = %+v", config)
+	}
+}
+
+func TestConfigOverlayRejectsBadChains(t *testing.T) {
+	base, _ := newTestRigel(t)
+	ctx := context.Background()
+
+	if err := base.View("testApp", "testModule", 1, "a").SetParent(ctx, "a"); !errors.Is(err, ErrOverlayCycle) {
+		t.Errorf("SetParent() to itself error = %v, want ErrOverlayCycle", err)
+	}
+
+	if err := base.View("testApp", "testModule", 1, "a").SetParent(ctx, "b"); err != nil {
+		t.Fatalf("SetParent() error = %v", err)
+	}
+	if err := base.View("testApp", "testModule", 1, "b").SetParent(ctx, "a"); !errors.Is(err, ErrOverlayCycle) {
+		t.Errorf("SetParent() making a cycle error = %v, want ErrOverlayCycle", err)
+	}
+
+	// c1 -> c2 -> ... -> c8 is as long as a chain may be
+	for i := 1; i < maxOverlayDepth; i++ {
+		config := fmt.Sprintf("c%d", i)
+		if err := base.View("testApp", "testModule", 1, config).SetParent(ctx, fmt.Sprintf("c%d", i+1)); err != nil {
+			t.Fatalf("SetParent() of %s error = %v", config, err)
+		}
+	}
+	if err := base.View("testApp", "testModule", 1, "c0").SetParent(ctx, "c1"); !errors.Is(err, ErrOverlayTooDeep) {
+		t.Errorf("SetParent() making a long chain error = %v, want ErrOverlayTooDeep", err)
+	}
+}
+
+func TestSetParentConcurrentCycle(t *testing.T) {
+	base, storage := newTestRigel(t)
+	ctx := context.Background()
+
+	// b is made a child of a after SetParent of a checked its parents, just before the write
+	reads := 0
+	storage.afterRead = func() {
+		if reads++; reads == 2 {
+			storage.Put(ctx, getConfParentPath("testApp", "testModule", 1, "b"), "a")
+		}
+	}
+	if err := base.View("testApp", "testModule", 1, "a").SetParent(ctx, "b"); !errors.Is(err, ErrOverlayCycle) {
+		t.Errorf("SetParent() racing a cycle error = %v, want ErrOverlayCycle", err)
+	}
+	if parent := storage.data[getConfParentPath("testApp", "testModule", 1, "a")]; parent != "" {
+		t.Errorf("parent of a = %q, want none", parent)
+	}
+}
+
+func TestWatchConfigOverlay(t *testing.T) {
+	base, storage := newTestRigel(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	storage.data[getConfKeyPath("testApp", "testModule", 1, "prod", "host")] = "prod.internal"
+	storage.data[getConfKeyPath("testApp", "testModule", 1, "testConf", "port")] = "9090"
+	storage.data[getConfParentPath("testApp", "testModule", 1, "testConf")] = "prod"
+
+	if err := base.WatchConfig(ctx); err != nil {
+		t.Fatalf("WatchConfig() error = %v", err)
+	}
+	events := make(chan ChangeEvent, 10)
+	sub := base.OnAnyChange(func(e ChangeEvent) { events <- e })
+	defer sub.Unsubscribe()
+
+	// A change to the parent is hidden by the override of the config
+	storage.Put(ctx, getConfKeyPath("testApp", "testModule", 1, "prod", "port"), "8080")
+	// A change to the parent of a key the config does not override is seen
+	storage.Put(ctx, getConfKeyPath("testApp", "testModule", 1, "prod", "host"), "prod2.internal")
+	// Removing the parent removes the values taken from it
+	storage.Put(ctx, getConfParentPath("testApp", "testModule", 1, "testConf"), "")
+
+	want := []ChangeEvent{
+		{Key: "host", OldValue: "prod.internal", NewValue: "prod2.internal", Revision: 2},
+		{Key: "host", OldValue: "prod2.internal", Deleted: true, Revision: 3},
+	}
+	for _, w := range want {
+		select {
+		case got := <-events:
+			if got != w {
+				t.Errorf("change event = %+v, want %+v", got, w)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("timed out waiting for %+v", w)
+		}
+	}
+	select {
+	case e := <-events:
+		t.Errorf("unexpected change event %+v", e)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
@@ -4,20 +4,24 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
-	"reflect"
 	"strconv"
+	"strings"
+	"sync"
+	"time"
 
-	"github.com/remiges-aniket/etcd"
+	"github.com/remiges-aniket/layout"
 	"github.com/remiges-aniket/types"
 )
 
 const (
-	rigelPrefix          = "/remiges/rigel"
-	schemaDescriptionKey = "description"
+	rigelPrefix          = layout.Prefix
+	schemaDescriptionKey = layout.DescriptionKey
 	schemaNameKey        = "name"
 	schemaVersionKey     = "version"
-	schemaFieldsKey      = "fields"
+	schemaFieldsKey      = layout.FieldsKey
 	defaultEtcdEndpoints = "localhost:2379"
+	defaultDialTimeout   = 5 * time.Second
+	maxOverlayDepth      = 8 // maxOverlayDepth is the most configs in the chain of parents of a config, itself included
 )
 
 // Rigel represents a client for Rigel configuration manager server.
@@ -28,6 +32,51 @@ type Rigel struct {
 	Module  string
 	Version int
 	Config  string
+
+	attributes     map[string]string // attributes of the client, matched against targeting rules
+	keyring        *Keyring          // keyring encrypting the values of secret fields
+	overrideLocks  bool              // overrideLocks makes writes to locked configs succeed, see WithLockOverride
+	overrideReason string
+	shared         *sharedState // shared is the state shared by the client and its views, see state
+}
+
+// sharedState is the state of a client that its views share: the change subscriptions,
+// the health of its watch, the schema cache and the snapshot file.
+type sharedState struct {
+	subscriptions *subscriptionRegistry
+	watch         *watchState
+	schemas       *schemaCache
+	snapshot      *snapshotState
+}
+
+// sharedMu guards the creation of the shared state of clients built as struct literals.
+var sharedMu sync.RWMutex
+
+func newSharedState() *sharedState {
+	return &sharedState{
+		subscriptions: newSubscriptionRegistry(),
+		watch:         newWatchState(),
+		schemas:       newSchemaCache(),
+		snapshot:      newSnapshotState(),
+	}
+}
+
+// state returns the shared state of the client, creating it on first use
+// when the client was built as a struct literal rather than with New.
+func (r *Rigel) state() *sharedState {
+	sharedMu.RLock()
+	shared := r.shared
+	sharedMu.RUnlock()
+	if shared != nil {
+		return shared
+	}
+
+	sharedMu.Lock()
+	defer sharedMu.Unlock()
+	if r.shared == nil {
+		r.shared = newSharedState()
+	}
+	return r.shared
 }
 
 // New creates a new instance of Rigel with the provided Storage interface.
@@ -41,6 +90,8 @@ func New(storage types.Storage, app string, module string, version int, config s
 		Module:  module,
 		Version: version,
 		Config:  config,
+
+		shared: newSharedState(),
 	}
 }
 
@@ -55,6 +106,8 @@ func NewWithStorage(storage types.Storage) *Rigel {
 	return &Rigel{
 		Storage: storage,
 		Cache:   NewInMemoryCache(),
+
+		shared: newSharedState(),
 	}
 }
 
@@ -86,110 +139,130 @@ func (r *Rigel) WithConfig(config string) *Rigel {
 	return r
 }
 
-// Default creates a new instance of Rigel with a default EtcdStorage instance.
-func Default() (*Rigel, error) {
-	_, err := etcd.NewEtcdStorage([]string{"localhost:2379"})
-	if err != nil {
-		return nil, fmt.Errorf("failed to create default EtcdStorage: %w", err)
-	}
-
-	return nil, nil
+// WithCache sets the Cache used by the Rigel client and returns the modified Rigel object.
+// By default an unbounded InMemoryCache is used; NewLRUCache gives a bounded cache with expiry,
+// and NoCache reads every value from the storage.
+// This method is typically used for method chaining during Rigel object creation.
+func (r *Rigel) WithCache(cache types.Cache) *Rigel {
+	r.Cache = cache
+	return r
 }
 
-// KeyExistsInSchema checks if a key exists in the schema.
-func (r *Rigel) KeyExistsInSchema(ctx context.Context, key string) (bool, error) {
-	schema, err := r.GetSchema(ctx)
+// Default creates a new instance of Rigel with an EtcdStorage instance, configured from the
+// environment as described by LoadOptions. The etcd endpoints, credentials, TLS files and dial
+// timeout, as well as the app, module, version and config of the client, are taken from the
+// RIGEL_* environment variables or the file named by RIGEL_CONFIG_FILE. The returned client is
+// ready for LoadConfig and WatchConfig.
+func Default() (*Rigel, error) {
+	opts, err := LoadOptions()
 	if err != nil {
-		return false, fmt.Errorf("failed to get schema: %w", err)
+		return nil, err
 	}
-
-	for _, field := range schema.Fields {
-		if field.Name == key {
-			return true, nil
-		}
+	if err := opts.validate(); err != nil {
+		return nil, err
 	}
 
-	return false, nil
-}
-
-// Set sets a value of a config key in the storage.
-func (r *Rigel) Set(ctx context.Context, configKey string, value string) error {
-	// Check if the key exists in the schema
-	exists, err := r.KeyExistsInSchema(ctx, configKey)
+	storage, err := opts.Storage()
 	if err != nil {
-		return fmt.Errorf("failed to check if key exists in schema: %w", err)
-	}
-	if !exists {
-		return &KeyNotFoundError{Key: configKey}
+		return nil, err
 	}
 
-	// Get the schema
-	schema, err := r.GetSchema(ctx)
-	if err != nil {
-		return fmt.Errorf("failed to get schema: %w", err)
+	r := New(storage, opts.App, opts.Module, opts.Version, opts.Config)
+	if opts.SnapshotFile != "" {
+		r.WithSnapshotFile(opts.SnapshotFile)
 	}
-
-	// Find the field in the schema
-	var field *types.Field
-	for _, f := range schema.Fields {
-		if f.Name == configKey {
-			field = &f
-			break
-		}
+	if len(opts.Attributes) > 0 {
+		r.WithAttributes(opts.Attributes)
 	}
-
-	// Validate the value against the field's constraints
-	if !validateValueAgainstConstraints(value, field) {
-		return fmt.Errorf("value does not meet the constraints of the field")
+	if opts.KeyringFile != "" {
+		keyring, err := LoadKeyring(opts.KeyringFile)
+		if err != nil {
+			return nil, err
+		}
+		r.WithKeyring(keyring)
 	}
+	return r, nil
+}
 
-	// Construct the key for the parameter
-	key := getConfKeyPath(r.App, r.Module, r.Version, r.Config, configKey)
-
-	// Set the value in the storage
-	err = r.Storage.Put(ctx, key, value)
+// KeyExistsInSchema checks if a key exists in the schema.
+// Keys of fields in groups are given by their dotted names, such as "db.host".
+func (r *Rigel) KeyExistsInSchema(ctx context.Context, key string) (bool, error) {
+	schema, err := r.GetSchema(ctx)
 	if err != nil {
-		return fmt.Errorf("failed to set config value: %w", err)
+		return false, fmt.Errorf("failed to get schema: %w", err)
 	}
 
-	// Update the value in the cache
-	r.Cache.Set(key, value)
+	return schema.Leaf(key) != nil, nil
+}
 
-	return nil
+// Set sets a value of a config key in the storage. The value must be valid for the schema field
+// of the key, and is encrypted if the field is a secret. It is written on condition that the
+// config is not locked, see SetLock.
+func (r *Rigel) Set(ctx context.Context, configKey string, value string) error {
+	u := r.Update()
+	if err := u.Set(ctx, configKey, value); err != nil {
+		return err
+	}
+	return u.Commit(ctx)
 }
 
 // LoadConfig retrieves the configuration data associated with the provided configName.
-// It then unmarshals this data into the provided configStruct.
+// It then stores this data into the fields of the provided configStruct, as described
+// for LoadConfigReport; use LoadConfigReport to find fields that do not match the schema.
 //
 // The configStruct parameter must be a pointer to a config struct used in the application.
 // If it is not, an error will be returned.
-// Non-pointer or non-struct types aren't supported due to type safety issues (e.g., unexpected fields in JSON)
-// and modification restrictions, as non-pointer variables can't be updated by json.Unmarshal.
+// Non-pointer or non-struct types aren't supported due to type safety issues (e.g., unexpected fields)
+// and modification restrictions, as non-pointer variables can't be updated.
 func (r *Rigel) LoadConfig(ctx context.Context, configStruct any) error {
-	// Check if configStruct is a pointer to a struct
-	val := reflect.ValueOf(configStruct)
-	if val.Kind() != reflect.Ptr || val.Elem().Kind() != reflect.Struct {
-		return fmt.Errorf("configStruct must be a pointer to a struct")
-	}
-
-	// Construct the configuration map
-	configMap, err := r.constructConfigMap(ctx)
-	if err != nil {
-		return err
-	}
+	_, err := r.LoadConfigReport(ctx, configStruct)
+	return err
+}
 
-	// Marshal the configuration map into a JSON string
-	configJSON, err := json.Marshal(configMap)
-	if err != nil {
-		return fmt.Errorf("failed to marshal config: %w", err)
+// ValidateSchema checks that schema can be added with AddSchema: it must have fields, each with a
+// name, unique among its siblings, that holds neither "." nor "/", and a known type. Groups must
+// have fields and other fields must not, flags must be bools, and the minimum of a field must not
+// be above its maximum.
+func ValidateSchema(schema types.Schema) error {
+	if len(schema.Fields) == 0 {
+		return fmt.Errorf("schema has no fields")
 	}
+	return validateFields(schema.Fields, "")
+}
 
-	// Unmarshal the JSON string into the provided configStruct
-	err = json.Unmarshal(configJSON, configStruct)
-	if err != nil {
-		return fmt.Errorf("failed to unmarshal config value: %w", err)
+// validateFields checks fields, the fields of the group named group, as ValidateSchema.
+func validateFields(fields []types.Field, group string) error {
+	seen := make(map[string]bool, len(fields))
+	for _, f := range fields {
+		name := f.Name
+		if group != "" {
+			name = group + "." + f.Name
+		}
+		switch {
+		case f.Name == "" || strings.ContainsAny(f.Name, "./"):
+			return fmt.Errorf("invalid field name %q", name)
+		case seen[f.Name]:
+			return fmt.Errorf("field %s is defined twice", name)
+		case f.IsGroup() && len(f.Fields) == 0:
+			return fmt.Errorf("group %s has no fields", name)
+		case !f.IsGroup() && len(f.Fields) > 0:
+			return fmt.Errorf("field %s is not a group but has fields", name)
+		case f.Flag && f.Type != "bool":
+			return fmt.Errorf("flag %s is not a bool", name)
+		case f.Constraints != nil && f.Constraints.Min != nil && f.Constraints.Max != nil && *f.Constraints.Min > *f.Constraints.Max:
+			return fmt.Errorf("minimum of %s is above its maximum", name)
+		}
+		switch f.Type {
+		case "string", "int", "float", "bool", types.FieldTypeSecret:
+		case types.FieldTypeGroup:
+			if err := validateFields(f.Fields, name); err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("field %s has unknown type %q", name, f.Type)
+		}
+		seen[f.Name] = true
 	}
-
 	return nil
 }
 
@@ -220,29 +293,36 @@ func (r *Rigel) AddSchema(ctx context.Context, schema types.Schema) error {
 		return fmt.Errorf("failed to store description: %v", err)
 	}
 
+	r.state().schemas.invalidate(baseSchemaPath)
 	return nil
 }
 
 // GetSchema retrieves a schema from the storage based on the provided schemaName and schemaVersion.
+// The fields and the description of the schema are read together in a single storage read.
+// While WatchConfig or WatchSchemas watches the schema, it is served from an in-memory cache.
 func (r *Rigel) GetSchema(ctx context.Context) (*types.Schema, error) {
+	schemaPath := getSchemaPath(r.App, r.Module, r.Version)
+	if schema, found := r.state().schemas.get(schemaPath); found {
+		return schema, nil
+	}
+	gen := r.state().schemas.generation()
 
 	// Construct the base key for the schema
 	schemaFieldsKey := getSchemaFieldsPath(r.App, r.Module, r.Version)
 	//Construct the base key for the schema description
 	schemaDescriptionKey := GetSchemaDescriptionPath(r.App, r.Module, r.Version)
 
-	description, err := r.Storage.Get(ctx, schemaDescriptionKey)
-	if err != nil {
-		return nil, err
-	}
-
-	fieldsStr, err := r.Storage.Get(ctx, schemaFieldsKey)
+	values, err := r.Storage.GetMany(ctx, schemaFieldsKey, schemaDescriptionKey)
 	if err != nil {
+		// While the config is served from the snapshot file, so is its schema
+		if schema, ok := r.snapshotSchema(); ok {
+			return schema, nil
+		}
 		return nil, err
 	}
 
 	var fields []types.Field
-	err = json.Unmarshal([]byte(fieldsStr), &fields)
+	err = json.Unmarshal([]byte(values[schemaFieldsKey]), &fields)
 	if err != nil {
 		return nil, fmt.Errorf("failed to unmarshal fields: %w", err)
 	}
@@ -251,54 +331,55 @@ func (r *Rigel) GetSchema(ctx context.Context) (*types.Schema, error) {
 	schema := &types.Schema{
 		Version:     r.Version,
 		Fields:      fields,
-		Description: description,
+		Description: values[schemaDescriptionKey],
 	}
 
-	fmt.Println("schema", schema)
+	r.state().schemas.set(schemaPath, schema, gen)
 	return schema, nil
 }
 
-// getConfigValue retrieves a configuration value from Rigel based on the provided schemaName, schemaVersion, and paramName.
-func (r *Rigel) getConfigValue(ctx context.Context, paramName string) (string, error) {
-	// Construct the key for the parameter
-	key := getConfKeyPath(r.App, r.Module, r.Version, r.Config, paramName)
-
-	// Retrieve the parameter value from the storage
-	value, err := r.Storage.Get(ctx, key)
+// GetAll retrieves the values of all keys of the config defined in the schema, keyed by
+// config key, with the values of secret fields decrypted. Keys without a value in the storage
+// are left out. The values are read with a single prefix read of the storage, and the cache is
+// filled with them.
+func (r *Rigel) GetAll(ctx context.Context) (map[string]string, error) {
+	schema, err := r.GetSchema(ctx)
 	if err != nil {
-		return "", err
+		return nil, err
+	}
+	values, _, err := r.getAll(ctx, schema)
+	if err != nil {
+		return nil, err
 	}
+	return r.decryptValues(schema, values)
+}
 
-	return value, nil
+// getAll reads the values of the fields of schema, resolved through the parents of the config, and caches them.
+// It also returns the storage revision of the read, or 0 if the storage cannot tell it.
+func (r *Rigel) getAll(ctx context.Context, schema *types.Schema) (map[string]string, int64, error) {
+	resolved, rev, err := r.resolveAll(ctx, schema)
+	if err != nil {
+		return nil, 0, err
+	}
+	values := make(map[string]string, len(resolved))
+	for name, rv := range resolved {
+		values[name] = rv.Value
+	}
+	return values, rev, nil
 }
 
-// constructConfigMap constructs a configuration map based on the provided schema, schemaName, and schemaVersion.
-// constructConfigMap constructs a configuration map based on the Rigel object.
-func (r *Rigel) constructConfigMap(ctx context.Context) (map[string]any, error) {
-	// Retrieve the schema
+// loadLive reads the schema and the values of the config from the storage.
+// It costs two storage reads: one for the schema and one for all the values.
+func (r *Rigel) loadLive(ctx context.Context) (*types.Schema, map[string]string, int64, error) {
 	schema, err := r.GetSchema(ctx)
 	if err != nil {
-		return nil, err
+		return nil, nil, 0, err
 	}
-	// Construct the configuration map
-	config := make(map[string]any)
-	for _, field := range schema.Fields {
-		// Retrieve the configuration value for the field
-		valueStr, err := r.getConfigValue(ctx, field.Name)
-		if err != nil {
-			return nil, err
-		}
-
-		// Convert the value to the correct type based on the field type
-		value, err := convertToType(valueStr, field.Type)
-		if err != nil {
-			return nil, err
-		}
-
-		// Add the value to the configuration map
-		config[field.Name] = value
+	values, rev, err := r.getAll(ctx, schema)
+	if err != nil {
+		return nil, nil, 0, err
 	}
-	return config, nil
+	return schema, values, rev, nil
 }
 
 type KeyNotFoundError struct {
@@ -310,22 +391,20 @@ func (e *KeyNotFoundError) Error() string {
 }
 
 // Get retrieves a value from the storage based on the provided key.
+// If the config has no value for the key, the value is taken from its parents, see SetParent.
 // It converts the retrieved value to the correct type based on the field type.
 // If the field type is not "int" or "bool", the value is assumed to be a string.
 // get retrieves a value from the cache or storage and returns it as a string.
 func (r *Rigel) Get(ctx context.Context, configKey string) (string, error) {
-	// Check if the key exists in the schema
-	exists, err := r.KeyExistsInSchema(ctx, configKey)
+	resolved, err := r.GetWithSource(ctx, configKey)
 	if err != nil {
-		return "", fmt.Errorf("failed to check if key exists in schema: %w", err)
-	}
-	if !exists {
-		return "", &KeyNotFoundError{Key: configKey}
+		return "", err
 	}
+	return resolved.Value, nil
+}
 
-	// Construct the key for the parameter
-	key := getConfKeyPath(r.App, r.Module, r.Version, r.Config, configKey)
-
+// cachedGet retrieves the value of key from the cache, or else from the storage, caching it.
+func (r *Rigel) cachedGet(ctx context.Context, key string) (string, error) {
 	// Try to get the value from the cache
 	value, found := r.Cache.Get(key)
 	if found {
@@ -335,15 +414,51 @@ func (r *Rigel) Get(ctx context.Context, configKey string) (string, error) {
 	// If the value is not in the cache, retrieve it from the storage
 	valueStr, err := r.Storage.Get(ctx, key)
 	if err != nil {
-		return "", &KeyNotFoundError{Key: key}
+		return "", err
 	}
 
-	// Store the value in the cache
-	r.Cache.Set(key, valueStr)
+	// Store the value in the cache, remembering missing keys if the cache supports it
+	if nc, ok := r.Cache.(types.NegativeCache); ok && valueStr == "" {
+		nc.SetMissing(key)
+	} else {
+		r.Cache.Set(key, valueStr)
+	}
 
 	return valueStr, nil
 }
 
+// cachedGetMany retrieves the values of keys from the cache, reading those not in the cache
+// from the storage in a single read and caching them. Missing keys have empty values.
+func (r *Rigel) cachedGetMany(ctx context.Context, keys ...string) (map[string]string, error) {
+	values := make(map[string]string, len(keys))
+	var missing []string
+	for _, key := range keys {
+		if value, found := r.Cache.Get(key); found {
+			values[key] = value
+		} else {
+			missing = append(missing, key)
+		}
+	}
+	if len(missing) == 0 {
+		return values, nil
+	}
+
+	kvs, err := r.Storage.GetMany(ctx, missing...)
+	if err != nil {
+		return nil, err
+	}
+	for _, key := range missing {
+		value := kvs[key]
+		if nc, ok := r.Cache.(types.NegativeCache); ok && value == "" {
+			nc.SetMissing(key)
+		} else {
+			r.Cache.Set(key, value)
+		}
+		values[key] = value
+	}
+	return values, nil
+}
+
 func (r *Rigel) GetInt(ctx context.Context, configKey string) (int, error) {
 	valueStr, err := r.Get(ctx, configKey)
 	if err != nil {
@@ -413,28 +528,3 @@ func convertToType(valueStr string, fieldType string) (interface{}, error) {
 		return valueStr, nil
 	}
 }
-
-// WatchConfig starts watching for changes to any key in the specified configuration namespace in the storage.
-// When a change is detected, it updates the corresponding key-value pair in the cache.
-// The method takes the schemaName, schemaVersion, and configName
-// to construct the base key for the configuration namespace.
-func (r *Rigel) WatchConfig(ctx context.Context) error {
-	// Construct the base key for the configuration
-	baseKey := getConfPath(r.App, r.Module, r.Version, r.Config)
-
-	events := make(chan types.Event)
-	if err := r.Storage.Watch(ctx, baseKey, events); err != nil {
-		return err
-	}
-
-	go func() {
-		for event := range events {
-			// Only update keys in the cache that have changed
-			if _, found := r.Cache.Get(event.Key); found {
-				r.Cache.Set(event.Key, event.Value)
-			}
-		}
-	}()
-
-	return nil
-}
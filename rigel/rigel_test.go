@@ -0,0 +1,874 @@
+package rigel
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/remiges-aniket/types"
+)
+
+// mockStorage is an in-memory implementation of types.Storage used by the tests.
+// Each write is a new revision, and the changes are kept so that they can be watched
+// from a past revision.
+type mockStorage struct {
+	mu        sync.Mutex
+	data      map[string]string
+	reads     int  // reads counts the read calls made on the storage
+	down      bool // down makes reads fail as if the storage could not be reached
+	watchers  map[string][]chan<- types.Event
+	rev       int64
+	history   []types.Event
+	afterRead func() // afterRead, if set, is called after each GetWithPrefixRev
+}
+
+func newMockStorage() *mockStorage {
+	return &mockStorage{
+		data:     make(map[string]string),
+		watchers: make(map[string][]chan<- types.Event),
+	}
+}
+
+func (m *mockStorage) Get(ctx context.Context, key string) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.reads++
+	if m.down {
+		return "", errStorageDown
+	}
+	return m.data[key], nil
+}
+
+func (m *mockStorage) GetWithPrefix(ctx context.Context, prefix string) (map[string]string, error) {
+	kvs, _, err := m.getWithPrefixRev(prefix)
+	return kvs, err
+}
+
+func (m *mockStorage) GetWithPrefixRev(ctx context.Context, prefix string) (map[string]string, int64, error) {
+	kvs, rev, err := m.getWithPrefixRev(prefix)
+	if m.afterRead != nil {
+		m.afterRead()
+	}
+	return kvs, rev, err
+}
+
+func (m *mockStorage) getWithPrefixRev(prefix string) (map[string]string, int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.reads++
+	if m.down {
+		return nil, 0, errStorageDown
+	}
+	kvs := make(map[string]string)
+	for k, v := range m.data {
+		if strings.HasPrefix(k, prefix) {
+			kvs[k] = v
+		}
+	}
+	return kvs, m.rev, nil
+}
+
+func (m *mockStorage) Revision(ctx context.Context) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.rev, nil
+}
+
+// GetWithPrefixAt undoes the changes made after rev, in reverse order, to the current keys
+// with prefix. Keys set directly in data, rather than through a write, have no history.
+func (m *mockStorage) GetWithPrefixAt(ctx context.Context, prefix string, rev int64) (map[string]string, error) {
+	kvs, _, err := m.getWithPrefixRev(prefix)
+	if err != nil {
+		return nil, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i := len(m.history) - 1; i >= 0 && m.history[i].Revision > rev; i-- {
+		event := m.history[i]
+		if !strings.HasPrefix(event.Key, prefix) {
+			continue
+		}
+		if event.PrevValue == "" {
+			delete(kvs, event.Key)
+		} else {
+			kvs[event.Key] = event.PrevValue
+		}
+	}
+	return kvs, nil
+}
+
+func (m *mockStorage) GetMany(ctx context.Context, keys ...string) (map[string]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.reads++
+	if m.down {
+		return nil, errStorageDown
+	}
+	kvs := make(map[string]string)
+	for _, k := range keys {
+		if v, ok := m.data[k]; ok {
+			kvs[k] = v
+		}
+	}
+	return kvs, nil
+}
+
+func (m *mockStorage) Put(ctx context.Context, key string, value string) error {
+	m.mu.Lock()
+	prev := m.data[key]
+	m.data[key] = value
+	m.apply([]types.Event{{Type: types.EventTypePut, Key: key, Value: value, PrevValue: prev}})
+	return nil
+}
+
+func (m *mockStorage) PutIf(ctx context.Context, key string, value string, kvs map[string]string) (bool, error) {
+	m.mu.Lock()
+	if m.data[key] != value {
+		m.mu.Unlock()
+		return false, nil
+	}
+	var events []types.Event
+	for k, v := range kvs {
+		events = append(events, types.Event{Type: types.EventTypePut, Key: k, Value: v, PrevValue: m.data[k]})
+		m.data[k] = v
+	}
+	m.apply(events)
+	return true, nil
+}
+
+func (m *mockStorage) Commit(ctx context.Context, txn types.Txn) (bool, error) {
+	m.mu.Lock()
+	for k, v := range txn.If {
+		if m.data[k] != v {
+			m.mu.Unlock()
+			return false, nil
+		}
+	}
+	var events []types.Event
+	for k, v := range txn.Put {
+		events = append(events, types.Event{Type: types.EventTypePut, Key: k, Value: v, PrevValue: m.data[k]})
+		m.data[k] = v
+	}
+	for _, k := range txn.Delete {
+		events = append(events, types.Event{Type: types.EventTypeDelete, Key: k, PrevValue: m.data[k]})
+		delete(m.data, k)
+	}
+	m.apply(events)
+	return true, nil
+}
+
+// delete removes key and notifies the watchers.
+func (m *mockStorage) delete(key string) {
+	m.mu.Lock()
+	prev := m.data[key]
+	delete(m.data, key)
+	m.apply([]types.Event{{Type: types.EventTypeDelete, Key: key, PrevValue: prev}})
+}
+
+// apply records events as the changes of a new revision, releases m.mu, which must be held,
+// and sends the events to the watchers of a prefix of their keys.
+func (m *mockStorage) apply(events []types.Event) {
+	m.rev++
+	var sends []watchSend
+	for i, event := range events {
+		event.Revision = m.rev
+		event.Last = i == len(events)-1
+		m.history = append(m.history, event)
+		sends = append(sends, m.targets(event)...)
+	}
+	m.mu.Unlock()
+
+	for _, s := range sends {
+		s.ch <- s.event
+	}
+}
+
+// watchSend is an event to be sent to a watcher.
+type watchSend struct {
+	ch    chan<- types.Event
+	event types.Event
+}
+
+// targets returns the sends of event to the watchers of a prefix of event.Key. m.mu must be held.
+func (m *mockStorage) targets(event types.Event) []watchSend {
+	var sends []watchSend
+	for prefix, chans := range m.watchers {
+		if strings.HasPrefix(event.Key, prefix) {
+			for _, ch := range chans {
+				sends = append(sends, watchSend{ch, event})
+			}
+		}
+	}
+	return sends
+}
+
+// notify sends event to every watcher of a prefix of event.Key.
+func (m *mockStorage) notify(event types.Event) {
+	m.mu.Lock()
+	sends := m.targets(event)
+	m.mu.Unlock()
+
+	for _, s := range sends {
+		s.ch <- s.event
+	}
+}
+
+func (m *mockStorage) Watch(ctx context.Context, key string, events chan<- types.Event) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.watchers[key] = append(m.watchers[key], events)
+	return nil
+}
+
+// WatchFrom sends the recorded changes to key from revision rev, then watches key.
+func (m *mockStorage) WatchFrom(ctx context.Context, key string, rev int64, events chan<- types.Event) error {
+	go func() {
+		for {
+			m.mu.Lock()
+			var backlog []types.Event
+			for _, event := range m.history {
+				if event.Revision >= rev && strings.HasPrefix(event.Key, key) {
+					backlog = append(backlog, event)
+				}
+			}
+			if len(backlog) == 0 {
+				m.watchers[key] = append(m.watchers[key], events)
+				m.mu.Unlock()
+				return
+			}
+			m.mu.Unlock()
+
+			for _, event := range backlog {
+				events <- event
+				rev = event.Revision + 1
+			}
+		}
+	}()
+	return nil
+}
+
+var errStorageDown = errors.New("storage is down")
+
+// newTestRigel returns a Rigel client backed by a mockStorage holding a small schema.
+func newTestRigel(t *testing.T) (*Rigel, *mockStorage) {
+	t.Helper()
+	storage := newMockStorage()
+	r := New(storage, "testApp", "testModule", 1, "testConf")
+
+	schema := types.Schema{
+		Version:     1,
+		Description: "test schema",
+		Fields: []types.Field{
+			{Name: "host", Type: "string"},
+			{Name: "port", Type: "int"},
+			{Name: "debug", Type: "bool"},
+		},
+	}
+	fields, err := json.Marshal(schema.Fields)
+	if err != nil {
+		t.Fatalf("failed to marshal fields: %v", err)
+	}
+	storage.data[getSchemaFieldsPath("testApp", "testModule", 1)] = string(fields)
+	storage.data[GetSchemaDescriptionPath("testApp", "testModule", 1)] = schema.Description
+	return r, storage
+}
+
+func TestOnChange(t *testing.T) {
+	r, storage := newTestRigel(t)
+	ctx := context.Background()
+
+	if err := r.WatchConfig(ctx); err != nil {
+		t.Fatalf("WatchConfig() error = %v", err)
+	}
+
+	portEvents := make(chan ChangeEvent, 10)
+	portSub := r.OnChange("port", func(e ChangeEvent) { portEvents <- e })
+	allEvents := make(chan ChangeEvent, 10)
+	allSub := r.OnAnyChange(func(e ChangeEvent) { allEvents <- e })
+	defer allSub.Unsubscribe()
+
+	storage.Put(ctx, getConfKeyPath("testApp", "testModule", 1, "testConf", "port"), "8080")
+	storage.Put(ctx, getConfKeyPath("testApp", "testModule", 1, "testConf", "port"), "9090")
+	storage.Put(ctx, getConfKeyPath("testApp", "testModule", 1, "testConf", "host"), "localhost")
+
+	want := []ChangeEvent{
+		{Key: "port", OldValue: nil, NewValue: 8080, Revision: 1},
+		{Key: "port", OldValue: 8080, NewValue: 9090, Revision: 2},
+	}
+	for _, w := range want {
+		select {
+		case got := <-portEvents:
+			if got != w {
+				t.Errorf("OnChange event = %+v, want %+v", got, w)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("timed out waiting for %+v", w)
+		}
+	}
+
+	for i := 0; i < 3; i++ {
+		select {
+		case <-allEvents:
+		case <-time.After(2 * time.Second):
+			t.Fatalf("OnAnyChange received %d events, want 3", i)
+		}
+	}
+
+	portSub.Unsubscribe()
+	storage.Put(ctx, getConfKeyPath("testApp", "testModule", 1, "testConf", "port"), "7070")
+	<-allEvents
+	select {
+	case e := <-portEvents:
+		t.Errorf("received event %+v after Unsubscribe", e)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestStructLiteral(t *testing.T) {
+	_, storage := newTestRigel(t)
+	r := &Rigel{Storage: storage, Cache: NewInMemoryCache(), App: "testApp", Module: "testModule", Version: 1, Config: "testConf"}
+	ctx := context.Background()
+
+	if _, err := r.GetSchema(ctx); err != nil {
+		t.Fatalf("GetSchema() error = %v", err)
+	}
+	if err := r.WatchConfig(ctx); err != nil {
+		t.Fatalf("WatchConfig() error = %v", err)
+	}
+	events := make(chan ChangeEvent, 1)
+	sub := r.View("testApp", "testModule", 1, "testConf").r.OnChange("port", func(e ChangeEvent) { events <- e })
+	defer sub.Unsubscribe()
+
+	storage.Put(ctx, getConfKeyPath("testApp", "testModule", 1, "testConf", "port"), "8080")
+	select {
+	case e := <-events:
+		if e.NewValue != 8080 {
+			t.Errorf("OnChange event = %+v, want the new port 8080", e)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out waiting for the change of port")
+	}
+	if status := r.WatchStatus(); !status.Running {
+		t.Errorf("WatchStatus() = %+v, want a running watch", status)
+	}
+}
+
+func TestLiveConfig(t *testing.T) {
+	r, storage := newTestRigel(t)
+	ctx := context.Background()
+
+	type serverConfig struct {
+		Host  string `json:"host"`
+		Port  int    `json:"port"`
+		Debug bool   `json:"debug"`
+	}
+
+	storage.data[getConfKeyPath("testApp", "testModule", 1, "testConf", "host")] = "localhost"
+	storage.data[getConfKeyPath("testApp", "testModule", 1, "testConf", "port")] = "8080"
+	storage.data[getConfKeyPath("testApp", "testModule", 1, "testConf", "debug")] = "false"
+
+	validate := func(c *serverConfig) error {
+		if c.Port < 1024 {
+			return fmt.Errorf("port %d is privileged", c.Port)
+		}
+		return nil
+	}
+	lc, err := NewLiveConfig(ctx, r, validate)
+	if err != nil {
+		t.Fatalf("NewLiveConfig() error = %v", err)
+	}
+	defer lc.Close()
+
+	if got := lc.Get().Port; got != 8080 {
+		t.Errorf("initial Port = %d, want 8080", got)
+	}
+
+	storage.Put(ctx, getConfKeyPath("testApp", "testModule", 1, "testConf", "port"), "9090")
+	waitFor(t, func() bool { return lc.Get().Port == 9090 })
+
+	storage.Put(ctx, getConfKeyPath("testApp", "testModule", 1, "testConf", "port"), "80")
+	select {
+	case <-lc.Errors():
+	case <-time.After(2 * time.Second):
+		t.Fatalf("expected a reload error for an invalid config")
+	}
+	if got := lc.Get().Port; got != 9090 {
+		t.Errorf("Port after failed reload = %d, want last good value 9090", got)
+	}
+}
+
+func TestLiveConfigChangeBeforeWatch(t *testing.T) {
+	r, storage := newTestRigel(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	type serverConfig struct {
+		Port int `json:"port"`
+	}
+	portKey := getConfKeyPath("testApp", "testModule", 1, "testConf", "port")
+	storage.data[portKey] = "8080"
+
+	// The port changes after the initial load, before the watch reads the config
+	storage.afterRead = func() {
+		storage.afterRead = nil
+		storage.Put(ctx, portKey, "9090")
+	}
+	lc, err := NewLiveConfig[serverConfig](ctx, r, nil)
+	if err != nil {
+		t.Fatalf("NewLiveConfig() error = %v", err)
+	}
+	defer lc.Close()
+
+	if got := lc.Get().Port; got != 9090 {
+		t.Errorf("Port = %d, want 9090 set before the watch started", got)
+	}
+}
+
+// waitFor polls cond until it returns true or the test times out.
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("condition not met before timeout")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestWatchStatus(t *testing.T) {
+	r, storage := newTestRigel(t)
+	ctx := context.Background()
+
+	if err := r.WatchConfig(ctx); err != nil {
+		t.Fatalf("WatchConfig() error = %v", err)
+	}
+	if status := r.WatchStatus(); !status.Running || !status.Healthy {
+		t.Errorf("WatchStatus() = %+v, want running and healthy", status)
+	}
+
+	baseKey := getConfPath("testApp", "testModule", 1, "testConf")
+	watchErr := fmt.Errorf("connection lost")
+	storage.notify(types.Event{Key: baseKey, Err: watchErr})
+
+	select {
+	case err := <-r.WatchErrors():
+		if err != watchErr {
+			t.Errorf("WatchErrors() received %v, want %v", err, watchErr)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("timed out waiting for watch error")
+	}
+	if status := r.WatchStatus(); status.Healthy || status.LastError != watchErr {
+		t.Errorf("WatchStatus() = %+v, want unhealthy with last error", status)
+	}
+
+	storage.Put(ctx, getConfKeyPath("testApp", "testModule", 1, "testConf", "port"), "8080")
+	waitFor(t, func() bool { return r.WatchStatus().Healthy })
+}
+
+func TestWatchConfigFromRead(t *testing.T) {
+	r, storage := newTestRigel(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	portKey := getConfKeyPath("testApp", "testModule", 1, "testConf", "port")
+	storage.Put(ctx, portKey, "8080")
+	events := make(chan ChangeEvent, 10)
+	sub := r.OnChange("port", func(e ChangeEvent) { events <- e })
+	defer sub.Unsubscribe()
+
+	// A write made between the read of the config and the start of the watch is not missed
+	storage.afterRead = func() {
+		storage.afterRead = nil
+		storage.Put(ctx, portKey, "9090")
+	}
+	if err := r.WatchConfig(ctx); err != nil {
+		t.Fatalf("WatchConfig() error = %v", err)
+	}
+	select {
+	case got := <-events:
+		if want := (ChangeEvent{Key: "port", OldValue: 8080, NewValue: 9090, Revision: 2}); got != want {
+			t.Errorf("change event = %+v, want %+v", got, want)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out waiting for the change made before the watch started")
+	}
+}
+
+func TestWatchConfigDeleteAndSchemaChange(t *testing.T) {
+	r, storage := newTestRigel(t)
+	ctx := context.Background()
+
+	portKey := getConfKeyPath("testApp", "testModule", 1, "testConf", "port")
+	storage.data[portKey] = "8080"
+	if _, err := r.Get(ctx, "port"); err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+
+	if err := r.WatchConfig(ctx); err != nil {
+		t.Fatalf("WatchConfig() error = %v", err)
+	}
+	events := make(chan ChangeEvent, 10)
+	sub := r.OnChange("port", func(e ChangeEvent) { events <- e })
+	defer sub.Unsubscribe()
+
+	storage.delete(portKey)
+	select {
+	case e := <-events:
+		want := ChangeEvent{Key: "port", OldValue: 8080, Deleted: true, Revision: 1}
+		if e != want {
+			t.Errorf("delete event = %+v, want %+v", e, want)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("timed out waiting for delete event")
+	}
+	if _, found := r.Cache.Get(portKey); found {
+		t.Errorf("deleted key is still cached")
+	}
+
+	// Changing the type of port in the schema changes the type of the values passed to callbacks
+	storage.Put(ctx, getSchemaFieldsPath("testApp", "testModule", 1), `[{"name":"port","type":"string"}]`)
+	storage.Put(ctx, portKey, "9090")
+	select {
+	case e := <-events:
+		if e.NewValue != "9090" {
+			t.Errorf("NewValue after schema change = %#v, want string \"9090\"", e.NewValue)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("timed out waiting for put event")
+	}
+}
+
+func TestLoadConfigReads(t *testing.T) {
+	r, storage := newTestRigel(t)
+	ctx := context.Background()
+
+	storage.data[getConfKeyPath("testApp", "testModule", 1, "testConf", "host")] = "localhost"
+	storage.data[getConfKeyPath("testApp", "testModule", 1, "testConf", "port")] = "8080"
+	storage.data[getConfKeyPath("testApp", "testModule", 1, "testConf", "debug")] = "true"
+	storage.data[getConfKeyPath("testApp", "testModule", 1, "otherConf", "port")] = "9090"
+
+	var config struct {
+		Host  string `json:"host"`
+		Port  int    `json:"port"`
+		Debug bool   `json:"debug"`
+	}
+	if err := r.LoadConfig(ctx, &config); err != nil {
+		t.Fatalf("LoadConfig() error = %v", err)
+	}
+	if config.Host != "localhost" || config.Port != 8080 || !config.Debug {
+		t.Errorf("LoadConfig() = %+v", config)
+	}
+	if storage.reads != 2 {
+		t.Errorf("LoadConfig() made %d storage reads, want 2", storage.reads)
+	}
+
+	// The values read by LoadConfig are served from the cache
+	if _, found := r.Cache.Get(getConfKeyPath("testApp", "testModule", 1, "testConf", "port")); !found {
+		t.Errorf("expected LoadConfig to warm the cache")
+	}
+
+	values, err := r.GetAll(ctx)
+	if err != nil {
+		t.Fatalf("GetAll() error = %v", err)
+	}
+	want := map[string]string{"host": "localhost", "port": "8080", "debug": "true"}
+	if !reflect.DeepEqual(values, want) {
+		t.Errorf("GetAll() = %v, want %v", values, want)
+	}
+}
+
+func TestSchemaCache(t *testing.T) {
+	r, storage := newTestRigel(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	storage.data[getConfKeyPath("testApp", "testModule", 1, "testConf", "port")] = "8080"
+
+	// Without a watch, the schema is read on every access
+	r.Get(ctx, "port")
+	r.Get(ctx, "port")
+	if storage.reads != 3 {
+		t.Errorf("Get() without watch made %d storage reads, want 3", storage.reads)
+	}
+
+	if err := r.WatchConfig(ctx); err != nil {
+		t.Fatalf("WatchConfig() error = %v", err)
+	}
+
+	// With a watch, a cached Get makes no storage reads
+	r.Get(ctx, "port")
+	storage.reads = 0
+	if value, err := r.Get(ctx, "port"); err != nil || value != "8080" {
+		t.Fatalf("Get() = %q, %v", value, err)
+	}
+	if storage.reads != 0 {
+		t.Errorf("cached Get() made %d storage reads, want 0", storage.reads)
+	}
+
+	// A change to the schema invalidates the cached copy
+	storage.Put(ctx, getSchemaFieldsPath("testApp", "testModule", 1), `[{"name":"timeout","type":"int"}]`)
+	waitFor(t, func() bool {
+		_, err := r.Get(ctx, "timeout")
+		return err == nil
+	})
+	if _, err := r.Get(ctx, "port"); err == nil {
+		t.Errorf("expected port to be unknown after the schema change")
+	}
+}
+
+func TestCopySchema(t *testing.T) {
+	max := 10
+	schema := &types.Schema{Fields: []types.Field{{
+		Name: "db",
+		Type: types.FieldTypeGroup,
+		Fields: []types.Field{
+			{Name: "pool", Type: "int", Constraints: &types.Constraints{Max: &max, Enum: []string{"5", "10"}}},
+		},
+	}}}
+
+	c := copySchema(schema)
+	pool := &c.Fields[0].Fields[0]
+	pool.Name = "size"
+	*pool.Constraints.Max = 20
+	pool.Constraints.Enum[0] = "1"
+
+	orig := schema.Fields[0].Fields[0]
+	if orig.Name != "pool" || *orig.Constraints.Max != 10 || orig.Constraints.Enum[0] != "5" {
+		t.Errorf("changing the copy changed the schema to %+v, %+v", orig, *orig.Constraints)
+	}
+}
+
+func TestViewConcurrent(t *testing.T) {
+	r, storage := newTestRigel(t)
+	ctx := context.Background()
+
+	// A second module with the same schema
+	storage.data[getSchemaFieldsPath("testApp", "otherModule", 1)] = storage.data[getSchemaFieldsPath("testApp", "testModule", 1)]
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		module := "testModule"
+		if i%2 == 1 {
+			module = "otherModule"
+		}
+		wg.Add(1)
+		go func(i int, module string) {
+			defer wg.Done()
+			view := r.View("testApp", module, 1, "testConf")
+			port := strconv.Itoa(8000 + i)
+			if err := view.Set(ctx, "port", port); err != nil {
+				t.Errorf("Set() error = %v", err)
+				return
+			}
+			if view.Module() != module {
+				t.Errorf("view module = %s, want %s", view.Module(), module)
+			}
+		}(i, module)
+	}
+	wg.Wait()
+
+	if r.App != "testApp" || r.Module != "testModule" {
+		t.Errorf("View() modified the client: app %s, module %s", r.App, r.Module)
+	}
+	for _, module := range []string{"testModule", "otherModule"} {
+		if storage.data[getConfKeyPath("testApp", module, 1, "testConf", "port")] == "" {
+			t.Errorf("expected port to be set for module %s", module)
+		}
+	}
+}
+
+func TestSnapshotFallback(t *testing.T) {
+	defer func(d time.Duration) { snapshotRetryInterval = d }(snapshotRetryInterval)
+	snapshotRetryInterval = 10 * time.Millisecond
+
+	r, storage := newTestRigel(t)
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "snapshot.json")
+	r.WithSnapshotFile(path)
+
+	type serverConfig struct {
+		Host string `json:"host"`
+		Port int    `json:"port"`
+	}
+	portKey := getConfKeyPath("testApp", "testModule", 1, "testConf", "port")
+	storage.data[getConfKeyPath("testApp", "testModule", 1, "testConf", "host")] = "localhost"
+	storage.data[portKey] = "8080"
+	storage.data[getConfKeyPath("testApp", "testModule", 1, "testConf", "debug")] = "false"
+
+	var config serverConfig
+	if err := r.LoadConfig(ctx, &config); err != nil {
+		t.Fatalf("LoadConfig() error = %v", err)
+	}
+	if status := r.SnapshotStatus(); status.Stale || status.SavedAt.IsZero() {
+		t.Errorf("SnapshotStatus() after live load = %+v, want a saved, non-stale snapshot", status)
+	}
+
+	// A new client started while the storage is down boots from the snapshot
+	storage.mu.Lock()
+	storage.down = true
+	storage.data[portKey] = "9090"
+	storage.mu.Unlock()
+	r2 := New(storage, "testApp", "testModule", 1, "testConf").WithSnapshotFile(path)
+	changes := make(chan ChangeEvent, 10)
+	sub := r2.OnAnyChange(func(e ChangeEvent) { changes <- e })
+	defer sub.Unsubscribe()
+
+	var stale serverConfig
+	if err := r2.LoadConfig(ctx, &stale); err != nil {
+		t.Fatalf("LoadConfig() from snapshot error = %v", err)
+	}
+	if stale != config {
+		t.Errorf("LoadConfig() from snapshot = %+v, want %+v", stale, config)
+	}
+	if !r2.SnapshotStatus().Stale {
+		t.Errorf("expected SnapshotStatus() to be stale")
+	}
+	if port, err := r2.GetInt(ctx, "port"); err != nil || port != 8080 {
+		t.Errorf("GetInt() from snapshot = %d, %v, want 8080", port, err)
+	}
+
+	// Once the storage is back, the client switches to the live config
+	storage.mu.Lock()
+	storage.down = false
+	storage.mu.Unlock()
+	waitFor(t, func() bool { return !r2.SnapshotStatus().Stale })
+	select {
+	case e := <-changes:
+		if e.Key != "port" || e.OldValue != 8080 || e.NewValue != 9090 {
+			t.Errorf("change after recovery = %+v", e)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("timed out waiting for change after recovery")
+	}
+}
+
+func TestFieldGroups(t *testing.T) {
+	r, storage := newTestRigel(t)
+	ctx := context.Background()
+
+	fields := []types.Field{
+		{Name: "host", Type: "string"},
+		{Name: "db", Type: types.FieldTypeGroup, Fields: []types.Field{
+			{Name: "host", Type: "string"},
+			{Name: "pool", Type: types.FieldTypeGroup, Fields: []types.Field{
+				{Name: "max", Type: "int"},
+			}},
+		}},
+	}
+	data, err := json.Marshal(fields)
+	if err != nil {
+		t.Fatalf("failed to marshal fields: %v", err)
+	}
+	storage.data[getSchemaFieldsPath("testApp", "testModule", 1)] = string(data)
+
+	schema, err := r.GetSchema(ctx)
+	if err != nil {
+		t.Fatalf("GetSchema() error = %v", err)
+	}
+	var names []string
+	for _, f := range schema.Leaves() {
+		names = append(names, f.Name)
+	}
+	if want := []string{"host", "db.host", "db.pool.max"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("Leaves() = %v, want %v", names, want)
+	}
+	if nested := types.NestFields(schema.Leaves()); !reflect.DeepEqual(nested, fields) {
+		t.Errorf("NestFields(Leaves()) = %+v, want %+v", nested, fields)
+	}
+
+	if err := r.Set(ctx, "db.pool.max", "20"); err != nil {
+		t.Fatalf("Set() error = %v", err)
+	}
+	if got := storage.data[rigelPrefix+"/testApp/testModule/1/config/testConf/db/pool/max"]; got != "20" {
+		t.Errorf("stored value = %q, want 20", got)
+	}
+	if max, err := r.GetInt(ctx, "db.pool.max"); err != nil || max != 20 {
+		t.Errorf("GetInt() = %d, %v, want 20", max, err)
+	}
+	if err := r.Set(ctx, "db", "x"); err == nil {
+		t.Errorf("Set() of a group expected an error")
+	}
+}
+
+func TestSubscriptionDropsWhenFull(t *testing.T) {
+	r, _ := newTestRigel(t)
+	started, release := make(chan struct{}, 1), make(chan struct{})
+	got := make(chan ChangeEvent, maxQueuedChanges+10)
+	sub := r.OnAnyChange(func(e ChangeEvent) {
+		select {
+		case started <- struct{}{}:
+		default:
+		}
+		<-release
+		got <- e
+	})
+	defer sub.Unsubscribe()
+
+	// The first change is taken by the blocked callback, the rest fill the queue
+	for i := 0; i <= maxQueuedChanges+5; i++ {
+		sub.enqueue(ChangeEvent{Key: "port", Revision: int64(i)})
+		if i == 0 {
+			<-started
+		}
+	}
+	if dropped := sub.Dropped(); dropped != 5 {
+		t.Errorf("Dropped() = %d, want 5", dropped)
+	}
+	close(release)
+	if e := <-got; e.Revision != 0 {
+		t.Errorf("first change = %+v, want revision 0", e)
+	}
+	if e := <-got; e.Revision != 6 {
+		t.Errorf("second change = %+v, want revision 6, the oldest kept", e)
+	}
+}
+
+func TestNoCache(t *testing.T) {
+	r, storage := newTestRigel(t)
+	r.WithCache(NoCache{})
+	ctx := context.Background()
+
+	for _, port := range []string{"8080", "9090"} {
+		storage.data[getConfKeyPath("testApp", "testModule", 1, "testConf", "port")] = port
+		got, err := r.Get(ctx, "port")
+		if err != nil || got != port {
+			t.Errorf("Get() = %q, %v, want %q", got, err, port)
+		}
+	}
+}
+
+func TestValidateSchema(t *testing.T) {
+	min, max := 10, 1
+	tests := []struct {
+		name    string
+		fields  []types.Field
+		wantErr bool
+	}{
+		{"valid", []types.Field{{Name: "port", Type: "int"}, {Name: "db", Type: types.FieldTypeGroup, Fields: []types.Field{{Name: "password", Type: types.FieldTypeSecret}}}}, false},
+		{"no fields", nil, true},
+		{"dotted name", []types.Field{{Name: "db.host", Type: "string"}}, true},
+		{"duplicate name", []types.Field{{Name: "port", Type: "int"}, {Name: "port", Type: "string"}}, true},
+		{"unknown type", []types.Field{{Name: "port", Type: "uint"}}, true},
+		{"empty group", []types.Field{{Name: "db", Type: types.FieldTypeGroup}}, true},
+		{"bad nested field", []types.Field{{Name: "db", Type: types.FieldTypeGroup, Fields: []types.Field{{Name: "", Type: "string"}}}}, true},
+		{"flag not bool", []types.Field{{Name: "beta", Type: "string", Flag: true}}, true},
+		{"min above max", []types.Field{{Name: "port", Type: "int", Constraints: &types.Constraints{Min: &min, Max: &max}}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := ValidateSchema(types.Schema{Version: 1, Fields: tt.fields}); (err != nil) != tt.wantErr {
+				t.Errorf("ValidateSchema() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -1,41 +1,79 @@
 package rigel
 
 import (
-	"fmt"
-
+	"github.com/remiges-aniket/layout"
 	"github.com/remiges-aniket/types"
 )
 
+// The paths of schemas and configs are those of package layout, shared with the server and tools.
+
 // getSchemaFieldsPath constructs the path for a schema based on the provided appName, moduleName and version.
 func getSchemaFieldsPath(appName string, moduleName string, version int) string {
-	return fmt.Sprintf("%s/%s/%s/%d/fields", rigelPrefix, appName, moduleName, version)
+	return layout.SchemaFieldsPath(appName, moduleName, version)
 }
 
 // getConfPath constructs the path for a configuration based on the provided appName, moduleName and version.
 func getConfPath(appName string, moduleName string, version int, namedConfig string) string {
-	return fmt.Sprintf("%s/%s/%s/%d/config/%s", rigelPrefix, appName, moduleName, version, namedConfig)
+	return layout.ConfigPath(appName, moduleName, version, namedConfig)
+}
+
+// getConfigsPath constructs the prefix of the keys of all the named configs of an app, module and version.
+func getConfigsPath(appName string, moduleName string, version int) string {
+	return layout.ConfigsPath(appName, moduleName, version)
+}
+
+// getConfParentPath constructs the path of the key holding the name of the parent of a named config.
+// Dots in config key names become slashes, so no config key has this path.
+func getConfParentPath(appName string, moduleName string, version int, namedConfig string) string {
+	return layout.ConfigParentPath(appName, moduleName, version, namedConfig)
 }
 
 // getConfKeyPath constructs the path for a configuration based on the provided appName, moduleName, version, namedConfig, and confKey.
+// The dotted name of a field in a group, such as "db.pool.max", becomes a nested path (db/pool/max).
 func getConfKeyPath(appName string, moduleName string, version int, namedConfig string, confKey string) string {
-	return fmt.Sprintf("%s/%s/%s/%d/config/%s/%s", rigelPrefix, appName, moduleName, version, namedConfig, confKey)
+	return layout.ConfigKeyPath(appName, moduleName, version, namedConfig, confKey)
+}
+
+// getConfRulesPath constructs the path of the key holding the targeting rules of a config key.
+func getConfRulesPath(appName string, moduleName string, version int, namedConfig string, confKey string) string {
+	return layout.ConfigRulesPath(appName, moduleName, version, namedConfig, confKey)
 }
 
+// getConfFlagPath constructs the path of the key holding the rollout state of a feature flag.
+func getConfFlagPath(appName string, moduleName string, version int, namedConfig string, confKey string) string {
+	return layout.ConfigFlagPath(appName, moduleName, version, namedConfig, confKey)
+}
+
+// GetConfKeyPath constructs the path of the key holding the value of confKey in a named config.
+//
+// Deprecated: use layout.ConfigKeyPath.
 func GetConfKeyPath(appName string, moduleName string, version int, namedConfig string, confKey string) string {
-	return fmt.Sprintf("%s/%s/%s/%d/%s/%s", rigelPrefix, appName, moduleName, version, namedConfig, confKey)
+	return layout.ConfigKeyPath(appName, moduleName, version, namedConfig, confKey)
+}
+
+// configKeyFromPath converts a key path below the config to the dotted name of the config key.
+func configKeyFromPath(path string) string {
+	return layout.KeyFromPath(path)
 }
 
-// getSchemaDescriptionPath constructs the path for a schema based on the provided appName, moduleName and version.
+// GetSchemaDescriptionPath constructs the path for a schema based on the provided appName, moduleName and version.
+//
+// Deprecated: use layout.SchemaDescriptionPath.
 func GetSchemaDescriptionPath(appName string, moduleName string, version int) string {
-	return fmt.Sprintf("%s/%s/%s/%d/description", rigelPrefix, appName, moduleName, version)
+	return layout.SchemaDescriptionPath(appName, moduleName, version)
 }
 
 // getSchemaPath constructs the base key for a schema in etcd based on the provided appName, moduleName and version.
 func getSchemaPath(appName string, moduleName string, version int) string {
-	return fmt.Sprintf("%s/%s/%s/%d/", rigelPrefix, appName, moduleName, version)
+	return layout.SchemaPath(appName, moduleName, version)
 }
 
 func validateValueAgainstConstraints(value string, field *types.Field) bool {
+	// Groups hold other fields, not values
+	if field.IsGroup() {
+		return false
+	}
+
 	// Convert the value to the correct type
 	val, err := convertToType(value, field.Type)
 	if err != nil {
@@ -50,7 +88,7 @@ func validateValueAgainstConstraints(value string, field *types.Field) bool {
 				if val.(int) < *field.Constraints.Min {
 					return false
 				}
-			case "string":
+			case "string", types.FieldTypeSecret:
 				if len(val.(string)) < *field.Constraints.Min {
 					return false
 				}
@@ -66,7 +104,7 @@ func validateValueAgainstConstraints(value string, field *types.Field) bool {
 				if val.(int) > *field.Constraints.Max {
 					return false
 				}
-			case "string":
+			case "string", types.FieldTypeSecret:
 				if len(val.(string)) > *field.Constraints.Max {
 					return false
 				}
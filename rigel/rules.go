@@ -0,0 +1,83 @@
+package rigel
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path"
+
+	"github.com/remiges-aniket/types"
+)
+
+// WithAttributes sets the attributes of the client, such as hostname, region or instance id,
+// against which the targeting rules of config values are evaluated, and returns the modified
+// Rigel object. A client without attributes only matches rules without entries, which SetRules
+// does not accept, so it always gets the values of the config.
+// This method is typically used for method chaining during Rigel object creation.
+func (r *Rigel) WithAttributes(attrs map[string]string) *Rigel {
+	r.attributes = make(map[string]string, len(attrs))
+	for name, value := range attrs {
+		r.attributes[name] = value
+	}
+	return r
+}
+
+// SetRules sets the targeting rules of a config key. Get, GetAll and LoadConfig give the value of
+// the first rule, in order, that matches the attributes of the client, and otherwise the value of
+// the config. Each rule must match on at least one attribute, its patterns must be valid for
+// path.Match, and its value must be valid for the schema field. No rules removes the rules.
+// Secret fields cannot have rules, since rules are stored unencrypted.
+func (r *Rigel) SetRules(ctx context.Context, configKey string, rules []types.Rule) error {
+	u := r.Update()
+	if err := u.SetRules(ctx, configKey, rules); err != nil {
+		return err
+	}
+	return u.Commit(ctx)
+}
+
+// GetRules returns the targeting rules of a config key set with SetRules in the config itself.
+func (r *Rigel) GetRules(ctx context.Context, configKey string) ([]types.Rule, error) {
+	value, err := r.cachedGet(ctx, getConfRulesPath(r.App, r.Module, r.Version, r.Config, configKey))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get rules: %w", err)
+	}
+	return parseRules(value)
+}
+
+// validateRule checks a rule for a config key of the given field.
+func validateRule(rule types.Rule, field *types.Field) error {
+	if len(rule.Match) == 0 {
+		return errors.New("rule matches no attributes")
+	}
+	for name, pattern := range rule.Match {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid pattern for %s: %w", name, err)
+		}
+	}
+	if !validateValueAgainstConstraints(rule.Value, field) {
+		return fmt.Errorf("value does not meet the constraints of the field")
+	}
+	return nil
+}
+
+// parseRules parses rules stored by SetRules. An empty value has no rules.
+func parseRules(value string) ([]types.Rule, error) {
+	if value == "" {
+		return nil, nil
+	}
+	var rules []types.Rule
+	if err := json.Unmarshal([]byte(value), &rules); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal rules: %w", err)
+	}
+	return rules, nil
+}
+
+// evaluateRules returns the value of the first of the stored rules that matches the attributes of the client.
+func (r *Rigel) evaluateRules(value string) (string, bool) {
+	rules, err := parseRules(value)
+	if err != nil {
+		return "", false
+	}
+	return types.EvaluateRules(rules, r.attributes)
+}
@@ -0,0 +1,96 @@
+package rigel
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/remiges-aniket/types"
+)
+
+func TestTargetingRules(t *testing.T) {
+	r, storage := newTestRigel(t)
+	ctx := context.Background()
+
+	storage.data[getConfKeyPath("testApp", "testModule", 1, "testConf", "port")] = "8080"
+
+	invalid := map[string][]types.Rule{
+		"no attributes": {{Value: "9090"}},
+		"bad pattern":   {{Match: map[string]string{"hostname": "web-["}, Value: "9090"}},
+		"bad value":     {{Match: map[string]string{"region": "mumbai"}, Value: "ninety"}},
+	}
+	for name, rules := range invalid {
+		if err := r.SetRules(ctx, "port", rules); err == nil {
+			t.Errorf("SetRules() with %s expected an error", name)
+		}
+	}
+
+	rules := []types.Rule{
+		{Match: map[string]string{"region": "delhi", "hostname": "web-*"}, Value: "7070"},
+		{Match: map[string]string{"region": "mumbai"}, Value: "9090"},
+	}
+	if err := r.SetRules(ctx, "port", rules); err != nil {
+		t.Fatalf("SetRules() error = %v", err)
+	}
+	if got, err := r.GetRules(ctx, "port"); err != nil || len(got) != 2 {
+		t.Errorf("GetRules() = %v, %v, want 2 rules", got, err)
+	}
+
+	tests := []struct {
+		attrs map[string]string
+		want  int
+	}{
+		{nil, 8080},
+		{map[string]string{"region": "mumbai", "hostname": "web-1"}, 9090},
+		{map[string]string{"region": "delhi", "hostname": "web-1"}, 7070},
+		{map[string]string{"region": "delhi", "hostname": "db-1"}, 8080},
+	}
+	for _, tt := range tests {
+		view := r.View("testApp", "testModule", 1, "testConf").WithAttributes(tt.attrs)
+		if port, err := view.GetInt(ctx, "port"); err != nil || port != tt.want {
+			t.Errorf("GetInt() with %v = %d, %v, want %d", tt.attrs, port, err, tt.want)
+		}
+		values, err := view.GetAll(ctx)
+		if err != nil || values["port"] != fmt.Sprint(tt.want) {
+			t.Errorf("GetAll() with %v = %v, %v, want port %d", tt.attrs, values, err, tt.want)
+		}
+	}
+}
+
+func TestWatchConfigRules(t *testing.T) {
+	r, storage := newTestRigel(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	r.WithAttributes(map[string]string{"region": "mumbai"})
+
+	storage.data[getConfKeyPath("testApp", "testModule", 1, "testConf", "port")] = "8080"
+	if err := r.WatchConfig(ctx); err != nil {
+		t.Fatalf("WatchConfig() error = %v", err)
+	}
+	events := make(chan ChangeEvent, 10)
+	sub := r.OnChange("port", func(e ChangeEvent) { events <- e })
+	defer sub.Unsubscribe()
+
+	// Rules the client does not match do not change its value
+	if err := r.SetRules(ctx, "port", []types.Rule{{Match: map[string]string{"region": "delhi"}, Value: "7070"}}); err != nil {
+		t.Fatalf("SetRules() error = %v", err)
+	}
+	if err := r.SetRules(ctx, "port", []types.Rule{{Match: map[string]string{"region": "mumbai"}, Value: "9090"}}); err != nil {
+		t.Fatalf("SetRules() error = %v", err)
+	}
+
+	select {
+	case got := <-events:
+		if want := (ChangeEvent{Key: "port", OldValue: 8080, NewValue: 9090, Revision: 2}); got != want {
+			t.Errorf("change event = %+v, want %+v", got, want)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("timed out waiting for the change of the rules")
+	}
+	select {
+	case e := <-events:
+		t.Errorf("unexpected change event %+v", e)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
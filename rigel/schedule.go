@@ -0,0 +1,536 @@
+package rigel
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/remiges-aniket/layout"
+	"github.com/remiges-aniket/types"
+)
+
+var (
+	// ErrNoConditionalWrites is returned by the functions for scheduled changes and by
+	// RotateSecrets when the storage cannot write atomically, which they need to apply each
+	// change exactly once and to not overwrite values set meanwhile.
+	ErrNoConditionalWrites = errors.New("storage does not support conditional writes")
+	// ErrChangeNotPending is returned when cancelling a scheduled change that was already applied or cancelled.
+	ErrChangeNotPending = errors.New("scheduled change is not pending")
+	// ErrChangeNotFound is returned when there is no scheduled change with a given id.
+	ErrChangeNotFound = errors.New("scheduled change not found")
+	// ErrPastTime is returned by GetAllResolvedAt for a past time whose values the storage does
+	// not keep, either because it keeps no history or because the history of that time is gone.
+	ErrPastTime = errors.New("values of the time are not kept")
+)
+
+// ScheduleChange schedules values, keyed by config key, to be set in the config at effectiveAt.
+// The values are checked against the schema now and the values of secret fields are encrypted.
+// Changes are applied by ApplyDueChanges, which a server runs periodically.
+func (r *Rigel) ScheduleChange(ctx context.Context, effectiveAt time.Time, values map[string]string, reason string) (types.ScheduledChange, error) {
+	if _, ok := r.Storage.(types.ConditionalWriter); !ok {
+		return types.ScheduledChange{}, ErrNoConditionalWrites
+	}
+	if len(values) == 0 {
+		return types.ScheduledChange{}, fmt.Errorf("no values to schedule")
+	}
+	schema, err := r.GetSchema(ctx)
+	if err != nil {
+		return types.ScheduledChange{}, fmt.Errorf("failed to get schema: %w", err)
+	}
+
+	stored := make(map[string]string, len(values))
+	for configKey, value := range values {
+		field := schema.Leaf(configKey)
+		if field == nil {
+			return types.ScheduledChange{}, &KeyNotFoundError{Key: configKey}
+		}
+		if !validateValueAgainstConstraints(value, field) {
+			return types.ScheduledChange{}, fmt.Errorf("value of %s does not meet the constraints of the field", configKey)
+		}
+		if stored[configKey], err = r.encryptValue(field, value); err != nil {
+			return types.ScheduledChange{}, fmt.Errorf("failed to encrypt secret value: %w", err)
+		}
+	}
+	id, err := newChangeID(effectiveAt)
+	if err != nil {
+		return types.ScheduledChange{}, err
+	}
+	change := types.ScheduledChange{
+		ID:          id,
+		App:         r.App,
+		Module:      r.Module,
+		Version:     r.Version,
+		Config:      r.Config,
+		EffectiveAt: effectiveAt.UTC(),
+		Values:      stored,
+		Reason:      reason,
+		Status:      types.SchedulePending,
+		CreatedAt:   time.Now().UTC(),
+	}
+	data, err := json.Marshal(change)
+	if err != nil {
+		return types.ScheduledChange{}, fmt.Errorf("failed to marshal scheduled change: %w", err)
+	}
+	// A change cannot be scheduled for a locked config, unless the client overrides locks
+	if err := r.writeUnlocked(ctx, map[string]string{layout.ScheduledChangePath(id): string(data)}, ""); err != nil {
+		return types.ScheduledChange{}, err
+	}
+	return change, nil
+}
+
+// ScheduledChanges returns the scheduled changes of all configs, whatever their status, in the
+// order of their EffectiveAt. Entries that cannot be parsed are left out; ApplyDueChanges reports
+// them and marks them failed.
+func (r *Rigel) ScheduledChanges(ctx context.Context) ([]types.ScheduledChange, error) {
+	changes, _, _, err := r.readScheduledChanges(ctx)
+	return changes, err
+}
+
+// CancelScheduledChange cancels the pending scheduled change with the given id. It fails with
+// ErrChangeNotPending if the change was applied or cancelled, even by another server at the same time.
+func (r *Rigel) CancelScheduledChange(ctx context.Context, id string) error {
+	w, ok := r.Storage.(types.ConditionalWriter)
+	if !ok {
+		return ErrNoConditionalWrites
+	}
+	key := layout.ScheduledChangePath(id)
+	raw, err := r.Storage.Get(ctx, key)
+	if err != nil {
+		return fmt.Errorf("failed to get scheduled change: %w", err)
+	}
+	if raw == "" {
+		return fmt.Errorf("%w: %s", ErrChangeNotFound, id)
+	}
+	var change types.ScheduledChange
+	if err := json.Unmarshal([]byte(raw), &change); err != nil {
+		return fmt.Errorf("failed to parse scheduled change %s: %w", id, err)
+	}
+	if change.Status != types.SchedulePending {
+		return fmt.Errorf("%w: %s is %s", ErrChangeNotPending, id, change.Status)
+	}
+
+	change.Status = types.ScheduleCancelled
+	data, err := json.Marshal(change)
+	if err != nil {
+		return fmt.Errorf("failed to marshal scheduled change: %w", err)
+	}
+	done, err := w.PutIf(ctx, key, raw, map[string]string{key: string(data)})
+	if err != nil {
+		return fmt.Errorf("failed to cancel scheduled change: %w", err)
+	}
+	if !done {
+		return fmt.Errorf("%w: %s changed while cancelling it", ErrChangeNotPending, id)
+	}
+	return nil
+}
+
+// ApplyDueChanges applies the pending scheduled changes of all configs whose EffectiveAt is not
+// after now, oldest first, and returns how many it applied. Each change is applied in a single
+// write along with its new status and the values it replaced, on condition that it is still
+// pending and that neither those values nor the locks of the config changed since they were
+// read, so a change is applied exactly once even when several servers run ApplyDueChanges at
+// the same time. A change to a locked config stays pending until the config is unlocked.
+//
+// The values of a change are checked against the schema again when it is applied. A change that
+// can no longer be applied, such as one to a field removed from the schema, is marked failed, and
+// so is an entry that cannot be parsed once the time in its id is not after now. The errors of
+// single changes, and those of the entries that cannot be parsed, do not keep the other changes
+// from being applied; they are returned together once all due changes were tried.
+func (r *Rigel) ApplyDueChanges(ctx context.Context, now time.Time) (int, error) {
+	if _, ok := r.Storage.(types.ConditionalWriter); !ok {
+		return 0, ErrNoConditionalWrites
+	}
+	changes, raws, invalid, err := r.readScheduledChanges(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	ids := make([]string, 0, len(invalid))
+	for id := range invalid {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	var errs []error
+	for _, id := range ids {
+		errs = append(errs, invalid[id])
+		// The time an entry was scheduled for is kept in its id, unless the id was made by hand
+		effectiveAt, _ := changeTime(id)
+		if !effectiveAt.After(now) {
+			change := types.ScheduledChange{ID: id, EffectiveAt: effectiveAt}
+			errs = append(errs, r.failChange(ctx, change, raws[id], invalid[id]))
+		}
+	}
+
+	applied := 0
+	for _, change := range changes {
+		if change.Status != types.SchedulePending || change.EffectiveAt.After(now) {
+			continue
+		}
+		done, err := r.applyChange(ctx, change, raws[change.ID], now)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed to apply scheduled change %s: %w", change.ID, err))
+			if errors.Is(err, errInvalidChange) {
+				errs = append(errs, r.failChange(ctx, change, raws[change.ID], err))
+			}
+			continue
+		}
+		if done {
+			applied++
+		}
+	}
+	return applied, errors.Join(errs...)
+}
+
+// errInvalidChange is wrapped by the errors of the scheduled changes that can never be applied.
+var errInvalidChange = errors.New("invalid scheduled change")
+
+// applyChange applies change, stored as raw, as described for ApplyDueChanges. It reports
+// whether it applied the change, which it does not if the config is locked or if another server
+// applied or cancelled the change first.
+func (r *Rigel) applyChange(ctx context.Context, change types.ScheduledChange, raw string, now time.Time) (bool, error) {
+	w := r.Storage.(types.ConditionalWriter)
+	view := r.View(change.App, change.Module, change.Version, change.Config)
+	schema, err := view.GetSchema(ctx)
+	if err != nil {
+		return false, fmt.Errorf("failed to get schema: %w", err)
+	}
+
+	// The schema may have changed since the change was scheduled
+	keys := make([]string, 0, len(change.Values))
+	values := make(map[string]string, len(change.Values))
+	for configKey, value := range change.Values {
+		field := schema.Leaf(configKey)
+		if field == nil {
+			return false, fmt.Errorf("%w: %s is not a field of the schema", errInvalidChange, configKey)
+		}
+		plaintext := value
+		if IsEncrypted(value) {
+			if plaintext, err = r.decryptSecret(value); err != nil {
+				return false, fmt.Errorf("failed to decrypt %s: %w", configKey, err)
+			}
+		}
+		if !validateValueAgainstConstraints(plaintext, field) {
+			return false, fmt.Errorf("%w: value of %s does not meet the constraints of the field", errInvalidChange, configKey)
+		}
+		key := getConfKeyPath(change.App, change.Module, change.Version, change.Config, configKey)
+		keys = append(keys, key)
+		if values[key], err = r.encryptValue(field, plaintext); err != nil {
+			return false, fmt.Errorf("failed to encrypt %s: %w", configKey, err)
+		}
+	}
+
+	changeKey := layout.ScheduledChangePath(change.ID)
+	for {
+		locks, lock, err := view.r.readLocks(ctx)
+		if err != nil {
+			return false, err
+		}
+		if lock != nil {
+			return false, nil
+		}
+		previous, err := r.Storage.GetMany(ctx, keys...)
+		if err != nil {
+			return false, fmt.Errorf("failed to get values replaced: %w", err)
+		}
+
+		txn := types.Txn{If: locks, Put: make(map[string]string, len(values)+1)}
+		txn.If[changeKey] = raw
+		change.Previous = make(map[string]string, len(change.Values))
+		for configKey := range change.Values {
+			key := getConfKeyPath(change.App, change.Module, change.Version, change.Config, configKey)
+			txn.If[key] = previous[key]
+			txn.Put[key] = values[key]
+			change.Previous[configKey] = previous[key]
+		}
+		appliedAt := now.UTC()
+		change.AppliedAt = &appliedAt
+		change.Status = types.ScheduleApplied
+		data, err := json.Marshal(change)
+		if err != nil {
+			return false, fmt.Errorf("failed to marshal scheduled change: %w", err)
+		}
+		txn.Put[changeKey] = string(data)
+		txn.Put = withClock(txn.Put)
+
+		done, err := w.Commit(ctx, txn)
+		if err != nil {
+			return false, err
+		}
+		if done {
+			for _, key := range keys {
+				r.Cache.Set(key, values[key])
+			}
+			return true, nil
+		}
+		// Either another server applied or cancelled the change first, or a value or lock
+		// changed since it was read and the change is tried again
+		current, err := r.Storage.Get(ctx, changeKey)
+		if err != nil {
+			return false, fmt.Errorf("failed to get scheduled change: %w", err)
+		}
+		if current != raw {
+			return false, nil
+		}
+	}
+}
+
+// failChange marks change, stored as raw, failed because of cause, unless another server applied
+// or cancelled it first.
+func (r *Rigel) failChange(ctx context.Context, change types.ScheduledChange, raw string, cause error) error {
+	w := r.Storage.(types.ConditionalWriter)
+	change.Status = types.ScheduleFailed
+	change.Error = cause.Error()
+	data, err := json.Marshal(change)
+	if err != nil {
+		return fmt.Errorf("failed to marshal scheduled change: %w", err)
+	}
+	key := layout.ScheduledChangePath(change.ID)
+	if _, err := w.PutIf(ctx, key, raw, map[string]string{key: string(data)}); err != nil {
+		return fmt.Errorf("failed to mark scheduled change %s failed: %w", change.ID, err)
+	}
+	return nil
+}
+
+// PruneScheduledChanges deletes the applied, cancelled and failed scheduled changes whose
+// EffectiveAt is before before, and returns how many it deleted. Pending changes are kept, and so
+// is a cancelled change until the time it was scheduled for has passed.
+func (r *Rigel) PruneScheduledChanges(ctx context.Context, before time.Time) (int, error) {
+	w, ok := r.Storage.(types.ConditionalWriter)
+	if !ok {
+		return 0, ErrNoConditionalWrites
+	}
+	changes, raws, _, err := r.readScheduledChanges(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	pruned := 0
+	for _, change := range changes {
+		if change.Status == types.SchedulePending || !change.EffectiveAt.Before(before) {
+			continue
+		}
+		key := layout.ScheduledChangePath(change.ID)
+		done, err := w.Commit(ctx, types.Txn{If: map[string]string{key: raws[change.ID]}, Delete: []string{key}})
+		if err != nil {
+			return pruned, fmt.Errorf("failed to delete scheduled change %s: %w", change.ID, err)
+		}
+		if done {
+			pruned++
+		}
+	}
+	return pruned, nil
+}
+
+// GetAllResolvedAt returns the values of all keys of the config, like GetAllResolved, as they were
+// or will be at the time at, resolved with the current schema.
+//
+// For a time that is not after now, the values are read from the history of the storage as they
+// were at the last revision written by then, see revisionAt. This needs a storage that is a
+// types.HistoryReader and a types.RevisionReader, and fails with ErrPastTime if the storage is
+// not, or no longer keeps the revision. For a future time, the values are a preview of those once
+// the pending scheduled changes of the config and its parents that fall due by then are applied;
+// values set directly in the meantime cannot be foreseen.
+func (r *Rigel) GetAllResolvedAt(ctx context.Context, at time.Time) (map[string]ResolvedValue, error) {
+	schema, err := r.GetSchema(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var kvs map[string]string
+	if at.After(time.Now()) {
+		kvs, err = r.valuesScheduledBy(ctx, at)
+	} else {
+		kvs, err = r.valuesAt(ctx, at)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	chain, err := r.chainFrom(kvs)
+	if err != nil {
+		return nil, err
+	}
+	fields := schema.Leaves()
+	resolved := make(map[string]ResolvedValue, len(fields))
+	for _, field := range fields {
+		rv, found := r.resolve(field.Name, chain, kvs)
+		if !found {
+			continue
+		}
+		if rv.Value, err = r.decryptValue(&field, rv.Value); err != nil {
+			return nil, fmt.Errorf("failed to decrypt %s: %w", field.Name, err)
+		}
+		resolved[field.Name] = rv
+	}
+	return resolved, nil
+}
+
+// valuesScheduledBy returns the values of all the configs of the version, keyed by storage key,
+// with the pending scheduled changes that fall due by at applied.
+func (r *Rigel) valuesScheduledBy(ctx context.Context, at time.Time) (map[string]string, error) {
+	kvs, _, err := r.readPrefix(ctx, getConfigsPath(r.App, r.Module, r.Version))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get config values: %w", err)
+	}
+	changes, _, _, err := r.readScheduledChanges(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, change := range changes {
+		if r.changesVersion(change) && change.Status == types.SchedulePending && !change.EffectiveAt.After(at) {
+			for configKey, value := range change.Values {
+				kvs[getConfKeyPath(r.App, r.Module, r.Version, change.Config, configKey)] = value
+			}
+		}
+	}
+	return kvs, nil
+}
+
+// valuesAt returns the values of all the configs of the version, keyed by storage key, as they
+// were at the past time at.
+func (r *Rigel) valuesAt(ctx context.Context, at time.Time) (map[string]string, error) {
+	hr, ok := r.Storage.(types.HistoryReader)
+	if !ok {
+		return nil, fmt.Errorf("%w: the storage keeps no history", ErrPastTime)
+	}
+	rev, err := r.revisionAt(ctx, hr, at)
+	if err != nil {
+		return nil, err
+	}
+	kvs, err := hr.GetWithPrefixAt(ctx, getConfigsPath(r.App, r.Module, r.Version), rev)
+	if errors.Is(err, types.ErrCompacted) {
+		return nil, fmt.Errorf("%w: %w", ErrPastTime, err)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get config values: %w", err)
+	}
+	return kvs, nil
+}
+
+// revisionAt returns the last revision of the storage written at or before the time at. Writes to
+// configs set the clock, see layout.ClockPath, in the same revision, so the revision is found by a
+// binary search of the past values of the clock. A revision written without setting the clock, such
+// as one of an older client, is taken to be as old as the last one that set it, and a revision
+// written before the clock was first set has no known time.
+func (r *Rigel) revisionAt(ctx context.Context, hr types.HistoryReader, at time.Time) (int64, error) {
+	rr, ok := r.Storage.(types.RevisionReader)
+	if !ok {
+		return 0, fmt.Errorf("%w: the storage cannot tell its revision", ErrPastTime)
+	}
+	current, err := rr.Revision(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get storage revision: %w", err)
+	}
+
+	// lo is written at or before at and hi after it, revision 0 being before any write
+	lo, hi := int64(0), current+1
+	var loWritten time.Time
+	for hi-lo > 1 {
+		mid := lo + (hi-lo)/2
+		written, err := clockAt(ctx, hr, mid)
+		switch {
+		case errors.Is(err, types.ErrCompacted):
+			// A revision before mid cannot be read, so there is no use looking for one
+			lo, loWritten = mid, time.Time{}
+		case err != nil:
+			return 0, err
+		case written.After(at):
+			hi = mid
+		default:
+			lo, loWritten = mid, written
+		}
+	}
+	if loWritten.IsZero() {
+		return 0, fmt.Errorf("%w: no revision is known to be written by %s", ErrPastTime, at.Format(time.RFC3339))
+	}
+	return lo, nil
+}
+
+// clockAt returns the time of the last write that set the clock at or before revision rev,
+// or the zero time if there was none.
+func clockAt(ctx context.Context, hr types.HistoryReader, rev int64) (time.Time, error) {
+	kvs, err := hr.GetWithPrefixAt(ctx, layout.ClockPath(), rev)
+	if err != nil {
+		return time.Time{}, err
+	}
+	value := kvs[layout.ClockPath()]
+	if value == "" {
+		return time.Time{}, nil
+	}
+	written, err := time.Parse(time.RFC3339Nano, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to parse clock at revision %d: %w", rev, err)
+	}
+	return written, nil
+}
+
+// withClock returns puts along with the setting of the clock to the current time, so that the
+// history of the storage tells when the write was made, see revisionAt.
+func withClock(puts map[string]string) map[string]string {
+	stamped := make(map[string]string, len(puts)+1)
+	for key, value := range puts {
+		stamped[key] = value
+	}
+	stamped[layout.ClockPath()] = time.Now().UTC().Format(time.RFC3339Nano)
+	return stamped
+}
+
+// readScheduledChanges reads all scheduled changes, sorted by EffectiveAt, along with the value
+// stored for each, keyed by id. Entries that cannot be parsed are left out of changes and their
+// errors are returned in invalid, keyed by id, so that one bad entry does not keep the others
+// from being listed or applied.
+func (r *Rigel) readScheduledChanges(ctx context.Context) (changes []types.ScheduledChange, raws map[string]string, invalid map[string]error, err error) {
+	kvs, err := r.Storage.GetWithPrefix(ctx, layout.SchedulePath())
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("failed to get scheduled changes: %w", err)
+	}
+	changes = make([]types.ScheduledChange, 0, len(kvs))
+	raws = make(map[string]string, len(kvs))
+	invalid = make(map[string]error)
+	for key, value := range kvs {
+		var change types.ScheduledChange
+		if err := json.Unmarshal([]byte(value), &change); err != nil {
+			id := strings.TrimPrefix(key, layout.SchedulePath())
+			invalid[id] = fmt.Errorf("failed to parse scheduled change %s: %w", id, err)
+			raws[id] = value
+			continue
+		}
+		changes = append(changes, change)
+		raws[change.ID] = value
+	}
+	sort.Slice(changes, func(i, j int) bool {
+		if !changes[i].EffectiveAt.Equal(changes[j].EffectiveAt) {
+			return changes[i].EffectiveAt.Before(changes[j].EffectiveAt)
+		}
+		return changes[i].ID < changes[j].ID
+	})
+	return changes, raws, invalid, nil
+}
+
+// changesVersion reports whether change is to a config of the app, module and version of the client.
+func (r *Rigel) changesVersion(change types.ScheduledChange) bool {
+	return change.App == r.App && change.Module == r.Module && change.Version == r.Version
+}
+
+// changeIDTimeFormat is the layout of the effective time that starts the id of a scheduled change.
+const changeIDTimeFormat = "20060102T150405Z"
+
+// newChangeID returns a new id for a change effective at effectiveAt. Ids sort by effective time.
+func newChangeID(effectiveAt time.Time) (string, error) {
+	suffix := make([]byte, 4)
+	if _, err := rand.Read(suffix); err != nil {
+		return "", fmt.Errorf("failed to generate scheduled change id: %w", err)
+	}
+	return effectiveAt.UTC().Format(changeIDTimeFormat) + "-" + hex.EncodeToString(suffix), nil
+}
+
+// changeTime returns the effective time, to the second, in id, made by newChangeID.
+func changeTime(id string) (time.Time, error) {
+	prefix, _, _ := strings.Cut(id, "-")
+	return time.Parse(changeIDTimeFormat, prefix)
+}
@@ -0,0 +1,201 @@
+package rigel
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/remiges-aniket/layout"
+	"github.com/remiges-aniket/types"
+)
+
+func TestScheduledChanges(t *testing.T) {
+	r, storage := newTestRigel(t)
+	ctx := context.Background()
+	portKey := getConfKeyPath("testApp", "testModule", 1, "testConf", "port")
+	before := time.Now()
+	if err := r.Set(ctx, "port", "8080"); err != nil {
+		t.Fatalf("Set() error = %v", err)
+	}
+
+	if _, err := r.ScheduleChange(ctx, time.Now().Add(time.Hour), map[string]string{"port": "ninety"}, ""); err == nil {
+		t.Errorf("ScheduleChange() expected an error for an invalid value")
+	}
+
+	now := time.Now()
+	effectiveAt := now.Add(time.Hour)
+	change, err := r.ScheduleChange(ctx, effectiveAt, map[string]string{"port": "9090", "host": "new.internal"}, "new port")
+	if err != nil {
+		t.Fatalf("ScheduleChange() error = %v", err)
+	}
+	if changes, err := r.ScheduledChanges(ctx); err != nil || len(changes) != 1 || changes[0].Status != types.SchedulePending {
+		t.Errorf("ScheduledChanges() = %+v, %v, want the pending change", changes, err)
+	}
+
+	// The change shows in values asked for after it takes effect, and not before
+	if values, err := r.GetAllResolvedAt(ctx, now.Add(2*time.Hour)); err != nil || values["port"].Value != "9090" || values["host"].Value != "new.internal" {
+		t.Errorf("GetAllResolvedAt(future) = %v, %v, want the scheduled values", values, err)
+	}
+	if values, err := r.GetAllResolvedAt(ctx, now.Add(30*time.Minute)); err != nil || values["port"].Value != "8080" {
+		t.Errorf("GetAllResolvedAt(before the change) = %v, %v, want port 8080", values, err)
+	}
+
+	if applied, err := r.ApplyDueChanges(ctx, now); err != nil || applied != 0 {
+		t.Errorf("ApplyDueChanges() before the change = %d, %v, want 0", applied, err)
+	}
+
+	// Several servers applying due changes at the same time apply the change once
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	total := 0
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			server := New(storage, "", "", 0, "")
+			applied, err := server.ApplyDueChanges(ctx, effectiveAt)
+			if err != nil {
+				t.Errorf("ApplyDueChanges() error = %v", err)
+			}
+			mu.Lock()
+			total += applied
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+	if total != 1 {
+		t.Errorf("ApplyDueChanges() applied the change %d times, want once", total)
+	}
+	if got := storage.data[portKey]; got != "9090" {
+		t.Errorf("port = %q, want 9090", got)
+	}
+
+	changes, err := r.ScheduledChanges(ctx)
+	if err != nil || len(changes) != 1 || changes[0].Status != types.ScheduleApplied {
+		t.Fatalf("ScheduledChanges() = %+v, %v, want the applied change", changes, err)
+	}
+	if want := map[string]string{"port": "8080", "host": ""}; !reflect.DeepEqual(changes[0].Previous, want) {
+		t.Errorf("Previous = %v, want %v", changes[0].Previous, want)
+	}
+
+	// Past values are read from the history of the storage
+	if values, err := r.GetAllResolvedAt(ctx, now); err != nil || values["port"].Value != "8080" {
+		t.Errorf("GetAllResolvedAt(before the apply) = %v, %v, want port 8080", values, err)
+	}
+	if values, err := r.GetAllResolvedAt(ctx, time.Now()); err != nil || values["port"].Value != "9090" {
+		t.Errorf("GetAllResolvedAt(after the apply) = %v, %v, want port 9090", values, err)
+	}
+	if _, err := r.GetAllResolvedAt(ctx, before); !errors.Is(err, ErrPastTime) {
+		t.Errorf("GetAllResolvedAt(before any write) error = %v, want ErrPastTime", err)
+	}
+
+	if err := r.CancelScheduledChange(ctx, change.ID); !errors.Is(err, ErrChangeNotPending) {
+		t.Errorf("CancelScheduledChange() of an applied change = %v, want ErrChangeNotPending", err)
+	}
+}
+
+func TestCancelAndLockScheduledChanges(t *testing.T) {
+	r, storage := newTestRigel(t)
+	ctx := context.Background()
+	portKey := getConfKeyPath("testApp", "testModule", 1, "testConf", "port")
+	storage.data[portKey] = "8080"
+
+	now := time.Now()
+	cancelled, err := r.ScheduleChange(ctx, now, map[string]string{"port": "9090"}, "")
+	if err != nil {
+		t.Fatalf("ScheduleChange() error = %v", err)
+	}
+	if err := r.CancelScheduledChange(ctx, cancelled.ID); err != nil {
+		t.Fatalf("CancelScheduledChange() error = %v", err)
+	}
+	if err := r.CancelScheduledChange(ctx, cancelled.ID); !errors.Is(err, ErrChangeNotPending) {
+		t.Errorf("CancelScheduledChange() twice = %v, want ErrChangeNotPending", err)
+	}
+	if err := r.CancelScheduledChange(ctx, "missing"); !errors.Is(err, ErrChangeNotFound) {
+		t.Errorf("CancelScheduledChange() of a missing change = %v, want ErrChangeNotFound", err)
+	}
+
+	if _, err := r.ScheduleChange(ctx, now, map[string]string{"port": "7070"}, ""); err != nil {
+		t.Fatalf("ScheduleChange() error = %v", err)
+	}
+	if err := r.SetLock(ctx, types.Lock{Scope: types.LockScopeConfig, Reason: "month-end"}); err != nil {
+		t.Fatalf("SetLock() error = %v", err)
+	}
+	if applied, err := r.ApplyDueChanges(ctx, now); err != nil || applied != 0 {
+		t.Errorf("ApplyDueChanges() of a locked config = %d, %v, want 0", applied, err)
+	}
+	if err := r.RemoveLock(ctx, types.LockScopeConfig); err != nil {
+		t.Fatalf("RemoveLock() error = %v", err)
+	}
+	if applied, err := r.ApplyDueChanges(ctx, now); err != nil || applied != 1 {
+		t.Errorf("ApplyDueChanges() after unlocking = %d, %v, want 1", applied, err)
+	}
+	if port, err := r.GetInt(ctx, "port"); err != nil || port != 7070 {
+		t.Errorf("GetInt() = %d, %v, want 7070 and not the cancelled 9090", port, err)
+	}
+}
+
+func TestApplyDueChangesContinuesPastBadChanges(t *testing.T) {
+	r, storage := newTestRigel(t)
+	ctx := context.Background()
+	portKey := getConfKeyPath("testApp", "testModule", 1, "testConf", "port")
+
+	now := time.Now()
+	bad, err := r.ScheduleChange(ctx, now.Add(-time.Minute), map[string]string{"port": "9090"}, "")
+	if err != nil {
+		t.Fatalf("ScheduleChange() error = %v", err)
+	}
+	if _, err := r.ScheduleChange(ctx, now, map[string]string{"port": "7070"}, ""); err != nil {
+		t.Fatalf("ScheduleChange() error = %v", err)
+	}
+	pastID, _ := newChangeID(now.Add(-time.Minute))
+	storage.data[layout.ScheduledChangePath(pastID)] = "{"
+	futureID, _ := newChangeID(now.Add(time.Hour))
+	storage.data[layout.ScheduledChangePath(futureID)] = "{"
+
+	// The first change no longer meets the constraints of the field, which changed after it was scheduled
+	max := 8000
+	schema := types.Schema{Version: 1, Fields: []types.Field{{Name: "port", Type: "int", Constraints: &types.Constraints{Max: &max}}}}
+	if err := r.AddSchema(ctx, schema); err != nil {
+		t.Fatalf("AddSchema() error = %v", err)
+	}
+	applied, err := r.ApplyDueChanges(ctx, now)
+	if applied != 1 || err == nil || !strings.Contains(err.Error(), bad.ID) || !strings.Contains(err.Error(), pastID) {
+		t.Errorf("ApplyDueChanges() = %d, %v, want 1 and the errors of the bad entries", applied, err)
+	}
+	if got := storage.data[portKey]; got != "7070" {
+		t.Errorf("port = %q, want 7070", got)
+	}
+	// The unparsable entry that is due is marked failed, and the one that is not yet is left as it is
+	changes, err := r.ScheduledChanges(ctx)
+	if err != nil || len(changes) != 3 {
+		t.Fatalf("ScheduledChanges() = %+v, %v, want the 2 parsable changes and the failed entry", changes, err)
+	}
+	for _, change := range changes {
+		if (change.ID == bad.ID || change.ID == pastID) && (change.Status != types.ScheduleFailed || change.Error == "") {
+			t.Errorf("bad change = %+v, want it failed with its error", change)
+		}
+	}
+	if storage.data[layout.ScheduledChangePath(futureID)] != "{" {
+		t.Errorf("unparsable entry not yet due was changed")
+	}
+
+	// Finished changes are pruned once they are old enough, and the pending ones are kept
+	pending, err := r.ScheduleChange(ctx, now.Add(-time.Minute), map[string]string{"port": "6060"}, "")
+	if err != nil {
+		t.Fatalf("ScheduleChange() error = %v", err)
+	}
+	if pruned, err := r.PruneScheduledChanges(ctx, now.Add(-time.Hour)); err != nil || pruned != 0 {
+		t.Errorf("PruneScheduledChanges() of recent changes = %d, %v, want 0", pruned, err)
+	}
+	if pruned, err := r.PruneScheduledChanges(ctx, now.Add(time.Hour)); err != nil || pruned != 3 {
+		t.Errorf("PruneScheduledChanges() = %d, %v, want 3", pruned, err)
+	}
+	if changes, err := r.ScheduledChanges(ctx); err != nil || len(changes) != 1 || changes[0].ID != pending.ID {
+		t.Errorf("ScheduledChanges() after pruning = %+v, %v, want the pending change", changes, err)
+	}
+}
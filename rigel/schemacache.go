@@ -0,0 +1,175 @@
+package rigel
+
+import (
+	"context"
+	"strings"
+	"sync"
+
+	"github.com/remiges-aniket/types"
+)
+
+// schemaCache holds parsed schemas keyed by schema path (see getSchemaPath).
+// A schema is only cached while a watch covering its path is running, so that
+// a change to the schema in the storage always invalidates the cached copy.
+type schemaCache struct {
+	mu      sync.RWMutex
+	schemas map[string]*types.Schema
+	watched map[string]int // watched counts the running watches per watched key prefix
+	gen     uint64         // gen is incremented on every invalidation
+}
+
+func newSchemaCache() *schemaCache {
+	return &schemaCache{
+		schemas: make(map[string]*types.Schema),
+		watched: make(map[string]int),
+	}
+}
+
+// get returns a copy of the schema cached for path.
+func (sc *schemaCache) get(path string) (*types.Schema, bool) {
+	sc.mu.RLock()
+	defer sc.mu.RUnlock()
+	schema, found := sc.schemas[path]
+	if !found {
+		return nil, false
+	}
+	return copySchema(schema), true
+}
+
+// generation returns a value to pass to set for a schema about to be read from the storage.
+func (sc *schemaCache) generation() uint64 {
+	sc.mu.RLock()
+	defer sc.mu.RUnlock()
+	return sc.gen
+}
+
+// set caches a copy of schema for path if a watch covers path. gen is the generation
+// seen before the schema was read; if an invalidation happened since, the schema may
+// be stale and is not cached.
+func (sc *schemaCache) set(path string, schema *types.Schema, gen uint64) {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	if gen != sc.gen {
+		return
+	}
+	for prefix := range sc.watched {
+		if strings.HasPrefix(path, prefix) {
+			sc.schemas[path] = copySchema(schema)
+			return
+		}
+	}
+}
+
+// invalidate removes the schema cached for path.
+func (sc *schemaCache) invalidate(path string) {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	sc.gen++
+	delete(sc.schemas, path)
+}
+
+// clear removes all cached schemas.
+func (sc *schemaCache) clear() {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	sc.gen++
+	sc.schemas = make(map[string]*types.Schema)
+}
+
+// watchStarted records that schemas under prefix are watched.
+func (sc *schemaCache) watchStarted(prefix string) {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	sc.watched[prefix]++
+}
+
+// watchStopped records that a watch of prefix ended. Schemas no longer covered by
+// any watch are dropped, because changes to them would go unnoticed.
+func (sc *schemaCache) watchStopped(prefix string) {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	sc.watched[prefix]--
+	if sc.watched[prefix] > 0 {
+		return
+	}
+	delete(sc.watched, prefix)
+
+	for path := range sc.schemas {
+		covered := false
+		for p := range sc.watched {
+			if strings.HasPrefix(path, p) {
+				covered = true
+				break
+			}
+		}
+		if !covered {
+			delete(sc.schemas, path)
+		}
+	}
+}
+
+// copySchema returns a deep copy of schema, so that changes to the copy, down to the fields
+// of its groups and their constraints, do not affect the cached schema.
+func copySchema(schema *types.Schema) *types.Schema {
+	c := *schema
+	c.Fields = copyFields(schema.Fields)
+	return &c
+}
+
+// copyFields returns a deep copy of fields.
+func copyFields(fields []types.Field) []types.Field {
+	if fields == nil {
+		return nil
+	}
+	copied := make([]types.Field, len(fields))
+	for i, f := range fields {
+		f.Fields = copyFields(f.Fields)
+		if f.Constraints != nil {
+			constraints := *f.Constraints
+			if constraints.Min != nil {
+				v := *constraints.Min
+				constraints.Min = &v
+			}
+			if constraints.Max != nil {
+				v := *constraints.Max
+				constraints.Max = &v
+			}
+			constraints.Enum = append([]string(nil), constraints.Enum...)
+			f.Constraints = &constraints
+		}
+		copied[i] = f
+	}
+	return copied
+}
+
+// WatchSchemas starts watching all schemas in the storage, so that every schema the
+// client reads is cached until it changes. This is meant for clients, such as the
+// server, that work with many schemas; WatchConfig already covers the schema of its config.
+// The watch ends when ctx is done.
+func (r *Rigel) WatchSchemas(ctx context.Context) error {
+	prefix := rigelPrefix + "/"
+
+	events := make(chan types.Event)
+	if err := r.Storage.Watch(ctx, prefix, events); err != nil {
+		return err
+	}
+
+	r.state().schemas.watchStarted(prefix)
+	go func() {
+		defer r.state().schemas.watchStopped(prefix)
+		for event := range events {
+			if event.Err != nil {
+				// Changes may have been missed while the watch was broken
+				r.state().schemas.clear()
+				continue
+			}
+			for _, name := range []string{schemaFieldsKey, schemaDescriptionKey} {
+				if strings.HasSuffix(event.Key, "/"+name) {
+					r.state().schemas.invalidate(strings.TrimSuffix(event.Key, name))
+				}
+			}
+		}
+	}()
+
+	return nil
+}
@@ -0,0 +1,188 @@
+package rigel
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/remiges-aniket/layout"
+	"github.com/remiges-aniket/types"
+)
+
+// SearchQuery selects the values returned by Search. A value is returned if it passes every
+// criterion that is set. App, Module, Version and Config restrict the search to some configs.
+type SearchQuery struct {
+	App     string
+	Module  string
+	Version int
+	Config  string
+
+	Key         string         // Key matches config keys as for path.Match, such as "db.*"
+	KeyRegexp   *regexp.Regexp // KeyRegexp matches config keys
+	Value       string         // Value matches values that contain it
+	ValueRegexp *regexp.Regexp // ValueRegexp matches values
+	Compare     *Comparison    // Compare matches the values of the int and float fields of the schema
+}
+
+// Comparison compares numeric values with Operand. Op is one of "=", "!=", "<", "<=", ">" and ">=".
+type Comparison struct {
+	Op      string
+	Operand float64
+}
+
+// ParseComparison parses a comparison written as an operator followed by a number, such as ">500".
+func ParseComparison(s string) (Comparison, error) {
+	// Two-character operators first, so that "<=" is not read as "<"
+	for _, op := range []string{"!=", "<=", ">=", "=", "<", ">"} {
+		operand, found := strings.CutPrefix(s, op)
+		if !found {
+			continue
+		}
+		n, err := strconv.ParseFloat(strings.TrimSpace(operand), 64)
+		if err != nil {
+			return Comparison{}, fmt.Errorf("invalid operand in comparison %q", s)
+		}
+		return Comparison{Op: op, Operand: n}, nil
+	}
+	return Comparison{}, fmt.Errorf("comparison %q must start with one of =, !=, <, <=, > and >=", s)
+}
+
+// Matches reports whether n compares to the operand as given by the operator.
+func (c Comparison) Matches(n float64) bool {
+	switch c.Op {
+	case "=":
+		return n == c.Operand
+	case "!=":
+		return n != c.Operand
+	case "<":
+		return n < c.Operand
+	case "<=":
+		return n <= c.Operand
+	case ">":
+		return n > c.Operand
+	case ">=":
+		return n >= c.Operand
+	}
+	return false
+}
+
+// SearchResult is a value found by Search, with the coordinates of its config.
+type SearchResult struct {
+	App     string `json:"app"`
+	Module  string `json:"module"`
+	Version int    `json:"ver"`
+	Config  string `json:"config"`
+	Key     string `json:"key"`
+	Value   string `json:"value"`
+	Type    string `json:"type,omitempty"` // Type is the type of the field in the schema, empty if the key is not in it
+}
+
+// Search returns the values stored in the named configs of all apps that pass the criteria of
+// q, sorted by app, module, version, config and key. Only the values set in each config are
+// searched, not those it inherits from its parents. The values of secret fields never match,
+// so they are never returned. Comparisons only match the values of fields whose type in the
+// schema is int or float.
+func Search(ctx context.Context, storage types.Storage, q SearchQuery) ([]SearchResult, error) {
+	prefix := layout.Prefix + "/"
+	if q.App != "" {
+		prefix += q.App + "/"
+	}
+	kvs, err := storage.GetWithPrefix(ctx, prefix)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get configs: %w", err)
+	}
+
+	schemas := make(map[string]*types.Schema)
+	for key, value := range kvs {
+		k, ok := layout.Parse(key)
+		if !ok || k.Config != "" || k.Path != layout.FieldsKey {
+			continue
+		}
+		var fields []types.Field
+		if err := json.Unmarshal([]byte(value), &fields); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal fields of %s: %w", key, err)
+		}
+		schemas[layout.SchemaPath(k.App, k.Module, k.Version)] = &types.Schema{Version: k.Version, Fields: fields}
+	}
+
+	results := []SearchResult{}
+	for key, value := range kvs {
+		k, ok := layout.Parse(key)
+		if !ok || k.Config == "" || layout.IsReserved(k.Path) {
+			continue
+		}
+		if !q.matchesConfig(k) {
+			continue
+		}
+		result := SearchResult{App: k.App, Module: k.Module, Version: k.Version, Config: k.Config, Key: layout.KeyFromPath(k.Path), Value: value}
+		if schema := schemas[layout.SchemaPath(k.App, k.Module, k.Version)]; schema != nil {
+			if field := schema.Leaf(result.Key); field != nil {
+				result.Type = field.Type
+			}
+		}
+		// Secret values are checked by type and by value, in case the schema has changed since
+		if result.Type == types.FieldTypeSecret || IsEncrypted(value) {
+			continue
+		}
+		if q.matches(result) {
+			results = append(results, result)
+		}
+	}
+
+	sort.Slice(results, func(i, j int) bool {
+		a, b := results[i], results[j]
+		if a.App != b.App {
+			return a.App < b.App
+		}
+		if a.Module != b.Module {
+			return a.Module < b.Module
+		}
+		if a.Version != b.Version {
+			return a.Version < b.Version
+		}
+		if a.Config != b.Config {
+			return a.Config < b.Config
+		}
+		return a.Key < b.Key
+	})
+	return results, nil
+}
+
+// matchesConfig reports whether the value at k is in a config the search is restricted to.
+func (q SearchQuery) matchesConfig(k layout.Key) bool {
+	return (q.App == "" || k.App == q.App) && (q.Module == "" || k.Module == q.Module) &&
+		(q.Version == 0 || k.Version == q.Version) && (q.Config == "" || k.Config == q.Config)
+}
+
+// matches reports whether the key and value of result pass the criteria of q.
+func (q SearchQuery) matches(result SearchResult) bool {
+	if q.Key != "" {
+		if matched, err := path.Match(q.Key, result.Key); err != nil || !matched {
+			return false
+		}
+	}
+	if q.KeyRegexp != nil && !q.KeyRegexp.MatchString(result.Key) {
+		return false
+	}
+	if q.Value != "" && !strings.Contains(result.Value, q.Value) {
+		return false
+	}
+	if q.ValueRegexp != nil && !q.ValueRegexp.MatchString(result.Value) {
+		return false
+	}
+	if q.Compare != nil {
+		if result.Type != "int" && result.Type != "float" {
+			return false
+		}
+		n, err := strconv.ParseFloat(result.Value, 64)
+		if err != nil || !q.Compare.Matches(n) {
+			return false
+		}
+	}
+	return true
+}
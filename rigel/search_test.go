@@ -0,0 +1,75 @@
+package rigel
+
+import (
+	"context"
+	"reflect"
+	"regexp"
+	"testing"
+)
+
+func TestSearch(t *testing.T) {
+	r, storage := newTestRigel(t)
+	ctx := context.Background()
+
+	set := func(config, key, value string) {
+		storage.data[getConfKeyPath("testApp", "testModule", 1, config, key)] = value
+	}
+	set("prod", "host", "db-old.internal")
+	set("prod", "port", "600")
+	set("prod", ".parent", "base")
+	set("dev", "host", "localhost")
+	set("dev", "port", "80")
+	set("base", "host", encryptedPrefix+"k1:c2VjcmV0") // an encrypted value never matches
+
+	compare, err := ParseComparison(">500")
+	if err != nil {
+		t.Fatalf("ParseComparison() error = %v", err)
+	}
+	tests := []struct {
+		name  string
+		query SearchQuery
+		want  []string // config/key of the results
+	}{
+		{"value substring", SearchQuery{Value: "old"}, []string{"prod/host"}},
+		{"value regexp", SearchQuery{ValueRegexp: regexp.MustCompile(`^\d+$`)}, []string{"dev/port", "prod/port"}},
+		{"key glob", SearchQuery{Key: "h*"}, []string{"dev/host", "prod/host"}},
+		{"key regexp", SearchQuery{KeyRegexp: regexp.MustCompile("^port$"), Config: "dev"}, []string{"dev/port"}},
+		{"comparison", SearchQuery{Compare: &compare}, []string{"prod/port"}},
+		{"comparison of strings", SearchQuery{Key: "host", Compare: &Comparison{Op: "!=", Operand: 0}}, nil},
+		{"encrypted", SearchQuery{Value: "enc:"}, nil},
+		{"other app", SearchQuery{App: "otherApp", Value: "o"}, nil},
+	}
+	for _, tt := range tests {
+		results, err := Search(ctx, r.Storage, tt.query)
+		if err != nil {
+			t.Fatalf("%s: Search() error = %v", tt.name, err)
+		}
+		var got []string
+		for _, res := range results {
+			got = append(got, res.Config+"/"+res.Key)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: Search() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestParseComparison(t *testing.T) {
+	tests := []struct {
+		in   string
+		want Comparison
+		ok   bool
+	}{
+		{">500", Comparison{Op: ">", Operand: 500}, true},
+		{"<=1.5", Comparison{Op: "<=", Operand: 1.5}, true},
+		{"!= 0", Comparison{Op: "!=", Operand: 0}, true},
+		{"500", Comparison{}, false},
+		{">big", Comparison{}, false},
+	}
+	for _, tt := range tests {
+		got, err := ParseComparison(tt.in)
+		if got != tt.want || (err == nil) != tt.ok {
+			t.Errorf("ParseComparison(%q) = %+v, %v, want %+v", tt.in, got, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,409 @@
+package rigel
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/remiges-aniket/layout"
+	"github.com/remiges-aniket/types"
+)
+
+const (
+	// SecretMask replaces the values of secret fields in server responses and logs.
+	SecretMask = "********"
+
+	encryptedPrefix = "enc:v1:" // encryptedPrefix starts every encrypted value, followed by the key id
+	keySize         = 32        // keySize is the size of keyring keys, for AES-256
+)
+
+// ErrNoKeyring is returned when a secret value is set or read by a client without a keyring.
+var ErrNoKeyring = errors.New("no keyring to encrypt or decrypt secret values")
+
+// Keyring holds the AES-GCM keys that encrypt the values of secret fields. Values are always
+// encrypted with the current key and can be decrypted with any key of the keyring, so that
+// keys can be rotated: add a new current key, run RotateSecrets, then remove the old key.
+type Keyring struct {
+	current string
+	aeads   map[string]cipher.AEAD
+}
+
+// keyringFile is the JSON layout of a keyring file: the id of the current key and the
+// base64-encoded 32-byte keys by id.
+type keyringFile struct {
+	Current string            `json:"current"`
+	Keys    map[string]string `json:"keys"`
+}
+
+// NewKeyring creates a keyring from 32-byte keys by id, encrypting with the key named current.
+func NewKeyring(current string, keys map[string][]byte) (*Keyring, error) {
+	if _, found := keys[current]; !found {
+		return nil, fmt.Errorf("current key %q is not in the keyring", current)
+	}
+	k := &Keyring{current: current, aeads: make(map[string]cipher.AEAD, len(keys))}
+	for id, key := range keys {
+		if id == "" || strings.Contains(id, ":") {
+			return nil, fmt.Errorf("invalid key id %q", id)
+		}
+		if len(key) != keySize {
+			return nil, fmt.Errorf("key %s is %d bytes, want %d", id, len(key), keySize)
+		}
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create cipher for key %s: %w", id, err)
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create cipher for key %s: %w", id, err)
+		}
+		k.aeads[id] = aead
+	}
+	return k, nil
+}
+
+// LoadKeyring reads a keyring file, a JSON object such as
+//
+//	{"current": "k2", "keys": {"k1": "<base64 key>", "k2": "<base64 key>"}}
+//
+// Use AddKeyringKey to create the file or add a key to it.
+func LoadKeyring(path string) (*Keyring, error) {
+	file, err := readKeyringFile(path)
+	if err != nil {
+		return nil, err
+	}
+	keys := make(map[string][]byte, len(file.Keys))
+	for id, encoded := range file.Keys {
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode key %s: %w", id, err)
+		}
+		keys[id] = key
+	}
+	return NewKeyring(file.Current, keys)
+}
+
+// AddKeyringKey adds a new random key named id to the keyring file at path and makes it the
+// current key, creating the file if it does not exist. The file is only readable by its owner.
+func AddKeyringKey(path string, id string) error {
+	file, err := readKeyringFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		file, err = &keyringFile{Keys: make(map[string]string)}, nil
+	}
+	if err != nil {
+		return err
+	}
+	if _, found := file.Keys[id]; found {
+		return fmt.Errorf("key %s is already in the keyring", id)
+	}
+
+	key := make([]byte, keySize)
+	if _, err := rand.Read(key); err != nil {
+		return fmt.Errorf("failed to generate key: %w", err)
+	}
+	file.Keys[id] = base64.StdEncoding.EncodeToString(key)
+	file.Current = id
+
+	// Check the keyring before replacing the file with it
+	if _, err := NewKeyring(file.Current, map[string][]byte{id: key}); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(file, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal keyring: %w", err)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return fmt.Errorf("failed to create keyring file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write keyring file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write keyring file: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("failed to replace keyring file: %w", err)
+	}
+	return nil
+}
+
+// readKeyringFile reads the keyring file at path.
+func readKeyringFile(path string) (*keyringFile, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read keyring file: %w", err)
+	}
+	var file keyringFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("failed to parse keyring file: %w", err)
+	}
+	if file.Keys == nil {
+		file.Keys = make(map[string]string)
+	}
+	return &file, nil
+}
+
+// Encrypt encrypts plaintext with the current key. The result names the key it was encrypted with.
+func (k *Keyring) Encrypt(plaintext string) (string, error) {
+	aead := k.aeads[k.current]
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	sealed := aead.Seal(nonce, nonce, []byte(plaintext), nil)
+	return encryptedPrefix + k.current + ":" + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// Decrypt decrypts a value encrypted by Encrypt with any key of the keyring.
+func (k *Keyring) Decrypt(value string) (string, error) {
+	id, sealed, err := splitEncrypted(value)
+	if err != nil {
+		return "", err
+	}
+	aead, found := k.aeads[id]
+	if !found {
+		return "", fmt.Errorf("key %s is not in the keyring", id)
+	}
+	if len(sealed) < aead.NonceSize() {
+		return "", errors.New("encrypted value is too short")
+	}
+	plaintext, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to decrypt value: %w", err)
+	}
+	return string(plaintext), nil
+}
+
+// splitEncrypted returns the key id and the nonce and ciphertext of an encrypted value.
+func splitEncrypted(value string) (string, []byte, error) {
+	rest, found := strings.CutPrefix(value, encryptedPrefix)
+	if !found {
+		return "", nil, errors.New("value is not encrypted")
+	}
+	id, encoded, found := strings.Cut(rest, ":")
+	if !found {
+		return "", nil, errors.New("encrypted value has no key id")
+	}
+	sealed, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to decode encrypted value: %w", err)
+	}
+	return id, sealed, nil
+}
+
+// IsEncrypted reports whether value was encrypted by a Keyring.
+func IsEncrypted(value string) bool {
+	return strings.HasPrefix(value, encryptedPrefix)
+}
+
+// MaskSecret returns SecretMask if key, a storage key, holds a value of a secret field of its
+// schema, and value itself otherwise. Whether the value is encrypted does not matter: values
+// set before their field became a secret are kept as they are. If the schema cannot be read,
+// the value is masked, as it may be a secret.
+func (r *Rigel) MaskSecret(ctx context.Context, key string, value string) string {
+	k, ok := layout.Parse(key)
+	if !ok || k.Config == "" || layout.IsReserved(k.Path) {
+		// Schemas, descriptions, parents, rules, flags and locks are not values
+		return value
+	}
+	schema, err := r.View(k.App, k.Module, k.Version, "").GetSchema(ctx)
+	if err != nil {
+		return SecretMask
+	}
+	return MaskValue(schema, layout.KeyFromPath(k.Path), value)
+}
+
+// MaskValue returns SecretMask if the config key name is a secret field of schema, and value
+// itself otherwise.
+func MaskValue(schema *types.Schema, name string, value string) string {
+	if field := schema.Leaf(name); field != nil && field.IsSecret() {
+		return SecretMask
+	}
+	return value
+}
+
+// RotateSecrets re-encrypts with the current key of keyring every value below prefix that was
+// encrypted with another key, including the values held by scheduled changes, and returns the
+// number of values re-encrypted. Each key is replaced on condition that it did not change since
+// it was read; a key set meanwhile is read again and re-encrypted if it still needs to be. Once
+// it succeeds, the old keys can be removed from the keyrings of all clients.
+func RotateSecrets(ctx context.Context, storage types.Storage, keyring *Keyring, prefix string) (int, error) {
+	w, ok := storage.(types.ConditionalWriter)
+	if !ok {
+		return 0, ErrNoConditionalWrites
+	}
+	kvs, err := storage.GetWithPrefix(ctx, prefix)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get values: %w", err)
+	}
+
+	rotated := 0
+	for key, value := range kvs {
+		for {
+			encrypted, n, err := rotateValue(keyring, key, value)
+			if err != nil {
+				return rotated, err
+			}
+			if n == 0 {
+				break
+			}
+			done, err := w.PutIf(ctx, key, value, map[string]string{key: encrypted})
+			if err != nil {
+				return rotated, fmt.Errorf("failed to store %s: %w", key, err)
+			}
+			if done {
+				rotated += n
+				break
+			}
+			// The value was set since it was read
+			if value, err = storage.Get(ctx, key); err != nil {
+				return rotated, fmt.Errorf("failed to get %s: %w", key, err)
+			}
+		}
+	}
+	return rotated, nil
+}
+
+// rotateValue returns value, the value of key, with the values in it that were encrypted with
+// a key other than the current key of keyring re-encrypted, along with how many were. The value
+// of a scheduled change holds the values it sets and the values it replaced.
+func rotateValue(keyring *Keyring, key string, value string) (string, int, error) {
+	if !strings.HasPrefix(key, layout.SchedulePath()) {
+		if !needsRotation(keyring, value) {
+			return value, 0, nil
+		}
+		encrypted, err := reencrypt(keyring, value)
+		if err != nil {
+			return "", 0, fmt.Errorf("failed to re-encrypt %s: %w", key, err)
+		}
+		return encrypted, 1, nil
+	}
+
+	var change types.ScheduledChange
+	if err := json.Unmarshal([]byte(value), &change); err != nil {
+		// ApplyDueChanges reports the entries that cannot be parsed
+		return value, 0, nil
+	}
+	n := 0
+	for _, values := range []map[string]string{change.Values, change.Previous} {
+		for configKey, v := range values {
+			if !needsRotation(keyring, v) {
+				continue
+			}
+			encrypted, err := reencrypt(keyring, v)
+			if err != nil {
+				return "", 0, fmt.Errorf("failed to re-encrypt %s of scheduled change %s: %w", configKey, change.ID, err)
+			}
+			values[configKey] = encrypted
+			n++
+		}
+	}
+	if n == 0 {
+		return value, 0, nil
+	}
+	data, err := json.Marshal(change)
+	if err != nil {
+		return "", 0, fmt.Errorf("failed to marshal scheduled change: %w", err)
+	}
+	return string(data), n, nil
+}
+
+// reencrypt decrypts value and encrypts it again with the current key of keyring.
+func reencrypt(keyring *Keyring, value string) (string, error) {
+	plaintext, err := keyring.Decrypt(value)
+	if err != nil {
+		return "", fmt.Errorf("failed to decrypt: %w", err)
+	}
+	return keyring.Encrypt(plaintext)
+}
+
+// needsRotation reports whether value is encrypted with a key other than the current key of keyring.
+func needsRotation(keyring *Keyring, value string) bool {
+	if !IsEncrypted(value) {
+		return false
+	}
+	id, _, err := splitEncrypted(value)
+	return err != nil || id != keyring.current
+}
+
+// WithKeyring sets the keyring used to encrypt and decrypt the values of secret fields and
+// returns the modified Rigel object. Without a keyring, secret values can be neither set nor read.
+// This method is typically used for method chaining during Rigel object creation.
+func (r *Rigel) WithKeyring(keyring *Keyring) *Rigel {
+	r.keyring = keyring
+	return r
+}
+
+// encryptValue encrypts value if field is a secret.
+func (r *Rigel) encryptValue(field *types.Field, value string) (string, error) {
+	if !field.IsSecret() {
+		return value, nil
+	}
+	if r.keyring == nil {
+		return "", ErrNoKeyring
+	}
+	return r.keyring.Encrypt(value)
+}
+
+// decryptValue decrypts value if field is a secret.
+func (r *Rigel) decryptValue(field *types.Field, value string) (string, error) {
+	if !field.IsSecret() {
+		return value, nil
+	}
+	return r.decryptSecret(value)
+}
+
+// decryptSecret decrypts the value of a secret field. Values stored before the field
+// became a secret are not encrypted and are returned as they are.
+func (r *Rigel) decryptSecret(value string) (string, error) {
+	if !IsEncrypted(value) {
+		return value, nil
+	}
+	if r.keyring == nil {
+		return "", ErrNoKeyring
+	}
+	return r.keyring.Decrypt(value)
+}
+
+// decryptValues returns values, keyed by field name, with the values of the secret fields of schema decrypted.
+func (r *Rigel) decryptValues(schema *types.Schema, values map[string]string) (map[string]string, error) {
+	decrypted := make(map[string]string, len(values))
+	for name, value := range values {
+		decrypted[name] = value
+	}
+	for _, field := range schema.Leaves() {
+		value, found := values[field.Name]
+		if !found || !field.IsSecret() {
+			continue
+		}
+		plaintext, err := r.decryptSecret(value)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decrypt %s: %w", field.Name, err)
+		}
+		decrypted[field.Name] = plaintext
+	}
+	return decrypted, nil
+}
+
+// changeValue converts a value of a field of type fieldType for a change callback.
+// The values of secret fields are decrypted, or masked if they cannot be.
+func (r *Rigel) changeValue(value string, fieldType string) any {
+	if fieldType != types.FieldTypeSecret {
+		return typedValue(value, fieldType)
+	}
+	plaintext, err := r.decryptSecret(value)
+	if err != nil {
+		return SecretMask
+	}
+	return plaintext
+}
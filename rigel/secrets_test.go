@@ -0,0 +1,223 @@
+package rigel
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/remiges-aniket/layout"
+	"github.com/remiges-aniket/types"
+)
+
+func TestKeyringRotation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keyring.json")
+	if err := AddKeyringKey(path, "k1"); err != nil {
+		t.Fatalf("AddKeyringKey() error = %v", err)
+	}
+	oldKeyring, err := LoadKeyring(path)
+	if err != nil {
+		t.Fatalf("LoadKeyring() error = %v", err)
+	}
+	encrypted, err := oldKeyring.Encrypt("s3cret")
+	if err != nil {
+		t.Fatalf("Encrypt() error = %v", err)
+	}
+	if !IsEncrypted(encrypted) || strings.Contains(encrypted, "s3cret") {
+		t.Fatalf("Encrypt() = %q", encrypted)
+	}
+
+	storage := newMockStorage()
+	storage.data["/remiges/rigel/app/mod/1/config/prod/password"] = encrypted
+	storage.data["/remiges/rigel/app/mod/1/config/prod/host"] = "localhost"
+	scheduled, err := json.Marshal(types.ScheduledChange{ID: "c1", Values: map[string]string{"password": encrypted, "host": "new.internal"}, Status: types.SchedulePending})
+	if err != nil {
+		t.Fatalf("failed to marshal scheduled change: %v", err)
+	}
+	storage.data[layout.ScheduledChangePath("c1")] = string(scheduled)
+
+	if err := AddKeyringKey(path, "k2"); err != nil {
+		t.Fatalf("AddKeyringKey() error = %v", err)
+	}
+	keyring, err := LoadKeyring(path)
+	if err != nil {
+		t.Fatalf("LoadKeyring() error = %v", err)
+	}
+	if plaintext, err := keyring.Decrypt(encrypted); err != nil || plaintext != "s3cret" {
+		t.Errorf("Decrypt() with the old key = %q, %v", plaintext, err)
+	}
+
+	rotated, err := RotateSecrets(context.Background(), storage, keyring, rigelPrefix+"/")
+	if err != nil || rotated != 2 {
+		t.Fatalf("RotateSecrets() = %d, %v, want 2", rotated, err)
+	}
+	if rotated, _ := RotateSecrets(context.Background(), storage, keyring, rigelPrefix+"/"); rotated != 0 {
+		t.Errorf("second RotateSecrets() = %d, want 0", rotated)
+	}
+
+	// The rotated value no longer needs the old key
+	if _, err := oldKeyring.Decrypt(storage.data["/remiges/rigel/app/mod/1/config/prod/password"]); err == nil {
+		t.Errorf("rotated value decrypted with the old key only")
+	}
+	if plaintext, err := keyring.Decrypt(storage.data["/remiges/rigel/app/mod/1/config/prod/password"]); err != nil || plaintext != "s3cret" {
+		t.Errorf("Decrypt() of the rotated value = %q, %v", plaintext, err)
+	}
+	if storage.data["/remiges/rigel/app/mod/1/config/prod/host"] != "localhost" {
+		t.Errorf("RotateSecrets() changed a value that is not a secret")
+	}
+
+	// So does the value of the pending scheduled change
+	var change types.ScheduledChange
+	if err := json.Unmarshal([]byte(storage.data[layout.ScheduledChangePath("c1")]), &change); err != nil {
+		t.Fatalf("failed to parse scheduled change: %v", err)
+	}
+	if _, err := oldKeyring.Decrypt(change.Values["password"]); err == nil {
+		t.Errorf("rotated scheduled value decrypted with the old key only")
+	}
+	if plaintext, err := keyring.Decrypt(change.Values["password"]); err != nil || plaintext != "s3cret" || change.Values["host"] != "new.internal" {
+		t.Errorf("rotated scheduled change = %v, decrypted to %q, %v", change.Values, plaintext, err)
+	}
+}
+
+func TestSecretFields(t *testing.T) {
+	r, storage := newTestRigel(t)
+	ctx := context.Background()
+
+	fields := []types.Field{
+		{Name: "host", Type: "string"},
+		{Name: "password", Type: types.FieldTypeSecret},
+	}
+	data, _ := json.Marshal(fields)
+	storage.data[getSchemaFieldsPath("testApp", "testModule", 1)] = string(data)
+
+	if err := r.Set(ctx, "password", "s3cret"); !errors.Is(err, ErrNoKeyring) {
+		t.Errorf("Set() of a secret without a keyring error = %v, want ErrNoKeyring", err)
+	}
+
+	path := filepath.Join(t.TempDir(), "keyring.json")
+	if err := AddKeyringKey(path, "k1"); err != nil {
+		t.Fatalf("AddKeyringKey() error = %v", err)
+	}
+	keyring, err := LoadKeyring(path)
+	if err != nil {
+		t.Fatalf("LoadKeyring() error = %v", err)
+	}
+	snapshotPath := filepath.Join(t.TempDir(), "snapshot.json")
+	r.WithKeyring(keyring).WithSnapshotFile(snapshotPath)
+
+	if err := r.Set(ctx, "password", "s3cret"); err != nil {
+		t.Fatalf("Set() error = %v", err)
+	}
+	stored := storage.data[getConfKeyPath("testApp", "testModule", 1, "testConf", "password")]
+	if !IsEncrypted(stored) || strings.Contains(stored, "s3cret") {
+		t.Errorf("stored value = %q, want it encrypted", stored)
+	}
+
+	if value, err := r.Get(ctx, "password"); err != nil || value != "s3cret" {
+		t.Errorf("Get() = %q, %v, want s3cret", value, err)
+	}
+	if values, err := r.GetAll(ctx); err != nil || values["password"] != "s3cret" {
+		t.Errorf("GetAll() = %v, %v", values, err)
+	}
+	var config struct {
+		Password string `json:"password"`
+	}
+	if err := r.LoadConfig(ctx, &config); err != nil || config.Password != "s3cret" {
+		t.Errorf("LoadConfig() = %+v, %v", config, err)
+	}
+
+	snapshot, err := os.ReadFile(snapshotPath)
+	if err != nil {
+		t.Fatalf("failed to read snapshot: %v", err)
+	}
+	if strings.Contains(string(snapshot), "s3cret") {
+		t.Errorf("snapshot file holds the secret in plaintext")
+	}
+
+	if err := r.SetRules(ctx, "password", []types.Rule{{Match: map[string]string{"region": "mumbai"}, Value: "x"}}); err == nil {
+		t.Errorf("SetRules() of a secret expected an error")
+	}
+}
+
+func TestMaskSecret(t *testing.T) {
+	r, storage := newTestRigel(t)
+	ctx := context.Background()
+
+	fields := []types.Field{
+		{Name: "host", Type: "string"},
+		{Name: "password", Type: types.FieldTypeSecret},
+	}
+	data, _ := json.Marshal(fields)
+	storage.data[getSchemaFieldsPath("testApp", "testModule", 1)] = string(data)
+
+	tests := []struct {
+		key   string
+		value string
+		want  string
+	}{
+		// A secret set before its field became one is kept in plaintext
+		{getConfKeyPath("testApp", "testModule", 1, "prod", "password"), "s3cret", SecretMask},
+		{getConfKeyPath("testApp", "testModule", 1, "prod", "host"), "enc:v1:not-a-secret", "enc:v1:not-a-secret"},
+		{getConfParentPath("testApp", "testModule", 1, "prod"), "base", "base"},
+		{getConfKeyPath("testApp", "otherModule", 1, "prod", "host"), "localhost", SecretMask},
+	}
+	for _, tt := range tests {
+		if got := r.MaskSecret(ctx, tt.key, tt.value); got != tt.want {
+			t.Errorf("MaskSecret(%q, %q) = %q, want %q", tt.key, tt.value, got, tt.want)
+		}
+	}
+}
+
+// racingStorage sets key to value just before the first conditional write, as a client setting
+// a value while it is rotated would.
+type racingStorage struct {
+	*mockStorage
+	key, value string
+}
+
+func (s *racingStorage) PutIf(ctx context.Context, key string, value string, kvs map[string]string) (bool, error) {
+	if s.value != "" {
+		s.mockStorage.Put(ctx, s.key, s.value)
+		s.value = ""
+	}
+	return s.mockStorage.PutIf(ctx, key, value, kvs)
+}
+
+func TestRotateSecretsConflict(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keyring.json")
+	if err := AddKeyringKey(path, "k1"); err != nil {
+		t.Fatalf("AddKeyringKey() error = %v", err)
+	}
+	oldKeyring, err := LoadKeyring(path)
+	if err != nil {
+		t.Fatalf("LoadKeyring() error = %v", err)
+	}
+	before, _ := oldKeyring.Encrypt("before")
+	during, _ := oldKeyring.Encrypt("during")
+	if err := AddKeyringKey(path, "k2"); err != nil {
+		t.Fatalf("AddKeyringKey() error = %v", err)
+	}
+	keyring, err := LoadKeyring(path)
+	if err != nil {
+		t.Fatalf("LoadKeyring() error = %v", err)
+	}
+
+	key := "/remiges/rigel/app/mod/1/config/prod/password"
+	storage := &racingStorage{mockStorage: newMockStorage(), key: key, value: during}
+	storage.data[key] = before
+
+	rotated, err := RotateSecrets(context.Background(), storage, keyring, rigelPrefix+"/")
+	if err != nil || rotated != 1 {
+		t.Fatalf("RotateSecrets() = %d, %v, want 1", rotated, err)
+	}
+	// The value set during the rotation is kept, re-encrypted
+	if plaintext, err := keyring.Decrypt(storage.data[key]); err != nil || plaintext != "during" {
+		t.Errorf("Decrypt() of the rotated value = %q, %v, want during", plaintext, err)
+	}
+	if _, err := oldKeyring.Decrypt(storage.data[key]); err == nil {
+		t.Errorf("rotated value decrypted with the old key only")
+	}
+}
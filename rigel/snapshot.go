@@ -0,0 +1,248 @@
+package rigel
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/remiges-aniket/types"
+)
+
+// snapshotRetryInterval is how often the live config is retried while a snapshot is in use.
+var snapshotRetryInterval = 5 * time.Second
+
+// Snapshot is the last-known-good copy of a config, saved to a local file by LoadConfig.
+type Snapshot struct {
+	App           string            `json:"app"`
+	Module        string            `json:"module"`
+	Version       int               `json:"ver"`
+	Config        string            `json:"config"`
+	SchemaVersion int               `json:"schemaVersion"`
+	Revision      int64             `json:"revision"` // Revision is the storage revision the values were read at, 0 if unknown
+	Schema        types.Schema      `json:"schema"`
+	Values        map[string]string `json:"values"`
+	SavedAt       time.Time         `json:"savedAt"`
+}
+
+// SnapshotStatus describes the use of the snapshot file of a Rigel client.
+type SnapshotStatus struct {
+	Path      string    // Path is the snapshot file, empty if snapshots are not used
+	Stale     bool      // Stale is true while the config is served from the snapshot
+	Revision  int64     // Revision is the storage revision of the snapshot in use or last saved
+	SavedAt   time.Time // SavedAt is when the snapshot in use or last saved was taken
+	LastError error     // LastError is the last error saving the snapshot or loading the live config
+}
+
+// snapshotState holds the snapshot of a Rigel client.
+type snapshotState struct {
+	mu       sync.Mutex
+	path     string
+	snap     *Snapshot // snap is the snapshot in use while stale
+	status   SnapshotStatus
+	retrying bool
+}
+
+func newSnapshotState() *snapshotState {
+	return &snapshotState{}
+}
+
+// WithSnapshotFile sets the file in which LoadConfig keeps the last successfully loaded config.
+// When the storage cannot be reached, LoadConfig loads the config from this file instead,
+// SnapshotStatus reports it as stale, and the live config is retried in the background.
+// Once the storage is back, the cache is refreshed with the live values and change
+// callbacks are notified of the values that differ from the snapshot.
+// This method is typically used for method chaining during Rigel object creation.
+func (r *Rigel) WithSnapshotFile(path string) *Rigel {
+	st := r.state().snapshot
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.path = path
+	st.status.Path = path
+	return r
+}
+
+// SnapshotStatus returns the state of the snapshot file set with WithSnapshotFile.
+func (r *Rigel) SnapshotStatus() SnapshotStatus {
+	st := r.state().snapshot
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	return st.status
+}
+
+// saveSnapshot writes the config values to the snapshot file, if one is set.
+func (r *Rigel) saveSnapshot(schema *types.Schema, values map[string]string, rev int64) {
+	st := r.state().snapshot
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if st.path == "" {
+		return
+	}
+
+	snap := &Snapshot{
+		App:           r.App,
+		Module:        r.Module,
+		Version:       r.Version,
+		Config:        r.Config,
+		SchemaVersion: schema.Version,
+		Revision:      rev,
+		Schema:        *schema,
+		Values:        values,
+		SavedAt:       time.Now(),
+	}
+	if err := writeSnapshot(st.path, snap); err != nil {
+		st.status.LastError = err
+		return
+	}
+	st.snap = nil
+	st.status.Stale = false
+	st.status.Revision = snap.Revision
+	st.status.SavedAt = snap.SavedAt
+}
+
+// loadSnapshot reads the snapshot file after loading the live config failed with liveErr.
+// It returns liveErr if there is no usable snapshot. On success the client is marked
+// stale, the cache is filled from the snapshot and the live config is retried.
+func (r *Rigel) loadSnapshot(liveErr error) (*types.Schema, map[string]string, error) {
+	st := r.state().snapshot
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if st.path == "" {
+		return nil, nil, liveErr
+	}
+
+	snap, err := readSnapshot(st.path)
+	if err != nil {
+		return nil, nil, liveErr
+	}
+	if snap.App != r.App || snap.Module != r.Module || snap.Version != r.Version || snap.Config != r.Config {
+		return nil, nil, liveErr
+	}
+
+	// The values of the snapshot are already resolved through the parents and rules of the config
+	for name, value := range snap.Values {
+		r.Cache.Set(getConfKeyPath(r.App, r.Module, r.Version, r.Config, name), value)
+	}
+	for _, field := range snap.Schema.Leaves() {
+		r.Cache.Set(getConfRulesPath(r.App, r.Module, r.Version, r.Config, field.Name), "")
+	}
+	r.Cache.Set(getConfParentPath(r.App, r.Module, r.Version, r.Config), "")
+	st.snap = snap
+	st.status.Stale = true
+	st.status.Revision = snap.Revision
+	st.status.SavedAt = snap.SavedAt
+	st.status.LastError = liveErr
+
+	if !st.retrying {
+		st.retrying = true
+		go r.retryLive()
+	}
+
+	schema := snap.Schema
+	return &schema, snap.Values, nil
+}
+
+// snapshotSchema returns the schema of the snapshot in use, if the client is stale
+// and the snapshot is of the client's app, module and version.
+func (r *Rigel) snapshotSchema() (*types.Schema, bool) {
+	st := r.state().snapshot
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	snap := st.snap
+	if snap == nil || snap.App != r.App || snap.Module != r.Module || snap.Version != r.Version {
+		return nil, false
+	}
+	return copySchema(&snap.Schema), true
+}
+
+// retryLive loads the live config until it succeeds, then switches the client back to it.
+func (r *Rigel) retryLive() {
+	st := r.state().snapshot
+	ticker := time.NewTicker(snapshotRetryInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		ctx, cancel := context.WithTimeout(context.Background(), snapshotRetryInterval)
+		schema, values, rev, err := r.loadLive(ctx)
+		cancel()
+		if err != nil {
+			st.mu.Lock()
+			st.status.LastError = err
+			st.mu.Unlock()
+			continue
+		}
+
+		st.mu.Lock()
+		snap := st.snap
+		st.retrying = false
+		st.mu.Unlock()
+
+		r.saveSnapshot(schema, values, rev)
+		if snap != nil {
+			r.notifySnapshotChanges(snap, schema, values, rev)
+		}
+		return
+	}
+}
+
+// notifySnapshotChanges tells subscribers about live values that differ from the snapshot.
+func (r *Rigel) notifySnapshotChanges(snap *Snapshot, schema *types.Schema, values map[string]string, rev int64) {
+	fieldTypes := getFieldTypes(schema)
+	for _, field := range schema.Leaves() {
+		oldValue, oldFound := snap.Values[field.Name]
+		newValue, newFound := values[field.Name]
+		if oldFound == newFound && oldValue == newValue {
+			continue
+		}
+		change := ChangeEvent{Key: field.Name, Deleted: !newFound, Revision: rev}
+		if oldFound {
+			change.OldValue = r.changeValue(oldValue, fieldTypes[field.Name])
+		}
+		if newFound {
+			change.NewValue = r.changeValue(newValue, fieldTypes[field.Name])
+		}
+		r.state().subscriptions.dispatch(change)
+	}
+}
+
+// writeSnapshot writes snap to path, replacing the file atomically.
+func writeSnapshot(path string, snap *Snapshot) error {
+	data, err := json.MarshalIndent(snap, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal snapshot: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return fmt.Errorf("failed to create snapshot file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write snapshot file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write snapshot file: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("failed to replace snapshot file: %w", err)
+	}
+	return nil
+}
+
+// readSnapshot reads a snapshot written by writeSnapshot.
+func readSnapshot(path string) (*Snapshot, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read snapshot file: %w", err)
+	}
+	var snap Snapshot
+	if err := json.Unmarshal(data, &snap); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal snapshot: %w", err)
+	}
+	return &snap, nil
+}
@@ -0,0 +1,172 @@
+package rigel
+
+import (
+	"sync"
+)
+
+// maxQueuedChanges is the most changes a subscription queues for its callback. When a slow
+// callback lets the queue fill up, the oldest change is dropped for each new one.
+const maxQueuedChanges = 1024
+
+// ChangeEvent describes a change to a single config key observed by WatchConfig.
+// OldValue and NewValue are converted to the type declared for the key in the schema
+// (int, bool, float64 or string). OldValue is nil when the previous value is not known,
+// and NewValue is nil when the key was deleted.
+type ChangeEvent struct {
+	Key      string
+	OldValue any
+	NewValue any
+	Deleted  bool  // Deleted is true when the key was removed from the storage
+	Revision int64 // Revision is the storage revision of the change
+}
+
+// ChangeFunc is a callback invoked with a ChangeEvent.
+type ChangeFunc func(ChangeEvent)
+
+// Subscription represents a callback registered with OnChange or OnAnyChange.
+// Callbacks of one subscription are run serially, in the order the changes were observed.
+// At most maxQueuedChanges changes wait for the callback; older ones are dropped, see Dropped.
+type Subscription struct {
+	key      string // key is the config key subscribed to, empty for all keys
+	fn       ChangeFunc
+	registry *subscriptionRegistry
+
+	mu      sync.Mutex
+	queue   []ChangeEvent
+	dropped uint64 // dropped counts the changes dropped because the queue was full
+	notify  chan struct{}
+	done    chan struct{}
+	stopped bool
+}
+
+// Unsubscribe removes the subscription. Changes queued before the call are dropped
+// and the callback is not invoked again. It is safe to call Unsubscribe more than once.
+func (s *Subscription) Unsubscribe() {
+	s.registry.remove(s)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.stopped {
+		return
+	}
+	s.stopped = true
+	s.queue = nil
+	close(s.done)
+}
+
+// Dropped returns the number of changes dropped because the callback did not keep up with them.
+func (s *Subscription) Dropped() uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.dropped
+}
+
+// enqueue adds an event to the subscription queue without blocking the caller, dropping the
+// oldest queued event if the queue is full.
+func (s *Subscription) enqueue(event ChangeEvent) {
+	s.mu.Lock()
+	if s.stopped {
+		s.mu.Unlock()
+		return
+	}
+	if len(s.queue) >= maxQueuedChanges {
+		s.queue = s.queue[1:]
+		s.dropped++
+	}
+	s.queue = append(s.queue, event)
+	s.mu.Unlock()
+
+	select {
+	case s.notify <- struct{}{}:
+	default:
+	}
+}
+
+// run delivers queued events to the callback one at a time until the subscription is stopped.
+func (s *Subscription) run() {
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-s.notify:
+		}
+
+		for {
+			s.mu.Lock()
+			if s.stopped || len(s.queue) == 0 {
+				s.mu.Unlock()
+				break
+			}
+			event := s.queue[0]
+			s.queue = s.queue[1:]
+			s.mu.Unlock()
+
+			s.fn(event)
+		}
+	}
+}
+
+// subscriptionRegistry holds the subscriptions of a Rigel client.
+type subscriptionRegistry struct {
+	mu   sync.RWMutex
+	subs map[*Subscription]struct{}
+}
+
+func newSubscriptionRegistry() *subscriptionRegistry {
+	return &subscriptionRegistry{
+		subs: make(map[*Subscription]struct{}),
+	}
+}
+
+func (sr *subscriptionRegistry) add(key string, fn ChangeFunc) *Subscription {
+	s := &Subscription{
+		key:      key,
+		fn:       fn,
+		registry: sr,
+		notify:   make(chan struct{}, 1),
+		done:     make(chan struct{}),
+	}
+
+	sr.mu.Lock()
+	sr.subs[s] = struct{}{}
+	sr.mu.Unlock()
+
+	go s.run()
+	return s
+}
+
+func (sr *subscriptionRegistry) remove(s *Subscription) {
+	sr.mu.Lock()
+	defer sr.mu.Unlock()
+	delete(sr.subs, s)
+}
+
+// hasSubscribers reports whether any subscription is registered.
+func (sr *subscriptionRegistry) hasSubscribers() bool {
+	sr.mu.RLock()
+	defer sr.mu.RUnlock()
+	return len(sr.subs) > 0
+}
+
+// dispatch queues the event on every subscription interested in event.Key.
+func (sr *subscriptionRegistry) dispatch(event ChangeEvent) {
+	sr.mu.RLock()
+	defer sr.mu.RUnlock()
+	for s := range sr.subs {
+		if s.key == "" || s.key == event.Key {
+			s.enqueue(event)
+		}
+	}
+}
+
+// OnChange registers fn to be called whenever the value of configKey changes in the
+// config being watched. Changes are only observed while WatchConfig is running.
+func (r *Rigel) OnChange(configKey string, fn ChangeFunc) *Subscription {
+	return r.state().subscriptions.add(configKey, fn)
+}
+
+// OnAnyChange registers fn to be called whenever any key of the config being watched changes.
+// Changes are only observed while WatchConfig is running.
+func (r *Rigel) OnAnyChange(fn ChangeFunc) *Subscription {
+	return r.state().subscriptions.add("", fn)
+}
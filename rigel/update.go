@@ -0,0 +1,138 @@
+package rigel
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/remiges-aniket/types"
+)
+
+// ConfigUpdate gathers changes to the values, targeting rules and parent of a config, to be
+// written together by Commit: either all of them are made or none is. Each change is checked
+// when it is added, as by Set, SetRules and SetParent. Create one with Rigel.Update.
+type ConfigUpdate struct {
+	r      *Rigel
+	schema *types.Schema
+	kvs    map[string]string
+	keys   []string // keys are the config keys changed, "" for the parent, as recorded by lock overrides
+	parent *string  // parent is the parent set by SetParent, checked again by Commit
+}
+
+// Update returns an empty ConfigUpdate of the config of the client.
+func (r *Rigel) Update() *ConfigUpdate {
+	return &ConfigUpdate{r: r, kvs: make(map[string]string)}
+}
+
+// Set adds the setting of configKey to value. The value must be valid for the schema field of
+// configKey, and is encrypted if the field is a secret.
+func (u *ConfigUpdate) Set(ctx context.Context, configKey string, value string) error {
+	field, err := u.field(ctx, configKey)
+	if err != nil {
+		return err
+	}
+
+	// Validate the value against the field's constraints
+	if !validateValueAgainstConstraints(value, field) {
+		return fmt.Errorf("value does not meet the constraints of the field")
+	}
+
+	// Encrypt the values of secret fields before they leave the client
+	value, err = u.r.encryptValue(field, value)
+	if err != nil {
+		return fmt.Errorf("failed to encrypt secret value: %w", err)
+	}
+
+	r := u.r
+	u.add(getConfKeyPath(r.App, r.Module, r.Version, r.Config, configKey), value, configKey)
+	return nil
+}
+
+// SetRules adds the setting of the targeting rules of configKey, checked as by Rigel.SetRules.
+func (u *ConfigUpdate) SetRules(ctx context.Context, configKey string, rules []types.Rule) error {
+	field, err := u.field(ctx, configKey)
+	if err != nil {
+		return err
+	}
+	if field.IsSecret() && len(rules) > 0 {
+		return fmt.Errorf("secret field %s cannot have rules", configKey)
+	}
+	for i, rule := range rules {
+		if err := validateRule(rule, field); err != nil {
+			return fmt.Errorf("invalid rule %d of %s: %w", i+1, configKey, err)
+		}
+	}
+
+	var value string
+	if len(rules) > 0 {
+		data, err := json.Marshal(rules)
+		if err != nil {
+			return fmt.Errorf("failed to marshal rules: %w", err)
+		}
+		value = string(data)
+	}
+
+	r := u.r
+	u.add(getConfRulesPath(r.App, r.Module, r.Version, r.Config, configKey), value, configKey)
+	return nil
+}
+
+// SetParent adds the setting of the parent of the config, checked as by Rigel.SetParent.
+func (u *ConfigUpdate) SetParent(ctx context.Context, parent string) error {
+	r := u.r
+	if _, err := r.checkParent(ctx, parent); err != nil {
+		return err
+	}
+
+	u.parent = &parent
+	u.add(getConfParentPath(r.App, r.Module, r.Version, r.Config), parent, "")
+	return nil
+}
+
+// Commit writes the changes in a single write, provided that the config is not locked, and
+// returns a LockedError otherwise, unless the client overrides locks. A parent set with
+// SetParent is checked again, and if the storage is a types.ConditionalWriter, the write is
+// made on condition that the parents read by the check did not change since.
+func (u *ConfigUpdate) Commit(ctx context.Context) error {
+	if len(u.kvs) == 0 {
+		return nil
+	}
+	var guard func(ctx context.Context) (map[string]string, error)
+	if u.parent != nil {
+		parent := *u.parent
+		guard = func(ctx context.Context) (map[string]string, error) {
+			return u.r.checkParent(ctx, parent)
+		}
+	}
+	if err := u.r.writeUnlockedIf(ctx, u.kvs, guard, u.keys...); err != nil {
+		return err
+	}
+	for key, value := range u.kvs {
+		u.r.Cache.Set(key, value)
+	}
+	return nil
+}
+
+// add adds the setting of key to value, a change to configKey.
+func (u *ConfigUpdate) add(key string, value string, configKey string) {
+	if _, found := u.kvs[key]; !found {
+		u.keys = append(u.keys, configKey)
+	}
+	u.kvs[key] = value
+}
+
+// field returns the schema field of configKey, reading the schema the first time.
+func (u *ConfigUpdate) field(ctx context.Context, configKey string) (*types.Field, error) {
+	if u.schema == nil {
+		schema, err := u.r.GetSchema(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get schema: %w", err)
+		}
+		u.schema = schema
+	}
+	field := u.schema.Leaf(configKey)
+	if field == nil {
+		return nil, &KeyNotFoundError{Key: configKey}
+	}
+	return field, nil
+}
@@ -0,0 +1,199 @@
+package rigel
+
+import (
+	"context"
+	"time"
+
+	"github.com/remiges-aniket/types"
+)
+
+// View is an immutable view of a Rigel client bound to one app, module, version and config.
+// It shares the storage, the cache and the schema cache of the client it was created from,
+// so it is cheap to create one per request. Unlike the With-prefixed functions of Rigel,
+// which modify the client, creating and using a View never affects other users of the client,
+// which makes it safe to use from concurrent request handlers.
+type View struct {
+	r *Rigel // r is a private copy of the client that is never modified
+}
+
+// View returns a View of the client bound to the given app, module, version and config.
+func (r *Rigel) View(app string, module string, version int, config string) *View {
+	r.state() // the view shares the state of the client, even one built as a struct literal
+	scoped := *r
+	scoped.App = app
+	scoped.Module = module
+	scoped.Version = version
+	scoped.Config = config
+	return &View{r: &scoped}
+}
+
+// WithAttributes returns a View of the same config whose targeting rules are evaluated against attrs
+// instead of the attributes of the client. See Rigel.WithAttributes.
+func (v *View) WithAttributes(attrs map[string]string) *View {
+	scoped := *v.r
+	scoped.WithAttributes(attrs)
+	return &View{r: &scoped}
+}
+
+// App returns the app the view is bound to.
+func (v *View) App() string {
+	return v.r.App
+}
+
+// Module returns the module the view is bound to.
+func (v *View) Module() string {
+	return v.r.Module
+}
+
+// Version returns the schema version the view is bound to.
+func (v *View) Version() int {
+	return v.r.Version
+}
+
+// Config returns the named config the view is bound to.
+func (v *View) Config() string {
+	return v.r.Config
+}
+
+// GetSchema retrieves the schema of the view's app, module and version. See Rigel.GetSchema.
+func (v *View) GetSchema(ctx context.Context) (*types.Schema, error) {
+	return v.r.GetSchema(ctx)
+}
+
+// AddSchema adds a schema to the view's app and module. See Rigel.AddSchema.
+func (v *View) AddSchema(ctx context.Context, schema types.Schema) error {
+	return v.r.AddSchema(ctx, schema)
+}
+
+// KeyExistsInSchema checks if a key exists in the view's schema. See Rigel.KeyExistsInSchema.
+func (v *View) KeyExistsInSchema(ctx context.Context, key string) (bool, error) {
+	return v.r.KeyExistsInSchema(ctx, key)
+}
+
+// Get retrieves a value of the view's config. See Rigel.Get.
+func (v *View) Get(ctx context.Context, configKey string) (string, error) {
+	return v.r.Get(ctx, configKey)
+}
+
+// GetInt retrieves a value of the view's config as an int. See Rigel.GetInt.
+func (v *View) GetInt(ctx context.Context, configKey string) (int, error) {
+	return v.r.GetInt(ctx, configKey)
+}
+
+// GetFloat retrieves a value of the view's config as a float64. See Rigel.GetFloat.
+func (v *View) GetFloat(ctx context.Context, configKey string) (float64, error) {
+	return v.r.GetFloat(ctx, configKey)
+}
+
+// GetBool retrieves a value of the view's config as a bool. See Rigel.GetBool.
+func (v *View) GetBool(ctx context.Context, configKey string) (bool, error) {
+	return v.r.GetBool(ctx, configKey)
+}
+
+// GetString retrieves a value of the view's config as a string. See Rigel.GetString.
+func (v *View) GetString(ctx context.Context, configKey string) (string, error) {
+	return v.r.GetString(ctx, configKey)
+}
+
+// GetAll retrieves all values of the view's config. See Rigel.GetAll.
+func (v *View) GetAll(ctx context.Context) (map[string]string, error) {
+	return v.r.GetAll(ctx)
+}
+
+// GetWithSource retrieves a value of the view's config along with the config it came from. See Rigel.GetWithSource.
+func (v *View) GetWithSource(ctx context.Context, configKey string) (ResolvedValue, error) {
+	return v.r.GetWithSource(ctx, configKey)
+}
+
+// GetAllResolved retrieves all values of the view's config along with the configs they came from. See Rigel.GetAllResolved.
+func (v *View) GetAllResolved(ctx context.Context) (map[string]ResolvedValue, error) {
+	return v.r.GetAllResolved(ctx)
+}
+
+// Parent returns the parent of the view's config. See Rigel.Parent.
+func (v *View) Parent(ctx context.Context) (string, error) {
+	return v.r.Parent(ctx)
+}
+
+// SetParent sets the parent of the view's config. See Rigel.SetParent.
+func (v *View) SetParent(ctx context.Context, parent string) error {
+	return v.r.SetParent(ctx, parent)
+}
+
+// GetRules returns the targeting rules of a key of the view's config. See Rigel.GetRules.
+func (v *View) GetRules(ctx context.Context, configKey string) ([]types.Rule, error) {
+	return v.r.GetRules(ctx, configKey)
+}
+
+// SetRules sets the targeting rules of a key of the view's config. See Rigel.SetRules.
+func (v *View) SetRules(ctx context.Context, configKey string, rules []types.Rule) error {
+	return v.r.SetRules(ctx, configKey, rules)
+}
+
+// IsEnabled reports whether a feature flag of the view's config is enabled for subjectKey. See Rigel.IsEnabled.
+func (v *View) IsEnabled(ctx context.Context, flag string, subjectKey string) (bool, error) {
+	return v.r.IsEnabled(ctx, flag, subjectKey)
+}
+
+// GetFlag returns the rollout state of a feature flag of the view's config. See Rigel.GetFlag.
+func (v *View) GetFlag(ctx context.Context, flag string) (types.FlagState, bool, error) {
+	return v.r.GetFlag(ctx, flag)
+}
+
+// SetFlag sets the rollout state of a feature flag of the view's config. See Rigel.SetFlag.
+func (v *View) SetFlag(ctx context.Context, flag string, state types.FlagState) error {
+	return v.r.SetFlag(ctx, flag, state)
+}
+
+// Set sets a value of the view's config. See Rigel.Set.
+func (v *View) Set(ctx context.Context, configKey string, value string) error {
+	return v.r.Set(ctx, configKey, value)
+}
+
+// Update returns an empty ConfigUpdate of the view's config. See Rigel.Update.
+func (v *View) Update() *ConfigUpdate {
+	return v.r.Update()
+}
+
+// LoadConfig loads the view's config into configStruct. See Rigel.LoadConfig.
+func (v *View) LoadConfig(ctx context.Context, configStruct any) error {
+	return v.r.LoadConfig(ctx, configStruct)
+}
+
+// WithLockOverride returns a View of the same config that writes to it even while it is locked,
+// recording reason with each such write. See Rigel.WithLockOverride.
+func (v *View) WithLockOverride(reason string) *View {
+	scoped := *v.r
+	scoped.WithLockOverride(reason)
+	return &View{r: &scoped}
+}
+
+// SetLock locks the view's config, module or app. See Rigel.SetLock.
+func (v *View) SetLock(ctx context.Context, lock types.Lock) error {
+	return v.r.SetLock(ctx, lock)
+}
+
+// RemoveLock removes the lock of the view's config, module or app. See Rigel.RemoveLock.
+func (v *View) RemoveLock(ctx context.Context, scope types.LockScope) error {
+	return v.r.RemoveLock(ctx, scope)
+}
+
+// ActiveLock returns the lock in force for the view's config. See Rigel.ActiveLock.
+func (v *View) ActiveLock(ctx context.Context) (*types.Lock, error) {
+	return v.r.ActiveLock(ctx)
+}
+
+// Overrides returns the writes made to the view's config by overriding its locks. See Rigel.Overrides.
+func (v *View) Overrides(ctx context.Context) ([]types.LockOverride, error) {
+	return v.r.Overrides(ctx)
+}
+
+// ScheduleChange schedules a change to the view's config. See Rigel.ScheduleChange.
+func (v *View) ScheduleChange(ctx context.Context, effectiveAt time.Time, values map[string]string, reason string) (types.ScheduledChange, error) {
+	return v.r.ScheduleChange(ctx, effectiveAt, values, reason)
+}
+
+// GetAllResolvedAt returns the values of the view's config at a past or future time. See Rigel.GetAllResolvedAt.
+func (v *View) GetAllResolvedAt(ctx context.Context, at time.Time) (map[string]ResolvedValue, error) {
+	return v.r.GetAllResolvedAt(ctx, at)
+}
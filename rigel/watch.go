@@ -0,0 +1,222 @@
+package rigel
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/remiges-aniket/layout"
+	"github.com/remiges-aniket/types"
+)
+
+// WatchConfig starts watching for changes to any key in the specified configuration namespace in the storage.
+// When a change is detected, it updates the corresponding key-value pair in the cache
+// and notifies the callbacks registered with OnChange and OnAnyChange. Deleted keys are
+// evicted from the cache. Changes to the parents of the config, see SetParent, are seen too:
+// callbacks are notified of the changes to the values the config resolves to. When the schema of the app, module and version changes, it is
+// fetched again so that change callbacks get values of the new types.
+// Errors reported by the storage are available through WatchErrors and WatchStatus;
+// the watch ends when ctx is done.
+// The method takes the schemaName, schemaVersion, and configName
+// to construct the base key for the configuration namespace.
+func (r *Rigel) WatchConfig(ctx context.Context) error {
+	// Construct the base key for the configuration and the keys of its schema
+	configsKey := getConfigsPath(r.App, r.Module, r.Version)
+	versionKey := getSchemaPath(r.App, r.Module, r.Version)
+	fieldsKey := getSchemaFieldsPath(r.App, r.Module, r.Version)
+	descriptionKey := GetSchemaDescriptionPath(r.App, r.Module, r.Version)
+
+	// lastValues holds the values of all the configs of the version last seen by the watch, so that
+	// change callbacks can be given the old value. It starts from the current values in the storage,
+	// read at the revision after which the watch starts, so that no change in between is missed.
+	lastValues, rev, err := r.readPrefix(ctx, configsKey)
+	if err != nil {
+		return fmt.Errorf("failed to get config values: %w", err)
+	}
+	chain, err := r.chainFrom(lastValues)
+	if err != nil {
+		return err
+	}
+
+	// Watch the whole version, so that changes to the schema are seen along with the config
+	watchCtx, cancel := context.WithCancel(ctx)
+	events := make(chan types.Event)
+	if err := r.watchAfter(watchCtx, versionKey, rev, events); err != nil {
+		cancel()
+		return err
+	}
+
+	// The schema gives the types used to convert values passed to change callbacks. It is read
+	// once the watch is established, so that a change to it after the read is seen by the watch.
+	schema, err := r.GetSchema(ctx)
+	if err != nil {
+		cancel()
+		return fmt.Errorf("failed to get schema: %w", err)
+	}
+	fieldTypes := getFieldTypes(schema)
+
+	r.state().watch.started()
+	r.state().schemas.watchStarted(versionKey)
+	go func() {
+		defer cancel()
+		defer r.state().watch.stopped()
+		defer r.state().schemas.watchStopped(versionKey)
+
+		for event := range events {
+			if event.Err != nil {
+				// Changes to the schema may have been missed while the watch was broken
+				r.state().schemas.invalidate(versionKey)
+				r.state().watch.failed(event.Err)
+				continue
+			}
+			r.state().watch.received()
+
+			switch {
+			case event.Key == fieldsKey || event.Key == descriptionKey:
+				r.state().schemas.invalidate(versionKey)
+				changed, err := r.GetSchema(ctx)
+				if err != nil {
+					r.state().watch.failed(fmt.Errorf("failed to get changed schema: %w", err))
+					continue
+				}
+				schema = changed
+				fieldTypes = getFieldTypes(schema)
+			case strings.HasPrefix(event.Key, configsKey):
+				_, path, _ := strings.Cut(strings.TrimPrefix(event.Key, configsKey), "/")
+				if path == layout.ParentKey {
+					chain = r.applyParentEvent(event, schema, chain, lastValues)
+					continue
+				}
+				if strings.HasPrefix(path, layout.FlagsKey+"/") {
+					updateCache(r.Cache, event, lastValues)
+					continue
+				}
+				if path == layout.LockKey || strings.HasPrefix(path, layout.OverridesKey+"/") {
+					// Locks and their overrides are not config values
+					continue
+				}
+				// A change to the rules of a key is a change to the value it may resolve to
+				configKey := configKeyFromPath(strings.TrimPrefix(path, layout.RulesKey+"/"))
+				r.applyConfigEvent(event, configKey, fieldTypes[configKey], chain, lastValues)
+			}
+		}
+	}()
+
+	return nil
+}
+
+// watchAfter watches key for the changes made after revision rev, as read by readPrefix.
+// If the storage cannot watch from a past revision or did not tell the revision of the read,
+// the watch starts with the changes made after the call.
+func (r *Rigel) watchAfter(ctx context.Context, key string, rev int64, events chan<- types.Event) error {
+	if rw, ok := r.Storage.(types.RevisionWatcher); ok && rev > 0 {
+		return rw.WatchFrom(ctx, key, rev+1, events)
+	}
+	return r.Storage.Watch(ctx, key, events)
+}
+
+// applyConfigEvent updates the cache with a change to a config key and notifies subscribers
+// if the value that configKey resolves to through chain has changed.
+func (r *Rigel) applyConfigEvent(event types.Event, configKey string, fieldType string, chain []string, lastValues map[string]string) {
+	if _, found := lastValues[event.Key]; !found && event.PrevValue != "" {
+		lastValues[event.Key] = event.PrevValue
+	}
+	old, oldFound := r.resolve(configKey, chain, lastValues)
+	updateCache(r.Cache, event, lastValues)
+
+	// A re-read of the config after a lost watch repeats unchanged values,
+	// and changes to a parent are hidden by the values of the config
+	current, found := r.resolve(configKey, chain, lastValues)
+	if oldFound == found && old.Value == current.Value {
+		return
+	}
+	r.dispatchChange(configKey, fieldType, old, oldFound, current, found, event.Revision)
+}
+
+// applyParentEvent updates the cache with a change to the parent of a config and, if it changes
+// the chain of parents of the watched config, notifies subscribers of the values that change with
+// it. It returns the new chain, or chain unchanged if the new parents are not valid.
+func (r *Rigel) applyParentEvent(event types.Event, schema *types.Schema, chain []string, lastValues map[string]string) []string {
+	updateCache(r.Cache, event, lastValues)
+
+	newChain, err := r.chainFrom(lastValues)
+	if err != nil {
+		r.state().watch.failed(err)
+		return chain
+	}
+	if strings.Join(newChain, "/") == strings.Join(chain, "/") {
+		return chain
+	}
+
+	fieldTypes := getFieldTypes(schema)
+	for _, field := range schema.Leaves() {
+		old, oldFound := r.resolve(field.Name, chain, lastValues)
+		current, found := r.resolve(field.Name, newChain, lastValues)
+		if oldFound != found || old.Value != current.Value {
+			r.dispatchChange(field.Name, fieldTypes[field.Name], old, oldFound, current, found, event.Revision)
+		}
+	}
+	return newChain
+}
+
+// updateCache applies a change to a key to the cache and to lastValues. Deleted keys are
+// evicted from the cache; only keys already in the cache are updated.
+func updateCache(cache types.Cache, event types.Event, lastValues map[string]string) {
+	if event.Type == types.EventTypeDelete {
+		cache.Delete(event.Key)
+		delete(lastValues, event.Key)
+		return
+	}
+	if isCached(cache, event.Key) {
+		cache.Set(event.Key, event.Value)
+	}
+	lastValues[event.Key] = event.Value
+}
+
+// isCached reports whether key is in cache, without counting as an access of the key
+// if the cache implements types.CacheContainer.
+func isCached(cache types.Cache, key string) bool {
+	if cc, ok := cache.(types.CacheContainer); ok {
+		return cc.Contains(key)
+	}
+	_, found := cache.Get(key)
+	return found
+}
+
+// dispatchChange notifies subscribers of a change to the value of configKey.
+func (r *Rigel) dispatchChange(configKey string, fieldType string, old ResolvedValue, oldFound bool, current ResolvedValue, found bool, rev int64) {
+	if !r.state().subscriptions.hasSubscribers() {
+		return
+	}
+	change := ChangeEvent{
+		Key:      configKey,
+		Deleted:  !found,
+		Revision: rev,
+	}
+	if oldFound {
+		change.OldValue = r.changeValue(old.Value, fieldType)
+	}
+	if found {
+		change.NewValue = r.changeValue(current.Value, fieldType)
+	}
+	r.state().subscriptions.dispatch(change)
+}
+
+// getFieldTypes returns the type of each field of schema holding values, keyed by dotted field name.
+func getFieldTypes(schema *types.Schema) map[string]string {
+	fields := schema.Leaves()
+	fieldTypes := make(map[string]string, len(fields))
+	for _, field := range fields {
+		fieldTypes[field.Name] = field.Type
+	}
+	return fieldTypes
+}
+
+// typedValue converts valueStr to fieldType, returning valueStr unchanged if conversion fails.
+func typedValue(valueStr string, fieldType string) any {
+	value, err := convertToType(valueStr, fieldType)
+	if err != nil {
+		return valueStr
+	}
+	return value
+}
@@ -0,0 +1,77 @@
+package rigel
+
+import (
+	"sync"
+	"time"
+)
+
+// watchErrorsBuffer is the number of watch errors kept for a reader of WatchErrors.
+// Errors that do not fit in the buffer are dropped; WatchStatus always has the latest one.
+const watchErrorsBuffer = 16
+
+// WatchStatus describes the health of the watch started by WatchConfig.
+type WatchStatus struct {
+	Running     bool      // Running is true while the watch is active
+	Healthy     bool      // Healthy is false from a watch error until the next change is received
+	LastError   error     // LastError is the most recent error reported by the watch
+	LastErrorAt time.Time // LastErrorAt is the time LastError was reported
+}
+
+// watchState tracks the health of the watch of a Rigel client.
+type watchState struct {
+	mu     sync.Mutex
+	status WatchStatus
+	errs   chan error
+}
+
+func newWatchState() *watchState {
+	return &watchState{
+		errs: make(chan error, watchErrorsBuffer),
+	}
+}
+
+func (ws *watchState) started() {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	ws.status.Running = true
+	ws.status.Healthy = true
+}
+
+func (ws *watchState) stopped() {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	ws.status.Running = false
+}
+
+func (ws *watchState) received() {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	ws.status.Healthy = true
+}
+
+func (ws *watchState) failed(err error) {
+	ws.mu.Lock()
+	ws.status.Healthy = false
+	ws.status.LastError = err
+	ws.status.LastErrorAt = time.Now()
+	ws.mu.Unlock()
+
+	select {
+	case ws.errs <- err:
+	default:
+	}
+}
+
+// WatchStatus returns the health of the watch started by WatchConfig.
+func (r *Rigel) WatchStatus() WatchStatus {
+	ws := r.state().watch
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	return ws.status
+}
+
+// WatchErrors returns a channel on which errors reported by the watch started by
+// WatchConfig are sent. The storage keeps retrying after reporting an error.
+func (r *Rigel) WatchErrors() <-chan error {
+	return r.state().watch.errs
+}
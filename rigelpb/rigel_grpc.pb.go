@@ -0,0 +1,420 @@
+// The gRPC API of the Rigel server. It mirrors the HTTP endpoints and is served by the same
+// code, see package grpcsvc. Errors carry the error code of the HTTP API, as in errortypes.yaml,
+// in a google.rpc.ErrorInfo detail whose domain is "rigel".
+
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.3.0
+// - protoc             v4.24.4
+// source: rigel.proto
+
+package rigelpb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.32.0 or later.
+const _ = grpc.SupportPackageIsVersion7
+
+const (
+	Rigel_GetSchema_FullMethodName    = "/rigel.v1.Rigel/GetSchema"
+	Rigel_ListSchemas_FullMethodName  = "/rigel.v1.Rigel/ListSchemas"
+	Rigel_CreateSchema_FullMethodName = "/rigel.v1.Rigel/CreateSchema"
+	Rigel_GetConfig_FullMethodName    = "/rigel.v1.Rigel/GetConfig"
+	Rigel_ListConfigs_FullMethodName  = "/rigel.v1.Rigel/ListConfigs"
+	Rigel_SetConfig_FullMethodName    = "/rigel.v1.Rigel/SetConfig"
+	Rigel_UpdateConfig_FullMethodName = "/rigel.v1.Rigel/UpdateConfig"
+	Rigel_Watch_FullMethodName        = "/rigel.v1.Rigel/Watch"
+)
+
+// RigelClient is the client API for Rigel service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type RigelClient interface {
+	// GetSchema returns a schema version, as /getschema.
+	GetSchema(ctx context.Context, in *GetSchemaRequest, opts ...grpc.CallOption) (*Schema, error)
+	// ListSchemas returns a page of the schemas, as /schemalist.
+	ListSchemas(ctx context.Context, in *ListRequest, opts ...grpc.CallOption) (*ListSchemasResponse, error)
+	// CreateSchema adds a schema version, replacing it if it exists, as /schemacreate. Only admins
+	// can: the call must carry the admin token of the server as a bearer token in its
+	// "authorization" metadata.
+	CreateSchema(ctx context.Context, in *CreateSchemaRequest, opts ...grpc.CallOption) (*CreateSchemaResponse, error)
+	// GetConfig returns the values of a config, as /configget.
+	GetConfig(ctx context.Context, in *GetConfigRequest, opts ...grpc.CallOption) (*Config, error)
+	// ListConfigs returns a page of the configs, as /configlist.
+	ListConfigs(ctx context.Context, in *ListRequest, opts ...grpc.CallOption) (*ListConfigsResponse, error)
+	// SetConfig sets a value of a config, as /configset.
+	SetConfig(ctx context.Context, in *SetConfigRequest, opts ...grpc.CallOption) (*SetConfigResponse, error)
+	// UpdateConfig sets the parent and values of a config, as /configupdate.
+	UpdateConfig(ctx context.Context, in *UpdateConfigRequest, opts ...grpc.CallOption) (*UpdateConfigResponse, error)
+	// Watch streams the changes to an app, a module or a config, as /configstream.
+	Watch(ctx context.Context, in *WatchRequest, opts ...grpc.CallOption) (Rigel_WatchClient, error)
+}
+
+type rigelClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewRigelClient(cc grpc.ClientConnInterface) RigelClient {
+	return &rigelClient{cc}
+}
+
+func (c *rigelClient) GetSchema(ctx context.Context, in *GetSchemaRequest, opts ...grpc.CallOption) (*Schema, error) {
+	out := new(Schema)
+	err := c.cc.Invoke(ctx, Rigel_GetSchema_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *rigelClient) ListSchemas(ctx context.Context, in *ListRequest, opts ...grpc.CallOption) (*ListSchemasResponse, error) {
+	out := new(ListSchemasResponse)
+	err := c.cc.Invoke(ctx, Rigel_ListSchemas_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *rigelClient) CreateSchema(ctx context.Context, in *CreateSchemaRequest, opts ...grpc.CallOption) (*CreateSchemaResponse, error) {
+	out := new(CreateSchemaResponse)
+	err := c.cc.Invoke(ctx, Rigel_CreateSchema_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *rigelClient) GetConfig(ctx context.Context, in *GetConfigRequest, opts ...grpc.CallOption) (*Config, error) {
+	out := new(Config)
+	err := c.cc.Invoke(ctx, Rigel_GetConfig_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *rigelClient) ListConfigs(ctx context.Context, in *ListRequest, opts ...grpc.CallOption) (*ListConfigsResponse, error) {
+	out := new(ListConfigsResponse)
+	err := c.cc.Invoke(ctx, Rigel_ListConfigs_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *rigelClient) SetConfig(ctx context.Context, in *SetConfigRequest, opts ...grpc.CallOption) (*SetConfigResponse, error) {
+	out := new(SetConfigResponse)
+	err := c.cc.Invoke(ctx, Rigel_SetConfig_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *rigelClient) UpdateConfig(ctx context.Context, in *UpdateConfigRequest, opts ...grpc.CallOption) (*UpdateConfigResponse, error) {
+	out := new(UpdateConfigResponse)
+	err := c.cc.Invoke(ctx, Rigel_UpdateConfig_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *rigelClient) Watch(ctx context.Context, in *WatchRequest, opts ...grpc.CallOption) (Rigel_WatchClient, error) {
+	stream, err := c.cc.NewStream(ctx, &Rigel_ServiceDesc.Streams[0], Rigel_Watch_FullMethodName, opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &rigelWatchClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type Rigel_WatchClient interface {
+	Recv() (*WatchEvent, error)
+	grpc.ClientStream
+}
+
+type rigelWatchClient struct {
+	grpc.ClientStream
+}
+
+func (x *rigelWatchClient) Recv() (*WatchEvent, error) {
+	m := new(WatchEvent)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// RigelServer is the server API for Rigel service.
+// All implementations must embed UnimplementedRigelServer
+// for forward compatibility
+type RigelServer interface {
+	// GetSchema returns a schema version, as /getschema.
+	GetSchema(context.Context, *GetSchemaRequest) (*Schema, error)
+	// ListSchemas returns a page of the schemas, as /schemalist.
+	ListSchemas(context.Context, *ListRequest) (*ListSchemasResponse, error)
+	// CreateSchema adds a schema version, replacing it if it exists, as /schemacreate. Only admins
+	// can: the call must carry the admin token of the server as a bearer token in its
+	// "authorization" metadata.
+	CreateSchema(context.Context, *CreateSchemaRequest) (*CreateSchemaResponse, error)
+	// GetConfig returns the values of a config, as /configget.
+	GetConfig(context.Context, *GetConfigRequest) (*Config, error)
+	// ListConfigs returns a page of the configs, as /configlist.
+	ListConfigs(context.Context, *ListRequest) (*ListConfigsResponse, error)
+	// SetConfig sets a value of a config, as /configset.
+	SetConfig(context.Context, *SetConfigRequest) (*SetConfigResponse, error)
+	// UpdateConfig sets the parent and values of a config, as /configupdate.
+	UpdateConfig(context.Context, *UpdateConfigRequest) (*UpdateConfigResponse, error)
+	// Watch streams the changes to an app, a module or a config, as /configstream.
+	Watch(*WatchRequest, Rigel_WatchServer) error
+	mustEmbedUnimplementedRigelServer()
+}
+
+// UnimplementedRigelServer must be embedded to have forward compatible implementations.
+type UnimplementedRigelServer struct {
+}
+
+func (UnimplementedRigelServer) GetSchema(context.Context, *GetSchemaRequest) (*Schema, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetSchema not implemented")
+}
+func (UnimplementedRigelServer) ListSchemas(context.Context, *ListRequest) (*ListSchemasResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListSchemas not implemented")
+}
+func (UnimplementedRigelServer) CreateSchema(context.Context, *CreateSchemaRequest) (*CreateSchemaResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method CreateSchema not implemented")
+}
+func (UnimplementedRigelServer) GetConfig(context.Context, *GetConfigRequest) (*Config, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetConfig not implemented")
+}
+func (UnimplementedRigelServer) ListConfigs(context.Context, *ListRequest) (*ListConfigsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListConfigs not implemented")
+}
+func (UnimplementedRigelServer) SetConfig(context.Context, *SetConfigRequest) (*SetConfigResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method SetConfig not implemented")
+}
+func (UnimplementedRigelServer) UpdateConfig(context.Context, *UpdateConfigRequest) (*UpdateConfigResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method UpdateConfig not implemented")
+}
+func (UnimplementedRigelServer) Watch(*WatchRequest, Rigel_WatchServer) error {
+	return status.Errorf(codes.Unimplemented, "method Watch not implemented")
+}
+func (UnimplementedRigelServer) mustEmbedUnimplementedRigelServer() {}
+
+// UnsafeRigelServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to RigelServer will
+// result in compilation errors.
+type UnsafeRigelServer interface {
+	mustEmbedUnimplementedRigelServer()
+}
+
+func RegisterRigelServer(s grpc.ServiceRegistrar, srv RigelServer) {
+	s.RegisterService(&Rigel_ServiceDesc, srv)
+}
+
+func _Rigel_GetSchema_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetSchemaRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(RigelServer).GetSchema(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Rigel_GetSchema_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(RigelServer).GetSchema(ctx, req.(*GetSchemaRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Rigel_ListSchemas_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(RigelServer).ListSchemas(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Rigel_ListSchemas_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(RigelServer).ListSchemas(ctx, req.(*ListRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Rigel_CreateSchema_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CreateSchemaRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(RigelServer).CreateSchema(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Rigel_CreateSchema_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(RigelServer).CreateSchema(ctx, req.(*CreateSchemaRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Rigel_GetConfig_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetConfigRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(RigelServer).GetConfig(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Rigel_GetConfig_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(RigelServer).GetConfig(ctx, req.(*GetConfigRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Rigel_ListConfigs_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(RigelServer).ListConfigs(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Rigel_ListConfigs_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(RigelServer).ListConfigs(ctx, req.(*ListRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Rigel_SetConfig_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SetConfigRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(RigelServer).SetConfig(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Rigel_SetConfig_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(RigelServer).SetConfig(ctx, req.(*SetConfigRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Rigel_UpdateConfig_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(UpdateConfigRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(RigelServer).UpdateConfig(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Rigel_UpdateConfig_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(RigelServer).UpdateConfig(ctx, req.(*UpdateConfigRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Rigel_Watch_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(WatchRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(RigelServer).Watch(m, &rigelWatchServer{stream})
+}
+
+type Rigel_WatchServer interface {
+	Send(*WatchEvent) error
+	grpc.ServerStream
+}
+
+type rigelWatchServer struct {
+	grpc.ServerStream
+}
+
+func (x *rigelWatchServer) Send(m *WatchEvent) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+// Rigel_ServiceDesc is the grpc.ServiceDesc for Rigel service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Rigel_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "rigel.v1.Rigel",
+	HandlerType: (*RigelServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "GetSchema",
+			Handler:    _Rigel_GetSchema_Handler,
+		},
+		{
+			MethodName: "ListSchemas",
+			Handler:    _Rigel_ListSchemas_Handler,
+		},
+		{
+			MethodName: "CreateSchema",
+			Handler:    _Rigel_CreateSchema_Handler,
+		},
+		{
+			MethodName: "GetConfig",
+			Handler:    _Rigel_GetConfig_Handler,
+		},
+		{
+			MethodName: "ListConfigs",
+			Handler:    _Rigel_ListConfigs_Handler,
+		},
+		{
+			MethodName: "SetConfig",
+			Handler:    _Rigel_SetConfig_Handler,
+		},
+		{
+			MethodName: "UpdateConfig",
+			Handler:    _Rigel_UpdateConfig_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "Watch",
+			Handler:       _Rigel_Watch_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "rigel.proto",
+}
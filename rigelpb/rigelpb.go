@@ -0,0 +1,5 @@
+// Package rigelpb holds the protocol buffer messages and the service of the gRPC API of the
+// Rigel server, generated from rigel.proto.
+package rigelpb
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative rigel.proto
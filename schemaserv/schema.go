@@ -2,14 +2,18 @@ package schemaserv
 
 import (
 	"context"
+	"fmt"
 	"net/http"
 	"strconv"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/go-playground/validator/v10"
+	"github.com/remiges-aniket/configsvc"
 	"github.com/remiges-aniket/etcd"
+	"github.com/remiges-aniket/layout"
 	"github.com/remiges-aniket/rigel"
+	"github.com/remiges-aniket/trees"
 	"github.com/remiges-aniket/types"
 	"github.com/remiges-aniket/utils"
 	"github.com/remiges-tech/alya/service"
@@ -80,31 +84,20 @@ func HandleGetSchemaRequest(c *gin.Context, s *service.Service) {
 		wscutils.SendErrorResponse(c, wscutils.NewResponse(wscutils.ErrorStatus, nil, []wscutils.ErrorMessage{wscutils.BuildErrorMessage(utils.INVALID_DEPENDENCY, &str)}))
 		return
 	}
-	client.WithApp(schemaName)
-	client.WithModule(schemaModule)
-	client.WithVersion(schemaVersion)
+	view := client.View(schemaName, schemaModule, schemaVersion, "")
 
 	// Create a context with a timeout
 	ctx, cancel := context.WithTimeout(context.Background(), utils.DIALTIMEOUT)
 	defer cancel()
 
 	// Getting schema details
-	schema, err := client.GetSchema(ctx)
+	response, err := GetSchema(ctx, view)
 	if err != nil {
 		lh.LogActivity("error occurred while getting Schema details: ", err)
 		wscutils.SendErrorResponse(c, wscutils.NewErrorResponse(SCHEMA_NOT_FOUND))
 		return
 	}
 
-	// Initialize response struct
-	response := GetSchemaResponse{
-		App:         schemaName,
-		Module:      schemaModule,
-		Ver:         schema.Version,
-		Fields:      schema.Fields,
-		Description: schema.Description,
-	}
-
 	// Send success response
 	wscutils.SendSuccessResponse(c, &wscutils.Response{Status: "success", Data: response, Messages: []wscutils.ErrorMessage{}})
 
@@ -113,6 +106,22 @@ func HandleGetSchemaRequest(c *gin.Context, s *service.Service) {
 
 }
 
+// GetSchema returns the schema of the app, module and version of view, with the fields of groups
+// nested. It serves /getschema and the GetSchema call of the gRPC API.
+func GetSchema(ctx context.Context, view *rigel.View) (GetSchemaResponse, error) {
+	schema, err := view.GetSchema(ctx)
+	if err != nil {
+		return GetSchemaResponse{}, err
+	}
+	return GetSchemaResponse{
+		App:         view.App(),
+		Module:      view.Module(),
+		Ver:         schema.Version,
+		Fields:      types.NestFields(schema.Leaves()),
+		Description: schema.Description,
+	}, nil
+}
+
 // Validate validates the request body
 func validateGetSchema(req GetSchemaRequest, c *gin.Context) []wscutils.ErrorMessage {
 	// validate request body using standard validator
@@ -152,6 +161,66 @@ func (req *GetSchemaRequest) getValsForGetSchemaError(err validator.FieldError)
 	return vals
 }
 
+// CreateSchemaRequest is the body of a /schemacreate request. Fields are nested in groups, as in
+// the response of /getschema.
+type CreateSchemaRequest struct {
+	App         string        `json:"app" validate:"required"`
+	Module      string        `json:"module" validate:"required"`
+	Version     int           `json:"ver" validate:"required"`
+	Description string        `json:"description,omitempty"`
+	Fields      []types.Field `json:"fields" validate:"required"`
+}
+
+// HandleCreateSchemaRequest handles the POST /schemacreate request. It adds a schema version,
+// replacing it if it exists, once checked with rigel.ValidateSchema. Only admins can create
+// schemas, see configsvc.RequireAdmin.
+func HandleCreateSchemaRequest(c *gin.Context, s *service.Service) {
+	lh := s.LogHarbour
+	lh.Log("CreateSchema Request Received")
+
+	if !configsvc.RequireAdmin(c, s) {
+		return
+	}
+
+	var createSchemaReq CreateSchemaRequest
+	if err := wscutils.BindJSON(c, &createSchemaReq); err != nil {
+		lh.LogActivity("error while binding json", err)
+		return
+	}
+	validationErrors := wscutils.WscValidate(createSchemaReq, createSchemaReq.getVals)
+	if len(validationErrors) > 0 {
+		lh.Debug0().LogDebug("Validation errors:", logharbour.DebugInfo{Variables: map[string]any{"validationErrors": validationErrors}})
+		wscutils.SendErrorResponse(c, wscutils.NewResponse(wscutils.ErrorStatus, nil, validationErrors))
+		return
+	}
+	schema := types.Schema{Version: createSchemaReq.Version, Description: createSchemaReq.Description, Fields: createSchemaReq.Fields}
+	if err := rigel.ValidateSchema(schema); err != nil {
+		field := "fields"
+		wscutils.SendErrorResponse(c, wscutils.NewResponse(wscutils.ErrorStatus, nil, []wscutils.ErrorMessage{wscutils.BuildErrorMessage(wscutils.ERRCODE_INVALID_REQUEST, &field, err.Error())}))
+		return
+	}
+
+	client, ok := s.Dependencies["rigel"].(*rigel.Rigel)
+	if !ok {
+		field := "rigelClient"
+		wscutils.SendErrorResponse(c, wscutils.NewResponse(wscutils.ErrorStatus, nil, []wscutils.ErrorMessage{wscutils.BuildErrorMessage(utils.INVALID_DEPENDENCY, &field)}))
+		return
+	}
+	view := client.View(createSchemaReq.App, createSchemaReq.Module, createSchemaReq.Version, "")
+	if err := view.AddSchema(c, schema); err != nil {
+		lh.LogActivity("error while adding schema:", err)
+		wscutils.SendErrorResponse(c, wscutils.NewErrorResponse(wscutils.ErrcodeDatabaseError))
+		return
+	}
+	lh.LogActivity("schema created:", map[string]any{"app": createSchemaReq.App, "module": createSchemaReq.Module, "ver": createSchemaReq.Version})
+	wscutils.SendSuccessResponse(c, &wscutils.Response{Status: wscutils.SuccessStatus, Data: "created successfully", Messages: []wscutils.ErrorMessage{}})
+}
+
+// getVals returns validation error details based on the field and tag.
+func (req *CreateSchemaRequest) getVals(err validator.FieldError) []string {
+	return nil
+}
+
 // container is used by getSchemaList handler to create and enrich
 // schema data it will eventually return. This is required to maintain the state
 // of the record while it goes through multiple iterations: first for list of apps,
@@ -162,14 +231,25 @@ type container struct {
 	moduleName   string
 	version      int
 	etcd         *etcd.EtcdStorage
+	params       utils.ListParams
+	entries      []utils.ListEntry
 	responseData []GetSchemaListResponse
+	total        int
+	nextCursor   string
+}
+
+// SchemaList is a page of the schema list.
+type SchemaList struct {
+	Schemas    []GetSchemaListResponse `json:"schemas"`
+	Total      int                     `json:"total"`                // Total is the number of schemas passing the filters, on all pages
+	NextCursor string                  `json:"nextCursor,omitempty"` // NextCursor is the cursor of the next page
 }
 
 func HandleGetSchemaListRequest(c *gin.Context, s *service.Service) {
 	lh := s.LogHarbour
 	lh.Log("GetSchemaList Request Received")
 
-	// Extracting etcdStorage and rigelTree from service dependency.
+	// Extracting etcdStorage from service dependency.
 
 	etcd, ok := s.Dependencies["etcd"].(*etcd.EtcdStorage)
 	if !ok {
@@ -177,25 +257,43 @@ func HandleGetSchemaListRequest(c *gin.Context, s *service.Service) {
 		wscutils.SendErrorResponse(c, wscutils.NewResponse(wscutils.ErrorStatus, nil, []wscutils.ErrorMessage{wscutils.BuildErrorMessage(utils.INVALID_DEPENDENCY, &field)}))
 		return
 	}
-	r := s.Dependencies["rTree"]
-	rTree, ok := r.(*utils.Node)
-	if !ok {
-		field := "rigelTree"
-		wscutils.SendErrorResponse(c, wscutils.NewResponse(wscutils.ErrorStatus, nil, []wscutils.ErrorMessage{wscutils.BuildErrorMessage(utils.INVALID_DEPENDENCY, &field)}))
+	var params utils.ListParams
+	if err := c.ShouldBindQuery(&params); err != nil {
+		lh.Debug0().LogActivity("error while binding query:", err.Error())
+		wscutils.SendErrorResponse(c, wscutils.NewErrorResponse(wscutils.ERRCODE_INVALID_REQUEST))
 		return
 	}
-
-	container := &container{
-		etcd: etcd,
+	if field, err := params.Validate(); err != nil {
+		lh.Debug0().LogActivity("invalid list parameters:", err.Error())
+		wscutils.SendErrorResponse(c, wscutils.NewResponse(wscutils.ErrorStatus, nil, []wscutils.ErrorMessage{wscutils.BuildErrorMessage(wscutils.ERRCODE_INVALID_REQUEST, &field, err.Error())}))
+		return
 	}
 
-	process(rTree, container)
+	response, err := ListSchemas(c, etcd, params)
+	if err != nil {
+		lh.Debug0().LogActivity("error while listing schemas:", err.Error())
+		wscutils.SendErrorResponse(c, wscutils.NewResponse(wscutils.ErrorStatus, nil, []wscutils.ErrorMessage{wscutils.BuildErrorMessage(wscutils.ErrcodeMissing, nil, err.Error())}))
+		return
+	}
+	wscutils.SendSuccessResponse(c, &wscutils.Response{Status: "success", Data: response, Messages: []wscutils.ErrorMessage{}})
+}
 
-	wscutils.SendSuccessResponse(c, &wscutils.Response{Status: "success", Data: container.responseData, Messages: []wscutils.ErrorMessage{}})
+// ListSchemas returns the page of the schemas in etcd passing params, which must be valid,
+// with their descriptions. It serves /schemalist and the ListSchemas call of the gRPC API.
+func ListSchemas(ctx context.Context, etcd *etcd.EtcdStorage, params utils.ListParams) (SchemaList, error) {
+	container := &container{
+		etcd:   etcd,
+		params: params,
+	}
+	if err := process(ctx, container); err != nil {
+		return SchemaList{}, err
+	}
+	return SchemaList{Schemas: container.responseData, Total: container.total, NextCursor: container.nextCursor}, nil
 }
 
-// process generates the required data by working on rigel keys tree rTree
-// it sets the value of the response to be sent in the container at c.responseData
+// process generates the required data by working on the tree of the rigel keys, read from etcd
+// for every list so that schemas added since the server started are listed.
+// It sets the value of the response to be sent in the container at c.responseData
 // overall processing is as follows:
 //
 //	  for each app in apps
@@ -203,15 +301,45 @@ func HandleGetSchemaListRequest(c *gin.Context, s *service.Service) {
 //			for each module in modules
 //				versions := versions in the module
 //				for each version in versions
-//					create the record for this schema (app, module, version combination)
+//					list this schema (app, module, version combination) if it passes the filters
 //				end version-for
 //			end module-for
 //		end app-for
-func process(rTree *utils.Node, c *container) {
+//
+// and then creates the records of the page of the sorted list asked for.
+func process(ctx context.Context, c *container) error {
+	ctx, cancel := context.WithTimeout(ctx, utils.DIALTIMEOUT)
+	defer cancel()
+
+	rTree, err := trees.Load(ctx, c.etcd)
+	if err != nil {
+		return err
+	}
 	appNodes := rTree.Ls(utils.RIGELPREFIX)
 	for _, n := range appNodes {
+		if layout.IsReserved(n.Name) || !c.params.MatchesApp(n.Name) {
+			continue
+		}
 		workOnApps(n, rTree, c)
 	}
+
+	page := c.params.Page(c.entries)
+	c.total = page.Total
+	c.nextCursor = page.NextCursor
+	c.responseData = make([]GetSchemaListResponse, 0, len(page.Entries))
+	descrs, err := getDescrs(ctx, c, page.Entries)
+	if err != nil {
+		return err
+	}
+	for _, e := range page.Entries {
+		c.responseData = append(c.responseData, GetSchemaListResponse{
+			App:         e.App,
+			Ver:         e.Ver,
+			Module:      e.Module,
+			Description: descrs[layout.SchemaDescriptionPath(e.App, e.Module, e.Ver)],
+		})
+	}
+	return nil
 }
 
 func workOnApps(n *utils.Node, rTree *utils.Node, c *container) {
@@ -220,6 +348,10 @@ func workOnApps(n *utils.Node, rTree *utils.Node, c *container) {
 	c.appName = appName
 	// modules
 	for _, m := range moduleNodes {
+		if layout.IsReserved(m.Name) || !c.params.MatchesModule(m.Name) {
+			// The lock of the app, or a module filtered out
+			continue
+		}
 		workOnModules(m, rTree, c)
 	}
 }
@@ -230,6 +362,10 @@ func workOnModules(m *utils.Node, rTree *utils.Node, c *container) {
 	c.moduleName = mName
 
 	for _, v := range versionNodes {
+		if layout.IsReserved(v.Name) {
+			// The lock of the module
+			continue
+		}
 		workOnVersions(v, rTree, c)
 	}
 }
@@ -238,41 +374,31 @@ func workOnVersions(v *utils.Node, rTree *utils.Node, c *container) {
 	vName := v.Name
 	vInt, err := strconv.Atoi(vName)
 	if err != nil {
-		wscutils.NewErrorResponse("invalid version")
+		// Not a version
 		return
 	}
 
 	c.version = vInt
 
-	generateResponse(c)
-
-}
-
-func generateResponse(c *container) {
-	descr := getDescr(c)
-	response := GetSchemaListResponse{
-		App:         c.appName,
-		Ver:         c.version,
-		Module:      c.moduleName,
-		Description: descr,
+	entry := utils.ListEntry{App: c.appName, Module: c.moduleName, Ver: c.version}
+	if c.params.Matches(entry) {
+		c.entries = append(c.entries, entry)
 	}
-	c.responseData = append(c.responseData, response)
 }
 
-func getDescr(t *container) string {
-	// Create a context with a timeout
-	ctx, cancel := context.WithTimeout(context.Background(), utils.DIALTIMEOUT)
-	defer cancel()
-
-	descr, err := t.etcd.Get(ctx, rigel.GetSchemaDescriptionPath(t.appName, t.moduleName, t.version)) // vInt))
+// getDescrs reads the descriptions of the schemas of entries in a single request.
+func getDescrs(ctx context.Context, t *container, entries []utils.ListEntry) (map[string]string, error) {
+	if len(entries) == 0 {
+		return nil, nil
+	}
+	keys := make([]string, len(entries))
+	for i, e := range entries {
+		keys[i] = layout.SchemaDescriptionPath(e.App, e.Module, e.Ver)
+	}
+	descrs, err := t.etcd.GetMany(ctx, keys...)
 	if err != nil {
-		if ctx.Err() == context.DeadlineExceeded {
-			wscutils.NewErrorResponse("description get timed out")
-		} else {
-			wscutils.NewErrorResponse("description get failed")
-		}
-		return ""
+		return nil, fmt.Errorf("failed to get schema descriptions: %w", err)
 	}
 
-	return descr
+	return descrs, nil
 }
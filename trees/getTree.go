@@ -2,12 +2,13 @@ package trees
 
 import (
 	"context"
+	"fmt"
 	"strconv"
+	"strings"
 
 	"github.com/remiges-aniket/etcd"
-	"github.com/remiges-aniket/rigel"
+	"github.com/remiges-aniket/layout"
 	"github.com/remiges-aniket/utils"
-	"github.com/remiges-tech/alya/wscutils"
 )
 
 // Container is used by getSchemaList handler to create and enrich
@@ -15,6 +16,8 @@ import (
 // of the record while it goes through multiple iterations: first for list of apps,
 // then the list of modules inside the app and then versions.
 // Container holds the state for each iteration.
+// Params filter, sort and page the configs; ResponseData is set to the configs of the page,
+// Total to the number of configs passing the filters and NextCursor to the cursor of the next page.
 type Container struct {
 	appName      string
 	moduleName   string
@@ -22,7 +25,12 @@ type Container struct {
 	Config       string
 	Description  string
 	Etcd         *etcd.EtcdStorage
+	Params       utils.ListParams
 	ResponseData []any
+	Total        int
+	NextCursor   string
+
+	entries []utils.ListEntry
 }
 
 type GetConfigListResponse struct {
@@ -33,11 +41,57 @@ type GetConfigListResponse struct {
 	Description string `json:"description"`
 }
 
-func Process(rTree *utils.Node, c *Container) {
+// Load builds the tree of the rigel keys in etcd. Only the keys are read, so no values, secret
+// or not, are kept in the tree.
+func Load(ctx context.Context, etcd *etcd.EtcdStorage) (*utils.Node, error) {
+	keys, err := etcd.GetKeys(ctx, utils.RIGELPREFIX+"/")
+	if err != nil {
+		return nil, err
+	}
+	rTree := utils.NewNode("")
+	for _, k := range keys {
+		rTree.AddPath(k, "")
+	}
+	return rTree, nil
+}
+
+// Process lists the configs in etcd that pass c.Params and sets the page asked for in c. The
+// keys are read for every list, so that configs created since the server started are listed.
+// Descriptions are read only for the configs of the page.
+func Process(ctx context.Context, c *Container) error {
+	ctx, cancel := context.WithTimeout(ctx, utils.DIALTIMEOUT)
+	defer cancel()
+
+	rTree, err := Load(ctx, c.Etcd)
+	if err != nil {
+		return err
+	}
 	appNodes := rTree.Ls(utils.RIGELPREFIX)
 	for _, n := range appNodes {
+		if layout.IsReserved(n.Name) || !c.Params.MatchesApp(n.Name) {
+			continue
+		}
 		workOnApps(n, rTree, c)
 	}
+
+	page := c.Params.Page(c.entries)
+	c.Total = page.Total
+	c.NextCursor = page.NextCursor
+	c.ResponseData = make([]any, 0, len(page.Entries))
+	descrs, err := getConfigDescrs(ctx, c, page.Entries)
+	if err != nil {
+		return err
+	}
+	for _, e := range page.Entries {
+		c.ResponseData = append(c.ResponseData, GetConfigListResponse{
+			App:         e.App,
+			Module:      e.Module,
+			Ver:         e.Ver,
+			Config:      e.Config,
+			Description: descrs[layout.ConfigDescriptionPath(e.App, e.Module, e.Ver, e.Config)],
+		})
+	}
+	return nil
 }
 
 func workOnApps(n *utils.Node, rTree *utils.Node, c *Container) {
@@ -46,6 +100,10 @@ func workOnApps(n *utils.Node, rTree *utils.Node, c *Container) {
 	c.appName = appName
 	// modules
 	for _, m := range moduleNodes {
+		if layout.IsReserved(m.Name) || !c.Params.MatchesModule(m.Name) {
+			// The lock of the app, or a module filtered out
+			continue
+		}
 		workOnModules(m, rTree, c)
 	}
 }
@@ -56,6 +114,10 @@ func workOnModules(m *utils.Node, rTree *utils.Node, c *Container) {
 	c.moduleName = mName
 
 	for _, v := range versionNodes {
+		if layout.IsReserved(v.Name) {
+			// The lock of the module
+			continue
+		}
 		workOnVersions(v, rTree, c)
 	}
 }
@@ -64,12 +126,13 @@ func workOnVersions(v *utils.Node, rTree *utils.Node, c *Container) {
 	vName := v.Name
 	vInt, err := strconv.Atoi(vName)
 	if err != nil {
-		wscutils.NewErrorResponse("invalid version")
+		// Not a version
 		return
 	}
 
 	c.version = vInt
-	configNodes := rTree.Ls(utils.RIGELPREFIX + "/" + c.appName + "/" + c.moduleName + "/" + vName)
+	// Named configs are kept below the config segment of the version, next to the schema
+	configNodes := rTree.Ls(strings.TrimSuffix(layout.ConfigsPath(c.appName, c.moduleName, vInt), "/"))
 
 	for _, conf := range configNodes {
 		workOnConfigs(conf, rTree, c)
@@ -79,53 +142,24 @@ func workOnVersions(v *utils.Node, rTree *utils.Node, c *Container) {
 
 func workOnConfigs(conf *utils.Node, rTree *utils.Node, c *Container) {
 	c.Config = conf.Name
-	GenerateResponse(c)
-}
-
-func GenerateResponse(c *Container) {
-	descr := getConfigDescr(c)
-	response := GetConfigListResponse{
-		App:         c.appName,
-		Ver:         c.version,
-		Module:      c.moduleName,
-		Config:      c.Config,
-		Description: descr,
+	entry := utils.ListEntry{App: c.appName, Module: c.moduleName, Ver: c.version, Config: c.Config}
+	if c.Params.Matches(entry) {
+		c.entries = append(c.entries, entry)
 	}
-	c.ResponseData = append(c.ResponseData, response)
 }
 
-func getDescr(t *Container) string {
-	// Create a context with a timeout
-	ctx, cancel := context.WithTimeout(context.Background(), utils.DIALTIMEOUT)
-	defer cancel()
-
-	descr, err := t.Etcd.Get(ctx, rigel.GetSchemaDescriptionPath(t.appName, t.moduleName, t.version)) // vInt))
-	if err != nil {
-		if ctx.Err() == context.DeadlineExceeded {
-			wscutils.NewErrorResponse("description get timed out")
-		} else {
-			wscutils.NewErrorResponse("description get failed")
-		}
-		return ""
+// getConfigDescrs reads the descriptions of the configs of entries in a single request.
+func getConfigDescrs(ctx context.Context, t *Container, entries []utils.ListEntry) (map[string]string, error) {
+	if len(entries) == 0 {
+		return nil, nil
 	}
-
-	return descr
-}
-
-func getConfigDescr(t *Container) string {
-	// Create a context with a timeout
-	ctx, cancel := context.WithTimeout(context.Background(), utils.DIALTIMEOUT)
-	defer cancel()
-
-	descr, err := t.Etcd.Get(ctx, rigel.GetConfKeyPath(t.appName, t.moduleName, t.version, t.Config, "description")) // vInt))
+	keys := make([]string, len(entries))
+	for i, e := range entries {
+		keys[i] = layout.ConfigDescriptionPath(e.App, e.Module, e.Ver, e.Config)
+	}
+	descrs, err := t.Etcd.GetMany(ctx, keys...)
 	if err != nil {
-		if ctx.Err() == context.DeadlineExceeded {
-			wscutils.NewErrorResponse("description get timed out")
-		} else {
-			wscutils.NewErrorResponse("description get failed")
-		}
-		return ""
+		return nil, fmt.Errorf("failed to get config descriptions: %w", err)
 	}
-
-	return descr
+	return descrs, nil
 }
@@ -3,6 +3,11 @@ package types
 
 import (
 	"context"
+	"errors"
+	"hash/fnv"
+	"path"
+	"strings"
+	"time"
 )
 
 // Schema represents the structure of a schema. Currently, the only supported type is JSON.
@@ -41,10 +46,228 @@ type Constraints struct {
 //	  "name": "maxConnections",
 //	  "type": "int"
 //	}
+//
+// A field of type "group" holds other fields instead of a value, which lets a schema
+// organise its fields hierarchically. The fields of a group are addressed by dotted
+// names, such as "db.pool.max" for the field max of group pool in group db, and their
+// values are stored under nested key paths (db/pool/max) below the config.
+//
+// Example:
+//
+//	{
+//	  "name": "db",
+//	  "type": "group",
+//	  "fields": [
+//	    {"name": "host", "type": "string"},
+//	    {"name": "pool", "type": "group", "fields": [{"name": "max", "type": "int"}]}
+//	  ]
+//	}
 type Field struct {
 	Name        string       `json:"name"` // Name represents the name of the field (config parameter).
-	Type        string       `json:"type"` // Type represents the type of the field. Currently, the supported types are "string", "int", and "bool".
+	Type        string       `json:"type"` // Type represents the type of the field. Currently, the supported types are "string", "int", "float", "bool", "secret" and "group".
 	Constraints *Constraints `json:"constraints"`
+	Fields      []Field      `json:"fields,omitempty"` // Fields holds the fields of a group.
+	Flag        bool         `json:"flag,omitempty"`   // Flag makes a bool field a feature flag, see FlagState.
+}
+
+// FieldTypeGroup is the type of a field that groups other fields.
+const FieldTypeGroup = "group"
+
+// FieldTypeSecret is the type of a string field, such as a password or an API key, whose values
+// are stored encrypted and masked in server responses.
+const FieldTypeSecret = "secret"
+
+// IsGroup reports whether the field groups other fields.
+func (f Field) IsGroup() bool {
+	return f.Type == FieldTypeGroup
+}
+
+// IsSecret reports whether the values of the field are secrets.
+func (f Field) IsSecret() bool {
+	return f.Type == FieldTypeSecret
+}
+
+// Leaves returns the fields of the schema that hold values, with groups flattened.
+// Each returned field is named by its full dotted name, such as "db.pool.max".
+func (s *Schema) Leaves() []Field {
+	return leaves(s.Fields, "")
+}
+
+func leaves(fields []Field, prefix string) []Field {
+	var result []Field
+	for _, f := range fields {
+		if f.IsGroup() {
+			result = append(result, leaves(f.Fields, prefix+f.Name+".")...)
+			continue
+		}
+		f.Name = prefix + f.Name
+		result = append(result, f)
+	}
+	return result
+}
+
+// Leaf returns the field holding values with the given dotted name, or nil if there is none.
+func (s *Schema) Leaf(name string) *Field {
+	for _, f := range s.Leaves() {
+		if f.Name == name {
+			return &f
+		}
+	}
+	return nil
+}
+
+// Rule is a targeting rule of a config value. A client whose attributes, such as hostname,
+// region or instance id, match every entry of Match gets Value instead of the value of the
+// config. Match values are patterns as for path.Match, so "web-*" matches "web-1".
+type Rule struct {
+	Match map[string]string `json:"match"`
+	Value string            `json:"value"`
+}
+
+// Matches reports whether attrs match every entry of the rule. An attribute missing from
+// attrs does not match. Malformed patterns never match.
+func (r Rule) Matches(attrs map[string]string) bool {
+	for name, pattern := range r.Match {
+		value, found := attrs[name]
+		if !found {
+			return false
+		}
+		if matched, err := path.Match(pattern, value); err != nil || !matched {
+			return false
+		}
+	}
+	return true
+}
+
+// EvaluateRules returns the value of the first of rules that matches attrs, in order.
+// It returns false if none matches, in which case the value of the config applies.
+func EvaluateRules(rules []Rule, attrs map[string]string) (string, bool) {
+	for _, rule := range rules {
+		if rule.Matches(attrs) {
+			return rule.Value, true
+		}
+	}
+	return "", false
+}
+
+// FlagState is the rollout state of a feature flag, a bool field with Flag set. Subjects, such as
+// user ids, in Deny are never enabled and those in Allow always are; of the others, Rollout percent
+// are enabled. Each subject falls in a fixed bucket of the flag, so raising Rollout only adds
+// subjects and a subject enabled at 10% stays enabled at 20%.
+type FlagState struct {
+	Rollout int      `json:"rollout"` // Rollout is the percentage of subjects enabled, from 0 to 100
+	Allow   []string `json:"allow,omitempty"`
+	Deny    []string `json:"deny,omitempty"`
+}
+
+// IsEnabled reports whether the flag named flag is enabled for subjectKey.
+func (s FlagState) IsEnabled(flag string, subjectKey string) bool {
+	for _, subject := range s.Deny {
+		if subject == subjectKey {
+			return false
+		}
+	}
+	for _, subject := range s.Allow {
+		if subject == subjectKey {
+			return true
+		}
+	}
+	return FlagBucket(flag, subjectKey) < s.Rollout
+}
+
+// FlagBucket returns the bucket, from 0 to 99, of subjectKey for the flag named flag.
+// Buckets are hashed on both, so that each flag is rolled out to a different set of subjects.
+func FlagBucket(flag string, subjectKey string) int {
+	h := fnv.New32a()
+	h.Write([]byte(flag))
+	h.Write([]byte{0})
+	h.Write([]byte(subjectKey))
+	return int(h.Sum32() % 100)
+}
+
+// LockScope is what a Lock applies to: all configs of an app, all configs of a module, or one named config.
+type LockScope string
+
+const (
+	LockScopeApp    LockScope = "app"
+	LockScopeModule LockScope = "module"
+	LockScopeConfig LockScope = "config"
+)
+
+// Lock makes configs read-only, for instance while month-end processing must see fixed values.
+// A lock with Expires set ends by itself at that time.
+type Lock struct {
+	Scope    LockScope  `json:"scope"`
+	Reason   string     `json:"reason"`
+	LockedAt time.Time  `json:"lockedAt"`
+	Expires  *time.Time `json:"expires,omitempty"`
+}
+
+// Active reports whether the lock is in force at now.
+func (l Lock) Active(now time.Time) bool {
+	return l.Expires == nil || now.Before(*l.Expires)
+}
+
+// LockOverride records a write made to a locked config by overriding its lock.
+type LockOverride struct {
+	Lock   Lock      `json:"lock"`   // Lock is the lock that was overridden
+	Key    string    `json:"key"`    // Key is the config key written, empty for the parent of the config
+	Reason string    `json:"reason"` // Reason is the reason given for the override
+	At     time.Time `json:"at"`
+}
+
+// ScheduleStatus is the state of a ScheduledChange.
+type ScheduleStatus string
+
+const (
+	SchedulePending   ScheduleStatus = "pending"
+	ScheduleApplied   ScheduleStatus = "applied"
+	ScheduleCancelled ScheduleStatus = "cancelled"
+	ScheduleFailed    ScheduleStatus = "failed" // ScheduleFailed is the status of a change that can no longer be applied, see ScheduledChange.Error
+)
+
+// ScheduledChange is a set of values of a named config to be set at EffectiveAt.
+// Values holds the values as stored, so the values of secret fields are encrypted.
+type ScheduledChange struct {
+	ID          string            `json:"id"`
+	App         string            `json:"app"`
+	Module      string            `json:"module"`
+	Version     int               `json:"ver"`
+	Config      string            `json:"config"`
+	EffectiveAt time.Time         `json:"effectiveAt"`
+	Values      map[string]string `json:"values"` // Values maps dotted config key names to their new values
+	Reason      string            `json:"reason,omitempty"`
+	Status      ScheduleStatus    `json:"status"`
+	CreatedAt   time.Time         `json:"createdAt"`
+	AppliedAt   *time.Time        `json:"appliedAt,omitempty"`
+	Previous    map[string]string `json:"previous,omitempty"` // Previous holds the values replaced when applied, "" for keys that had none
+	Error       string            `json:"error,omitempty"`    // Error tells why a failed change could not be applied
+}
+
+// NestFields arranges fields named with dotted names, as returned by Leaves, into groups.
+// Fields are kept in the order in which their name, or the name of their group, first appears.
+func NestFields(fields []Field) []Field {
+	var result []Field
+	groups := make(map[string]int) // groups maps group names to their index in result
+	var members = make(map[string][]Field)
+
+	for _, f := range fields {
+		group, rest, found := strings.Cut(f.Name, ".")
+		if !found {
+			result = append(result, f)
+			continue
+		}
+		if _, seen := groups[group]; !seen {
+			groups[group] = len(result)
+			result = append(result, Field{Name: group, Type: FieldTypeGroup})
+		}
+		f.Name = rest
+		members[group] = append(members[group], f)
+	}
+	for group, i := range groups {
+		result[i].Fields = NestFields(members[group])
+	}
+	return result
 }
 
 // Storage is an interface that abstracts the operations for getting and putting data in
@@ -55,6 +278,14 @@ type Storage interface {
 	// If an error occurs during the operation, it is returned.
 	Get(ctx context.Context, key string) (string, error)
 
+	// GetWithPrefix retrieves all keys starting with prefix and their values in a single read.
+	// If no key has the prefix, it returns an empty map and no error.
+	GetWithPrefix(ctx context.Context, prefix string) (map[string]string, error)
+
+	// GetMany retrieves the values of the given keys in a single read.
+	// Keys that do not exist are left out of the returned map.
+	GetMany(ctx context.Context, keys ...string) (map[string]string, error)
+
 	// Put stores a value with the specified key.
 	// If the key already exists, its value is updated; if it does not, a new key-value pair is created.
 	// If an error occurs during the operation, it is returned.
@@ -63,15 +294,92 @@ type Storage interface {
 	// Watch watches for changes to a key in the storage and sends the events to the provided channel.
 	// The events includes the key and the updated value.
 	// events is the channel to send events when the key's value changes
+	// Problems with the watch are reported as events with Err set. The storage closes the
+	// events channel when the watch ends.
 	Watch(ctx context.Context, key string, events chan<- Event) error
 }
 
+// ConditionalWriter is implemented by storages that can make several writes atomically.
+type ConditionalWriter interface {
+	// PutIf stores all of kvs in a single write, provided that key still holds value. It reports
+	// whether it did; if key holds another value, nothing is stored.
+	PutIf(ctx context.Context, key string, value string, kvs map[string]string) (bool, error)
+
+	// Commit makes all the writes of txn in a single write, provided that every key of txn.If
+	// holds its value. It reports whether it did; if a key holds another value, nothing is written.
+	Commit(ctx context.Context, txn Txn) (bool, error)
+}
+
+// Txn is a set of writes made at once by ConditionalWriter.Commit.
+type Txn struct {
+	If     map[string]string // If maps keys to the values they must hold, "" for keys that are empty or do not exist
+	Put    map[string]string // Put maps the keys to store to their values
+	Delete []string          // Delete lists the keys to delete
+}
+
+// EventType tells whether an Event is for a key that was put or deleted.
+type EventType int
+
+const (
+	EventTypePut EventType = iota
+	EventTypeDelete
+)
+
+func (t EventType) String() string {
+	switch t {
+	case EventTypePut:
+		return "put"
+	case EventTypeDelete:
+		return "delete"
+	}
+	return "unknown"
+}
+
+// RevisionReader is implemented by storages that can tell the revision of the data they return.
+type RevisionReader interface {
+	// GetWithPrefixRev works like Storage.GetWithPrefix and also returns the storage revision
+	// at which the keys were read.
+	GetWithPrefixRev(ctx context.Context, prefix string) (map[string]string, int64, error)
+	// Revision returns the current revision of the storage.
+	Revision(ctx context.Context) (int64, error)
+}
+
+// HistoryReader is implemented by storages that keep the past revisions of their keys.
+type HistoryReader interface {
+	// GetWithPrefixAt works like Storage.GetWithPrefix, reading the keys as they were at revision
+	// rev. If the storage no longer has that revision, it returns an error wrapping ErrCompacted.
+	GetWithPrefixAt(ctx context.Context, prefix string, rev int64) (map[string]string, error)
+}
+
+// ErrCompacted is wrapped by the errors of RevisionWatcher.WatchFrom and HistoryReader.GetWithPrefixAt
+// when the storage no longer has the changes or the revision asked for.
+var ErrCompacted = errors.New("revision compacted")
+
+// RevisionWatcher is implemented by storages that can watch keys from a past revision.
+type RevisionWatcher interface {
+	// WatchFrom works like Storage.Watch, starting with the changes made at revision rev
+	// rather than with those made after the call. If the storage no longer has those changes,
+	// it returns an error wrapping ErrCompacted.
+	WatchFrom(ctx context.Context, key string, rev int64, events chan<- Event) error
+}
+
 // Event represents a change to a key in the storage.
+// Type tells whether the key was put or deleted
 // Key is the key that was changed
-// Value is the new value of the key
+// Value is the new value of the key, empty for a delete
+// PrevValue is the value of the key before the change, empty if it did not exist or is not known
+// Revision is the storage revision at which the change was made
+// Last is set on the last change of its revision sent by RevisionWatcher.WatchFrom, which sends
+// the changes of a revision one after another
+// Err is set instead when the watch ran into a problem; Key is then the watched key
 type Event struct {
-	Key   string
-	Value string
+	Type      EventType
+	Key       string
+	Value     string
+	PrevValue string
+	Revision  int64
+	Last      bool
+	Err       error
 }
 
 type Cache interface {
@@ -79,3 +387,16 @@ type Cache interface {
 	Set(key string, value string)
 	Delete(key string)
 }
+
+// CacheContainer is implemented by caches that can tell whether a key is cached
+// without counting it as an access of the key.
+type CacheContainer interface {
+	Contains(key string) bool
+}
+
+// NegativeCache is implemented by caches that can remember keys missing in the storage.
+// Get reports a key remembered as missing as found with an empty value.
+type NegativeCache interface {
+	Cache
+	SetMissing(key string)
+}
@@ -0,0 +1,184 @@
+package utils
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+)
+
+// MAXLISTLIMIT is the largest page the list endpoints return.
+const MAXLISTLIMIT = 1000
+
+// ListParams are the query parameters of the list endpoints, /configlist and /schemalist.
+// App and Module filter by exact name, MinVer and MaxVer by version range, and Config by a
+// pattern of config names as for path.Match, such as "prod-*". Sort names the field to sort
+// by, one of app, module, ver and config, with a leading "-" for descending order; entries
+// that tie are ordered by app, module, ver and config. A page holds at most Limit entries,
+// all of them if Limit is 0, starting at Offset or after Cursor, the NextCursor of the
+// previous page. Unlike an offset, a cursor does not skip or repeat entries when entries
+// before it are added or removed between pages.
+type ListParams struct {
+	App    string `form:"app"`
+	Module string `form:"module"`
+	MinVer int    `form:"minver"`
+	MaxVer int    `form:"maxver"`
+	Config string `form:"config"`
+	Sort   string `form:"sort"`
+	Limit  int    `form:"limit"`
+	Offset int    `form:"offset"`
+	Cursor string `form:"cursor"`
+}
+
+// ListEntry identifies a schema version, or a named config of one, in a list.
+type ListEntry struct {
+	App    string `json:"app"`
+	Module string `json:"module"`
+	Ver    int    `json:"ver"`
+	Config string `json:"config,omitempty"`
+}
+
+// ListPage is a page of entries along with the information needed to fetch the others.
+type ListPage struct {
+	Entries    []ListEntry
+	Total      int    // Total is the number of entries that pass the filters, on all pages
+	NextCursor string // NextCursor fetches the next page, empty on the last one
+}
+
+// Validate checks the parameters, returning the name of the first invalid one.
+func (p ListParams) Validate() (string, error) {
+	if p.Limit < 0 || p.Limit > MAXLISTLIMIT {
+		return "limit", fmt.Errorf("limit must be between 0 and %d", MAXLISTLIMIT)
+	}
+	if p.Offset < 0 {
+		return "offset", fmt.Errorf("offset must not be negative")
+	}
+	if p.Offset > 0 && p.Cursor != "" {
+		return "cursor", fmt.Errorf("offset and cursor cannot be used together")
+	}
+	if p.Config != "" {
+		if _, err := path.Match(p.Config, ""); err != nil {
+			return "config", fmt.Errorf("invalid config pattern: %w", err)
+		}
+	}
+	switch strings.TrimPrefix(p.Sort, "-") {
+	case "", "app", "module", "ver", "config":
+	default:
+		return "sort", fmt.Errorf("cannot sort by %q", p.Sort)
+	}
+	if p.Cursor != "" {
+		if _, err := decodeCursor(p.Cursor); err != nil {
+			return "cursor", err
+		}
+	}
+	return "", nil
+}
+
+// MatchesApp reports whether the entries of app may pass the filters.
+func (p ListParams) MatchesApp(app string) bool {
+	return p.App == "" || p.App == app
+}
+
+// MatchesModule reports whether the entries of module may pass the filters.
+func (p ListParams) MatchesModule(module string) bool {
+	return p.Module == "" || p.Module == module
+}
+
+// Matches reports whether e passes the filters. The config pattern applies only to entries of configs.
+func (p ListParams) Matches(e ListEntry) bool {
+	if !p.MatchesApp(e.App) || !p.MatchesModule(e.Module) {
+		return false
+	}
+	if (p.MinVer > 0 && e.Ver < p.MinVer) || (p.MaxVer > 0 && e.Ver > p.MaxVer) {
+		return false
+	}
+	if p.Config != "" && e.Config != "" {
+		if matched, _ := path.Match(p.Config, e.Config); !matched {
+			return false
+		}
+	}
+	return true
+}
+
+// Page sorts entries, which must already be filtered, and returns the page asked for by p.
+// p must have been validated.
+func (p ListParams) Page(entries []ListEntry) ListPage {
+	less := p.less()
+	sort.Slice(entries, func(i, j int) bool { return less(entries[i], entries[j]) })
+
+	start := p.Offset
+	if p.Cursor != "" {
+		after, _ := decodeCursor(p.Cursor)
+		start = sort.Search(len(entries), func(i int) bool { return less(after, entries[i]) })
+	}
+	if start > len(entries) {
+		start = len(entries)
+	}
+	end := len(entries)
+	if p.Limit > 0 && start+p.Limit < end {
+		end = start + p.Limit
+	}
+
+	page := ListPage{Entries: entries[start:end], Total: len(entries)}
+	if end < len(entries) {
+		page.NextCursor = encodeCursor(entries[end-1])
+	}
+	return page
+}
+
+// less returns the order of the entries asked for by p.
+func (p ListParams) less() func(a, b ListEntry) bool {
+	field, desc := strings.TrimPrefix(p.Sort, "-"), strings.HasPrefix(p.Sort, "-")
+	return func(a, b ListEntry) bool {
+		if c := compareField(field, a, b); c != 0 {
+			return (c < 0) != desc
+		}
+		for _, f := range []string{"app", "module", "ver", "config"} {
+			if c := compareField(f, a, b); c != 0 {
+				return c < 0
+			}
+		}
+		return false
+	}
+}
+
+// compareField compares a field of two entries, returning -1, 0 or 1.
+func compareField(field string, a, b ListEntry) int {
+	switch field {
+	case "app":
+		return strings.Compare(a.App, b.App)
+	case "module":
+		return strings.Compare(a.Module, b.Module)
+	case "ver":
+		switch {
+		case a.Ver < b.Ver:
+			return -1
+		case a.Ver > b.Ver:
+			return 1
+		}
+		return 0
+	case "config":
+		return strings.Compare(a.Config, b.Config)
+	}
+	return 0
+}
+
+// encodeCursor returns the cursor of the page after the entry e.
+func encodeCursor(e ListEntry) string {
+	data, _ := json.Marshal(e)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeCursor(cursor string) (ListEntry, error) {
+	var e ListEntry
+	data, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err == nil {
+		err = json.Unmarshal(data, &e)
+	}
+	if err != nil {
+		return ListEntry{}, fmt.Errorf("invalid cursor")
+	}
+	return e, nil
+}
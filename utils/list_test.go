@@ -0,0 +1,111 @@
+package utils
+
+import (
+	"reflect"
+	"testing"
+)
+
+func listEntries() []ListEntry {
+	return []ListEntry{
+		{App: "shop", Module: "cart", Ver: 2, Config: "prod-eu"},
+		{App: "bank", Module: "loans", Ver: 1, Config: "dev"},
+		{App: "shop", Module: "cart", Ver: 1, Config: "prod-us"},
+		{App: "bank", Module: "cards", Ver: 3, Config: "prod-in"},
+		{App: "shop", Module: "cart", Ver: 2, Config: "dev"},
+	}
+}
+
+func filtered(p ListParams) []ListEntry {
+	var entries []ListEntry
+	for _, e := range listEntries() {
+		if p.Matches(e) {
+			entries = append(entries, e)
+		}
+	}
+	return entries
+}
+
+func TestListParamsMatches(t *testing.T) {
+	p := ListParams{App: "shop", MinVer: 2, Config: "prod-*"}
+	want := []ListEntry{{App: "shop", Module: "cart", Ver: 2, Config: "prod-eu"}}
+	if got := filtered(p); !reflect.DeepEqual(got, want) {
+		t.Errorf("Matches kept %v, want %v", got, want)
+	}
+
+	// The config pattern does not apply to schemas
+	if !p.Matches(ListEntry{App: "shop", Module: "cart", Ver: 3}) {
+		t.Errorf("Matches rejected a schema for the config pattern")
+	}
+	if p.Matches(ListEntry{App: "shop", Module: "cart", Ver: 1}) {
+		t.Errorf("Matches kept a version below MinVer")
+	}
+}
+
+func TestListParamsPage(t *testing.T) {
+	p := ListParams{Sort: "-ver"}
+	page := p.Page(listEntries())
+	want := []ListEntry{
+		{App: "bank", Module: "cards", Ver: 3, Config: "prod-in"},
+		{App: "shop", Module: "cart", Ver: 2, Config: "dev"},
+		{App: "shop", Module: "cart", Ver: 2, Config: "prod-eu"},
+		{App: "bank", Module: "loans", Ver: 1, Config: "dev"},
+		{App: "shop", Module: "cart", Ver: 1, Config: "prod-us"},
+	}
+	if !reflect.DeepEqual(page.Entries, want) || page.Total != 5 || page.NextCursor != "" {
+		t.Errorf("Page = %+v, want all entries in %v", page, want)
+	}
+
+	p = ListParams{Limit: 2, Offset: 2}
+	page = p.Page(listEntries())
+	want = []ListEntry{
+		{App: "shop", Module: "cart", Ver: 1, Config: "prod-us"},
+		{App: "shop", Module: "cart", Ver: 2, Config: "dev"},
+	}
+	if !reflect.DeepEqual(page.Entries, want) || page.Total != 5 {
+		t.Errorf("Page with offset = %+v, want %v", page, want)
+	}
+}
+
+func TestListParamsCursor(t *testing.T) {
+	var got []ListEntry
+	p := ListParams{Sort: "config", Limit: 2}
+	for pages := 0; ; pages++ {
+		if pages == 3 {
+			t.Fatalf("cursor did not reach the last page")
+		}
+		if _, err := p.Validate(); err != nil {
+			t.Fatalf("Validate() = %v", err)
+		}
+		page := p.Page(listEntries())
+		got = append(got, page.Entries...)
+		if page.NextCursor == "" {
+			break
+		}
+		p.Cursor = page.NextCursor
+	}
+	want := ListParams{Sort: "config"}.Page(listEntries()).Entries
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("pages = %v, want %v", got, want)
+	}
+}
+
+func TestListParamsValidate(t *testing.T) {
+	tests := []struct {
+		params ListParams
+		field  string
+	}{
+		{ListParams{Limit: 10, Sort: "-app", Config: "prod-*"}, ""},
+		{ListParams{Limit: MAXLISTLIMIT + 1}, "limit"},
+		{ListParams{Offset: -1}, "offset"},
+		{ListParams{Offset: 1, Cursor: encodeCursor(ListEntry{App: "a"})}, "cursor"},
+		{ListParams{Cursor: "not a cursor"}, "cursor"},
+		{ListParams{Config: "prod-["}, "config"},
+		{ListParams{Sort: "description"}, "sort"},
+	}
+	for _, tt := range tests {
+		field, err := tt.params.Validate()
+		if field != tt.field || (err != nil) != (tt.field != "") {
+			t.Errorf("Validate(%+v) = %q, %v, want field %q", tt.params, field, err, tt.field)
+		}
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"time"
 
 	"github.com/go-playground/validator/v10"
+	"github.com/remiges-aniket/layout"
 	"github.com/remiges-tech/alya/wscutils"
 )
 
@@ -19,8 +20,23 @@ const (
 
 const (
 	DIALTIMEOUT        = 5 * time.Second
-	RIGELPREFIX        = "/remiges/rigel"
+	RIGELPREFIX        = layout.Prefix
 	INVALID_DEPENDENCY = "invalid_dependency"
+	CONFIG_LOCKED      = "config_locked" // CONFIG_LOCKED is the error code of writes rejected because the config is locked
+	CHANGE_NOT_FOUND   = "change_not_found"
+	CHANGE_NOT_PENDING = "change_not_pending" // CHANGE_NOT_PENDING is the error code for cancelling a scheduled change already applied or cancelled
+	INVALID_TIME       = "invalid_time"
+	NOT_AUTHORIZED     = "not_authorized"      // NOT_AUTHORIZED is the error code of lock management and lock overrides by a non-admin
+	INVALID_PARENT     = "invalid_parent"      // INVALID_PARENT is the error code of a parent that would make a cycle or too long a chain
+	INVALID_RULES      = "invalid_rules"       // INVALID_RULES is the error code of targeting rules that cannot be set
+	SCHEDULEINTERVAL   = 5 * time.Second       // SCHEDULEINTERVAL is how often the server applies scheduled changes that fell due
+	SCHEDULERETENTION  = 30 * 24 * time.Hour   // SCHEDULERETENTION is how long finished scheduled changes are kept after their effective time
+	SSEKEEPALIVE       = 15 * time.Second      // SSEKEEPALIVE is how often an idle change stream sends a keepalive comment
+	SSEBUFFERSIZE      = 1000                  // SSEBUFFERSIZE is the number of recent changes kept per stream, from which clients resume
+	LONGPOLLTIMEOUT    = 30 * time.Second      // LONGPOLLTIMEOUT is how long /configwatch waits for a change by default
+	LONGPOLLMAXTIMEOUT = 120 * time.Second     // LONGPOLLMAXTIMEOUT is the longest wait a /configwatch client can ask for
+	LONGPOLLSETTLE     = 50 * time.Millisecond // LONGPOLLSETTLE is how long /configwatch gathers changes after the first one
+	HUBGRACEPERIOD     = time.Minute           // HUBGRACEPERIOD is how long the server keeps watching a config after its last stream or poll ended, so the next poll resumes from the changes kept
 )
 
 type Status int
@@ -46,6 +62,14 @@ type GetConfigRequestParams struct {
 	Module  *string `form:"module" binding:"required"`
 	Version int     `form:"ver" binding:"required"`
 	Config  *string `form:"config" binding:"required"`
+	// Effective asks for the values the config resolves to through its parents, instead of its own values
+	Effective bool `form:"effective"`
+	// At asks for the effective values at a time in RFC 3339 format: as they were at a past time, or a preview at a future time, once the pending scheduled changes due by then are applied
+	At string `form:"at"`
+	// Past, Current and Future, like those of GetRequest, restrict the time asked for to a past At, the current time with no At, or a future At; with none of them set, any time may be asked for
+	Past    bool `form:"past"`
+	Current bool `form:"current"`
+	Future  bool `form:"future"`
 }
 
 type CreateConfigRequest struct {
@@ -83,6 +107,9 @@ type AppConfig struct {
 	DBPassword       string `json:"db_password"`
 	DBName           string `json:"db_name"`
 	AppServerPort    string `json:"app_server_port"`
+	GRPCServerPort   string `json:"grpc_server_port"` // GRPCServerPort serves the gRPC API, which is not served if it is empty
+	Namespace        string `json:"namespace"`        // Namespace holds all the keys of the server in etcd, such as "/tenants/acme"
+	AdminToken       string `json:"admin_token"`      // AdminToken authorizes lock management and lock overrides, which are refused if it is empty
 	KeycloakURL      string `json:"keycloak_url"`
 	KeycloakClientID string `json:"keycloak_client_id"`
 }
@@ -205,6 +232,7 @@ func (n *Node) Ls(path string) []*Node {
 		if !exists {
 			//fmt.Errorf("%v not valid child", part)
 			wscutils.NewErrorResponse(" Insvalid child")
+			return nil
 		}
 		current = child
 	}